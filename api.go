@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MickaelBergem/dnsstresss/stress"
+	"github.com/logrusorgru/aurora"
+)
+
+// controlAPI runs the tests asked over the REST API of -listen, one at a time. A test is the one
+// of the command line, with the options of the testDefinition POSTed to /start replacing its own.
+type controlAPI struct {
+	token    string
+	defaults []byte // Config of the command line, as JSON
+	local    func(cfg *stress.Config)
+
+	mu       sync.Mutex
+	runner   *stress.Runner // Current test, or the last one
+	running  bool
+	started  time.Time
+	interval *statsRecord // Last interval of the test
+	summary  *statsRecord // Set once the test is over
+}
+
+// testDefinition are the options of the command line a test can replace, written as their flags
+// (e.g. {"rate": 1000, "duration": "1m"}). The files and the resolvers stay the ones of the host.
+type testDefinition struct {
+	Domains     string `json:"domains"` // Weighted list, as -domains
+	Types       string `json:"types"`   // As -types
+	Rate        *int   `json:"rate"`
+	Ramp        string `json:"ramp"`
+	Concurrency *int   `json:"concurrency"`
+	Duration    string `json:"duration"`
+	Count       *int   `json:"count"`
+	Transport   string `json:"transport"` // udp, tcp, dot or doq, the resolvers keeping their ports
+}
+
+// apply replaces the options of a test with the ones of the definition
+func (d *testDefinition) apply(cfg *stress.Config) error {
+	var err error
+	if d.Domains != "" {
+		if cfg.Domains, cfg.DomainWeights, err = stress.ParseWeightedList(d.Domains); err != nil {
+			return fmt.Errorf("invalid domains: %s", err)
+		}
+	}
+	if d.Types != "" {
+		if cfg.QueryTypes, cfg.TypeWeights, err = stress.ParseQueryTypes(d.Types); err != nil {
+			return fmt.Errorf("invalid types: %s", err)
+		}
+	}
+	if d.Rate != nil {
+		cfg.Rate = *d.Rate
+	}
+	if d.Ramp != "" {
+		if cfg.Ramp, err = stress.ParseRampProfile(d.Ramp); err != nil {
+			return fmt.Errorf("invalid ramp: %s", err)
+		}
+	}
+	if d.Concurrency != nil {
+		cfg.Concurrency = *d.Concurrency
+	}
+	if d.Duration != "" {
+		if cfg.Duration, err = time.ParseDuration(d.Duration); err != nil {
+			return fmt.Errorf("invalid duration: %s", err)
+		}
+	}
+	if d.Count != nil {
+		cfg.Count = *d.Count
+	}
+	if d.Transport != "" {
+		if cfg.DOHEndpoint != "" {
+			return fmt.Errorf("the transport of a DOH test cannot be changed")
+		}
+		transport := strings.ToLower(d.Transport)
+		switch transport {
+		case "udp", "tcp", "dot", "doq":
+		default:
+			return fmt.Errorf("invalid transport %q, expected udp, tcp, dot or doq", d.Transport)
+		}
+		cfg.TCP = transport == "tcp"
+		cfg.DoT = transport == "dot"
+		cfg.DoQ = transport == "doq"
+	}
+	return nil
+}
+
+// controlStatus is the answer of the API: the state of the test and its stats so far
+type controlStatus struct {
+	State       string       `json:"state"` // idle, running, paused or done
+	Started     *time.Time   `json:"started,omitempty"`
+	Rate        int          `json:"rate"`
+	Concurrency int          `json:"concurrency"`
+	Interval    *statsRecord `json:"interval,omitempty"`
+	Summary     *statsRecord `json:"summary,omitempty"`
+}
+
+func newControlAPI(token string, defaults *stress.Config, local func(cfg *stress.Config)) (http.Handler, error) {
+	encoded, err := json.Marshal(defaults)
+	if err != nil {
+		return nil, err
+	}
+	a := &controlAPI{token: token, defaults: encoded, local: local}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", a.start)
+	mux.HandleFunc("/stop", a.control(func(runner *stress.Runner) error {
+		runner.Stop()
+		return nil
+	}))
+	mux.HandleFunc("/pause", a.control(func(runner *stress.Runner) error {
+		runner.Pause()
+		return nil
+	}))
+	mux.HandleFunc("/resume", a.control(func(runner *stress.Runner) error {
+		runner.Resume()
+		return nil
+	}))
+	mux.HandleFunc("/rate", a.set(func(runner *stress.Runner, value int) error {
+		return runner.SetRate(value)
+	}))
+	mux.HandleFunc("/concurrency", a.set(func(runner *stress.Runner, value int) error {
+		return runner.SetConcurrency(value)
+	}))
+	mux.HandleFunc("/stats", a.stats)
+	return mux, nil
+}
+
+// authorized tells whether a request holds the token, and answers it otherwise
+func (a *controlAPI) authorized(w http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method != method {
+		http.Error(w, fmt.Sprintf("expected a %s request", method), http.StatusMethodNotAllowed)
+		return false
+	}
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (a *controlAPI) start(w http.ResponseWriter, req *http.Request) {
+	if !a.authorized(w, req, http.MethodPost) {
+		return
+	}
+	var definition testDefinition
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&definition); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("invalid test definition: %s", err), http.StatusBadRequest)
+		return
+	}
+	cfg := stress.NewConfig()
+	if err := json.Unmarshal(a.defaults, cfg); err != nil {
+		http.Error(w, fmt.Sprintf("unable to copy the options of the command line: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if err := definition.apply(cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.local(cfg)
+	cfg.OnInterval = func(stats *stress.Stats) {
+		record := newStatsRecord("interval", stats)
+		a.mu.Lock()
+		a.interval = &record
+		a.mu.Unlock()
+		reportInterval(stats)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.running {
+		http.Error(w, "already running a test", http.StatusConflict)
+		return
+	}
+	runner, err := stress.NewRunner(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.runner = runner
+	a.running = true
+	a.started = time.Now()
+	a.interval = nil
+	a.summary = nil
+	go a.run(runner, cfg.Concurrency)
+	a.writeStatus(w, http.StatusAccepted)
+}
+
+// run runs a test in the background, and keeps its totals
+func (a *controlAPI) run(runner *stress.Runner, concurrency int) {
+	printBanner("%s", aurora.Faint(fmt.Sprintf("Running a test with %d threads.\n", concurrency)))
+	totals := runner.Run()
+	reportSummary(totals)
+	record := newStatsRecord("summary", totals)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.running = false
+	a.summary = &record
+}
+
+// control returns the handler of a POST acting on the running test
+func (a *controlAPI) control(action func(runner *stress.Runner) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if a.authorized(w, req, http.MethodPost) {
+			a.act(w, action)
+		}
+	}
+}
+
+// set returns the handler of a POST changing a value of the running test, given as JSON (e.g.
+// {"value": 1000})
+func (a *controlAPI) set(action func(runner *stress.Runner, value int) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !a.authorized(w, req, http.MethodPost) {
+			return
+		}
+		var body struct {
+			Value *int `json:"value"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Value == nil {
+			http.Error(w, `expected a value, e.g. {"value": 1000}`, http.StatusBadRequest)
+			return
+		}
+		a.act(w, func(runner *stress.Runner) error {
+			return action(runner, *body.Value)
+		})
+	}
+}
+
+// act applies an action to the running test, and answers with its new state
+func (a *controlAPI) act(w http.ResponseWriter, action func(runner *stress.Runner) error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.running {
+		http.Error(w, "no test running", http.StatusConflict)
+		return
+	}
+	if err := action(a.runner); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.writeStatus(w, http.StatusOK)
+}
+
+func (a *controlAPI) stats(w http.ResponseWriter, req *http.Request) {
+	if !a.authorized(w, req, http.MethodGet) {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.writeStatus(w, http.StatusOK)
+}
+
+// writeStatus answers with the state of the test, the lock being held
+func (a *controlAPI) writeStatus(w http.ResponseWriter, code int) {
+	status := controlStatus{State: "idle", Interval: a.interval, Summary: a.summary}
+	if a.runner != nil {
+		started := a.started
+		status.Started = &started
+		status.Rate = a.runner.Rate()
+		status.Concurrency = a.runner.ActiveThreads()
+		switch {
+		case !a.running:
+			status.State = "done"
+		case a.runner.Paused():
+			status.State = "paused"
+		default:
+			status.State = "running"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
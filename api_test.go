@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MickaelBergem/dnsstresss/stress"
+)
+
+// newTestAPI serves the API for a test of a resolver that never answers, until stopped
+func newTestAPI(t *testing.T) *httptest.Server {
+	defaults := stress.NewConfig()
+	defaults.Resolvers = []string{"127.0.0.1:5499"}
+	defaults.Domains = []string{"example.com."}
+	defaults.Concurrency = 2
+	defaults.Rate = 10
+	defaults.OnInterval = nil
+	handler, err := newControlAPI("secret", defaults, func(cfg *stress.Config) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func callAPI(t *testing.T, server *httptest.Server, method, path, token, body string) (int, controlStatus) {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var status controlStatus
+	if resp.Header.Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, status
+}
+
+func TestControlAPIAuthorization(t *testing.T) {
+	server := newTestAPI(t)
+	for _, test := range []struct {
+		method, path, token string
+		expected            int
+	}{
+		{http.MethodGet, "/stats", "", http.StatusUnauthorized},
+		{http.MethodGet, "/stats", "wrong", http.StatusUnauthorized},
+		{http.MethodPost, "/start", "", http.StatusUnauthorized},
+		{http.MethodPost, "/rate", "wrong", http.StatusUnauthorized},
+		{http.MethodPost, "/stats", "secret", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/start", "secret", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/concurrency", "secret", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/stop", "secret", http.StatusConflict}, // No test running
+		{http.MethodGet, "/stats", "secret", http.StatusOK},
+	} {
+		if code, _ := callAPI(t, server, test.method, test.path, test.token, ""); code != test.expected {
+			t.Errorf("Expected %d for %s %s with token %q, got %d", test.expected, test.method, test.path, test.token, code)
+		}
+	}
+}
+
+func TestControlAPIDefinition(t *testing.T) {
+	server := newTestAPI(t)
+	for _, body := range []string{
+		`{"QueryFile": "/etc/passwd"}`,
+		`{"Resolvers": ["192.0.2.1:53"]}`,
+		`{"duration": "soon"}`,
+		`{"transport": "carrier-pigeon"}`,
+		`{"types": "NOPE"}`,
+		`not json`,
+	} {
+		if code, _ := callAPI(t, server, http.MethodPost, "/start", "secret", body); code != http.StatusBadRequest {
+			t.Errorf("Expected the test definition %s to be rejected, got %d", body, code)
+		}
+	}
+}
+
+func TestControlAPITest(t *testing.T) {
+	quiet = true
+	defer func() { quiet = false }()
+	server := newTestAPI(t)
+	code, status := callAPI(t, server, http.MethodPost, "/start", "secret", `{"rate": 20, "duration": "1m", "transport": "tcp"}`)
+	if code != http.StatusAccepted || status.State != "running" {
+		t.Fatalf("Expected the test to start, got %d %+v", code, status)
+	}
+	waitStatus(t, server, func(status controlStatus) bool { return status.Rate == 20 })
+	if code, _ := callAPI(t, server, http.MethodPost, "/start", "secret", ""); code != http.StatusConflict {
+		t.Errorf("Expected a second test to be refused, got %d", code)
+	}
+
+	for _, test := range []struct {
+		path, body string
+		expected   int
+	}{
+		{"/rate", "", http.StatusBadRequest},
+		{"/rate", `{"rate": 100}`, http.StatusBadRequest},
+		{"/rate", `{"value": "fast"}`, http.StatusBadRequest},
+		{"/rate", `{"value": -1}`, http.StatusBadRequest},
+		{"/concurrency", `{}`, http.StatusBadRequest},
+		{"/concurrency", `{"value": 3}`, http.StatusBadRequest}, // Beyond the threads of the run
+		{"/rate", `{"value": 50}`, http.StatusOK},
+		{"/concurrency", `{"value": 1}`, http.StatusOK},
+	} {
+		if code, _ := callAPI(t, server, http.MethodPost, test.path, "secret", test.body); code != test.expected {
+			t.Errorf("Expected %d for %s with %s, got %d", test.expected, test.path, test.body, code)
+		}
+	}
+	if _, status := callAPI(t, server, http.MethodGet, "/stats", "secret", ""); status.Rate != 50 || status.Concurrency != 1 {
+		t.Errorf("Expected a rate of 50 with 1 thread, got %+v", status)
+	}
+
+	if code, _ := callAPI(t, server, http.MethodPost, "/stop", "secret", ""); code != http.StatusOK {
+		t.Fatalf("Expected the test to stop, got %d", code)
+	}
+	if status := waitStatus(t, server, func(status controlStatus) bool { return status.State == "done" }); status.Summary == nil {
+		t.Errorf("Expected the summary of the test")
+	}
+}
+
+// waitStatus polls the stats of the API until the condition holds, for 5 seconds at most
+func waitStatus(t *testing.T, server *httptest.Server, condition func(status controlStatus) bool) controlStatus {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, status := callAPI(t, server, http.MethodGet, "/stats", "secret", "")
+		if condition(status) {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected state of the test: %+v", status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// targets are the domains given as arguments, or in the configuration file
+var targets []string
+
+// targetsKey lists the target domains in a configuration file, like the arguments of the command
+const targetsKey = "targets"
+
+// loadConfigFile applies the options of a YAML file to the flags that are not given on the
+// command line. The keys are the names of the flags, and lists are joined with commas (e.g.
+// "r: [192.0.2.1, 192.0.2.2]" for "-r 192.0.2.1,192.0.2.2"), or give each value of the flags
+// that can be repeated. The target domains are only taken from the file when there are none on
+// the command line.
+func loadConfigFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var options map[string]interface{}
+	if err := yaml.Unmarshal(content, &options); err != nil {
+		return err
+	}
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values, err := configValues(options[key])
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		switch {
+		case key == targetsKey:
+			if len(targets) == 0 {
+				targets = values
+			}
+		case key == "config":
+			return fmt.Errorf("a configuration file cannot load another one")
+		case flag.Lookup(key) == nil:
+			return fmt.Errorf("unknown option %q", key)
+		case !explicit[key]:
+			if _, repeatable := flag.Lookup(key).Value.(*listFlag); repeatable {
+				for _, value := range values {
+					if err := flag.Set(key, value); err != nil {
+						return fmt.Errorf("%s: invalid value %q", key, value)
+					}
+				}
+				continue
+			}
+			value := strings.Join(values, ",")
+			if err := flag.Set(key, value); err != nil {
+				return fmt.Errorf("%s: invalid value %q", key, value)
+			}
+		}
+	}
+	return nil
+}
+
+// configValues returns the value of an option as text, or the elements of a list
+func configValues(value interface{}) ([]string, error) {
+	switch value := value.(type) {
+	case nil:
+		return nil, fmt.Errorf("missing value")
+	case []interface{}:
+		values := make([]string, len(value))
+		for index, element := range value {
+			switch element.(type) {
+			case nil, []interface{}, map[string]interface{}:
+				return nil, fmt.Errorf("expected a list of values")
+			}
+			values[index] = fmt.Sprint(element)
+		}
+		return values, nil
+	case map[string]interface{}:
+		return nil, fmt.Errorf("expected a value or a list of values")
+	}
+	return []string{fmt.Sprint(value)}, nil
+}
+
+// listFlag is a flag that can be given several times, its values are kept in order
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// configFlags are flags of a private command line, replacing the one of the package for a test
+type configFlags struct {
+	resolvers string
+	rate      int
+	verbose   bool
+	headers   listFlag
+}
+
+// setUpConfigFile writes a configuration file and parses the arguments on a private command line
+func setUpConfigFile(t *testing.T, content string, args ...string) (*configFlags, string) {
+	commandLine, previousTargets := flag.CommandLine, targets
+	t.Cleanup(func() {
+		flag.CommandLine, targets = commandLine, previousTargets
+	})
+	flags := &configFlags{}
+	flag.CommandLine = flag.NewFlagSet("dnsstresss", flag.ContinueOnError)
+	flag.StringVar(&flags.resolvers, "r", "127.0.0.1", "")
+	flag.IntVar(&flags.rate, "rate", 0, "")
+	flag.BoolVar(&flags.verbose, "v", false, "")
+	flag.Var(&flags.headers, "doh-header", "")
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	targets = flag.Args()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return flags, path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	flags, path := setUpConfigFile(t, `
+r: [192.0.2.1, 192.0.2.2]
+rate: 100
+v: true
+doh-header: ["X-A: 1", "X-B: 2"]
+targets: [example.com., example.org.]
+`)
+	if err := loadConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if flags.resolvers != "192.0.2.1,192.0.2.2" || flags.rate != 100 || !flags.verbose {
+		t.Errorf("Unexpected flags from the file: %+v", flags)
+	}
+	if expected := (listFlag{"X-A: 1", "X-B: 2"}); !reflect.DeepEqual(flags.headers, expected) {
+		t.Errorf("Expected each header to be set, got %q", flags.headers)
+	}
+	if expected := []string{"example.com.", "example.org."}; !reflect.DeepEqual(targets, expected) {
+		t.Errorf("Expected the targets of the file, got %v", targets)
+	}
+}
+
+func TestLoadConfigFileCommandLine(t *testing.T) {
+	flags, path := setUpConfigFile(t, `
+r: 192.0.2.1
+rate: 100
+doh-header: "X-A: 1"
+targets: example.org.
+`, "-rate", "5", "-doh-header", "X-C: 3", "example.net.")
+	if err := loadConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if flags.resolvers != "192.0.2.1" || flags.rate != 5 {
+		t.Errorf("Expected the command line to win over the file, got %+v", flags)
+	}
+	if expected := (listFlag{"X-C: 3"}); !reflect.DeepEqual(flags.headers, expected) {
+		t.Errorf("Expected the headers of the command line only, got %q", flags.headers)
+	}
+	if expected := []string{"example.net."}; !reflect.DeepEqual(targets, expected) {
+		t.Errorf("Expected the targets of the command line, got %v", targets)
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	for _, test := range []struct {
+		content, expected string
+	}{
+		{"config: other.yaml", "cannot load another one"},
+		{"unknown: 1", `unknown option "unknown"`},
+		{"rate: fast", `rate: invalid value "fast"`},
+		{"r:", "r: missing value"},
+		{"r: {a: 1}", "r: expected a value or a list of values"},
+		{"r: [[192.0.2.1]]", "r: expected a list of values"},
+		{"[not, a, map]", "cannot unmarshal"},
+	} {
+		_, path := setUpConfigFile(t, test.content)
+		if err := loadConfigFile(path); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expected an error with %q for %q, got %v", test.expected, test.content, err)
+		}
+	}
+}
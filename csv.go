@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// csvStats writes one row of statistics per record
+type csvStats struct {
+	writer      *csv.Writer
+	wroteHeader bool
+}
+
+func newCSVStats(w io.Writer) *csvStats {
+	return &csvStats{writer: csv.NewWriter(w)}
+}
+
+func csvHeader() []string {
+	header := []string{"type", "timestamp", "warmup", "duration_s", "sent", "received", "errors", "timeouts", "retries", "qps", "avg_latency_ms", "max_latency_ms"}
+	for _, percentile := range latencyPercentiles {
+		header = append(header, percentileName(percentile)+"_latency_ms")
+	}
+	// Added after the percentiles, to keep the columns of the previous versions in place
+	return append(header, "stddev_latency_ms", "iqr_latency_ms")
+}
+
+// writeRecord appends a row, and flushes it right away so that a killed run still has its
+// partial data. The header is written along with the first row.
+func (c *csvStats) writeRecord(record statsRecord) error {
+	if !c.wroteHeader {
+		if err := c.write(csvHeader()); err != nil {
+			return err
+		}
+		c.wroteHeader = true
+	}
+	row := []string{
+		record.Type,
+		record.Timestamp.Format(time.RFC3339Nano),
+		strconv.FormatBool(record.Warmup),
+		strconv.FormatFloat(record.Duration, 'f', 3, 64),
+		strconv.Itoa(record.Sent),
+		strconv.Itoa(record.Received),
+		strconv.Itoa(record.Errors),
+		strconv.Itoa(record.Timeouts),
+		strconv.Itoa(record.Retries),
+		strconv.FormatFloat(record.QPS, 'f', 1, 64),
+		strconv.FormatFloat(record.MeanLatency, 'f', 3, 64),
+		strconv.FormatFloat(record.MaxLatency, 'f', 3, 64),
+	}
+	for _, percentile := range latencyPercentiles {
+		row = append(row, strconv.FormatFloat(record.Percentiles[percentileName(percentile)], 'f', 3, 64))
+	}
+	row = append(row, strconv.FormatFloat(record.StdDev, 'f', 3, 64), strconv.FormatFloat(record.IQR, 'f', 3, 64))
+	return c.write(row)
+}
+
+func (c *csvStats) write(row []string) error {
+	if err := c.writer.Write(row); err != nil {
+		return err
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
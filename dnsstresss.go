@@ -1,58 +1,363 @@
 package main
 
 import (
-	"crypto/rand"
 	"encoding/base64"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"math/big"
+	"log"
+	"math"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/MickaelBergem/dnsstresss/stress"
 	"github.com/logrusorgru/aurora"
 	"github.com/miekg/dns"
 )
 
+// defaultAutoConcurrency is the maximum number of threads of -auto-concurrency, unless given
+const defaultAutoConcurrency = 1000
+
 // Runtime options
 var (
 	concurrency     int
+	autoConcurrency bool
+	slaErrorRate    string
+	slaP99          time.Duration
+	findMax         bool
+	findMaxStart    int
+	findMaxWindow   time.Duration
 	displayInterval int
 	verbose         bool
 	iterative       bool
+	opcode          string
+	headerFlags     string
 	resolver        string
+	distribution    string
+	resolverWeights string
 	randomIds       bool
 	flood           bool
 	dohEndpoint     string
+	source          string
+	rampup          time.Duration
+	metricsAddr     string
+	webAddr         string
+	count           int
+	retries         int
+	retryTCP        bool
+	floodBatch      int
+	inFlight        int
+	timeout         time.Duration
+	dohHTTP2        bool
+	dohHTTP3        bool
+	dohMaxIdle      int
+	dohMethod       string
+	dohHeaders      listFlag
+	dohToken        string
+	dohBasicAuth    string
+	quiet           bool
+	perDomain       bool
+	logFile         string
+	logLevel        string
+	configPath      string
+	maxErrorRate    string
+	maxP99          time.Duration
+	weightedDomains string
+	csvPath         string
+	recordPath      string
+	sinkURLs        string
+	otlpTraceRatio  float64
+	queryPattern    string
+	patternRandom   bool
+	ptrRanges       string
+	ptrRandom       bool
+	reuseConn       bool
+	poolSize        int
+	reusePort       bool
+	warmup          time.Duration
+	typesMix        string
+	queryType       string
+	ixfrSerial      uint
+	randomPrefix    bool
+	wordlistPath    string
+	wordlistDepth   int
+	randomCase      bool
+	update          bool
+	rate            int
+	ramp            string
+	arrivals        string
+	burst           int
+	burstInterval   time.Duration
+	duration        time.Duration
+	outputFormat    string
+	tcp             bool
+	dot             bool
+	doq             bool
+	tlsServerName   string
+	insecure        bool
+	tlsCert         string
+	tlsKey          string
+	ednsBufSize     int
+	amplification   bool
+	fuzz            float64
+	trackTTL        bool
+	soaZones        string
+	soaInterval     time.Duration
+	dnssecOK        bool
+	ednsPadding     int
+	cookies         bool
+	clientSubnets   string
+	subnetLength    int
+	tsigKey         string
+	dnssecValidate  bool
+	dnssecAnchors   string
+	queryFile       string
+	replayPath      string
+	replaySpeed     float64
+	qfileInOrder    bool
+	expect          string
+	ipv4Only        bool
+	ipv6Only        bool
+	agentAddr       string
+	agentsList      string
+	agentToken      string
+	listenAddr      string
+	listenToken     string
+	tui             bool
+	compareWith     string
 )
 
+// bannerOutput is where the informative messages are printed
+var bannerOutput io.Writer = os.Stdout
+
 func init() {
+	flag.BoolVar(&ipv4Only, "4", false,
+		"Only use IPv4 to reach the resolvers")
+	flag.BoolVar(&ipv6Only, "6", false,
+		"Only use IPv6 to reach the resolvers, querying AAAA records unless -type or -types is given (-r defaults to ::1)")
+	flag.StringVar(&agentAddr, "agent", "",
+		"Run as an agent, listening on this address for the tests of a controller (e.g. :8053)")
+	flag.StringVar(&agentsList, "agents", "",
+		"Run the test from these comma-separated agents instead, combining their stats (e.g. host1:8053,host2:8053)")
+	flag.StringVar(&agentToken, "agent-token", "",
+		"Secret shared by the controller and the agents, required with -agent and -agents")
+	flag.StringVar(&listenAddr, "listen", "",
+		"Serve a REST API on this address to start, stop, reconfigure and follow the tests of the command line, instead of running one (e.g. :8054)")
+	flag.StringVar(&listenToken, "listen-token", "",
+		"Secret the clients of -listen send as a bearer token, required with -listen")
 	flag.IntVar(&concurrency, "concurrency", 50,
 		"Internal buffer")
+	flag.BoolVar(&autoConcurrency, "auto-concurrency", false,
+		"Adjust the number of threads to get the highest rate of answers within -sla-error-rate and -sla-p99, up to -concurrency (1000 unless given)")
+	flag.StringVar(&slaErrorRate, "sla-error-rate", "1%",
+		"Share of errors the resolvers are kept under with -auto-concurrency and -find-max")
+	flag.DurationVar(&slaP99, "sla-p99", 0,
+		"p99 latency the resolvers are kept under with -auto-concurrency and -find-max (e.g. 20ms)")
+	flag.BoolVar(&findMax, "find-max", false,
+		"Search the highest rate the resolvers keep up with within -sla-error-rate and -sla-p99, doubling it from -find-max-start then by bisection")
+	flag.IntVar(&findMaxStart, "find-max-start", 1000,
+		"First rate tried by -find-max, in queries per second")
+	flag.DurationVar(&findMaxWindow, "find-max-window", 10*time.Second,
+		"Duration each rate is held for by -find-max")
 	flag.IntVar(&displayInterval, "d", 1000,
 		"Update interval of the stats (in ms)")
 	flag.BoolVar(&verbose, "v", false,
 		"Verbose logging")
-	flag.BoolVar(&randomIds, "random", false,
+	flag.BoolVar(&randomIds, "random", true,
 		"Use random Request Identifiers for each query")
 	flag.BoolVar(&iterative, "i", false,
 		"Do an iterative query instead of recursive (to stress authoritative nameservers)")
+	flag.StringVar(&opcode, "opcode", "QUERY",
+		"Opcode of the queries, by name (QUERY, IQUERY, STATUS or NOTIFY) or by value from 0 to 15")
+	flag.StringVar(&headerFlags, "header-flags", "",
+		"Comma-separated bits of the header set on the queries: aa, ad, cd and z (e.g. cd,z)")
 	flag.StringVar(&resolver, "r", "127.0.0.1:53",
-		"Resolver to test against")
+		"Resolver to test against, or comma-separated list of resolvers (system for the ones configured on the system)")
+	flag.StringVar(&compareWith, "compare-r", "",
+		"Also send each query to this resolver, comparing its latency, response codes and answers with the ones of -r")
+	flag.StringVar(&distribution, "resolver-strategy", "round-robin",
+		"How queries are distributed over several resolvers: round-robin, weighted or hash (of the query name)")
+	flag.StringVar(&resolverWeights, "resolver-weights", "",
+		"Comma-separated weights of the resolvers, for the weighted strategy (e.g. 3,1)")
+	flag.IntVar(&inFlight, "inflight", 0,
+		"Maximum number of queries each thread keeps waiting for an answer, on sockets whose answers are read in the background (implies -f)")
+	flag.IntVar(&floodBatch, "batch", 32,
+		"Number of queries sent at once with -f over UDP when there is no rate, using a single sendmmsg call on Linux (1 to send them one by one)")
 	flag.BoolVar(&flood, "f", false,
 		"Don't wait for an answer before sending another")
 	flag.StringVar(&dohEndpoint, "doh", "",
 		"DOH endpoint to use for DNS over HTTPS requests")
+	flag.BoolVar(&dohHTTP2, "doh-http2", false,
+		"Require HTTP/2 for DOH requests")
+	flag.BoolVar(&dohHTTP3, "doh-http3", false,
+		"Send the DOH requests over HTTP/3 (QUIC), on a connection shared by the threads")
+	flag.IntVar(&dohMaxIdle, "doh-max-idle-conns", 0,
+		"Maximum number of idle DOH connections kept open (defaults to the concurrency)")
+	flag.StringVar(&dohMethod, "doh-method", "GET",
+		"HTTP method used for DOH requests: GET or POST")
+	flag.Var(&dohHeaders, "doh-header",
+		"HTTP header added to the DOH requests, as \"Name: value\" (can be repeated)")
+	flag.StringVar(&dohToken, "doh-token", "",
+		"Bearer token sent in the Authorization header of the DOH requests")
+	flag.StringVar(&dohBasicAuth, "doh-basic-auth", "",
+		"Credentials sent with HTTP basic authentication in the DOH requests, as user:password")
+	flag.BoolVar(&tui, "tui", false,
+		"Display the stats in an interactive dashboard, with keys to pause (p), change the rate (+/-) and quit (q)")
+	flag.BoolVar(&perDomain, "per-domain", false,
+		"Break the stats of each interval and the summary down by target domain, when there are several of them")
+	flag.BoolVar(&quiet, "quiet", false,
+		"Only print the final summary")
+	flag.StringVar(&configPath, "config", "",
+		"YAML file of options, named like the flags and overridden by the ones given on the command line (targets lists the domains)")
+	flag.StringVar(&logFile, "log-file", "",
+		"Write the periodic stats, and the logs of -log-level, to this file instead of the standard output")
+	flag.StringVar(&logLevel, "log-level", "",
+		"Log JSON records along with the stats: error for the failed queries, info for the messages of the threads too (like -v), debug for every query")
+	flag.StringVar(&maxErrorRate, "max-error-rate", "",
+		"Exit with status 3 at the end of the run if the share of errors is above this threshold (e.g. 1%)")
+	flag.DurationVar(&maxP99, "max-p99", 0,
+		"Exit with status 3 at the end of the run if the p99 latency is above this threshold (e.g. 50ms)")
+	flag.StringVar(&weightedDomains, "domains", "",
+		"Weighted target domains (e.g. example.com:70,cdn.example.com:30)")
+	flag.StringVar(&recordPath, "record", "",
+		"Append the stats of each interval and the summary to this file, as JSON lines (e.g. results.ndjson)")
+	flag.StringVar(&sinkURLs, "sink", "",
+		"Comma-separated metrics sinks to push the stats of each interval to: statsd://host:port, influx://host:port, influx+http://host:port/write?db=name, graphite://host:port or otlp+http://host:4318")
+	flag.Float64Var(&otlpTraceRatio, "otlp-traces", 0,
+		"Share of the queries exported as trace spans to the first otlp+http sink (e.g. 0.01)")
+	flag.StringVar(&csvPath, "csv", "",
+		"Write the stats of each interval to this CSV file")
+	flag.StringVar(&outputFormat, "output", "text",
+		"Format of the stats: text, json or csv")
+	flag.StringVar(&expect, "expect", "",
+		"Comma-separated expected answers, mismatches are counted separately (e.g. example.com.=93.184.216.34,example.com./AAAA=2001:db8::1)")
+	flag.StringVar(&queryFile, "qfile", "",
+		"Read the queries from this file, with one \"name qtype [weight]\" per line (reloaded on SIGHUP)")
+	flag.BoolVar(&qfileInOrder, "qfile-in-order", false,
+		"Send the queries of -qfile in the order of the file, looping at its end, instead of picking them at random")
+	flag.StringVar(&replayPath, "replay", "",
+		"Replay the DNS queries over UDP of this packet capture (pcap or pcapng), in order, once unless -count or -duration is set")
+	flag.Float64Var(&replaySpeed, "replay-speed", 0,
+		"Send the queries of -replay at the timing of the capture, sped up by this factor (e.g. 1 for the original timing, 10 for ten times faster)")
+	flag.StringVar(&queryPattern, "query-pattern", "",
+		"Query names generated from a pattern instead of target domains (e.g. host-%d.zone.example.)")
+	flag.BoolVar(&patternRandom, "query-pattern-random", false,
+		"Expand the query pattern with random integers instead of incrementing ones")
+	flag.StringVar(&ptrRanges, "ptr", "",
+		"Query the reverse names of the addresses of CIDR ranges instead of target domains, comma-separated (e.g. 10.0.0.0/16)")
+	flag.BoolVar(&ptrRandom, "ptr-random", false,
+		"Query random addresses of the -ptr ranges instead of sweeping them in order")
+	flag.IntVar(&poolSize, "pool", 0,
+		"Number of TCP or TLS connections to each resolver shared by the threads, which pipeline their queries on them (0 for one per thread)")
+	flag.BoolVar(&reuseConn, "reuse-conn", true,
+		"Keep one connection per thread for all its queries instead of dialing for each (ignored when flooding)")
+	flag.BoolVar(&reusePort, "reuseport", false,
+		"Set SO_REUSEPORT on the sockets, so that all the threads can send from the same -source port")
+	flag.DurationVar(&warmup, "warmup", 0,
+		"Duration at the beginning of the run excluded from the summary (e.g. 5s)")
+	flag.IntVar(&rate, "rate", 0,
+		"Maximum number of queries per second, shared by all the threads (0 for no limit)")
+	flag.StringVar(&arrivals, "distribution", "constant",
+		"Timing of the queries sent at the -rate or -ramp: constant, or poisson for exponentially distributed intervals between the queries of each thread")
+	flag.IntVar(&burst, "burst", 0,
+		"Send the queries by bursts of this size, as fast as possible, idle until the next one (see -burst-interval)")
+	flag.DurationVar(&burstInterval, "burst-interval", time.Second,
+		"Time between the starts of two bursts of -burst")
+	flag.StringVar(&ramp, "ramp", "",
+		"Load profile followed by the send rate, as comma-separated from:to:duration steps in queries per second, instead of -rate (e.g. 0:1000qps:60s,1000:5000:120s)")
+	flag.BoolVar(&randomCase, "0x20", false,
+		"Randomize the case of the query names (DNS 0x20), counting the answers that do not keep it as case mismatches")
+	flag.BoolVar(&randomPrefix, "random-prefix", false,
+		"Prepend a random label to each query name, so that it misses the resolver cache")
+	flag.StringVar(&wordlistPath, "wordlist", "",
+		"File of labels, one per line, prepended at random to the target domains so that the names come back like real ones (see -wordlist-depth)")
+	flag.IntVar(&wordlistDepth, "wordlist-depth", 1,
+		"Highest number of labels of -wordlist prepended to each query name, from 1 up to it")
+	flag.BoolVar(&dot, "dot", false,
+		"Send the queries over TLS (DNS over TLS, port 853 by default), with one persistent connection per thread")
+	flag.BoolVar(&doq, "doq", false,
+		"Send the queries over QUIC (DNS over QUIC, port 853 by default), with one stream per query on a shared connection")
+	flag.StringVar(&tlsServerName, "tls-servername", "",
+		"Server name used to verify the certificate of the resolver (defaults to the resolver address)")
+	flag.BoolVar(&insecure, "insecure", false,
+		"Do not verify the certificate of the resolver or DOH endpoint")
+	flag.StringVar(&tlsCert, "tls-cert", "",
+		"PEM file of the client certificate presented to the resolver or DOH endpoint, over TLS, QUIC or HTTPS")
+	flag.StringVar(&tlsKey, "tls-key", "",
+		"PEM file of the private key of the client certificate (defaults to -tls-cert)")
+	flag.BoolVar(&amplification, "amplification", false,
+		"Measure the size of the answers relative to the queries by name and type, querying ANY, DNSKEY and TXT records with a 4096 bytes EDNS buffer unless -type and -edns-bufsize are given")
+	flag.BoolVar(&trackTTL, "track-ttl", false,
+		"Infer from the TTLs of the answers whether they came from the cache of the resolvers, and report the hit ratio by domain")
+	flag.Float64Var(&fuzz, "fuzz", 0,
+		"Share of the queries replaced by malformed packets (e.g. 0.05), counting how the resolvers react to them")
+	flag.StringVar(&soaZones, "soa", "",
+		"Comma-separated zones whose SOA record is checked on each resolver apart from the load, reporting the changes of their serial and the latency of the checks (see -soa-interval)")
+	flag.DurationVar(&soaInterval, "soa-interval", 5*time.Second,
+		"Time between two checks of the SOA records of -soa")
+	flag.IntVar(&ednsBufSize, "edns-bufsize", 0,
+		"Add an EDNS OPT record advertising this UDP buffer size (0 for no OPT record, 1232 when one is needed)")
+	flag.BoolVar(&dnssecOK, "dnssec", false,
+		"Set the DNSSEC OK bit, to get the signatures along with the answers")
+	flag.BoolVar(&dnssecValidate, "dnssec-validate", false,
+		"Verify the DNSSEC signatures of the answers up to the root zone (or -dnssec-anchors), counting failures separately")
+	flag.StringVar(&dnssecAnchors, "dnssec-anchors", "",
+		"Comma-separated DS records trusted instead of the root zone ones (e.g. \"example. IN DS 12345 13 2 ...\")")
+	flag.IntVar(&ednsPadding, "edns-padding", 0,
+		"Pad the queries to a multiple of this block size using EDNS padding (e.g. 128, 0 for no padding)")
+	flag.StringVar(&clientSubnets, "ecs", "",
+		"Comma-separated EDNS Client Subnet prefixes sent in the queries, one of them picked at random for each query (e.g. 203.0.113.0/24)")
+	flag.IntVar(&subnetLength, "ecs-random", 0,
+		"Send random subnets of this prefix length within the -ecs prefixes instead (e.g. 24 with -ecs 10.0.0.0/8)")
+	flag.StringVar(&tsigKey, "tsig", "",
+		"Sign the queries with this TSIG key given as name:algorithm:secret, verifying the signatures of the answers (e.g. key.example.:hmac-sha256:c2VjcmV0)")
+	flag.BoolVar(&cookies, "cookies", false,
+		"Send an EDNS cookie (RFC 7873) in the queries, echoing the server cookie returned by each resolver")
+	flag.BoolVar(&tcp, "tcp", false,
+		"Send the queries over TCP, with one persistent connection per thread")
+	flag.BoolVar(&update, "update", false,
+		"Send dynamic updates adding records to the target domains as zones, each one deleted by the next update, instead of queries")
+	flag.StringVar(&queryType, "type", "A",
+		"Record type to query, or comma-separated list of types (e.g. AAAA or A,AAAA,MX)")
+	flag.UintVar(&ixfrSerial, "ixfr-serial", 0,
+		"Serial of the version of the zones the transfers start from, with -type IXFR")
+	flag.StringVar(&typesMix, "types", "",
+		"Weighted mix of record types to query, instead of -type (e.g. A:50,AAAA:40,HTTPS:10)")
+	flag.StringVar(&typesMix, "type-mix", "",
+		"Same as -types")
+	flag.StringVar(&source, "source", "",
+		"Local source addresses to send queries from, spread over the threads: comma-separated IPs, IP:port pairs or interface names")
+	flag.DurationVar(&rampup, "rampup", 0,
+		"Spread the start of the threads over this duration (e.g. 10s)")
+	flag.StringVar(&metricsAddr, "metrics-addr", "",
+		"Address to expose Prometheus metrics on (e.g. :9090)")
+	flag.StringVar(&metricsAddr, "metrics-listen", "",
+		"Same as -metrics-addr")
+	flag.StringVar(&webAddr, "web", "",
+		"Address to serve a dashboard charting the rates, errors and latency percentiles of the run live on (e.g. :8080)")
+	flag.IntVar(&count, "count", 0,
+		"Stop after sending this number of queries in total (0 for no limit)")
+	flag.DurationVar(&duration, "duration", 0,
+		"Stop after running for this duration (e.g. 30s, 0 for no limit)")
+	flag.IntVar(&retries, "retries", 0,
+		"Number of times a failed query is retried before counting it as an error")
+	flag.DurationVar(&timeout, "timeout", 2*time.Second,
+		"Time to wait for each answer before counting the query as timed out (e.g. 500ms)")
+	flag.BoolVar(&retryTCP, "retry-tcp", false,
+		"Send the queries again over TCP when their answers are truncated, as a stub resolver would")
 }
 
 func main() {
-	fmt.Printf("dnsstresss - dns stress tool\n\n")
-
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, strings.Join([]string{
+			"dnsstresss - dns stress tool",
+			"",
 			"Send DNS requests as fast as possible to a given server and display the rate.",
 			"",
 			"Usage: dnsstresss [option ...] targetdomain [targetdomain [...] ]",
@@ -62,187 +367,773 @@ func main() {
 	}
 
 	flag.Parse()
+	targets = flag.Args()
+	if configPath != "" {
+		if err := loadConfigFile(configPath); err != nil {
+			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to load the configuration file", err))
+			os.Exit(2)
+		}
+	}
+
+	var logOutput io.Writer = os.Stdout
+	if logFile != "" {
+		file, err := os.Create(logFile)
+		if err != nil {
+			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to open the log file", err))
+			os.Exit(2)
+		}
+		defer file.Close()
+		logOutput = file
+		statsColors = aurora.NewAurora(false)
+	}
+	statsOutput = logOutput
+	if logLevel != "" {
+		var err error
+		if queryLog, err = newQueryLogger(logLevel, logOutput); err != nil {
+			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to set up the logs", err))
+			os.Exit(2)
+		}
+	}
+	if quiet && logFile == "" {
+		statsOutput = ioutil.Discard
+	}
+
+	switch outputFormat {
+	case "text":
+	case "json":
+		recordOutput = newJSONStats(logOutput)
+	case "csv":
+		recordOutput = newCSVStats(logOutput)
+	default:
+		fmt.Println(aurora.Sprintf(aurora.Red("Unknown output format %q (expected text, json or csv)"), outputFormat))
+		os.Exit(2)
+	}
+	if recordOutput != nil && logFile == "" {
+		// The records are written to the standard output, keep it parseable
+		bannerOutput = os.Stderr
+	}
+
+	if tui && (verbose || quiet || (recordOutput != nil || queryLog != nil) && logFile == "") {
+		fmt.Println(aurora.Red("The dashboard cannot be used along with -v, -quiet, or records and logs written to the standard output"))
+		os.Exit(2)
+	}
+
+	if csvPath != "" {
+		file, err := os.Create(csvPath)
+		if err != nil {
+			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to open the CSV file", err))
+			os.Exit(2)
+		}
+		defer file.Close()
+		csvOutput = newCSVStats(file)
+	}
+	if recordPath != "" {
+		file, err := os.OpenFile(recordPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to open the record file", err))
+			os.Exit(2)
+		}
+		defer file.Close()
+		timelineOutput = newJSONStats(file)
+	}
+	if sinkURLs != "" {
+		var sinks multiSink
+		for _, sinkURL := range strings.Split(sinkURLs, ",") {
+			sink, err := newSink(strings.TrimSpace(sinkURL))
+			if err != nil {
+				fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the metrics sink", err))
+				os.Exit(2)
+			}
+			sinks = append(sinks, sink)
+			if otlp, ok := sink.(*otlpSink); ok && otlpTraceRatio > 0 && querySpans == nil {
+				querySpans = newSpanBuffer(otlpTraceRatio)
+				otlp.spans = querySpans
+			}
+		}
+		sinkOutput = sinks
+	}
+	if otlpTraceRatio != 0 && (querySpans == nil || otlpTraceRatio > 1) {
+		fmt.Println(aurora.Red("The share of the queries exported as spans needs an otlp+http sink, and has to be between 0 and 1"))
+		os.Exit(2)
+	}
+	if querySpans != nil && (agentsList != "" || flood || inFlight > 0) {
+		fmt.Println(aurora.Red("The spans of the queries are not available for distributed tests or when flooding"))
+		os.Exit(2)
+	}
+
+	printBanner("dnsstresss - dns stress tool\n\n")
+
+	if (agentAddr != "" || agentsList != "") && metricsAddr != "" {
+		fmt.Println(aurora.Red("The metrics are not available for distributed tests"))
+		os.Exit(2)
+	}
+	if agentAddr != "" && webAddr != "" {
+		fmt.Println(aurora.Red("The web dashboard is served by the controller, not by the agents"))
+		os.Exit(2)
+	}
+	if agentAddr != "" {
+		serveAgent()
+		return
+	}
+	if listenAddr != "" {
+		serveControl()
+		return
+	}
 
 	// We need at least one target domain
-	if flag.NArg() < 1 {
+	if len(targets) < 1 && weightedDomains == "" && queryPattern == "" && queryFile == "" && replayPath == "" && ptrRanges == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	// Process target domains
-	targetDomains := make([]string, flag.NArg())
-	for index, element := range flag.Args() {
-		if element[len(element)-1] == '.' {
-			targetDomains[index] = element
-		} else {
-			targetDomains[index] = element + "."
-		}
+	cfg := newConfig()
+	parseThresholds()
+	runner, err := stress.NewRunner(cfg)
+	if err != nil {
+		fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to set up the test", err))
+		os.Exit(2)
 	}
 
 	// Display resolver or DOH endpoint information
-	if dohEndpoint != "" {
-		fmt.Printf("Testing DOH endpoint: %s.\n", aurora.Bold(dohEndpoint))
+	var target string
+	if cfg.DOHEndpoint != "" {
+		method := cfg.DOHMethod
+		if cfg.DOHHTTP3 {
+			method += " over HTTP/3"
+		}
+		target = fmt.Sprintf("%s (using %s)", cfg.DOHEndpoint, method)
+		printBanner("Testing DOH endpoint: %s (using %s).\n", aurora.Bold(cfg.DOHEndpoint), method)
+	} else if len(cfg.Resolvers) == 1 {
+		target = fmt.Sprintf("%s (over %s)", cfg.Resolvers[0], runner.TransportName())
+		printBanner("Testing resolver: %s (over %s).\n", aurora.Bold(cfg.Resolvers[0]), runner.TransportName())
 	} else {
-		parsedResolver, err := ParseIPPort(resolver)
-		resolver = parsedResolver
-		if err != nil {
-			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the resolver address", err))
-			os.Exit(2)
+		target = fmt.Sprintf("%s (over %s, %s)", strings.Join(cfg.Resolvers, ", "), runner.TransportName(), distribution)
+		printBanner("Testing resolvers: %s (over %s, %s).\n", aurora.Bold(strings.Join(cfg.Resolvers, ", ")), runner.TransportName(), distribution)
+	}
+	if cfg.CompareResolver != "" {
+		printBanner("Comparing with: %s.\n", aurora.Bold(cfg.CompareResolver))
+	}
+	var agents []string
+	if agentsList != "" {
+		agents = strings.Split(agentsList, ",")
+		printBanner("Running from agents: %s.\n", aurora.Bold(strings.Join(agents, ", ")))
+	}
+	if cfg.Sources != nil {
+		addresses := make([]string, len(cfg.Sources))
+		for index, source := range cfg.Sources {
+			addresses[index] = source.String()
 		}
-		fmt.Printf("Testing resolver: %s.\n", aurora.Bold(resolver))
+		printBanner("Sending from: %s.\n", aurora.Bold(strings.Join(addresses, ", ")))
 	}
 
-	fmt.Printf("Target domains: %v.\n\n", targetDomains)
+	if metricsAddr != "" {
+		startMetricsServer(metricsAddr, runner.Metrics())
+		printBanner("Exposing metrics on: %s.\n", aurora.Bold(metricsAddr+"/metrics"))
+	}
+	if webAddr != "" {
+		webOutput = startWebDashboard(webAddr, target)
+		printBanner("Serving the dashboard on: %s.\n", aurora.Bold("http://"+webAddr+"/"))
+	}
 
-	// Check if domains can be resolved initially
+	if queryPattern != "" {
+		printBanner("Query pattern: %s\n", dns.Fqdn(queryPattern))
+	} else if queryFile != "" {
+		printBanner("Queries: %d from %s.\n", runner.LoadedQueries(), queryFile)
+		if agents == nil {
+			go reloadQueryFileOnSignal(runner)
+		}
+	} else if ptrRanges != "" {
+		order := "in order"
+		if ptrRandom {
+			order = "at random"
+		}
+		printBanner("Reverse ranges: %s, %s.\n", ptrRanges, order)
+	} else if replayPath != "" {
+		printBanner("Replaying: %d queries captured over %s from %s", runner.LoadedQueries(), runner.CaptureDuration().Round(time.Millisecond), replayPath)
+		if replaySpeed > 0 {
+			printBanner(", at %gx their timing", replaySpeed)
+		}
+		printBanner(".\n")
+	} else if update {
+		printBanner("Zones to update: %v.\n", runner.Domains())
+	} else if runner.Transfers() {
+		printBanner("Zones to transfer: %v.\n", runner.Domains())
+	} else {
+		printBanner("Target domains: %v.\n", runner.Domains())
+	}
+	if cfg.Expect != nil {
+		printBanner("Checking the answers for %d expectations.\n", len(cfg.Expect))
+	}
+	if cfg.TSIG != nil {
+		printBanner("Signing the queries with the TSIG key %s (%s).\n", cfg.TSIG.Name, strings.TrimSuffix(cfg.TSIG.Algorithm, "."))
+	}
+	if cfg.Wordlist != "" {
+		printBanner("Prepending up to %d labels of %s to the names.\n", cfg.WordlistDepth, cfg.Wordlist)
+	}
+	if cfg.RandomCase {
+		printBanner("Randomizing the case of the names, checking that the answers keep it.\n")
+	}
+	if amplification {
+		printBanner("Measuring the amplification of the answers.\n")
+	}
+	if cfg.TrackTTL {
+		printBanner("Tracking the TTLs of the answers to infer the cache hits.\n")
+	}
+	if len(cfg.SOAZones) > 0 {
+		printBanner("Checking the SOA records of %s every %s.\n", strings.Join(cfg.SOAZones, ", "), cfg.SOAInterval)
+	}
+	if cfg.Fuzz > 0 {
+		printBanner("Replacing %g%% of the queries with malformed packets.\n", 100*cfg.Fuzz)
+	}
+	if retryTCP {
+		printBanner("Retrying the truncated answers over TCP.\n")
+	}
+	if description := runner.EDNSDescription(); description != "" {
+		printBanner("EDNS: %s.\n", description)
+	}
+	if cfg.Opcode != dns.OpcodeQuery || cfg.HeaderFlags != (stress.HeaderFlags{}) {
+		name, found := dns.OpcodeToString[cfg.Opcode]
+		if !found {
+			name = fmt.Sprint(cfg.Opcode)
+		}
+		printBanner("Header: opcode %s, flags %s.\n", name, cfg.HeaderFlags)
+	}
+	if dnssecValidate {
+		printBanner("Validating the DNSSEC signatures of the answers.\n")
+	}
+	if update {
+		printBanner("Record types added: %s.\n\n", typeNames(runner.QueryTypes()))
+	} else {
+		printBanner("Query types: %s.\n\n", typeNames(runner.QueryTypes()))
+	}
+
+	// Check if domains can be resolved initially, the agents may reach the resolvers differently
 	hasErrors := false
-	for i := range targetDomains {
-		hasErrors = hasErrors || testRequest(targetDomains[i])
+	for _, domain := range runner.Domains() {
+		if agents != nil {
+			break
+		}
+		for _, address := range runner.CheckedResolvers() {
+			if err := runner.Check(address, domain); err != nil {
+				using := address
+				if cfg.DOHEndpoint != "" {
+					using = cfg.DOHEndpoint
+				}
+				printBanner("Checking \"%s\" failed: %+v (using %s)\n", domain, aurora.Red(err), using)
+				hasErrors = true
+			}
+		}
 	}
 	if hasErrors {
-		fmt.Printf("%s %s", aurora.BgBrown(" WARNING "), "Could not resolve some domains you provided, you may receive only errors.\n")
+		printBanner("%s %s", aurora.BgBrown(" WARNING "), "Could not resolve some domains you provided, you may receive only errors.\n")
 	}
 
-	// Create a channel for communicating the number of sent messages
-	sentCounterCh := make(chan statsMessage, concurrency)
-
-	// Run concurrently
-	for threadID := 0; threadID < concurrency; threadID++ {
-		go linearResolver(threadID, targetDomains[threadID%len(targetDomains)], sentCounterCh)
+	if rate > 0 {
+		printBanner("%s", aurora.Faint(fmt.Sprintf("Limiting the rate to %d queries per second.\n", rate)))
+	} else if cfg.Ramp != nil {
+		printBanner("%s", aurora.Faint(fmt.Sprintf("Following the load profile %s.\n", ramp)))
 	}
-	fmt.Print(aurora.Faint(fmt.Sprintf("Started %d threads.\n", concurrency)))
-
-	if !flood {
-		go timerStats(sentCounterCh)
+	if findMax {
+		printBanner("%s", aurora.Faint(fmt.Sprintf("Searching the maximum rate keeping %s, from %d queries per second held for %s each.\n", slaDescription(), findMaxStart, findMaxWindow)))
+	}
+	if cfg.Burst > 0 {
+		printBanner("Sending bursts of %d queries every %s.\n", cfg.Burst, cfg.BurstInterval)
+	}
+	if arrivals == "poisson" {
+		printBanner("%s", aurora.Faint("Queries arrive as a Poisson process, with exponentially distributed intervals.\n"))
+	}
+	threads := fmt.Sprintf("%d threads", concurrency)
+	if agents != nil {
+		threads += " on each agent"
+	}
+	if autoConcurrency {
+		printBanner("%s", aurora.Faint(fmt.Sprintf("Tuning the concurrency up to %s, keeping %s.\n", threads, slaDescription())))
+	} else if rampup > 0 {
+		printBanner("%s", aurora.Faint(fmt.Sprintf("Ramping up %s over %s.\n", threads, rampup)))
 	} else {
-		fmt.Println("Flooding mode, nothing will be printed.")
+		printBanner("%s", aurora.Faint(fmt.Sprintf("Started %s.\n", threads)))
+	}
+	if warmup > 0 {
+		printBanner("%s", aurora.Faint(fmt.Sprintf("Warming up for %s, excluded from the summary.\n", warmup)))
+	}
+	if runner.PersistentConnections() {
+		printBanner("%s", aurora.Faint(fmt.Sprintf("Each thread reuses a single %s connection.\n", runner.TransportName())))
+	} else if doq {
+		printBanner("%s", aurora.Faint("All the threads share a single QUIC connection, with one stream per query.\n"))
+	} else if poolSize > 0 {
+		printBanner("%s", aurora.Faint(fmt.Sprintf("The threads share %d %s connections per resolver, pipelining their queries.\n", poolSize, runner.TransportName())))
+	}
+	if inFlight > 0 {
+		printBanner("%s", aurora.Faint(fmt.Sprintf("Flooding mode, each thread keeps up to %d queries in flight and matches the answers to them.\n", inFlight)))
+	} else if flood {
+		printBanner("%s", aurora.Faint("Flooding mode, answers are matched to the queries without waiting for them.\n"))
 	}
-	// We still need this useless routine to empty the channels, even when flooding
-	displayStats(sentCounterCh)
+
+	if agents != nil {
+		controller, err := stress.NewController(cfg, agents, agentToken)
+		if err == nil {
+			go stopOnSignal(controller)
+			startTUI(target, controller)
+			startProgress(len(agents))
+			var totals *stress.Stats
+			totals, err = controller.Run()
+			stopTUI()
+			if err == nil {
+				reportSummary(totals)
+				checkThresholds(totals)
+				return
+			}
+		}
+		fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to run the test from the agents", err))
+		os.Exit(2)
+	}
+
+	go stopOnSignal(runner)
+	startTUI(target, runner)
+	startProgress(0)
+	totals := runner.Run()
+	stopTUI()
+	reportSummary(totals)
+	checkThresholds(totals)
 }
 
-func testRequest(domain string) bool {
-	message := new(dns.Msg).SetQuestion(domain, dns.TypeA)
-	if iterative {
-		message.RecursionDesired = false
+// startTUI takes over the terminal with the dashboard when -tui is set, exiting if it cannot
+func startTUI(title string, control runControl) {
+	if !tui {
+		return
 	}
-	err := dnsExchange(resolver, message)
+	var err error
+	liveDashboard, err = startDashboard(title, control)
 	if err != nil {
-		fmt.Printf("Checking \"%s\" failed: %+v (using %s)\n", domain, aurora.Red(err), resolver)
-		return true
+		fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to start the dashboard", err))
+		os.Exit(2)
 	}
-	return false
 }
 
-func linearResolver(threadID int, domain string, sentCounterCh chan<- statsMessage) {
-	// Resolve the domain as fast as possible
-	if verbose {
-		fmt.Printf("Starting thread #%d.\n", threadID)
+// stopTUI gives the terminal back once the run is over
+func stopTUI() {
+	if liveDashboard != nil {
+		liveDashboard.close()
+		liveDashboard = nil
 	}
+}
 
-	// Every N steps, we will tell the stats module how many requests we sent
-	displayStep := 5
-	maxRequestID := big.NewInt(65536)
-	errors := 0
-
-	message := new(dns.Msg).SetQuestion(domain, dns.TypeA)
-	if iterative {
-		message.RecursionDesired = false
+// serveAgent runs the tests asked by the controllers, until interrupted. The source addresses
+// and the verbose logging of the command line apply to all of them.
+func serveAgent() {
+	if agentToken == "" {
+		fmt.Println(aurora.Red("An agent needs a secret to share with its controllers: -agent-token"))
+		os.Exit(2)
+	}
+	sources := sourceAddresses()
+	handler := stress.NewAgent(agentToken, func(cfg *stress.Config) {
+		cfg.Sources = sources
+		if verbose {
+			cfg.Logger = log.New(os.Stdout, "", 0)
+		}
+		printBanner("%s", aurora.Faint(fmt.Sprintf("Running a test with %d threads.\n", cfg.Concurrency)))
+	})
+	printBanner("Waiting for tests on: %s.\n", aurora.Bold(agentAddr))
+	if err := http.ListenAndServe(agentAddr, handler); err != nil {
+		fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to run the agent", err))
+		os.Exit(2)
 	}
+}
 
-	var start time.Time
-	var elapsed time.Duration    // Total time spent resolving
-	var maxElapsed time.Duration // Maximum time took by a request
+// serveControl runs the tests asked over the REST API, until interrupted. Their options are the
+// ones of the command line, unless replaced by the ones of the request.
+func serveControl() {
+	if listenToken == "" {
+		fmt.Println(aurora.Red("The API needs a secret to share with its clients: -listen-token"))
+		os.Exit(2)
+	}
+	if agentsList != "" || metricsAddr != "" || webAddr != "" || tui {
+		fmt.Println(aurora.Red("The tests of -listen cannot run from agents, or expose metrics, a web dashboard or a terminal one"))
+		os.Exit(2)
+	}
+	host := newConfig()
+	handler, err := newControlAPI(listenToken, host, func(cfg *stress.Config) {
+		cfg.Sources = host.Sources
+		cfg.Logger = host.Logger
+		cfg.OnQuery = host.OnQuery
+	})
+	if err != nil {
+		fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to set up the API", err))
+		os.Exit(2)
+	}
+	printBanner("Waiting for tests on: %s.\n", aurora.Bold(listenAddr))
+	if err := http.ListenAndServe(listenAddr, handler); err != nil {
+		fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to serve the API", err))
+		os.Exit(2)
+	}
+}
 
-	for {
-		for i := 0; i < displayStep; i++ {
-			// Try to resolve the domain
-			if randomIds {
-				// Regenerate message Id to avoid servers dropping (seemingly) duplicate messages
-				newid, _ := rand.Int(rand.Reader, maxRequestID)
-				message.Id = uint16(newid.Int64())
+// newConfig builds the options of the run from the command line, exiting on invalid values
+func newConfig() *stress.Config {
+	cfg := stress.NewConfig()
+	cfg.Concurrency = concurrency
+	cfg.Interval = time.Duration(displayInterval) * time.Millisecond
+	cfg.Rampup = rampup
+	cfg.Warmup = warmup
+	cfg.Count = count
+	cfg.Duration = duration
+	cfg.Rate = rate
+	cfg.Arrivals = arrivals
+	cfg.Burst = burst
+	if burst > 0 {
+		cfg.BurstInterval = burstInterval
+	}
+	cfg.Retries = retries
+	cfg.RetryTCP = retryTCP
+	cfg.Timeout = timeout
+	if inFlight > 0 {
+		flood = true
+	}
+	cfg.Flood = flood
+	cfg.InFlight = inFlight
+	cfg.FloodBatch = floodBatch
+	cfg.QueryPattern = queryPattern
+	cfg.PatternRandom = patternRandom
+	cfg.QueryFile = queryFile
+	cfg.QueryFileInOrder = qfileInOrder
+	cfg.Replay = replayPath
+	cfg.ReplaySpeed = replaySpeed
+	cfg.RandomPrefix = randomPrefix
+	if wordlistPath != "" {
+		cfg.Wordlist = wordlistPath
+		cfg.WordlistDepth = wordlistDepth
+	}
+	cfg.RandomCase = randomCase
+	cfg.Update = update
+	cfg.RandomIDs = randomIds
+	cfg.Iterative = iterative
+	opcodeValue, err := stress.ParseOpcode(opcode)
+	if err != nil {
+		fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the opcode", err))
+		os.Exit(2)
+	}
+	cfg.Opcode = opcodeValue
+	flags, err := stress.ParseHeaderFlags(headerFlags)
+	if err != nil {
+		fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the header flags", err))
+		os.Exit(2)
+	}
+	cfg.HeaderFlags = flags
+	cfg.Distribution = distribution
+	cfg.ReuseConn = reuseConn
+	cfg.PoolSize = poolSize
+	cfg.ReusePort = reusePort
+	cfg.TCP = tcp
+	cfg.DoT = dot
+	cfg.DoQ = doq
+	cfg.TLSServerName = tlsServerName
+	cfg.Insecure = insecure
+	cfg.DOHEndpoint = dohEndpoint
+	cfg.DOHMethod = strings.ToUpper(dohMethod)
+	cfg.DOHHTTP2 = dohHTTP2
+	cfg.DOHHTTP3 = dohHTTP3
+	cfg.DOHMaxIdleConns = dohMaxIdle
+	cfg.TLSCert = tlsCert
+	cfg.TLSKey = tlsKey
+	if tlsKey != "" && tlsCert == "" {
+		fmt.Println(aurora.Red("-tls-key needs -tls-cert"))
+		os.Exit(2)
+	}
+	if len(dohHeaders) > 0 || dohToken != "" || dohBasicAuth != "" {
+		if dohEndpoint == "" {
+			fmt.Println(aurora.Red("-doh-header, -doh-token and -doh-basic-auth need a DOH endpoint (-doh)"))
+			os.Exit(2)
+		}
+		headers, err := stress.ParseHTTPHeaders(dohHeaders)
+		if err != nil {
+			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the DOH headers", err))
+			os.Exit(2)
+		}
+		switch {
+		case dohToken != "" && dohBasicAuth != "":
+			fmt.Println(aurora.Red("-doh-token and -doh-basic-auth cannot be used together"))
+			os.Exit(2)
+		case dohToken != "":
+			headers.Set("Authorization", "Bearer "+dohToken)
+		case dohBasicAuth != "":
+			if !strings.Contains(dohBasicAuth, ":") {
+				fmt.Println(aurora.Red("-doh-basic-auth expects user:password"))
+				os.Exit(2)
 			}
-
-			if flood {
-				go dnsExchange(resolver, message)
-			} else {
-				start = time.Now()
-				err := dnsExchange(resolver, message)
-				spent := time.Since(start)
-				elapsed += spent
-				if spent > maxElapsed {
-					maxElapsed = spent
-				}
-				if err != nil {
-					if verbose {
-						fmt.Printf("%s error: %d (%s)\n", domain, err, resolver)
-					}
-					errors++
-				}
+			headers.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(dohBasicAuth)))
+		}
+		cfg.DOHHeaders = headers
+	}
+	cfg.EDNSBufSize = ednsBufSize
+	cfg.Amplification = amplification
+	cfg.Fuzz = fuzz
+	cfg.TrackTTL = trackTTL
+	if soaZones != "" {
+		cfg.SOAZones = strings.Split(soaZones, ",")
+		cfg.SOAInterval = soaInterval
+	}
+	cfg.DNSSECOK = dnssecOK
+	cfg.EDNSPadding = ednsPadding
+	cfg.Cookies = cookies
+	cfg.DNSSECValidate = dnssecValidate
+	cfg.Metrics = metricsAddr != ""
+	cfg.OnInterval = reportInterval
+	if verbose {
+		cfg.Logger = log.New(os.Stdout, "", 0)
+	}
+	if queryLog != nil && queryLog.logs("info") {
+		cfg.Logger = log.New(queryLog, "", 0)
+	}
+	if querySpans != nil || queryLog != nil {
+		cfg.OnQuery = func(event stress.QueryEvent) {
+			if querySpans != nil {
+				querySpans.add(event)
+			}
+			if queryLog != nil {
+				queryLog.logQuery(event)
 			}
 		}
+	}
 
-		// Update the counter of sent requests and requests
-		sentCounterCh <- statsMessage{
-			sent:       displayStep,
-			err:        errors,
-			elapsed:    elapsed,
-			maxElapsed: maxElapsed,
+	// Process target domains, the ones given as arguments all have the same weight
+	cfg.Domains = targets
+	cfg.DomainWeights = make([]int, len(cfg.Domains))
+	for index := range cfg.DomainWeights {
+		cfg.DomainWeights[index] = 1
+	}
+	if weightedDomains != "" {
+		items, weights, err := stress.ParseWeightedList(weightedDomains)
+		if err != nil {
+			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the weighted domains", err))
+			os.Exit(2)
 		}
-		errors = 0
-		elapsed = 0
-		maxElapsed = 0
+		cfg.Domains = append(cfg.Domains, items...)
+		cfg.DomainWeights = append(cfg.DomainWeights, weights...)
+	}
+	if queryPattern != "" || queryFile != "" || replayPath != "" || ptrRanges != "" {
+		cfg.DomainWeights = nil
 	}
-}
 
-func dnsExchange(resolver string, message *dns.Msg) error {
-	// Check if DOH is enabled
-	if dohEndpoint != "" {
-		response, err := performDOHRequest(message)
+	if ipv4Only && ipv6Only {
+		fmt.Println(aurora.Red("-4 and -6 cannot be used together"))
+		os.Exit(2)
+	}
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	if ipv4Only {
+		cfg.IPVersion = 4
+	}
+	if ipv6Only {
+		cfg.IPVersion = 6
+		if !explicit["type"] {
+			queryType = "AAAA"
+		}
+		if !explicit["r"] {
+			resolver = "::1"
+		}
+	}
+	if explicit["burst-interval"] && burst == 0 {
+		fmt.Println(aurora.Red("-burst-interval needs the size of the bursts: -burst"))
+		os.Exit(2)
+	}
+	if explicit["wordlist-depth"] && wordlistPath == "" {
+		fmt.Println(aurora.Red("-wordlist-depth needs the labels to prepend: -wordlist"))
+		os.Exit(2)
+	}
+	if explicit["soa-interval"] && soaZones == "" {
+		fmt.Println(aurora.Red("-soa-interval needs the zones to check: -soa"))
+		os.Exit(2)
+	}
+	if autoConcurrency && !explicit["concurrency"] {
+		concurrency = defaultAutoConcurrency
+		cfg.Concurrency = concurrency
+	}
+	if autoConcurrency || findMax {
+		errorRate, err := parsePercentage(slaErrorRate)
 		if err != nil {
-			return fmt.Errorf("DOH request failed: %v", err)
+			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the SLA error rate", err))
+			os.Exit(2)
 		}
-		if len(response) == 0 {
-			return fmt.Errorf("empty DOH response")
+		cfg.SLA = stress.SLA{MaxErrorRate: errorRate, MaxP99: slaP99}
+	}
+	cfg.AutoConcurrency = autoConcurrency
+	cfg.FindMax = findMax
+	cfg.FindMaxStart = findMaxStart
+	cfg.FindMaxWindow = findMaxWindow
+	if ptrRanges != "" {
+		ranges, err := stress.ParseReverseRanges(ptrRanges)
+		if err != nil {
+			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the reverse ranges", err))
+			os.Exit(2)
+		}
+		cfg.ReverseRanges = ranges
+		cfg.ReverseRandom = ptrRandom
+		if !explicit["type"] && typesMix == "" {
+			queryType = "PTR"
+		}
+	} else if ptrRandom {
+		fmt.Println(aurora.Red("-ptr-random needs the ranges given with -ptr"))
+		os.Exit(2)
+	}
+	if amplification {
+		// Large answers are the ones of interest
+		if !explicit["type"] && typesMix == "" {
+			queryType = "ANY,DNSKEY,TXT"
+		}
+		if !explicit["edns-bufsize"] {
+			cfg.EDNSBufSize = 4096
 		}
-		return nil
 	}
 
-	// Standard DNS request (UDP)
-	dnsconn, err := net.Dial("udp", resolver)
+	// Process query types, a list given with -type uses the same weight for all of them
+	typesSpec := queryType
+	if typesMix != "" {
+		typesSpec = typesMix
+	}
+	qtypes, weights, err := stress.ParseQueryTypes(typesSpec)
 	if err != nil {
-		return err
+		fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the query types", err))
+		os.Exit(2)
+	}
+	cfg.QueryTypes = qtypes
+	cfg.TypeWeights = weights
+	if ixfrSerial > math.MaxUint32 {
+		fmt.Println(aurora.Red("-ixfr-serial has to be a 32 bits serial"))
+		os.Exit(2)
 	}
-	co := &dns.Conn{Conn: dnsconn}
-	defer co.Close()
+	cfg.TransferSerial = uint32(ixfrSerial)
 
-	// Actually send the message and wait for answer
-	co.WriteMsg(message)
+	if dohEndpoint == "" {
+		defaultPort := "53"
+		if dot || doq {
+			defaultPort = "853"
+		}
+		cfg.Resolvers, err = stress.ParseResolverList(resolver, defaultPort)
+		if err != nil {
+			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the resolver address", err))
+			os.Exit(2)
+		}
+		if compareWith != "" {
+			cfg.CompareResolver, err = stress.ParseIPPortWithDefault(compareWith, defaultPort)
+			if err != nil {
+				fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the compared resolver address", err))
+				os.Exit(2)
+			}
+		}
+		if resolverWeights != "" {
+			cfg.ResolverWeights, err = stress.ParseResolverWeights(resolverWeights, len(cfg.Resolvers))
+			if err != nil {
+				fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the resolver weights", err))
+				os.Exit(2)
+			}
+		}
+	}
 
-	_, err = co.ReadMsg()
-	return err
+	cfg.Sources = sourceAddresses()
+
+	if ramp != "" {
+		cfg.Ramp, err = stress.ParseRampProfile(ramp)
+		if err != nil {
+			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the load profile", err))
+			os.Exit(2)
+		}
+	}
+	if dnssecAnchors != "" {
+		cfg.DNSSECAnchors = strings.Split(dnssecAnchors, ",")
+	}
+	if tsigKey != "" {
+		cfg.TSIG, err = stress.ParseTSIGKey(tsigKey)
+		if err != nil {
+			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the TSIG key", err))
+			os.Exit(2)
+		}
+	}
+	if clientSubnets != "" {
+		cfg.ClientSubnets, err = stress.ParseClientSubnets(clientSubnets)
+		if err != nil {
+			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the client subnets", err))
+			os.Exit(2)
+		}
+		cfg.ClientSubnetLength = subnetLength
+	} else if subnetLength != 0 {
+		fmt.Println(aurora.Red("-ecs-random needs the prefixes given with -ecs"))
+		os.Exit(2)
+	}
+	if expect != "" {
+		cfg.Expect, err = stress.ParseExpectations(expect)
+		if err != nil {
+			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the expected answers", err))
+			os.Exit(2)
+		}
+	}
+	return cfg
 }
 
-// performDOHRequest sends a DNS query over HTTPS
-func performDOHRequest(query *dns.Msg) ([]byte, error) {
-	rawQuery, err := query.Pack()
+// sourceAddresses returns the addresses given with -source, exiting if they are invalid
+func sourceAddresses() []*net.UDPAddr {
+	if source == "" {
+		return nil
+	}
+	sources, err := stress.ParseSourceList(source)
 	if err != nil {
-		return nil, fmt.Errorf("failed to pack DNS query: %v", err)
+		fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the source addresses", err))
+		os.Exit(2)
 	}
+	return sources
+}
 
-	encodedQuery := base64.RawURLEncoding.EncodeToString(rawQuery)
-	req, err := http.NewRequest("GET", dohEndpoint+"?dns="+encodedQuery, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create DOH request: %v", err)
+// stopOnSignal stops the threads on Ctrl-C or SIGTERM, so that the summary is still displayed.
+// A second signal exits right away.
+func stopOnSignal(runner interface{ Stop() }) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	printBanner("%s", aurora.Faint("\nStopping, waiting for the queries in flight...\n"))
+	runner.Stop()
+	<-signals
+	os.Exit(130)
+}
+
+// reloadQueryFileOnSignal reloads the queries whenever SIGHUP is received, keeping the previous
+// ones if the file became invalid
+func reloadQueryFileOnSignal(runner *stress.Runner) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		loaded, err := runner.ReloadQueryFile()
+		if err != nil {
+			fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to reload the query file", err))
+			continue
+		}
+		printBanner("%s", aurora.Faint(fmt.Sprintf("Reloaded %d queries from %s.\n", loaded, queryFile)))
 	}
-	req.Header.Set("Accept", "application/dns-message")
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("DOH request failed: %v", err)
+// startMetricsServer exposes the metrics on /metrics, in the background
+func startMetricsServer(addr string, handler http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Fprintf(os.Stderr, "Metrics server stopped: %s\n", err)
+		}
+	}()
+}
+
+// typeNames returns the textual representation of a list of record types
+func typeNames(qtypes []uint16) string {
+	names := make([]string, len(qtypes))
+	for index, qtype := range qtypes {
+		names[index] = dns.TypeToString[qtype]
 	}
-	defer resp.Body.Close()
+	return strings.Join(names, ", ")
+}
 
-	return ioutil.ReadAll(resp.Body)
+// printBanner prints informative messages, unless running quietly
+func printBanner(format string, a ...interface{}) {
+	if !quiet {
+		fmt.Fprintf(bannerOutput, format, a...)
+	}
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/MickaelBergem/dnsstresss/stress"
+)
+
+// latencyPercentiles are the percentiles of the latency reported in the stats
+var latencyPercentiles = []float64{50, 90, 95, 99, 99.9}
+
+// percentileMs returns the given percentile of the latency, in milliseconds
+func percentileMs(histogram *hdrhistogram.Histogram, percentile float64) float64 {
+	return float64(histogram.ValueAtPercentile(percentile)) / 1000.
+}
+
+// formatPercentiles returns the latency percentiles, e.g. "p50=0.8 p90=1.2 p99=3.1ms"
+func formatPercentiles(histogram *hdrhistogram.Histogram) string {
+	parts := make([]string, len(latencyPercentiles))
+	for index, percentile := range latencyPercentiles {
+		parts[index] = fmt.Sprintf("%s=%.1f", percentileName(percentile), percentileMs(histogram, percentile))
+	}
+	return strings.Join(parts, " ") + "ms"
+}
+
+// histogramWidth is the length of the bar of the largest bucket of the latency histogram
+const histogramWidth = 40
+
+// printLatencyHistogram draws the latency buckets as bars, which shows at a glance whether the
+// answers come from a cache or need a recursion
+func printLatencyHistogram(buckets []stress.LatencyBucket) {
+	largest, total := 0, 0
+	for _, bucket := range buckets {
+		if bucket.Count > largest {
+			largest = bucket.Count
+		}
+		total += bucket.Count
+	}
+	var lower time.Duration
+	for _, bucket := range buckets {
+		label := fmt.Sprintf("%s-%s", lower, bucket.Upper)
+		if lower == 0 {
+			label = fmt.Sprintf("0-%s", bucket.Upper)
+		}
+		bar := strings.Repeat("#", (bucket.Count*histogramWidth+largest-1)/largest)
+		fmt.Printf("  %-13s %-*s %d (%d%%)\n", label, histogramWidth, bar, bucket.Count, 100*bucket.Count/total)
+		lower = bucket.Upper
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MickaelBergem/dnsstresss/stress"
+	"github.com/miekg/dns"
+)
+
+// queryLog writes the logs of -log-level, when set
+var queryLog *queryLogger
+
+// Levels of -log-level, each one logging more than the previous ones
+var logLevels = []string{"error", "info", "debug"}
+
+// queryLogger writes the JSON log records of -log-level, buffered and flushed at each interval
+// so that the threads don't have to wait for the writes
+type queryLogger struct {
+	level  int // Index in logLevels
+	mu     sync.Mutex
+	output *bufio.Writer
+}
+
+// logRecord is a line of the log: a query, or a message of the threads
+type logRecord struct {
+	Time      string   `json:"time"`
+	Level     string   `json:"level"`
+	Message   string   `json:"message,omitempty"`
+	Name      string   `json:"qname,omitempty"`
+	Type      string   `json:"qtype,omitempty"`
+	Rcode     string   `json:"rcode,omitempty"`
+	RTT       *float64 `json:"rtt_ms,omitempty"`
+	Transport string   `json:"transport,omitempty"`
+	Resolver  string   `json:"resolver,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+func newQueryLogger(level string, output io.Writer) (*queryLogger, error) {
+	for index, name := range logLevels {
+		if strings.EqualFold(level, name) {
+			return &queryLogger{level: index, output: bufio.NewWriter(output)}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown log level %q (expected %s)", level, strings.Join(logLevels, ", "))
+}
+
+// logs tells whether the records of a level are written
+func (l *queryLogger) logs(level string) bool {
+	for index, name := range logLevels {
+		if name == level {
+			return index <= l.level
+		}
+	}
+	return false
+}
+
+func (l *queryLogger) write(record logRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.output.Write(line)
+	l.output.WriteByte('\n')
+}
+
+// logQuery records a query of the threads: the failed ones as errors, the others for debugging
+func (l *queryLogger) logQuery(event stress.QueryEvent) {
+	rtt := 1000 * event.Latency.Seconds()
+	record := logRecord{
+		Time:      event.Start.Format(time.RFC3339Nano),
+		Level:     "debug",
+		Name:      event.Name,
+		Type:      dns.TypeToString[event.Type],
+		Rcode:     event.Outcome,
+		RTT:       &rtt,
+		Transport: event.Transport,
+		Resolver:  event.Resolver,
+	}
+	if event.Err != nil {
+		record.Level = "error"
+		record.Error = event.Err.Error()
+	}
+	if l.logs(record.Level) {
+		l.write(record)
+	}
+}
+
+// Write takes the messages of the threads, as the output of their logger
+func (l *queryLogger) Write(message []byte) (int, error) {
+	if !l.logs("info") {
+		return len(message), nil
+	}
+	l.write(logRecord{Time: time.Now().Format(time.RFC3339Nano), Level: "info", Message: strings.TrimSpace(string(message))})
+	return len(message), nil
+}
+
+func (l *queryLogger) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.output.Flush()
+}
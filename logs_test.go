@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MickaelBergem/dnsstresss/stress"
+	"github.com/miekg/dns"
+)
+
+// logQueries writes a failed query, a message and an answered query at a level, and decodes the
+// records written
+func logQueries(t *testing.T, level string) []logRecord {
+	var output bytes.Buffer
+	logger, err := newQueryLogger(level, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	logger.logQuery(stress.QueryEvent{Start: start, Latency: 1500 * time.Millisecond, Name: "a.example.com.",
+		Type: dns.TypeAAAA, Resolver: "192.0.2.53:53", Transport: "UDP", Outcome: "timeout", Err: errors.New("i/o timeout")})
+	logger.Write([]byte("example.com. serial changed from 1 to 2\n"))
+	logger.logQuery(stress.QueryEvent{Start: start, Latency: 2 * time.Millisecond, Name: "b.example.com.",
+		Type: dns.TypeA, Resolver: "192.0.2.53:53", Transport: "UDP", Outcome: "NOERROR"})
+	if output.Len() != 0 {
+		t.Errorf("Expected the records to be buffered until flushed, got %q", output.String())
+	}
+	logger.flush()
+
+	var records []logRecord
+	decoder := json.NewDecoder(&output)
+	for decoder.More() {
+		var record logRecord
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestQueryLoggerLevels(t *testing.T) {
+	for _, test := range []struct {
+		level    string
+		expected []string
+	}{
+		{"error", []string{"error"}},
+		{"INFO", []string{"error", "info"}},
+		{"debug", []string{"error", "info", "debug"}},
+	} {
+		records := logQueries(t, test.level)
+		var levels []string
+		for _, record := range records {
+			levels = append(levels, record.Level)
+		}
+		if len(levels) != len(test.expected) {
+			t.Errorf("Expected the records %v at level %s, got %v", test.expected, test.level, levels)
+			continue
+		}
+		for index := range levels {
+			if levels[index] != test.expected[index] {
+				t.Errorf("Expected the records %v at level %s, got %v", test.expected, test.level, levels)
+				break
+			}
+		}
+	}
+	if _, err := newQueryLogger("trace", &bytes.Buffer{}); err == nil {
+		t.Errorf("Expected an error for an unknown level")
+	}
+}
+
+func TestQueryLoggerRecords(t *testing.T) {
+	records := logQueries(t, "debug")
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+	failed, message, answered := records[0], records[1], records[2]
+	if failed.Time != "2026-10-14T12:00:00Z" || failed.Name != "a.example.com." || failed.Type != "AAAA" ||
+		failed.Rcode != "timeout" || failed.RTT == nil || *failed.RTT != 1500 || failed.Transport != "UDP" ||
+		failed.Resolver != "192.0.2.53:53" || failed.Error != "i/o timeout" {
+		t.Errorf("Unexpected record of the failed query: %+v", failed)
+	}
+	if message.Message != "example.com. serial changed from 1 to 2" || message.Name != "" || message.RTT != nil {
+		t.Errorf("Unexpected record of the message: %+v", message)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, message.Time); err != nil {
+		t.Errorf("Expected the time of the message, got %q", message.Time)
+	}
+	if answered.Rcode != "NOERROR" || answered.Type != "A" || *answered.RTT != 2 || answered.Error != "" {
+		t.Errorf("Unexpected record of the answered query: %+v", answered)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MickaelBergem/dnsstresss/stress"
+	"github.com/miekg/dns"
+)
+
+// maxBufferedSpans bounds the spans kept between two intervals, the others are dropped
+const maxBufferedSpans = 10000
+
+// querySpans samples the queries exported as spans, with -otlp-traces
+var querySpans *spanBuffer
+
+// Values of the OTLP enums
+const (
+	otlpDeltaTemporality = 1
+	otlpSpanKindClient   = 3
+	otlpStatusError      = 2
+)
+
+// otlpSink posts the metrics of each interval to an OpenTelemetry collector, over OTLP/HTTP with
+// the JSON encoding, along with the spans of the queries sampled since the previous one
+type otlpSink struct {
+	metricsURL string
+	tracesURL  string
+	client     *http.Client
+	spans      *spanBuffer // Set on the sink exporting the spans
+}
+
+func newOTLPSink(base string) *otlpSink {
+	base = strings.TrimSuffix(base, "/")
+	return &otlpSink{metricsURL: base + "/v1/metrics", tracesURL: base + "/v1/traces", client: &http.Client{Timeout: sinkTimeout}}
+}
+
+// The OTLP/HTTP JSON payloads, see opentelemetry-proto. The 64 bits integers are strings.
+type (
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		String *string `json:"stringValue,omitempty"`
+		Bool   *bool   `json:"boolValue,omitempty"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpDataPoint struct {
+		Attributes []otlpAttribute `json:"attributes"`
+		Start      string          `json:"startTimeUnixNano"`
+		Time       string          `json:"timeUnixNano"`
+		Int        string          `json:"asInt,omitempty"`
+		Double     *float64        `json:"asDouble,omitempty"`
+	}
+	otlpSum struct {
+		DataPoints  []otlpDataPoint `json:"dataPoints"`
+		Temporality int             `json:"aggregationTemporality"`
+		Monotonic   bool            `json:"isMonotonic"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	}
+	otlpMetric struct {
+		Name  string     `json:"name"`
+		Unit  string     `json:"unit,omitempty"`
+		Sum   *otlpSum   `json:"sum,omitempty"`
+		Gauge *otlpGauge `json:"gauge,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpSpan struct {
+		TraceID    string          `json:"traceId"`
+		SpanID     string          `json:"spanId"`
+		Name       string          `json:"name"`
+		Kind       int             `json:"kind"`
+		Start      string          `json:"startTimeUnixNano"`
+		End        string          `json:"endTimeUnixNano"`
+		Attributes []otlpAttribute `json:"attributes"`
+		Status     otlpStatus      `json:"status"`
+	}
+)
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{String: &value}}
+}
+
+func boolAttribute(key string, value bool) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{Bool: &value}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// otlpResourcePayload wraps the items of a scope in the resource of the run
+func otlpResourcePayload(kind, items string, content interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"resource" + kind: []map[string]interface{}{{
+			"resource": otlpResource{Attributes: []otlpAttribute{stringAttribute("service.name", sinkPrefix)}},
+			"scope" + kind: []map[string]interface{}{{
+				"scope": otlpScope{Name: sinkPrefix},
+				items:   content,
+			}},
+		}},
+	}
+}
+
+// otlpMetrics converts the metrics of a record: the counts of the interval are delta sums, the
+// others gauges
+func otlpMetrics(record statsRecord) []otlpMetric {
+	attributes := []otlpAttribute{boolAttribute("warmup", record.Warmup)}
+	start := unixNano(record.Timestamp.Add(-time.Duration(record.Duration * float64(time.Second))))
+	end := unixNano(record.Timestamp)
+	var metrics []otlpMetric
+	for _, metric := range sinkMetrics(record) {
+		point := otlpDataPoint{Attributes: attributes, Start: start, Time: end}
+		converted := otlpMetric{Name: sinkPrefix + "." + metric.name}
+		switch {
+		case strings.HasPrefix(metric.name, "latency."):
+			converted.Unit = "ms"
+		case strings.HasPrefix(metric.name, "response_size."):
+			converted.Unit = "By"
+		}
+		if metric.counter {
+			point.Int = strconv.FormatInt(int64(metric.value), 10)
+			converted.Sum = &otlpSum{DataPoints: []otlpDataPoint{point}, Temporality: otlpDeltaTemporality, Monotonic: true}
+		} else {
+			value := metric.value
+			point.Double = &value
+			converted.Gauge = &otlpGauge{DataPoints: []otlpDataPoint{point}}
+		}
+		metrics = append(metrics, converted)
+	}
+	return metrics
+}
+
+func (o *otlpSink) writeRecord(record statsRecord) error {
+	if err := o.post(o.metricsURL, otlpResourcePayload("Metrics", "metrics", otlpMetrics(record))); err != nil {
+		return err
+	}
+	if o.spans != nil {
+		if spans := o.spans.take(); len(spans) > 0 {
+			return o.post(o.tracesURL, otlpResourcePayload("Spans", "spans", spans))
+		}
+	}
+	return nil
+}
+
+func (o *otlpSink) post(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	response, err := o.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("the OTLP collector answered %s (%s)", response.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// spanBuffer keeps a share of the queries as spans, until the next interval exports them
+type spanBuffer struct {
+	ratio float64
+	mu    sync.Mutex
+	rnd   *rand.Rand
+	spans []otlpSpan
+}
+
+func newSpanBuffer(ratio float64) *spanBuffer {
+	return &spanBuffer{ratio: ratio, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// add samples a query, it is called by the threads
+func (b *spanBuffer) add(event stress.QueryEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rnd.Float64() >= b.ratio {
+		return
+	}
+	if len(b.spans) >= maxBufferedSpans {
+		return
+	}
+	ids := make([]byte, 24)
+	b.rnd.Read(ids)
+	span := otlpSpan{
+		TraceID: hex.EncodeToString(ids[:16]),
+		SpanID:  hex.EncodeToString(ids[16:]),
+		Name:    "DNS " + dns.TypeToString[event.Type],
+		Kind:    otlpSpanKindClient,
+		Start:   unixNano(event.Start),
+		End:     unixNano(event.Start.Add(event.Latency)),
+		Attributes: []otlpAttribute{
+			stringAttribute("dns.question.name", event.Name),
+			stringAttribute("dns.question.type", dns.TypeToString[event.Type]),
+			stringAttribute("dns.response_code", event.Outcome),
+			stringAttribute("server.address", event.Resolver),
+			stringAttribute("dns.transport", event.Transport),
+		},
+	}
+	if event.Err != nil {
+		span.Status = otlpStatus{Code: otlpStatusError, Message: event.Err.Error()}
+	}
+	b.spans = append(b.spans, span)
+}
+
+// take returns the spans sampled since the previous call
+func (b *spanBuffer) take() []otlpSpan {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	spans := b.spans
+	b.spans = nil
+	return spans
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MickaelBergem/dnsstresss/stress"
+	"github.com/miekg/dns"
+)
+
+// otlpCollector keeps the payloads posted to an OTLP/HTTP endpoint, by path
+type otlpCollector struct {
+	mu       sync.Mutex
+	payloads map[string][][]byte
+}
+
+func (c *otlpCollector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	if req.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "expected JSON", http.StatusUnsupportedMediaType)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.payloads[req.URL.Path] = append(c.payloads[req.URL.Path], body)
+}
+
+// The fields of the payloads checked by the tests, as written by opentelemetry-proto
+type (
+	testOTLPAttribute struct {
+		Key   string `json:"key"`
+		Value struct {
+			String *string `json:"stringValue"`
+			Bool   *bool   `json:"boolValue"`
+		} `json:"value"`
+	}
+	testOTLPResource struct {
+		Attributes []testOTLPAttribute `json:"attributes"`
+	}
+	testOTLPPoint struct {
+		Attributes []testOTLPAttribute `json:"attributes"`
+		Start      string              `json:"startTimeUnixNano"`
+		Time       string              `json:"timeUnixNano"`
+		Int        string              `json:"asInt"`
+		Double     *float64            `json:"asDouble"`
+	}
+	testOTLPMetrics struct {
+		ResourceMetrics []struct {
+			Resource     testOTLPResource `json:"resource"`
+			ScopeMetrics []struct {
+				Scope struct {
+					Name string `json:"name"`
+				} `json:"scope"`
+				Metrics []struct {
+					Name string `json:"name"`
+					Unit string `json:"unit"`
+					Sum  *struct {
+						DataPoints  []testOTLPPoint `json:"dataPoints"`
+						Temporality int             `json:"aggregationTemporality"`
+						Monotonic   bool            `json:"isMonotonic"`
+					} `json:"sum"`
+					Gauge *struct {
+						DataPoints []testOTLPPoint `json:"dataPoints"`
+					} `json:"gauge"`
+				} `json:"metrics"`
+			} `json:"scopeMetrics"`
+		} `json:"resourceMetrics"`
+	}
+	testOTLPSpans struct {
+		ResourceSpans []struct {
+			Resource   testOTLPResource `json:"resource"`
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID    string              `json:"traceId"`
+					SpanID     string              `json:"spanId"`
+					Name       string              `json:"name"`
+					Kind       int                 `json:"kind"`
+					Start      string              `json:"startTimeUnixNano"`
+					End        string              `json:"endTimeUnixNano"`
+					Attributes []testOTLPAttribute `json:"attributes"`
+					Status     struct {
+						Code    int    `json:"code"`
+						Message string `json:"message"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+)
+
+func TestOTLPSink(t *testing.T) {
+	collector := &otlpCollector{payloads: make(map[string][][]byte)}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+	sink := newOTLPSink(server.URL + "/")
+	sink.spans = newSpanBuffer(1)
+
+	start := time.Unix(1700000000, 0)
+	sink.spans.add(stress.QueryEvent{Start: start, Latency: 2 * time.Millisecond, Name: "example.com.",
+		Type: dns.TypeAAAA, Resolver: "192.0.2.53:53", Transport: "UDP", Outcome: "NOERROR"})
+	sink.spans.add(stress.QueryEvent{Start: start, Latency: time.Second, Name: "example.org.",
+		Type: dns.TypeA, Resolver: "192.0.2.53:53", Transport: "UDP", Outcome: "timeout", Err: errors.New("i/o timeout")})
+	record := sinkTestRecord()
+	record.Duration = 2
+	if err := sink.writeRecord(record); err != nil {
+		t.Fatal(err)
+	}
+	// No spans were sampled since, only the metrics are posted
+	if err := sink.writeRecord(record); err != nil {
+		t.Fatal(err)
+	}
+	if posted := len(collector.payloads["/v1/metrics"]); posted != 2 {
+		t.Errorf("Expected the metrics to be posted twice, got %d", posted)
+	}
+	if posted := len(collector.payloads["/v1/traces"]); posted != 1 {
+		t.Fatalf("Expected the spans to be posted once, got %d", posted)
+	}
+
+	var metrics testOTLPMetrics
+	if err := json.Unmarshal(collector.payloads["/v1/metrics"][0], &metrics); err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics.ResourceMetrics) != 1 || len(metrics.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("Expected a resource with a scope, got %s", collector.payloads["/v1/metrics"][0])
+	}
+	resource := metrics.ResourceMetrics[0]
+	if attributes := resource.Resource.Attributes; len(attributes) != 1 || attributes[0].Key != "service.name" ||
+		attributes[0].Value.String == nil || *attributes[0].Value.String != "dnsstresss" {
+		t.Errorf("Expected the service name of the resource, got %+v", attributes)
+	}
+	if name := resource.ScopeMetrics[0].Scope.Name; name != "dnsstresss" {
+		t.Errorf("Expected the dnsstresss scope, got %q", name)
+	}
+	found := 0
+	for _, metric := range resource.ScopeMetrics[0].Metrics {
+		switch metric.Name {
+		case "dnsstresss.sent":
+			found++
+			if metric.Sum == nil || metric.Gauge != nil || metric.Sum.Temporality != 1 || !metric.Sum.Monotonic ||
+				len(metric.Sum.DataPoints) != 1 || metric.Sum.DataPoints[0].Int != "100" {
+				t.Errorf("Expected a delta sum of 100 queries sent, got %+v", metric)
+				continue
+			}
+			point := metric.Sum.DataPoints[0]
+			if point.Start != "1699999998000000000" || point.Time != "1700000000000000000" {
+				t.Errorf("Expected the points to span the interval, got %s to %s", point.Start, point.Time)
+			}
+			if len(point.Attributes) != 1 || point.Attributes[0].Key != "warmup" || point.Attributes[0].Value.Bool == nil {
+				t.Errorf("Expected the warmup attribute, got %+v", point.Attributes)
+			}
+		case "dnsstresss.latency.p99":
+			found++
+			if metric.Gauge == nil || metric.Sum != nil || metric.Unit != "ms" || len(metric.Gauge.DataPoints) != 1 ||
+				metric.Gauge.DataPoints[0].Double == nil || *metric.Gauge.DataPoints[0].Double != 4 {
+				t.Errorf("Expected a gauge of 4ms, got %+v", metric)
+			}
+		}
+	}
+	if found != 2 {
+		t.Errorf("Expected the sent and latency.p99 metrics, found %d of them", found)
+	}
+
+	var traces testOTLPSpans
+	if err := json.Unmarshal(collector.payloads["/v1/traces"][0], &traces); err != nil {
+		t.Fatal(err)
+	}
+	if len(traces.ResourceSpans) != 1 || len(traces.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Expected a resource with a scope, got %s", collector.payloads["/v1/traces"][0])
+	}
+	spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	answered, failed := spans[0], spans[1]
+	if answered.Name != "DNS AAAA" || answered.Kind != 3 || len(answered.TraceID) != 32 || len(answered.SpanID) != 16 ||
+		answered.Start != "1700000000000000000" || answered.End != "1700000000002000000" || answered.Status.Code != 0 {
+		t.Errorf("Unexpected span of the answered query: %+v", answered)
+	}
+	if len(answered.Attributes) == 0 || answered.Attributes[0].Key != "dns.question.name" || *answered.Attributes[0].Value.String != "example.com." {
+		t.Errorf("Expected the name of the query first in the attributes, got %+v", answered.Attributes)
+	}
+	if failed.Status.Code != 2 || failed.Status.Message != "i/o timeout" || failed.TraceID == answered.TraceID {
+		t.Errorf("Unexpected span of the failed query: %+v", failed)
+	}
+}
+
+func TestOTLPSinkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	if err := newOTLPSink(server.URL).writeRecord(sinkTestRecord()); err == nil {
+		t.Errorf("Expected the error of the collector")
+	}
+}
+
+func TestSpanBufferSampling(t *testing.T) {
+	for _, test := range []struct {
+		ratio    float64
+		queries  int
+		min, max int
+	}{
+		{0, 1000, 0, 0},
+		{1, 1000, 1000, 1000},
+		{0.1, 10000, 800, 1200},
+		{1, maxBufferedSpans + 10, maxBufferedSpans, maxBufferedSpans}, // Bounded
+	} {
+		buffer := newSpanBuffer(test.ratio)
+		buffer.rnd = rand.New(rand.NewSource(1))
+		for i := 0; i < test.queries; i++ {
+			buffer.add(stress.QueryEvent{Type: dns.TypeA})
+		}
+		if sampled := len(buffer.take()); sampled < test.min || sampled > test.max {
+			t.Errorf("Expected between %d and %d spans out of %d queries at %v, got %d", test.min, test.max, test.queries, test.ratio, sampled)
+		}
+		if left := len(buffer.take()); left != 0 {
+			t.Errorf("Expected the spans to be taken once, got %d again", left)
+		}
+	}
+}
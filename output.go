@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/MickaelBergem/dnsstresss/stress"
+	"github.com/miekg/dns"
+)
+
+// statsRecord is the machine-readable form of the stats of an interval, or of the summary
+type statsRecord struct {
+	Type        string                          `json:"type"`
+	Timestamp   time.Time                       `json:"timestamp"`
+	Warmup      bool                            `json:"warmup,omitempty"`
+	Duration    float64                         `json:"duration_s"`
+	Sent        int                             `json:"sent"`
+	Received    int                             `json:"received"`
+	Errors      int                             `json:"errors"`
+	Timeouts    int                             `json:"timeouts"`
+	Retries     int                             `json:"retries"`
+	Records     int                             `json:"transfer_records,omitempty"`
+	QPS         float64                         `json:"qps"`
+	MeanLatency float64                         `json:"mean_latency_ms"`
+	MaxLatency  float64                         `json:"max_latency_ms"`
+	StdDev      float64                         `json:"latency_stddev_ms"`
+	IQR         float64                         `json:"latency_iqr_ms"`
+	Percentiles map[string]float64              `json:"latency_percentiles_ms"`
+	Sizes       *sizesRecord                    `json:"response_sizes_bytes,omitempty"`
+	MaxRate     int                             `json:"max_rate,omitempty"`
+	TargetRate  int                             `json:"target_rate,omitempty"`
+	Rcodes      map[string]int                  `json:"rcodes,omitempty"`
+	Protocols   map[string]int                  `json:"doh_protocols,omitempty"`
+	Compared    *comparedRecord                 `json:"compared,omitempty"`
+	Domains     map[string]countsRecord         `json:"domains,omitempty"`
+	Types       map[string]countsRecord         `json:"types,omitempty"`
+	Fuzz        map[string]map[string]int       `json:"fuzz,omitempty"`
+	Cache       map[string]cacheRecord          `json:"cache,omitempty"`
+	SOA         map[string]map[string]soaRecord `json:"soa,omitempty"`
+}
+
+// soaRecord are the checks of the SOA record of a zone on a resolver
+type soaRecord struct {
+	Checks      int     `json:"checks"`
+	Errors      int     `json:"errors"`
+	FirstSerial uint32  `json:"first_serial,omitempty"`
+	Serial      uint32  `json:"serial,omitempty"`
+	Changes     int     `json:"serial_changes"`
+	Behind      int     `json:"behind"`
+	MeanLatency float64 `json:"mean_latency_ms"`
+	MaxLatency  float64 `json:"max_latency_ms"`
+}
+
+// cacheRecord are the answers for a domain inferred to come from the cache, or not
+type cacheRecord struct {
+	Hits     int     `json:"hits"`
+	Misses   int     `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// countsRecord are the stats of a subset of the queries, e.g. of a target domain
+type countsRecord struct {
+	Sent        int     `json:"sent"`
+	Errors      int     `json:"errors"`
+	MeanLatency float64 `json:"mean_latency_ms"`
+}
+
+func newCountsRecord(counts stress.QueryCounts) countsRecord {
+	record := countsRecord{Sent: counts.Sent, Errors: counts.Errors}
+	if counts.Sent > 0 {
+		record.MeanLatency = 1000. * counts.Elapsed.Seconds() / float64(counts.Sent)
+	}
+	return record
+}
+
+// sizesRecord are the wire sizes of the answers
+type sizesRecord struct {
+	Min  int64   `json:"min"`
+	Mean float64 `json:"mean"`
+	Max  int64   `json:"max"`
+}
+
+// comparedRecord are the stats of the compared resolver
+type comparedRecord struct {
+	Errors           int                `json:"errors"`
+	MeanLatency      float64            `json:"mean_latency_ms"`
+	Percentiles      map[string]float64 `json:"latency_percentiles_ms"`
+	RcodeMismatches  int                `json:"rcode_mismatches"`
+	AnswerMismatches int                `json:"answer_mismatches"`
+}
+
+func newStatsRecord(recordType string, counts *stress.Stats) statsRecord {
+	duration := counts.Duration
+	qps := 0.
+	if duration > 0 {
+		qps = float64(counts.Sent) / duration.Seconds()
+	}
+	record := statsRecord{
+		Type:        recordType,
+		Timestamp:   time.Now(),
+		Warmup:      counts.Warmup,
+		Duration:    duration.Seconds(),
+		Sent:        counts.Sent,
+		Received:    counts.Received,
+		Errors:      counts.Errors,
+		Timeouts:    counts.Timeouts(),
+		Retries:     counts.Retries,
+		Records:     counts.TransferRecords,
+		QPS:         qps,
+		MeanLatency: counts.MeanLatency(),
+		MaxLatency:  1000. * counts.MaxElapsed.Seconds(),
+		StdDev:      counts.LatencyStdDev(),
+		IQR:         counts.LatencyIQR(),
+		Percentiles: make(map[string]float64, len(latencyPercentiles)),
+		Rcodes:      counts.ByOutcome,
+		Protocols:   counts.ByProtocol,
+		Fuzz:        counts.ByFuzz,
+		TargetRate:  counts.TargetRate,
+	}
+	for _, percentile := range latencyPercentiles {
+		record.Percentiles[percentileName(percentile)] = percentileMs(counts.Latency, percentile)
+	}
+	if counts.Search != nil {
+		record.MaxRate = counts.Search.MaxRate
+	}
+	if sizes := counts.ResponseSizes; sizes.TotalCount() > 0 {
+		record.Sizes = &sizesRecord{Min: sizes.Min(), Mean: sizes.Mean(), Max: sizes.Max()}
+	}
+	if perDomain && len(counts.ByDomain) > 0 {
+		record.Domains = make(map[string]countsRecord, len(counts.ByDomain))
+		for domain, domainCounts := range counts.ByDomain {
+			record.Domains[domain] = newCountsRecord(domainCounts)
+		}
+	}
+	if len(counts.ByCache) > 0 {
+		record.Cache = make(map[string]cacheRecord, len(counts.ByCache))
+		for domain, cacheCounts := range counts.ByCache {
+			record.Cache[domain] = cacheRecord{Hits: cacheCounts.Hits, Misses: cacheCounts.Misses, HitRatio: cacheCounts.HitRatio()}
+		}
+	}
+	if len(counts.BySOA) > 0 {
+		record.SOA = make(map[string]map[string]soaRecord, len(counts.BySOA))
+		for zone, resolvers := range counts.BySOA {
+			record.SOA[zone] = make(map[string]soaRecord, len(resolvers))
+			for resolver, soaCounts := range resolvers {
+				record.SOA[zone][resolver] = soaRecord{
+					Checks:      soaCounts.Checks,
+					Errors:      soaCounts.Errors,
+					FirstSerial: soaCounts.FirstSerial,
+					Serial:      soaCounts.Serial,
+					Changes:     soaCounts.Changes,
+					Behind:      soaCounts.Behind,
+					MeanLatency: soaCounts.MeanLatency(),
+					MaxLatency:  1000. * soaCounts.MaxElapsed.Seconds(),
+				}
+			}
+		}
+	}
+	if len(counts.ByType) > 0 {
+		record.Types = make(map[string]countsRecord, len(counts.ByType))
+		for qtype, typeCounts := range counts.ByType {
+			record.Types[dns.TypeToString[qtype]] = newCountsRecord(typeCounts)
+		}
+	}
+	if compared := counts.Compared; compared != nil {
+		record.Compared = &comparedRecord{
+			Errors:           compared.Errors,
+			MeanLatency:      record.MeanLatency + comparedMeanDelta(counts),
+			Percentiles:      make(map[string]float64, len(latencyPercentiles)),
+			RcodeMismatches:  compared.RcodeMismatches,
+			AnswerMismatches: compared.AnswerMismatches,
+		}
+		for _, percentile := range latencyPercentiles {
+			record.Compared.Percentiles[percentileName(percentile)] = percentileMs(compared.Latency, percentile)
+		}
+	}
+	return record
+}
+
+// percentileName returns the name of a percentile, e.g. "p99.9"
+func percentileName(percentile float64) string {
+	return "p" + strconv.FormatFloat(percentile, 'f', -1, 64)
+}
+
+// recordWriter writes stats records in a machine-readable format
+type recordWriter interface {
+	writeRecord(record statsRecord) error
+}
+
+// jsonStats writes one JSON object per line for each record
+type jsonStats struct {
+	encoder *json.Encoder
+}
+
+func newJSONStats(w io.Writer) *jsonStats {
+	return &jsonStats{encoder: json.NewEncoder(w)}
+}
+
+func (j *jsonStats) writeRecord(record statsRecord) error {
+	return j.encoder.Encode(record)
+}
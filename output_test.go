@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/MickaelBergem/dnsstresss/stress"
+)
+
+func TestNewStatsRecordQPS(t *testing.T) {
+	for _, test := range []struct {
+		duration time.Duration
+		expected float64
+	}{
+		{2 * time.Second, 50},
+		{0, 0}, // e.g. an interval cut by the end of the warmup
+	} {
+		counts := &stress.Stats{
+			Sent:          100,
+			Duration:      test.duration,
+			Latency:       hdrhistogram.New(1, 60000000, 3),
+			ResponseSizes: hdrhistogram.New(1, 65535, 3),
+		}
+		record := newStatsRecord("interval", counts)
+		if record.QPS != test.expected {
+			t.Errorf("Expected %v queries per second over %s, got %v", test.expected, test.duration, record.QPS)
+		}
+		if _, err := json.Marshal(record); err != nil {
+			t.Errorf("Unable to encode the record over %s: %s", test.duration, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// The schedule of a run bounded by -count or -duration, and where it is
+var (
+	runStarted      time.Time
+	totalSent       int // Queries sent in the intervals, including the warmup
+	plannedCount    int
+	plannedDuration time.Duration
+)
+
+// startProgress records the start of the run, whose agents each send -count queries
+func startProgress(agentsCount int) {
+	runStarted = time.Now()
+	plannedCount = count
+	if agentsCount > 0 {
+		plannedCount *= agentsCount
+	}
+	plannedDuration = duration
+}
+
+// formatProgress describes where a bounded run is in its schedule: its completion, the time
+// elapsed and left, and the number of queries it is projected to send. The run ends at the
+// earliest of its duration and of the time the count is projected to be reached at the current
+// pace. It returns an empty string for the runs without bounds.
+func formatProgress() string {
+	if runStarted.IsZero() {
+		return ""
+	}
+	return progressAt(time.Since(runStarted), totalSent)
+}
+
+// progressAt describes the progress of the run once it sent the given queries, with no
+// projection until it sent some
+func progressAt(elapsed time.Duration, sent int) string {
+	if plannedCount == 0 && plannedDuration == 0 {
+		return ""
+	}
+	end := plannedDuration
+	projected := 0
+	byCount := false // The count is reached before the duration
+	if plannedDuration > 0 && elapsed > 0 {
+		projected = int(math.Round(float64(sent) * plannedDuration.Seconds() / elapsed.Seconds()))
+	}
+	if plannedCount > 0 && sent > 0 && elapsed > 0 {
+		if countEnd := time.Duration(float64(elapsed) * float64(plannedCount) / float64(sent)); end == 0 || countEnd < end {
+			end = countEnd
+			projected = plannedCount
+			byCount = true
+		}
+	}
+	if end == 0 {
+		// Nothing tells yet when the count is reached
+		return ""
+	}
+	left := end - elapsed
+	if left < 0 {
+		left = 0
+	}
+	done := 100 * elapsed.Seconds() / end.Seconds()
+	if byCount {
+		done = 100 * float64(sent) / float64(plannedCount)
+	}
+	if done > 100 {
+		done = 100
+	}
+	return fmt.Sprintf("[%.0f%%, %s elapsed, %s left, ~%d queries]", done, elapsed.Round(time.Second), left.Round(time.Second), projected)
+}
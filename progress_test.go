@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressAt(t *testing.T) {
+	defer func(count int, duration time.Duration) {
+		plannedCount, plannedDuration = count, duration
+	}(plannedCount, plannedDuration)
+
+	for _, test := range []struct {
+		count    int
+		duration time.Duration
+		elapsed  time.Duration
+		sent     int
+		expected string
+	}{
+		{0, 0, 10 * time.Second, 1000, ""}, // Not bounded
+		// Bounded by the duration, projecting the queries at the current pace
+		{0, time.Minute, 15 * time.Second, 1500, "[25%, 15s elapsed, 45s left, ~6000 queries]"},
+		{0, time.Minute, 15 * time.Second, 0, "[25%, 15s elapsed, 45s left, ~0 queries]"},
+		{0, time.Minute, 0, 0, "[0%, 0s elapsed, 1m0s left, ~0 queries]"},
+		{0, time.Minute, 2 * time.Minute, 100, "[100%, 2m0s elapsed, 0s left, ~50 queries]"},
+		// Bounded by the count, ending when it is projected to be reached
+		{1000, 0, 10 * time.Second, 250, "[25%, 10s elapsed, 30s left, ~1000 queries]"},
+		{1000, 0, 10 * time.Second, 0, ""},
+		{1000, 0, 0, 0, ""},
+		{1000, 0, 10 * time.Second, 1200, "[100%, 10s elapsed, 0s left, ~1000 queries]"},
+		// Bounded by both, the earliest wins
+		{1000, time.Minute, 10 * time.Second, 500, "[50%, 10s elapsed, 10s left, ~1000 queries]"},
+		{100000, time.Minute, 10 * time.Second, 500, "[17%, 10s elapsed, 50s left, ~3000 queries]"},
+		{1000, time.Minute, 10 * time.Second, 0, "[17%, 10s elapsed, 50s left, ~0 queries]"},
+	} {
+		plannedCount, plannedDuration = test.count, test.duration
+		if progress := progressAt(test.elapsed, test.sent); progress != test.expected {
+			t.Errorf("Expected %q for %d queries out of %d in %s of %s, got %q",
+				test.expected, test.sent, test.count, test.elapsed, test.duration, progress)
+		}
+	}
+}
+
+func TestFormatProgressNotStarted(t *testing.T) {
+	defer func(started time.Time, count int) {
+		runStarted, plannedCount = started, count
+	}(runStarted, plannedCount)
+	runStarted, plannedCount = time.Time{}, 1000
+	if progress := formatProgress(); progress != "" {
+		t.Errorf("Expected no progress before the run started, got %q", progress)
+	}
+}
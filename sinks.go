@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sinkPrefix starts the names of the metrics pushed to the sinks
+const sinkPrefix = "dnsstresss"
+
+// maxDatagramSize keeps the StatsD and InfluxDB datagrams below the usual MTU
+const maxDatagramSize = 1400
+
+// sinkTimeout bounds the time spent pushing the stats of an interval
+const sinkTimeout = 2 * time.Second
+
+// sinkMetric is a value of the stats of an interval, named like "latency.p99" or "rcode.NOERROR"
+type sinkMetric struct {
+	name    string
+	value   float64
+	counter bool // A count of the interval, the other metrics are gauges
+}
+
+// sinkMetrics flattens a stats record into metrics, sorted by name
+func sinkMetrics(record statsRecord) []sinkMetric {
+	metrics := []sinkMetric{
+		{"sent", float64(record.Sent), true},
+		{"received", float64(record.Received), true},
+		{"errors", float64(record.Errors), true},
+		{"timeouts", float64(record.Timeouts), true},
+		{"retries", float64(record.Retries), true},
+		{"qps", record.QPS, false},
+		{"latency.mean", record.MeanLatency, false},
+		{"latency.max", record.MaxLatency, false},
+		{"latency.stddev", record.StdDev, false},
+		{"latency.iqr", record.IQR, false},
+	}
+	for _, percentile := range latencyPercentiles {
+		name := percentileName(percentile)
+		metrics = append(metrics, sinkMetric{"latency." + metricName(name), record.Percentiles[name], false})
+	}
+	if record.Sizes != nil {
+		metrics = append(metrics,
+			sinkMetric{"response_size.mean", record.Sizes.Mean, false},
+			sinkMetric{"response_size.max", float64(record.Sizes.Max), false},
+		)
+	}
+	for outcome, n := range record.Rcodes {
+		metrics = append(metrics, sinkMetric{"rcode." + metricName(outcome), float64(n), true})
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name < metrics[j].name
+	})
+	return metrics
+}
+
+// metricName replaces the characters that the sinks use as separators
+func metricName(name string) string {
+	return strings.NewReplacer(".", "_", " ", "_", ",", "_", "=", "_", ":", "_").Replace(name)
+}
+
+// formatValue formats a metric value without trailing zeros
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// newSink returns the writer pushing the stats of the intervals to a metrics sink given as an
+// URL: statsd://host:port, influx://host:port (line protocol over UDP),
+// influx+http://host:port/write?db=name (or influx+https), graphite://host:port or
+// otlp+http://host:port (or otlp+https)
+func newSink(sinkURL string) (recordWriter, error) {
+	parsed, err := url.Parse(sinkURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("no address in %q", sinkURL)
+	}
+	switch parsed.Scheme {
+	case "statsd":
+		return &datagramSink{address: parsed.Host, format: statsdLines}, nil
+	case "influx":
+		return &datagramSink{address: parsed.Host, format: influxLines}, nil
+	case "influx+http", "influx+https":
+		parsed.Scheme = strings.TrimPrefix(parsed.Scheme, "influx+")
+		return &httpSink{url: parsed.String(), client: &http.Client{Timeout: sinkTimeout}}, nil
+	case "graphite":
+		return &graphiteSink{address: parsed.Host}, nil
+	case "otlp+http", "otlp+https":
+		parsed.Scheme = strings.TrimPrefix(parsed.Scheme, "otlp+")
+		return newOTLPSink(parsed.String()), nil
+	}
+	return nil, fmt.Errorf("unknown sink %q (expected statsd, influx, influx+http, influx+https, graphite, otlp+http or otlp+https)", parsed.Scheme)
+}
+
+// multiSink pushes the records to several sinks
+type multiSink []recordWriter
+
+func (m multiSink) writeRecord(record statsRecord) error {
+	var failed []string
+	for _, sink := range m {
+		if err := sink.writeRecord(record); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// statsdLines formats the metrics for StatsD: counters for the counts, gauges for the others
+func statsdLines(record statsRecord) []string {
+	var lines []string
+	for _, metric := range sinkMetrics(record) {
+		kind := "g"
+		if metric.counter {
+			kind = "c"
+		}
+		lines = append(lines, fmt.Sprintf("%s.%s:%s|%s", sinkPrefix, metric.name, formatValue(metric.value), kind))
+	}
+	return lines
+}
+
+// influxLines formats the metrics as a single point of the InfluxDB line protocol
+func influxLines(record statsRecord) []string {
+	var fields []string
+	for _, metric := range sinkMetrics(record) {
+		value := formatValue(metric.value)
+		if metric.counter {
+			value += "i"
+		}
+		fields = append(fields, metricName(metric.name)+"="+value)
+	}
+	return []string{fmt.Sprintf("%s,warmup=%t %s %d", sinkPrefix, record.Warmup, strings.Join(fields, ","), record.Timestamp.UnixNano())}
+}
+
+// datagramSink sends the lines of each record over UDP, in datagrams of several lines
+type datagramSink struct {
+	address string
+	format  func(record statsRecord) []string
+	conn    net.Conn
+}
+
+func (d *datagramSink) writeRecord(record statsRecord) error {
+	if d.conn == nil {
+		conn, err := net.Dial("udp", d.address)
+		if err != nil {
+			return err
+		}
+		d.conn = conn
+	}
+	var datagram bytes.Buffer
+	flush := func() error {
+		if datagram.Len() == 0 {
+			return nil
+		}
+		_, err := d.conn.Write(datagram.Bytes())
+		datagram.Reset()
+		return err
+	}
+	for _, line := range d.format(record) {
+		if datagram.Len() > 0 && datagram.Len()+1+len(line) > maxDatagramSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if datagram.Len() > 0 {
+			datagram.WriteByte('\n')
+		}
+		datagram.WriteString(line)
+	}
+	return flush()
+}
+
+// httpSink posts the points to the write endpoint of InfluxDB
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (h *httpSink) writeRecord(record statsRecord) error {
+	body := strings.Join(influxLines(record), "\n") + "\n"
+	response, err := h.client.Post(h.url, "text/plain; charset=utf-8", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("InfluxDB answered %s (%s)", response.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// graphiteSink sends the metrics over the plaintext protocol of Graphite, on a TCP connection
+// opened again after errors
+type graphiteSink struct {
+	address string
+	conn    net.Conn
+}
+
+func (g *graphiteSink) writeRecord(record statsRecord) error {
+	if g.conn == nil {
+		conn, err := net.DialTimeout("tcp", g.address, sinkTimeout)
+		if err != nil {
+			return err
+		}
+		g.conn = conn
+	}
+	var lines strings.Builder
+	for _, metric := range sinkMetrics(record) {
+		fmt.Fprintf(&lines, "%s.%s %s %d\n", sinkPrefix, metric.name, formatValue(metric.value), record.Timestamp.Unix())
+	}
+	g.conn.SetWriteDeadline(time.Now().Add(sinkTimeout))
+	if _, err := io.WriteString(g.conn, lines.String()); err != nil {
+		g.conn.Close()
+		g.conn = nil
+		return err
+	}
+	return nil
+}
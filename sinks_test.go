@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sinkTestRecord() statsRecord {
+	return statsRecord{
+		Type:        "interval",
+		Timestamp:   time.Unix(1700000000, 0),
+		Sent:        100,
+		Received:    98,
+		Errors:      2,
+		Timeouts:    1,
+		QPS:         50,
+		MeanLatency: 1.5,
+		MaxLatency:  12.25,
+		StdDev:      0.5,
+		IQR:         0.75,
+		Percentiles: map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 4, "p99.9": 10},
+		Rcodes:      map[string]int{"NOERROR": 97, "SERVFAIL": 1},
+	}
+}
+
+func TestNewSink(t *testing.T) {
+	for _, test := range []struct {
+		url, expected string
+	}{
+		{"statsd://127.0.0.1:8125", "*main.datagramSink 127.0.0.1:8125"},
+		{"influx://127.0.0.1:8089", "*main.datagramSink 127.0.0.1:8089"},
+		{"influx+http://127.0.0.1:8086/write?db=dns", "*main.httpSink http://127.0.0.1:8086/write?db=dns"},
+		{"influx+https://influx.example/write?db=dns", "*main.httpSink https://influx.example/write?db=dns"},
+		{"graphite://127.0.0.1:2003", "*main.graphiteSink 127.0.0.1:2003"},
+		{"otlp+http://127.0.0.1:4318", "*main.otlpSink"},
+	} {
+		sink, err := newSink(test.url)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", test.url, err)
+			continue
+		}
+		description := fmt.Sprintf("%T", sink)
+		switch sink := sink.(type) {
+		case *datagramSink:
+			description += " " + sink.address
+		case *httpSink:
+			description += " " + sink.url
+		case *graphiteSink:
+			description += " " + sink.address
+		}
+		if description != test.expected {
+			t.Errorf("Expected %s for %s, got %s", test.expected, test.url, description)
+		}
+	}
+	for _, url := range []string{"statsd://", "127.0.0.1:8125", "kafka://127.0.0.1:9092", "%zz"} {
+		if _, err := newSink(url); err == nil {
+			t.Errorf("Expected an error for %q", url)
+		}
+	}
+}
+
+func TestStatsdLines(t *testing.T) {
+	expected := []string{
+		"dnsstresss.errors:2|c",
+		"dnsstresss.latency.iqr:0.75|g",
+		"dnsstresss.latency.max:12.25|g",
+		"dnsstresss.latency.mean:1.5|g",
+		"dnsstresss.latency.p50:1|g",
+		"dnsstresss.latency.p90:2|g",
+		"dnsstresss.latency.p95:3|g",
+		"dnsstresss.latency.p99:4|g",
+		"dnsstresss.latency.p99_9:10|g",
+		"dnsstresss.latency.stddev:0.5|g",
+		"dnsstresss.qps:50|g",
+		"dnsstresss.rcode.NOERROR:97|c",
+		"dnsstresss.rcode.SERVFAIL:1|c",
+		"dnsstresss.received:98|c",
+		"dnsstresss.retries:0|c",
+		"dnsstresss.sent:100|c",
+		"dnsstresss.timeouts:1|c",
+	}
+	if lines := statsdLines(sinkTestRecord()); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected the lines\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+}
+
+func TestInfluxLines(t *testing.T) {
+	expected := []string{"dnsstresss,warmup=false errors=2i,latency_iqr=0.75,latency_max=12.25,latency_mean=1.5," +
+		"latency_p50=1,latency_p90=2,latency_p95=3,latency_p99=4,latency_p99_9=10,latency_stddev=0.5,qps=50," +
+		"rcode_NOERROR=97i,rcode_SERVFAIL=1i,received=98i,retries=0i,sent=100i,timeouts=1i 1700000000000000000"}
+	if lines := influxLines(sinkTestRecord()); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected the lines\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+}
+
+func TestGraphiteSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	sink := &graphiteSink{address: listener.Addr().String()}
+	if err := sink.writeRecord(sinkTestRecord()); err != nil {
+		t.Fatal(err)
+	}
+	defer sink.conn.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(conn)
+	for _, expected := range []string{
+		"dnsstresss.errors 2 1700000000\n",
+		"dnsstresss.latency.iqr 0.75 1700000000\n",
+		"dnsstresss.latency.max 12.25 1700000000\n",
+	} {
+		if line, err := reader.ReadString('\n'); err != nil || line != expected {
+			t.Errorf("Expected the line %q, got %q (%v)", expected, line, err)
+		}
+	}
+}
+
+func TestDatagramSinkSplit(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("dnsstresss.metric_%03d:%d|c", i, i)+strings.Repeat("0", 20))
+	}
+	sink := &datagramSink{address: listener.LocalAddr().String(), format: func(record statsRecord) []string {
+		return lines
+	}}
+	if err := sink.writeRecord(statsRecord{}); err != nil {
+		t.Fatal(err)
+	}
+	defer sink.conn.Close()
+
+	var datagrams, received []string
+	buffer := make([]byte, 65536)
+	for len(received) < len(lines) {
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := listener.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("Got %d lines out of %d: %s", len(received), len(lines), err)
+		}
+		datagrams = append(datagrams, string(buffer[:n]))
+		received = append(received, strings.Split(string(buffer[:n]), "\n")...)
+	}
+	if !reflect.DeepEqual(received, lines) {
+		t.Errorf("Expected the lines to be sent in order, got %q", received)
+	}
+	if len(datagrams) < 2 {
+		t.Errorf("Expected the lines to be split, got %d datagram", len(datagrams))
+	}
+	for i, datagram := range datagrams {
+		if len(datagram) > maxDatagramSize {
+			t.Errorf("Expected datagrams of %d bytes at most, got %d", maxDatagramSize, len(datagram))
+		}
+		if next := i + 1; next < len(datagrams) {
+			if line := strings.SplitN(datagrams[next], "\n", 2)[0]; len(datagram)+1+len(line) <= maxDatagramSize {
+				t.Errorf("Expected the datagram of %d bytes to take the next line", len(datagram))
+			}
+		}
+	}
+}
@@ -2,9 +2,15 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/MickaelBergem/dnsstresss/stress"
 	"github.com/logrusorgru/aurora"
+	"github.com/miekg/dns"
 )
 
 func round(val float64) int {
@@ -15,89 +21,641 @@ func round(val float64) int {
 	return int(val + 0.5)
 }
 
-type statsMessage struct {
-	sent       int
-	err        int
-	flush      bool
-	elapsed    time.Duration
-	maxElapsed time.Duration
+// statsOutput is where the periodic stats are written, using statsColors
+var (
+	statsOutput io.Writer     = os.Stdout
+	statsColors aurora.Aurora = aurora.NewAurora(true)
+	csvOutput   *csvStats
+	// timelineOutput gets the JSON records of all the intervals, along with any other output
+	timelineOutput recordWriter
+	// sinkOutput pushes the stats of the intervals to the metrics sinks
+	sinkOutput recordWriter
+	// recordOutput replaces the text stats with machine-readable records
+	recordOutput recordWriter
+)
+
+// totalReceived is the number of replies received in the intervals after the warmup
+var totalReceived int
+
+// reportInterval writes the stats of an interval, in the chosen format
+func reportInterval(interval *stress.Stats) {
+	if queryLog != nil {
+		queryLog.flush()
+	}
+	totalSent += interval.Sent
+	record := newStatsRecord("interval", interval)
+	if csvOutput != nil {
+		if err := csvOutput.writeRecord(record); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write the CSV stats: %s\n", err)
+		}
+	}
+	if timelineOutput != nil {
+		if err := timelineOutput.writeRecord(record); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to record the stats: %s\n", err)
+		}
+	}
+	if sinkOutput != nil {
+		if err := sinkOutput.writeRecord(record); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to push the stats: %s\n", err)
+		}
+	}
+	if webOutput != nil {
+		webOutput.writeRecord(record)
+	}
+	if recordOutput != nil {
+		if quiet {
+			// Only the summary is written
+		} else if err := recordOutput.writeRecord(record); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write the stats: %s\n", err)
+		}
+	} else if liveDashboard != nil {
+		liveDashboard.update(interval)
+	} else {
+		printInterval(interval)
+	}
+	if !interval.Warmup {
+		totalReceived += interval.Received
+	}
 }
 
-func displayStats(channel chan statsMessage) {
-	// Displays every N seconds the number of sent requests, and the rate
-	start := time.Now()
-	sent := 0
-	var elapsed time.Duration
-	var maxElapsed time.Duration
-	errors := 0
-	totalSent := 0
-	totalReceived := 0
-	for {
-		// Read the channel and add the number of sent messages
-		added := <-channel
-		sent += added.sent
-		errors += added.err
-		elapsed += added.elapsed
-		if added.maxElapsed > maxElapsed {
-			maxElapsed = added.maxElapsed
-		}
-
-		if added.flush == true {
-			// Something has asked for a display flush
-
-			elapsedSeconds := time.Since(start).Seconds()
-
-			if sent > 0 {
-				fmt.Printf(
-					"%s %6.dr/s",
-					aurora.Faint("Requests sent:"),
-					round(float64(sent)/elapsedSeconds),
-				)
-
-				// Successful requests? (replies received)
-				fmt.Printf(
-					"\t%s %6.dr/s",
-					aurora.Faint("Replies received:"),
-					round(float64(sent-errors)/elapsedSeconds),
-				)
-
-				fmt.Printf(
-					" (mean=%.0fms / max=%.0fms)",
-					1000.*elapsed.Seconds()/float64(sent),
-					1000.*maxElapsed.Seconds(),
-				)
-
-				if errors > 0 {
-					fmt.Printf(
-						"\t %s",
-						aurora.Red(fmt.Sprintf("Errors: %d (%d%%)",
-							errors,
-							100*errors/sent,
-						)),
-					)
-				}
+// reportSummary writes the stats of the whole run, in the chosen format
+func reportSummary(totals *stress.Stats) {
+	if queryLog != nil {
+		queryLog.flush()
+	}
+	summary := newStatsRecord("summary", totals)
+	if csvOutput != nil {
+		if err := csvOutput.writeRecord(summary); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write the CSV stats: %s\n", err)
+		}
+	}
+	if timelineOutput != nil {
+		if err := timelineOutput.writeRecord(summary); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to record the stats: %s\n", err)
+		}
+	}
+	if webOutput != nil {
+		webOutput.writeRecord(summary)
+		webOutput.close()
+	}
+	if recordOutput != nil {
+		if err := recordOutput.writeRecord(summary); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write the stats: %s\n", err)
+		}
+	} else {
+		displaySummary(totals)
+	}
+}
+
+// printInterval writes the text stats of an interval
+func printInterval(interval *stress.Stats) {
+	if logFile != "" {
+		fmt.Fprintf(statsOutput, "%s ", time.Now().Format(time.RFC3339))
+	}
+
+	duration := interval.Duration
+	sent := interval.Sent
+	errors := interval.Errors
+	if sent > 0 {
+		fmt.Fprintf(
+			statsOutput,
+			"%s %6.dr/s",
+			statsColors.Faint("Requests sent:"),
+			round(float64(sent)/duration.Seconds()),
+		)
+
+		// Successful requests? (replies received)
+		fmt.Fprintf(
+			statsOutput,
+			"\t%s %6.dr/s",
+			statsColors.Faint("Replies received:"),
+			round(float64(interval.Received)/duration.Seconds()),
+		)
+
+		fmt.Fprintf(
+			statsOutput,
+			" (mean=%.0fms / sd=%.1fms / iqr=%.1fms / max=%.0fms)",
+			interval.MeanLatency(),
+			interval.LatencyStdDev(),
+			interval.LatencyIQR(),
+			1000.*interval.MaxElapsed.Seconds(),
+		)
+
+		fmt.Fprintf(statsOutput, " %s", statsColors.Faint("["+formatPercentiles(interval.Latency)+"]"))
+
+		if interval.TargetRate > 0 {
+			fmt.Fprintf(statsOutput, "\t%s %dr/s", statsColors.Faint("Target:"), interval.TargetRate)
+		}
+
+		if interval.Threads > 0 {
+			fmt.Fprintf(statsOutput, "\t%s %d", statsColors.Faint("Threads:"), interval.Threads)
+		}
+
+		if interval.TransferRecords > 0 {
+			fmt.Fprintf(
+				statsOutput,
+				"\t%s %6.dr/s",
+				statsColors.Faint("Records transferred:"),
+				round(float64(interval.TransferRecords)/duration.Seconds()),
+			)
+		}
+
+		if errors > 0 {
+			fmt.Fprintf(
+				statsOutput,
+				"\t %s",
+				statsColors.Red(fmt.Sprintf("%s: %d (%d%%)",
+					errorsLabel(),
+					errors,
+					100*errors/sent,
+				)),
+			)
+		}
+
+		if timeouts := interval.Timeouts(); timeouts > 0 {
+			fmt.Fprintf(
+				statsOutput,
+				"\t %s",
+				statsColors.Red(fmt.Sprintf("Timeouts: %d (%d%%)",
+					timeouts,
+					100*timeouts/sent,
+				)),
+			)
+		}
+
+		if interval.Retries > 0 {
+			fmt.Fprintf(
+				statsOutput,
+				"\t %s",
+				statsColors.Brown(fmt.Sprintf("Retries: %d (first attempt ok: %d%%)",
+					interval.Retries,
+					100*(sent-interval.FirstErrors)/sent,
+				)),
+			)
+		}
+
+		if interval.Invalid > 0 {
+			fmt.Fprintf(
+				statsOutput,
+				"\t %s",
+				statsColors.Magenta(fmt.Sprintf("DNSSEC failures: %d (%d%%)",
+					interval.Invalid,
+					100*interval.Invalid/sent,
+				)),
+			)
+		}
+
+		if interval.Mismatches > 0 {
+			fmt.Fprintf(
+				statsOutput,
+				"\t %s",
+				statsColors.Magenta(fmt.Sprintf("Mismatches: %d (%d%%)",
+					interval.Mismatches,
+					100*interval.Mismatches/sent,
+				)),
+			)
+		}
+
+		if interval.CaseMismatches > 0 {
+			fmt.Fprintf(
+				statsOutput,
+				"\t %s",
+				statsColors.Magenta(fmt.Sprintf("Case mismatches: %d (%d%%)",
+					interval.CaseMismatches,
+					100*interval.CaseMismatches/sent,
+				)),
+			)
+		}
+
+		if interval.Truncated > 0 {
+			fmt.Fprintf(
+				statsOutput,
+				"\t %s",
+				statsColors.Yellow(fmt.Sprintf("Truncated: %d (%d%%)",
+					interval.Truncated,
+					100*interval.Truncated/sent,
+				)),
+			)
+		}
+
+		if compared := interval.Compared; compared != nil {
+			mismatches := compared.RcodeMismatches + compared.AnswerMismatches
+			fmt.Fprintf(
+				statsOutput,
+				"\t %s",
+				statsColors.Cyan(fmt.Sprintf("Compared: %+.1fms, %d errors, %d mismatches (%d%%)",
+					comparedMeanDelta(interval),
+					compared.Errors,
+					mismatches,
+					100*mismatches/sent,
+				)),
+			)
+		}
+
+		// Successful answers are already counted as replies
+		var outcomes []string
+		for _, outcome := range stress.SortedOutcomes(interval.ByOutcome) {
+			if outcome != dns.RcodeToString[dns.RcodeSuccess] {
+				outcomes = append(outcomes, fmt.Sprintf("%s=%d", outcome, interval.ByOutcome[outcome]))
+			}
+		}
+		if len(outcomes) > 0 {
+			fmt.Fprintf(statsOutput, "\t %s", statsColors.Faint("("+strings.Join(outcomes, " ")+")"))
+		}
+	} else {
+		fmt.Fprintf(statsOutput, "No requests were sent %s", statsColors.Sprintf(statsColors.Faint("(total responses received: %d)"), totalReceived))
+	}
+
+	if interval.Warmup {
+		fmt.Fprintf(statsOutput, " %s", statsColors.Faint("(warmup)"))
+	}
+	if progress := formatProgress(); progress != "" {
+		fmt.Fprintf(statsOutput, "\t %s", statsColors.Faint(progress))
+	}
+	fmt.Fprint(statsOutput, "\n")
+
+	if perDomain {
+		printDomains(interval)
+	}
+	if len(interval.BySOA) > 0 {
+		printSOA(interval)
+	}
+}
+
+// printSOA writes the checks of the SOA records made during an interval, below its stats
+func printSOA(interval *stress.Stats) {
+	for _, check := range sortedSOA(interval.BySOA) {
+		counts := check.counts
+		fmt.Fprintf(statsOutput, "  %s %-*s", statsColors.Faint("SOA"), check.width, check.label)
+		if counts.Checks > counts.Errors {
+			fmt.Fprintf(statsOutput, " serial %d (mean=%.1fms)", counts.Serial, counts.MeanLatency())
+		}
+		if counts.Changes > 0 {
+			fmt.Fprintf(statsOutput, "\t %s", statsColors.Brown(fmt.Sprintf("Changed from %d", counts.FirstSerial)))
+		}
+		if counts.Behind > 0 {
+			fmt.Fprintf(statsOutput, "\t %s", statsColors.Magenta("Behind another resolver"))
+		}
+		if counts.Errors > 0 {
+			fmt.Fprintf(statsOutput, "\t %s", statsColors.Red(fmt.Sprintf("Errors: %d/%d", counts.Errors, counts.Checks)))
+		}
+		fmt.Fprint(statsOutput, "\n")
+	}
+}
+
+// soaLine is the checks of a zone on a resolver, labeled for display
+type soaLine struct {
+	label  string
+	width  int // Of the longest label
+	counts stress.SOACounts
+}
+
+// sortedSOA returns the checks of the SOA records by zone then resolver
+func sortedSOA(bySOA map[string]map[string]stress.SOACounts) []soaLine {
+	var lines []soaLine
+	width := 0
+	for zone, resolvers := range bySOA {
+		for resolver, counts := range resolvers {
+			label := zone + " " + resolver
+			if len(label) > width {
+				width = len(label)
+			}
+			lines = append(lines, soaLine{label: label, counts: counts})
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].label < lines[j].label })
+	for i := range lines {
+		lines[i].width = width
+	}
+	return lines
+}
+
+// printDomains writes the breakdown of an interval by target domain, below its stats
+func printDomains(interval *stress.Stats) {
+	domains, width := sortedKeys(interval.ByDomain)
+	for _, domain := range domains {
+		counts := interval.ByDomain[domain]
+		fmt.Fprintf(statsOutput, "  %-*s %6.dr/s", width, domain, round(float64(counts.Sent)/interval.Duration.Seconds()))
+		if !flood && counts.Sent > 0 {
+			fmt.Fprintf(statsOutput, " (mean=%.0fms)", 1000.*counts.Elapsed.Seconds()/float64(counts.Sent))
+			if counts.Errors > 0 {
+				fmt.Fprintf(statsOutput, "\t %s", statsColors.Red(fmt.Sprintf("%s: %d (%d%%)", errorsLabel(), counts.Errors, 100*counts.Errors/counts.Sent)))
+			}
+		}
+		fmt.Fprint(statsOutput, "\n")
+	}
+}
+
+// sortedKeys returns the keys of a breakdown in order, along with the length of the longest one
+func sortedKeys(breakdown map[string]stress.QueryCounts) ([]string, int) {
+	keys := make([]string, 0, len(breakdown))
+	width := 0
+	for key := range breakdown {
+		keys = append(keys, key)
+		if len(key) > width {
+			width = len(key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, width
+}
+
+// errorsLabel is how the failed queries are called: while flooding, they are the ones left
+// unanswered
+func errorsLabel() string {
+	if flood {
+		return "Dropped"
+	}
+	return "Errors"
+}
+
+// displaySummary prints the statistics aggregated over the whole run
+func displaySummary(totals *stress.Stats) {
+	duration := totals.Duration
+	sent := totals.Sent
+	fmt.Printf("\n%s\n", aurora.Bold("Summary:"))
+	fmt.Printf("  %s %d (%dr/s)\n", aurora.Faint("Requests sent:   "), sent, round(float64(sent)/duration.Seconds()))
+	received := totals.Received
+	fmt.Printf("  %s %d (%dr/s)\n", aurora.Faint("Replies received:"), received, round(float64(received)/duration.Seconds()))
+	if totals.TransferRecords > 0 {
+		fmt.Printf(
+			"  %s %d (%dr/s, %d per transfer)\n",
+			aurora.Faint("Records:         "),
+			totals.TransferRecords,
+			round(float64(totals.TransferRecords)/duration.Seconds()),
+			totals.TransferRecords/received,
+		)
+	}
+	if sent > 0 {
+		if flood {
+			fmt.Printf("  %s %d (%d%%)\n", aurora.Faint("Dropped:         "), totals.Errors, 100*totals.Errors/sent)
+		} else {
+			fmt.Printf("  %s %d (%d%%)\n", aurora.Faint("Errors:          "), totals.Errors, 100*totals.Errors/sent)
+		}
+		fmt.Printf("  %s %d (%d%%)\n", aurora.Faint("Timeouts:        "), totals.Timeouts(), 100*totals.Timeouts()/sent)
+		if retries > 0 && !flood {
+			fmt.Printf("  %s %d (first attempt ok: %d%%)\n", aurora.Faint("Retries:         "), totals.Retries, 100*(sent-totals.FirstErrors)/sent)
+		}
+		if dnssecValidate {
+			fmt.Printf("  %s %d (%d%%)\n", aurora.Faint("DNSSEC failures: "), totals.Invalid, 100*totals.Invalid/sent)
+		}
+		if expect != "" {
+			fmt.Printf("  %s %d (%d%%)\n", aurora.Faint("Mismatches:      "), totals.Mismatches, 100*totals.Mismatches/sent)
+		}
+		if randomCase {
+			fmt.Printf("  %s %d (%d%%)\n", aurora.Faint("Case mismatches: "), totals.CaseMismatches, 100*totals.CaseMismatches/sent)
+		}
+		if retryTCP {
+			fmt.Printf("  %s %d (%d%%), %d retried over TCP\n", aurora.Faint("Truncated:       "), totals.Truncated, 100*totals.Truncated/sent, totals.TCPFallbacks)
+		} else if totals.Truncated > 0 {
+			fmt.Printf("  %s %d (%d%%)\n", aurora.Faint("Truncated:       "), totals.Truncated, 100*totals.Truncated/sent)
+		}
+		fmt.Printf(
+			"  %s mean=%.0fms / max=%.0fms\n",
+			aurora.Faint("Latency:         "),
+			totals.MeanLatency(),
+			1000.*totals.MaxElapsed.Seconds(),
+		)
+		fmt.Printf(
+			"  %s sd=%.1fms / iqr=%.1fms\n",
+			aurora.Faint("Latency spread:  "),
+			totals.LatencyStdDev(),
+			totals.LatencyIQR(),
+		)
+		fmt.Printf("  %s %s\n", aurora.Faint("Percentiles:     "), formatPercentiles(totals.Latency))
+		if sizes := totals.ResponseSizes; sizes.TotalCount() > 0 {
+			fmt.Printf("  %s min=%dB / mean=%.0fB / max=%dB\n", aurora.Faint("Response sizes:  "), sizes.Min(), sizes.Mean(), sizes.Max())
+		}
+	}
+	fmt.Printf("  %s %s\n", aurora.Faint("Duration:        "), duration.Round(time.Millisecond))
+	if totals.Threads > 0 {
+		fmt.Printf("  %s %d threads for the best rate\n", aurora.Faint("Concurrency:     "), totals.Threads)
+	}
+
+	if compared := totals.Compared; compared != nil && sent > 0 {
+		fmt.Printf("\n%s\n", aurora.Bold("Compared with "+compareWith+":"))
+		fmt.Printf("  %s %d (%d%%)\n", aurora.Faint("Errors:           "), compared.Errors, 100*compared.Errors/sent)
+		fmt.Printf(
+			"  %s mean=%.1fms (%+.1fms)\n",
+			aurora.Faint("Latency:          "),
+			1000.*compared.Elapsed.Seconds()/float64(sent),
+			comparedMeanDelta(totals),
+		)
+		fmt.Printf("  %s %s\n", aurora.Faint("Percentiles:      "), formatPercentiles(compared.Latency))
+		deltas := make([]string, len(latencyPercentiles))
+		for index, percentile := range latencyPercentiles {
+			deltas[index] = fmt.Sprintf("%s=%+.1f", percentileName(percentile), percentileMs(compared.Latency, percentile)-percentileMs(totals.Latency, percentile))
+		}
+		fmt.Printf("  %s %sms\n", aurora.Faint("Differences:      "), strings.Join(deltas, " "))
+		fmt.Printf("  %s %d (%d%%)\n", aurora.Faint("Rcode mismatches: "), compared.RcodeMismatches, 100*compared.RcodeMismatches/sent)
+		fmt.Printf("  %s %d (%d%%)\n", aurora.Faint("Answer mismatches:"), compared.AnswerMismatches, 100*compared.AnswerMismatches/sent)
+	}
+
+	if search := totals.Search; search != nil {
+		fmt.Printf("\n%s\n", aurora.Bold("Rates tried:"))
+		for _, level := range search.Levels {
+			verdict := aurora.Green("met   ")
+			if !level.Met {
+				verdict = aurora.Red("missed")
+			}
+			fmt.Printf(
+				"  %8dr/s  %s  answered=%.0fr/s, errors=%.2f%%, p99=%.1fms\n",
+				level.Rate, verdict, level.Answered, 100*level.ErrorRate, 1000.*level.P99.Seconds(),
+			)
+		}
+		if search.MaxRate > 0 {
+			fmt.Printf("  %s %s\n", aurora.Faint("Maximum rate:"), aurora.Bold(fmt.Sprintf("%dr/s", search.MaxRate)))
+		} else {
+			fmt.Printf("  %s\n", aurora.Red("No rate tried was met"))
+		}
+	}
+
+	if buckets := totals.LatencyBuckets(); len(buckets) > 0 {
+		fmt.Printf("\n%s\n", aurora.Bold("By latency:"))
+		printLatencyHistogram(buckets)
+	}
+
+	if len(totals.ByOutcome) > 0 {
+		// Shares of the outcomes rather than of the queries sent, as floods count the answers and
+		// the expired queries of the interval, which may have been sent in the previous ones
+		outcomes := 0
+		for _, n := range totals.ByOutcome {
+			outcomes += n
+		}
+		fmt.Printf("\n%s\n", aurora.Bold("By response code:"))
+		for _, outcome := range stress.SortedOutcomes(totals.ByOutcome) {
+			n := totals.ByOutcome[outcome]
+			share := 0
+			if outcomes > 0 {
+				share = 100 * n / outcomes
+			}
+			fmt.Printf("  %-13s %d (%d%%)\n", outcome, n, share)
+		}
+	}
+
+	if len(totals.ByProtocol) > 0 {
+		answers := 0
+		protocols := make([]string, 0, len(totals.ByProtocol))
+		for protocol, n := range totals.ByProtocol {
+			protocols = append(protocols, protocol)
+			answers += n
+		}
+		sort.Strings(protocols)
+		fmt.Printf("\n%s\n", aurora.Bold("By DOH protocol:"))
+		for _, protocol := range protocols {
+			n := totals.ByProtocol[protocol]
+			fmt.Printf("  %-13s %d (%d%%)\n", protocol, n, 100*n/answers)
+		}
+	}
+
+	if answers := int(totals.ResponseSizes.TotalCount()); answers > 0 {
+		fmt.Printf("\n%s\n", aurora.Bold("By response size:"))
+		lower := 0
+		for index, n := range totals.ResponseSizeBuckets() {
+			var label string
+			if index < len(stress.ResponseSizeBounds) {
+				label = fmt.Sprintf("%d-%dB", lower, stress.ResponseSizeBounds[index])
+				lower = stress.ResponseSizeBounds[index] + 1
 			} else {
-				fmt.Printf("No requests were sent %s", aurora.Sprintf(aurora.Faint("(total responses received: %d)"), totalReceived))
+				label = fmt.Sprintf("> %dB", stress.ResponseSizeBounds[index-1])
+			}
+			fmt.Printf("  %-13s %d (%d%%)\n", label, n, 100*n/answers)
+		}
+	}
+
+	if len(totals.ByUpdate) > 0 {
+		fmt.Printf("\n%s\n", aurora.Bold("By update:"))
+		for _, operation := range []string{stress.UpdateAdd, stress.UpdateDelete} {
+			if counts, ok := totals.ByUpdate[operation]; ok {
+				printBreakdown(fmt.Sprintf("%-8s", operation), counts)
 			}
+		}
+	}
+
+	if len(totals.ByType) > 0 {
+		fmt.Printf("\n%s\n", aurora.Bold("By query type:"))
+		for _, qtype := range stress.SortedTypes(totals.ByType) {
+			printBreakdown(fmt.Sprintf("%-8s", dns.TypeToString[qtype]), totals.ByType[qtype])
+		}
+	}
+
+	if len(totals.ByResolver) > 0 {
+		fmt.Printf("\n%s\n", aurora.Bold("By resolver:"))
+		addresses := make([]string, 0, len(totals.ByResolver))
+		for address := range totals.ByResolver {
+			addresses = append(addresses, address)
+		}
+		sort.Strings(addresses)
+		for _, address := range addresses {
+			printBreakdown(address, totals.ByResolver[address])
+		}
+	}
 
-			fmt.Print("\n")
+	if perDomain && len(totals.ByDomain) > 0 {
+		fmt.Printf("\n%s\n", aurora.Bold("By domain:"))
+		domains, width := sortedKeys(totals.ByDomain)
+		for _, domain := range domains {
+			printBreakdown(fmt.Sprintf("%-*s", width, domain), totals.ByDomain[domain])
+		}
+	}
 
-			start = time.Now()
-			totalSent += sent
-			totalReceived += sent - errors
-			sent = 0
-			errors = 0
-			elapsed = 0
-			maxElapsed = 0
+	if len(totals.ByAmplification) > 0 {
+		fmt.Printf("\n%s\n", aurora.Bold("By amplification:"))
+		keys := stress.SortedAmplification(totals.ByAmplification)
+		width := 0
+		for _, key := range keys {
+			if len(key) > width {
+				width = len(key)
+			}
+		}
+		for _, key := range keys {
+			counts := totals.ByAmplification[key]
+			fmt.Printf(
+				"  %-*s %5.1fx  %d answers, query=%dB, answer mean=%dB / max=%dB\n",
+				width, key,
+				counts.Factor(),
+				counts.Answers,
+				round(float64(counts.QueryBytes)/float64(counts.Answers)),
+				round(float64(counts.ResponseBytes)/float64(counts.Answers)),
+				counts.MaxResponse,
+			)
 		}
 	}
+
+	if len(totals.ByCache) > 0 {
+		fmt.Printf("\n%s\n", aurora.Bold("By cache:"))
+		domains := make([]string, 0, len(totals.ByCache))
+		width := 0
+		for domain := range totals.ByCache {
+			domains = append(domains, domain)
+			if len(domain) > width {
+				width = len(domain)
+			}
+		}
+		sort.Strings(domains)
+		for _, domain := range domains {
+			counts := totals.ByCache[domain]
+			fmt.Printf("  %-*s hit ratio %5.1f%% (%d hits, %d misses)\n", width, domain, 100*counts.HitRatio(), counts.Hits, counts.Misses)
+		}
+	}
+
+	if len(totals.BySOA) > 0 {
+		fmt.Printf("\n%s\n", aurora.Bold("By SOA:"))
+		for _, check := range sortedSOA(totals.BySOA) {
+			counts := check.counts
+			fmt.Printf("  %-*s %d checks, %d errors", check.width, check.label, counts.Checks, counts.Errors)
+			if counts.Checks > counts.Errors {
+				fmt.Printf(", serial %d", counts.Serial)
+				if counts.Changes > 0 {
+					fmt.Printf(" (%d changes from %d)", counts.Changes, counts.FirstSerial)
+				}
+				if counts.Behind > 0 {
+					fmt.Printf(", behind %d times", counts.Behind)
+				}
+			}
+			fmt.Printf(", mean=%.1fms / max=%.0fms\n", counts.MeanLatency(), 1000.*counts.MaxElapsed.Seconds())
+		}
+	}
+
+	if len(totals.ByFuzz) > 0 {
+		fmt.Printf("\n%s\n", aurora.Bold("By mutation:"))
+		mutations := make([]string, 0, len(totals.ByFuzz))
+		for mutation := range totals.ByFuzz {
+			mutations = append(mutations, mutation)
+		}
+		sort.Strings(mutations)
+		for _, mutation := range mutations {
+			fuzzed := 0
+			var outcomes []string
+			for _, outcome := range stress.SortedOutcomes(totals.ByFuzz[mutation]) {
+				n := totals.ByFuzz[mutation][outcome]
+				fuzzed += n
+				outcomes = append(outcomes, fmt.Sprintf("%s=%d", outcome, n))
+			}
+			fmt.Printf("  %-18s %d queries: %s\n", mutation, fuzzed, strings.Join(outcomes, ", "))
+		}
+	}
+}
+
+// comparedMeanDelta returns how much slower the compared resolver answered on average, in
+// milliseconds
+func comparedMeanDelta(counts *stress.Stats) float64 {
+	if counts.Sent == 0 {
+		return 0
+	}
+	return 1000. * (counts.Compared.Elapsed - counts.Elapsed).Seconds() / float64(counts.Sent)
 }
 
-func timerStats(channel chan<- statsMessage) {
-	// Periodically triggers a display update for the stats
-	for {
-		timer := time.NewTimer(time.Duration(displayInterval) * time.Millisecond)
-		<-timer.C
-		channel <- statsMessage{flush: true}
+// printBreakdown prints the summary line of a subset of the queries
+func printBreakdown(label string, counts stress.QueryCounts) {
+	fmt.Printf("  %s %d sent", label, counts.Sent)
+	if !flood && counts.Sent > 0 {
+		fmt.Printf(
+			", %d errors (%d%%), mean=%.0fms",
+			counts.Errors,
+			100*counts.Errors/counts.Sent,
+			1000.*counts.Elapsed.Seconds()/float64(counts.Sent),
+		)
 	}
+	fmt.Print("\n")
 }
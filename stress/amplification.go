@@ -0,0 +1,58 @@
+package stress
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+// AmplificationCounts are the sizes of the queries for a name and type, and of their answers
+type AmplificationCounts struct {
+	Answers       int
+	QueryBytes    int
+	ResponseBytes int
+	MaxResponse   int // Size of the largest answer
+}
+
+// Factor returns how many bytes were answered for each byte of the queries
+func (c AmplificationCounts) Factor() float64 {
+	if c.QueryBytes == 0 {
+		return 0
+	}
+	return float64(c.ResponseBytes) / float64(c.QueryBytes)
+}
+
+// amplificationKey identifies the queries for a name and type, like "example.com./ANY"
+func amplificationKey(name string, qtype uint16) string {
+	return fmt.Sprintf("%s/%s", name, dns.TypeToString[qtype])
+}
+
+// addAmplification merges the sizes of an interval into the aggregated ones
+func addAmplification(total map[string]AmplificationCounts, added map[string]AmplificationCounts) {
+	for key, counts := range added {
+		current := total[key]
+		current.Answers += counts.Answers
+		current.QueryBytes += counts.QueryBytes
+		current.ResponseBytes += counts.ResponseBytes
+		if counts.MaxResponse > current.MaxResponse {
+			current.MaxResponse = counts.MaxResponse
+		}
+		total[key] = current
+	}
+}
+
+// SortedAmplification returns the names and types of a breakdown, from the largest factor
+func SortedAmplification(byAmplification map[string]AmplificationCounts) []string {
+	keys := make([]string, 0, len(byAmplification))
+	for key := range byAmplification {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if factor, other := byAmplification[keys[i]].Factor(), byAmplification[keys[j]].Factor(); factor != other {
+			return factor > other
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
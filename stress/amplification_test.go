@@ -0,0 +1,42 @@
+package stress
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestAmplification(t *testing.T) {
+	query := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	answer := new(dns.Msg).SetReply(query)
+	for index := 0; index < 4; index++ {
+		answer.Answer = append(answer.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, byte(index)),
+		})
+	}
+	packed, _ := answer.Pack()
+	answer.Compress = true
+	compressed, _ := answer.Pack()
+	answer.Compress = false
+	if size := wireSize(answer); size != len(compressed) || size >= len(packed) {
+		t.Errorf("Got a size of %d, expected %d once compressed", size, len(compressed))
+	}
+
+	total := map[string]AmplificationCounts{}
+	addAmplification(total, map[string]AmplificationCounts{
+		amplificationKey("example.com.", dns.TypeANY): {Answers: 1, QueryBytes: 40, ResponseBytes: 2000, MaxResponse: 2000},
+		amplificationKey("example.com.", dns.TypeA):   {Answers: 1, QueryBytes: 40, ResponseBytes: 80, MaxResponse: 80},
+	})
+	addAmplification(total, map[string]AmplificationCounts{
+		amplificationKey("example.com.", dns.TypeANY): {Answers: 1, QueryBytes: 40, ResponseBytes: 1000, MaxResponse: 1000},
+	})
+	if counts := total["example.com./ANY"]; counts.Answers != 2 || counts.MaxResponse != 2000 || counts.Factor() != 37.5 {
+		t.Errorf("Unexpected sizes of the ANY answers: %+v", counts)
+	}
+	if sorted := SortedAmplification(total); !reflect.DeepEqual(sorted, []string{"example.com./ANY", "example.com./A"}) {
+		t.Errorf("Got the order %v, expected the largest factor first", sorted)
+	}
+}
@@ -0,0 +1,121 @@
+package stress
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// autoConcurrencyGain is the increase of the rate of answers that makes the threads added worth
+// it, smaller ones are taken as noise
+const autoConcurrencyGain = 0.05
+
+// SLA bounds the errors and the latency of the resolvers, which the auto concurrency keeps them
+// within
+type SLA struct {
+	MaxErrorRate float64       // Share of the queries failing, e.g. 0.01 (0 for no bound)
+	MaxP99       time.Duration // 99th percentile of the latency (0 for no bound)
+}
+
+// Met tells whether the stats of a period are within the bounds
+func (s SLA) Met(stats *Stats) bool {
+	if stats.Sent == 0 {
+		return true
+	}
+	if s.MaxErrorRate > 0 && float64(stats.Errors)/float64(stats.Sent) > s.MaxErrorRate {
+		return false
+	}
+	if s.MaxP99 > 0 && time.Duration(stats.Latency.ValueAtQuantile(99))*time.Microsecond > s.MaxP99 {
+		return false
+	}
+	return true
+}
+
+// concurrencyTuner adjusts the number of threads sending queries after each interval, from a
+// single one to the Concurrency of the run: it doubles them while the rate of answers grows
+// (like the slow start of TCP), then adds 10% of them to probe for a higher rate, going back to
+// the best number of threads when the rate does not follow. A quarter of them are removed when
+// the resolvers get out of the SLA.
+type concurrencyTuner struct {
+	max        int
+	sla        SLA
+	active     int32 // Threads sending queries, the others wait
+	slowStart  bool
+	best       float64 // Best rate of answers, measured with bestActive threads
+	bestActive int
+}
+
+func newConcurrencyTuner(max int, sla SLA) *concurrencyTuner {
+	return &concurrencyTuner{max: max, sla: sla, active: 1, slowStart: true, bestActive: 1}
+}
+
+// threads returns the number of threads sending queries
+func (t *concurrencyTuner) threads() int {
+	return int(atomic.LoadInt32(&t.active))
+}
+
+// grow returns the number of threads probing for a higher rate
+func (t *concurrencyTuner) grow(active int) int {
+	next := active + (active+9)/10
+	if t.slowStart {
+		next = 2 * active
+	}
+	if next > t.max {
+		next = t.max
+	}
+	return next
+}
+
+// tune sets the number of threads for the next interval from the stats of the last one
+func (t *concurrencyTuner) tune(interval *Stats) {
+	if interval.Sent == 0 {
+		// Nothing to learn, e.g. while the run is paused
+		return
+	}
+	active := t.threads()
+	rate := float64(interval.Received-interval.Errors) / interval.Duration.Seconds()
+	next := active
+	switch {
+	case !t.sla.Met(interval):
+		t.slowStart = false
+		next = active * 3 / 4
+		if next < 1 {
+			next = 1
+		}
+		// The rates measured before were from resolvers keeping up
+		t.best = 0
+	case rate > t.best*(1+autoConcurrencyGain):
+		t.best = rate
+		t.bestActive = active
+		next = t.grow(active)
+	case active == t.bestActive:
+		// The same threads as the best rate, which is refreshed, probe again
+		t.best = rate
+		t.slowStart = false
+		next = t.grow(active)
+	default:
+		t.slowStart = false
+		next = t.bestActive
+	}
+	atomic.StoreInt32(&t.active, int32(next))
+}
+
+// ActiveThreads returns the number of threads sending queries: all of them, unless the auto
+// concurrency is adjusting it or SetConcurrency lowered it
+func (r *Runner) ActiveThreads() int {
+	if r.tuner == nil {
+		if active := atomic.LoadInt32(&r.activeThreads); active > 0 {
+			return int(active)
+		}
+		return r.cfg.Concurrency
+	}
+	return r.tuner.threads()
+}
+
+// parked tells whether a thread has to wait, beyond the threads the auto concurrency or
+// SetConcurrency let send queries
+func (r *Runner) parked(threadID int) bool {
+	if active := atomic.LoadInt32(&r.activeThreads); active > 0 && threadID >= int(active) {
+		return true
+	}
+	return r.tuner != nil && threadID >= r.tuner.threads()
+}
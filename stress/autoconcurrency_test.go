@@ -0,0 +1,56 @@
+package stress
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConcurrencyTuner(t *testing.T) {
+	tuner := newConcurrencyTuner(100, SLA{MaxErrorRate: 0.01})
+	interval := func(received, errors int) *Stats {
+		stats := newStats(false)
+		stats.Sent = received
+		stats.Received = received
+		stats.Errors = errors
+		stats.Duration = time.Second
+		return stats
+	}
+
+	// The threads are doubled while the rate grows
+	for _, expected := range []int{2, 4, 8} {
+		tuner.tune(interval(1000*tuner.threads(), 0))
+		if tuner.threads() != expected {
+			t.Fatalf("Got %d threads, expected %d", tuner.threads(), expected)
+		}
+	}
+	// Without a higher rate, the best number of threads is restored, then probed again by 10%
+	tuner.tune(interval(4000, 0))
+	if tuner.threads() != 4 {
+		t.Fatalf("Got %d threads, expected to go back to 4", tuner.threads())
+	}
+	tuner.tune(interval(4000, 0))
+	if tuner.threads() != 5 {
+		t.Fatalf("Got %d threads, expected to probe with 5", tuner.threads())
+	}
+	// Out of the SLA, a quarter of the threads are removed
+	tuner.tune(interval(5000, 100))
+	if tuner.threads() != 3 {
+		t.Fatalf("Got %d threads, expected 3 out of the SLA", tuner.threads())
+	}
+}
+
+func TestSLA(t *testing.T) {
+	stats := newStats(false)
+	stats.Sent = 100
+	stats.Errors = 2
+	recordLatency(stats.Latency, 30*time.Millisecond)
+	if !(SLA{}).Met(stats) {
+		t.Errorf("An empty SLA should always be met")
+	}
+	if (SLA{MaxErrorRate: 0.01}).Met(stats) {
+		t.Errorf("2%% of errors should be out of a 1%% SLA")
+	}
+	if (SLA{MaxP99: 20 * time.Millisecond}).Met(stats) {
+		t.Errorf("A p99 of 30ms should be out of a 20ms SLA")
+	}
+}
@@ -0,0 +1,50 @@
+package stress
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultBurstInterval is the time between the starts of two bursts, unless set otherwise
+const defaultBurstInterval = time.Second
+
+// burstPacer releases the queries of all the threads by bursts of Burst queries at the start of
+// each BurstInterval, sent as fast as the threads can, leaving the rest of the interval idle. A
+// burst not sent by the start of the next interval is cut short, so that they never merge.
+type burstPacer struct {
+	mu       sync.Mutex
+	size     int
+	interval time.Duration
+	start    time.Time
+	burst    int64 // Index of the current burst since the start
+	sent     int   // Queries of the current burst handed out
+}
+
+func newBurstPacer(size int, interval time.Duration, start time.Time) *burstPacer {
+	return &burstPacer{size: size, interval: interval, start: start}
+}
+
+func (b *burstPacer) wait() bool {
+	b.mu.Lock()
+	b.advance(time.Now())
+	at := b.start.Add(time.Duration(b.burst) * b.interval)
+	b.mu.Unlock()
+
+	if delay := time.Until(at); delay > 0 {
+		time.Sleep(delay)
+	}
+	return true
+}
+
+// advance hands out a query, in the current burst or in the next one once it is complete
+func (b *burstPacer) advance(now time.Time) {
+	if current := int64(now.Sub(b.start) / b.interval); b.burst < current {
+		b.burst = current
+		b.sent = 0
+	}
+	if b.sent >= b.size {
+		b.burst++
+		b.sent = 0
+	}
+	b.sent++
+}
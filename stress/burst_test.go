@@ -0,0 +1,28 @@
+package stress
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBurstPacer(t *testing.T) {
+	start := time.Now()
+	pacer := newBurstPacer(3, 100*time.Millisecond, start)
+	for _, step := range []struct {
+		at    time.Duration
+		burst int64
+	}{
+		{0, 0}, {0, 0}, {0, 0},
+		{10 * time.Millisecond, 1}, // The first burst is complete, wait for the next one
+		{10 * time.Millisecond, 1},
+		{250 * time.Millisecond, 2}, // The second burst is cut short by the third one
+		{250 * time.Millisecond, 2},
+		{250 * time.Millisecond, 2},
+		{250 * time.Millisecond, 3},
+	} {
+		pacer.advance(start.Add(step.at))
+		if pacer.burst != step.burst {
+			t.Errorf("Expected burst %d at %s, got %d", step.burst, step.at, pacer.burst)
+		}
+	}
+}
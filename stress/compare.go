@@ -0,0 +1,90 @@
+package stress
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/miekg/dns"
+)
+
+// Comparison are the stats of the queries also sent to Config.CompareResolver, next to the ones
+// of the resolvers tested
+type Comparison struct {
+	Errors           int // Queries the compared resolver did not answer, or could not resolve
+	Elapsed          time.Duration
+	Latency          *hdrhistogram.Histogram `json:"-"` // Latencies of its answers, in microseconds
+	RcodeMismatches  int                     // Queries answered with a different response code
+	AnswerMismatches int                     // Queries answered with the same response code, but different records
+}
+
+func newComparison() *Comparison {
+	return &Comparison{Latency: newLatencyHistogram()}
+}
+
+// merge adds the comparison of another period
+func (c *Comparison) merge(other *Comparison) {
+	c.Errors += other.Errors
+	c.Elapsed += other.Elapsed
+	c.RcodeMismatches += other.RcodeMismatches
+	c.AnswerMismatches += other.AnswerMismatches
+	if other.Latency != nil {
+		c.Latency.Merge(other.Latency)
+	}
+}
+
+// comparedAnswer is the outcome of a query sent to the compared resolver
+type comparedAnswer struct {
+	response *dns.Msg
+	err      error
+	spent    time.Duration
+}
+
+// compareAnswers accounts for the answers of the tested and the compared resolvers to a query,
+// and returns a description of their difference, or "" when they agree
+func (c *Comparison) compareAnswers(response *dns.Msg, err error, compared comparedAnswer) string {
+	c.Elapsed += compared.spent
+	if compared.err != nil || failedRcode(compared.response.Rcode) {
+		c.Errors++
+	}
+	if err != nil || compared.err != nil {
+		return ""
+	}
+	if response.Rcode != compared.response.Rcode {
+		c.RcodeMismatches++
+		return rcodeName(response.Rcode) + " vs " + rcodeName(compared.response.Rcode)
+	}
+	if !sameAnswers(response, compared.response) {
+		c.AnswerMismatches++
+		return strings.Join(answerRecords(response), " ") + " vs " + strings.Join(answerRecords(compared.response), " ")
+	}
+	return ""
+}
+
+// sameAnswers tells whether two responses have the same answer records, in any order and
+// regardless of their TTL
+func sameAnswers(a *dns.Msg, b *dns.Msg) bool {
+	first, second := answerRecords(a), answerRecords(b)
+	if len(first) != len(second) {
+		return false
+	}
+	for index := range first {
+		if first[index] != second[index] {
+			return false
+		}
+	}
+	return true
+}
+
+// answerRecords returns the sorted answer records of a response, with their TTL zeroed
+func answerRecords(response *dns.Msg) []string {
+	records := make([]string, len(response.Answer))
+	for index, rr := range response.Answer {
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = 0
+		records[index] = strings.ToLower(rr.String())
+	}
+	sort.Strings(records)
+	return records
+}
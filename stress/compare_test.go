@@ -0,0 +1,34 @@
+package stress
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCompareAnswers(t *testing.T) {
+	answer := func(rcode int, records ...string) *dns.Msg {
+		response := new(dns.Msg)
+		response.Rcode = rcode
+		for _, record := range records {
+			rr, _ := dns.NewRR(record)
+			response.Answer = append(response.Answer, rr)
+		}
+		return response
+	}
+	tested := answer(dns.RcodeSuccess, "example.com. 60 IN A 192.0.2.1", "example.com. 60 IN A 192.0.2.2")
+
+	comparison := newComparison()
+	// Same records in another order, with other TTLs
+	if difference := comparison.compareAnswers(tested, nil, comparedAnswer{response: answer(dns.RcodeSuccess, "example.com. 30 IN A 192.0.2.2", "EXAMPLE.com. 30 IN A 192.0.2.1")}); difference != "" {
+		t.Errorf("Got a difference %q for the same answers", difference)
+	}
+	comparison.compareAnswers(tested, nil, comparedAnswer{response: answer(dns.RcodeSuccess, "example.com. 60 IN A 192.0.2.1")})
+	comparison.compareAnswers(tested, nil, comparedAnswer{response: answer(dns.RcodeServerFailure)})
+	comparison.compareAnswers(tested, nil, comparedAnswer{err: errors.New("timeout")})
+	if comparison.AnswerMismatches != 1 || comparison.RcodeMismatches != 1 || comparison.Errors != 2 {
+		t.Errorf("Got %d answer mismatches, %d response code mismatches and %d errors, expected 1, 1 and 2",
+			comparison.AnswerMismatches, comparison.RcodeMismatches, comparison.Errors)
+	}
+}
@@ -0,0 +1,160 @@
+// Package stress sends DNS queries as fast as possible to resolvers, and measures how they keep
+// up. The dnsstresss command is a thin wrapper over it.
+package stress
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Config holds the options of a run. NewConfig returns one with the defaults of the command line.
+type Config struct {
+	Concurrency int           // Number of threads sending queries, or their maximum with AutoConcurrency
+	Interval    time.Duration // Period of the interval stats
+	Rampup      time.Duration // Spread the start of the threads over this duration
+	Warmup      time.Duration // Duration at the beginning of the run excluded from the summary
+	Count       int           // Stop after sending this number of queries in total (0 for no limit)
+	Duration    time.Duration // Stop after running for this duration (0 for no limit)
+	Rate        int           // Maximum number of queries per second, shared by all the threads
+	Ramp        []RampStep    // Load profile followed by the rate instead, see ParseRampProfile
+	Arrivals    string        // Timing of the queries at the target rate: "constant" or "poisson"
+	Retries     int           // Number of times a failed query is retried before counting it as an error
+	Timeout     time.Duration // Time waited for each answer before counting the query as timed out
+	Flood       bool          // Don't wait for an answer before sending another
+	FloodBatch  int           // Queries sent at once when flooding over UDP without a rate, with sendmmsg on Linux (0 or 1 to send them one by one)
+	InFlight    int           // Queries each flooding thread keeps waiting for an answer at most (0 for no bound)
+	RetryTCP    bool          // Send the queries again over TCP when their answers are truncated
+
+	// Burst sends the queries by bursts of this size at the start of each BurstInterval (1s by
+	// default), idle for the rest of it, instead of at a rate
+	Burst         int
+	BurstInterval time.Duration
+
+	// AutoConcurrency adjusts the number of threads to get the highest rate of answers, keeping
+	// the resolvers within the SLA
+	AutoConcurrency bool
+	SLA             SLA
+	// FindMax searches the highest rate the resolvers keep up with within the SLA, starting from
+	// FindMaxStart queries per second and holding each rate for FindMaxWindow
+	FindMax       bool
+	FindMaxStart  int
+	FindMaxWindow time.Duration
+
+	// Queries are made for the weighted Domains, or the names generated from QueryPattern, or
+	// the queries of QueryFile, or the ones of the Replay capture, or the reverse names of the
+	// addresses of ReverseRanges
+	Domains          []string
+	DomainWeights    []int // Defaults to the same weight for all the domains
+	QueryPattern     string
+	PatternRandom    bool
+	QueryFile        string
+	QueryFileInOrder bool
+	Replay           string       // Packet capture (pcap or pcapng) whose queries are sent again, in order
+	ReplaySpeed      float64      // Follow the timing of the capture, sped up by this factor (0 to ignore it)
+	ReverseRanges    []*net.IPNet // Swept in order, or at random with ReverseRandom
+	ReverseRandom    bool
+	QueryTypes       []uint16 // AXFR or IXFR make zone transfers of the Domains instead, over TCP
+	TypeWeights      []int    // Defaults to the same weight for all the types
+	TransferSerial   uint32   // Serial of the version of the zones the IXFR transfers start from
+	RandomPrefix     bool
+	RandomCase       bool // Randomize the case of the query names (0x20), checking that the answers keep it
+	Update           bool // Send dynamic updates of the Domains as zones instead of queries, adding and deleting records
+	Amplification    bool // Measure the size of the answers relative to the queries, by name and type
+	RandomIDs        bool
+	Iterative        bool
+	Opcode           int         // Opcode of the queries, dns.OpcodeQuery by default
+	HeaderFlags      HeaderFlags // Bits of the header set on the queries
+	// Wordlist is a file of labels prepended to the Domains, from one to WordlistDepth of them (1
+	// by default) picked at random for each query, so that the names come back unlike with
+	// RandomPrefix
+	Wordlist      string
+	WordlistDepth int
+
+	// Resolvers are distributed over according to Distribution: "round-robin", "weighted"
+	// (using ResolverWeights) or "hash" of the query name
+	Resolvers       []string
+	Distribution    string
+	ResolverWeights []int
+	CompareResolver string         // Resolver also sent each query, to compare its answers with the ones of the Resolvers
+	Sources         []*net.UDPAddr `json:"-"` // Local addresses the threads send from, spread over them
+	IPVersion       int            // 4 or 6 to only use this IP version, 0 for either
+	ReuseConn       bool
+	ReusePort       bool
+	TCP             bool
+	DoT             bool
+	PoolSize        int // Connections to each resolver shared by the threads over TCP or TLS, pipelining the queries (0 for one per thread)
+	DoQ             bool
+	TLSServerName   string
+	Insecure        bool
+	TLSCert         string // PEM file of the client certificate presented over TLS, QUIC and HTTPS
+	TLSKey          string // PEM file of its private key, defaults to TLSCert
+
+	DOHEndpoint     string
+	DOHMethod       string
+	DOHHTTP2        bool
+	DOHHTTP3        bool        // Send the DOH requests over QUIC, instead of TCP
+	DOHMaxIdleConns int         // Defaults to the concurrency, not used over HTTP/3
+	DOHHeaders      http.Header // Added to the DOH requests, e.g. for authentication, see ParseHTTPHeaders
+
+	EDNSBufSize    int
+	DNSSECOK       bool
+	EDNSPadding    int
+	DNSSECValidate bool
+	Cookies        bool // Send DNS cookies, echoing the server cookies of each resolver
+
+	// ClientSubnets are sent as EDNS Client Subnet, one of them picked at random for each query.
+	// With a ClientSubnetLength, random subnets of this length within them are sent instead.
+	ClientSubnets      []*net.IPNet
+	ClientSubnetLength int
+
+	TSIG          *TSIGKey     // Signs the queries, the answers have to be signed with the same key
+	DNSSECAnchors []string     // DS records trusted instead of the root zone ones
+	Expect        Expectations // Answers checked against, see ParseExpectations
+	// TrackTTL infers from the TTLs of the answers whether they came from the cache of the
+	// resolvers, see Stats.ByCache
+	TrackTTL bool
+	// Fuzz is the share of the queries replaced by malformed packets (e.g. 0.05), sent on their
+	// own connection and only accounted for in Stats.ByFuzz
+	Fuzz float64
+	// SOAZones are the zones whose SOA record is checked on each resolver every SOAInterval (5s
+	// by default), on a side channel, to follow their serial during the load, see Stats.BySOA
+	SOAZones    []string
+	SOAInterval time.Duration
+
+	// The options below are specific to the host, they are not sent to the agents
+	Metrics bool `json:"-"` // Collect the Prometheus metrics served by Runner.Metrics
+
+	// Logger receives the details of each failed query when set
+	Logger *log.Logger `json:"-"`
+	// OnInterval receives the stats of each interval, which are only valid during the call
+	OnInterval func(stats *Stats) `json:"-"`
+	// OnQuery receives each query of the threads not flooding, it has to be safe for concurrent use
+	OnQuery func(event QueryEvent) `json:"-"`
+}
+
+// NewConfig returns the default options
+func NewConfig() *Config {
+	return &Config{
+		Concurrency:  50,
+		Interval:     time.Second,
+		Timeout:      defaultTimeout,
+		FloodBatch:   defaultFloodBatch,
+		QueryTypes:   []uint16{dns.TypeA},
+		RandomIDs:    true,
+		Resolvers:    []string{"127.0.0.1:53"},
+		Distribution: "round-robin",
+		ReuseConn:    true,
+		DOHMethod:    "GET",
+	}
+}
+
+// logf reports a detail of the run, when logging is enabled
+func (c *Config) logf(format string, a ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, a...)
+	}
+}
@@ -0,0 +1,206 @@
+package stress
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// defaultTimeout bounds the time spent waiting for an answer when no Timeout is set, so that a
+// lost reply does not wedge the thread forever
+const defaultTimeout = 2 * time.Second
+
+// newTLSConfig prepares the TLS configuration for DNS over TLS, QUIC and HTTPS. Sessions are
+// cached so that re-dialed connections can resume them. Without TLSServerName, the server name is
+// the address of each resolver, or the host of the DOH endpoint.
+func newTLSConfig(cfg *Config) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         cfg.TLSServerName,
+		InsecureSkipVerify: cfg.Insecure,
+		ClientSessionCache: tls.NewLRUClientSessionCache(cfg.Concurrency),
+	}
+	if cfg.TLSCert != "" {
+		key := cfg.TLSKey
+		if key == "" {
+			key = cfg.TLSCert
+		}
+		certificate, err := tls.LoadX509KeyPair(cfg.TLSCert, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// transportNetwork returns the network used to reach the resolver, restricted to the IP version
+// when one is set
+func (r *Runner) transportNetwork() string {
+	if r.cfg.TCP || r.cfg.DoT {
+		return r.cfg.ipNetwork("tcp")
+	}
+	return r.cfg.ipNetwork("udp")
+}
+
+// ipNetwork returns the family specific variant of a network (e.g. "udp6") when an IP version is
+// set
+func (c *Config) ipNetwork(network string) string {
+	if c.IPVersion != 0 {
+		return fmt.Sprintf("%s%d", network, c.IPVersion)
+	}
+	return network
+}
+
+// TransportName returns the name of the transport used to reach the resolvers, for display,
+// along with the IP version when one is set (e.g. "TCP/IPv6")
+func (r *Runner) TransportName() string {
+	name := "UDP"
+	if r.cfg.DoT {
+		name = "TLS"
+	} else if r.cfg.DoQ {
+		name = "QUIC"
+	} else if r.cfg.TCP {
+		name = "TCP"
+	}
+	if r.cfg.IPVersion != 0 {
+		name += fmt.Sprintf("/IPv%d", r.cfg.IPVersion)
+	}
+	return name
+}
+
+// queryTransport names the transport of the queries in the QueryEvents
+func (r *Runner) queryTransport() string {
+	if r.cfg.DOHEndpoint == "" {
+		return r.TransportName()
+	}
+	if r.cfg.DOHHTTP3 {
+		return "DOH/HTTP3"
+	}
+	return "DOH"
+}
+
+// PersistentConnections tells whether threads keep their connection open between queries. TCP
+// and TLS connections are always kept, unless flooding. QUIC uses its own shared session instead,
+// and pooled connections are shared by the threads.
+func (r *Runner) PersistentConnections() bool {
+	return (r.cfg.ReuseConn || r.cfg.TCP || r.cfg.DoT) && !r.cfg.Flood && r.cfg.DOHEndpoint == "" && !r.cfg.DoQ && !r.transfers && r.pools == nil
+}
+
+// source returns the local address a thread sends its queries to a resolver from. The threads
+// are spread over the source addresses of the same family as the resolver.
+func (r *Runner) source(threadID int, address string) *net.UDPAddr {
+	if len(r.cfg.Sources) == 0 {
+		return nil
+	}
+	version := ipVersion(address)
+	if version == 0 {
+		version = r.cfg.IPVersion
+	}
+	matching := r.ipv4Sources
+	if version == 6 {
+		matching = r.ipv6Sources
+	}
+	if len(matching) == 0 {
+		// Dialing reports the mismatch
+		matching = r.cfg.Sources
+	}
+	return matching[threadID%len(matching)]
+}
+
+// dialResolver opens a connection to the resolver, from the source address if one is given
+func (r *Runner) dialResolver(network string, address string, source *net.UDPAddr) (net.Conn, error) {
+	dialer := &net.Dialer{}
+	if r.cfg.ReusePort {
+		dialer.Control = setReusePort
+	}
+	if source != nil {
+		if strings.HasPrefix(network, "udp") {
+			dialer.LocalAddr = source
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: source.IP, Port: source.Port}
+		}
+	}
+	if r.cfg.DoT {
+		return tls.DialWithDialer(dialer, network, address, r.tlsConfig)
+	}
+	return dialer.Dial(network, address)
+}
+
+// persistentConn is a connection to the resolver kept open by a thread for all its queries. It
+// is only re-dialed after an error.
+type persistentConn struct {
+	runner   *Runner
+	resolver string
+	source   *net.UDPAddr
+	co       *dns.Conn
+	packed   *packCache // Wire format of the queries, when it can be cached
+	buf      []byte     // Query being sent, with a packed cache
+}
+
+func (p *persistentConn) exchange(message *dns.Msg) (*dns.Msg, error) {
+	reused := p.co != nil
+	response, err := p.send(message)
+	if err != nil && reused && isClosedConn(err) {
+		// The resolver closed the connection since the previous query (e.g. after too many
+		// queries on a TCP connection), open a new one
+		return p.send(message)
+	}
+	return response, err
+}
+
+func (p *persistentConn) send(message *dns.Msg) (*dns.Msg, error) {
+	if p.co == nil {
+		dnsconn, err := p.runner.dialResolver(p.runner.transportNetwork(), p.resolver, p.source)
+		if err != nil {
+			return nil, err
+		}
+		p.co = &dns.Conn{Conn: dnsconn}
+	}
+
+	p.co.SetDeadline(time.Now().Add(p.runner.cfg.Timeout))
+	var mac string
+	var err error
+	if p.packed != nil {
+		if p.buf, err = p.packed.appendPacked(p.buf[:0], message); err == nil {
+			_, err = p.co.Write(p.buf)
+		}
+	} else {
+		mac, err = p.runner.cfg.TSIG.writeQuery(p.co, message)
+	}
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	for {
+		response, err := p.runner.cfg.TSIG.readAnswer(p.co, mac)
+		// Late answers to previous queries may still arrive on the socket, skip them: they are
+		// not signed for this query either
+		if response != nil && response.Id != message.Id {
+			continue
+		}
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		return response, nil
+	}
+}
+
+// isClosedConn tells whether an error was caused by the other side closing the connection
+func isClosedConn(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+func (p *persistentConn) close() {
+	if p.co != nil {
+		p.co.Close()
+		p.co = nil
+	}
+}
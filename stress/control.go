@@ -0,0 +1,100 @@
+package stress
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// pausePollInterval is how often paused threads check whether they can resume
+const pausePollInterval = 10 * time.Millisecond
+
+// Pause makes the threads hold their next queries until Resume is called
+func (r *Runner) Pause() {
+	atomic.StoreInt32(&r.paused, 1)
+}
+
+// Resume lets the threads send their queries again after Pause
+func (r *Runner) Resume() {
+	atomic.StoreInt32(&r.paused, 0)
+}
+
+// Paused tells whether the threads are holding their queries
+func (r *Runner) Paused() bool {
+	return atomic.LoadInt32(&r.paused) != 0
+}
+
+// waitWhilePaused blocks while the run is paused, or while the thread is parked, unless the
+// threads have to stop
+func (r *Runner) waitWhilePaused(threadID int) {
+	for (atomic.LoadInt32(&r.paused) != 0 || r.parked(threadID)) && atomic.LoadInt32(&r.stopRequested) == 0 {
+		time.Sleep(pausePollInterval)
+	}
+}
+
+// Rate returns the current target rate of the run in queries per second, 0 when it is not
+// limited
+func (r *Runner) Rate() int {
+	limiter := r.limiter.Load()
+	if limiter == nil {
+		return 0
+	}
+	return limiter.currentRate()
+}
+
+// SetRate replaces the target rate, or the load profile, of a running test with a fixed rate of
+// queries per second, 0 for no limit. Poisson arrivals keep their rate.
+func (r *Runner) SetRate(rate int) error {
+	if r.cfg.Arrivals == "poisson" {
+		return fmt.Errorf("the rate of poisson arrivals cannot be changed")
+	}
+	if r.timing != nil {
+		return fmt.Errorf("the rate of a replay follows the timing of the capture")
+	}
+	if r.search != nil {
+		return fmt.Errorf("the rate is set by the search of the maximum rate")
+	}
+	if r.bursts != nil {
+		return fmt.Errorf("the queries are sent by bursts")
+	}
+	if rate < 0 {
+		return fmt.Errorf("the rate cannot be negative")
+	}
+	if rate == 0 {
+		r.limiter.Store(nil)
+	} else {
+		r.limiter.Store(newRateLimiter(rate, r.cfg.Concurrency))
+	}
+	return nil
+}
+
+// SetConcurrency lets only the given number of threads send queries, parking the others, up to
+// the Concurrency of the run. 0 lets all the threads send queries again.
+func (r *Runner) SetConcurrency(threads int) error {
+	if r.tuner != nil {
+		return fmt.Errorf("the concurrency is set by the auto concurrency")
+	}
+	if threads < 0 || threads > r.cfg.Concurrency {
+		return fmt.Errorf("the concurrency has to be between 0 (all) and the %d threads of the run", r.cfg.Concurrency)
+	}
+	atomic.StoreInt32(&r.activeThreads, int32(threads))
+	return nil
+}
+
+// sharedLimiter paces the queries of a thread with the limiter of the run, when there is one
+type sharedLimiter struct {
+	runner *Runner
+}
+
+func (s sharedLimiter) wait() bool {
+	if limiter := s.runner.limiter.Load(); limiter != nil {
+		return limiter.wait()
+	}
+	return true
+}
+
+// currentRate returns the rate of the profile followed by the limiter, at the current time
+func (r *rateLimiter) currentRate() int {
+	return int(math.Round(r.profile.rateAt(time.Since(r.start))))
+}
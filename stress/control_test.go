@@ -0,0 +1,25 @@
+package stress
+
+import "testing"
+
+func TestSetConcurrency(t *testing.T) {
+	r := &Runner{cfg: Config{Concurrency: 4}}
+	if err := r.SetConcurrency(2); err != nil {
+		t.Fatal(err)
+	}
+	if active := r.ActiveThreads(); active != 2 {
+		t.Errorf("Expected 2 active threads, got %d", active)
+	}
+	if r.parked(1) || !r.parked(2) || !r.parked(3) {
+		t.Errorf("Expected the threads beyond the second one to be parked")
+	}
+	if err := r.SetConcurrency(5); err == nil {
+		t.Errorf("Expected an error beyond the threads of the run")
+	}
+	if err := r.SetConcurrency(0); err != nil {
+		t.Fatal(err)
+	}
+	if active := r.ActiveThreads(); active != 4 || r.parked(3) {
+		t.Errorf("Expected all the threads to send queries again, got %d", active)
+	}
+}
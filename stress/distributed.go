@@ -0,0 +1,379 @@
+package stress
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// Distributed runs: a controller sends the Config of a run to several agents as JSON, in a POST
+// to /run, and each agent streams back the stats of its intervals as JSON lines, then its totals.
+// A POST to /stop asks the agent to stop sending queries, which still ends with the totals. Both
+// sides authenticate with a shared token.
+
+// agentMessage is a line of the stream of an agent
+type agentMessage struct {
+	Stats           *Stats
+	Latency         *hdrhistogram.Snapshot
+	ComparedLatency *hdrhistogram.Snapshot `json:",omitempty"`
+	ResponseSizes   *hdrhistogram.Snapshot `json:",omitempty"`
+	Final           bool                   // The stats are the totals of the run, which is over
+}
+
+func newAgentMessage(stats *Stats, final bool) agentMessage {
+	message := agentMessage{Stats: stats, Latency: stats.Latency.Export(), ResponseSizes: stats.ResponseSizes.Export(), Final: final}
+	if stats.Compared != nil {
+		message.ComparedLatency = stats.Compared.Latency.Export()
+	}
+	return message
+}
+
+// decode returns the stats of the message, or an error if it is incomplete
+func (m agentMessage) decode(flood bool) (*Stats, error) {
+	if m.Stats == nil || m.Latency == nil {
+		return nil, fmt.Errorf("invalid stats")
+	}
+	stats := m.Stats
+	stats.Latency = hdrhistogram.Import(m.Latency)
+	stats.ResponseSizes = newSizeHistogram()
+	if m.ResponseSizes != nil {
+		// Agents of previous versions don't send them
+		stats.ResponseSizes = hdrhistogram.Import(m.ResponseSizes)
+	}
+	if stats.Compared != nil {
+		if m.ComparedLatency == nil {
+			return nil, fmt.Errorf("invalid stats")
+		}
+		stats.Compared.Latency = hdrhistogram.Import(m.ComparedLatency)
+	}
+	stats.flood = flood
+	return stats, nil
+}
+
+// agent runs the tests asked by a controller, one at a time
+type agent struct {
+	token string
+	local func(cfg *Config)
+
+	mu      sync.Mutex
+	running bool
+	runner  *Runner // Current run, once it is set up
+}
+
+// NewAgent returns the handler of an agent, running the tests asked by the controllers holding
+// the token. The local function may set the options specific to the host (e.g. the Sources or
+// the Logger) on the Config of each run.
+func NewAgent(token string, local func(cfg *Config)) http.Handler {
+	a := &agent{token: token, local: local}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run", a.run)
+	mux.HandleFunc("/stop", a.stop)
+	return mux
+}
+
+// authorized tells whether a request comes from a controller, and answers it otherwise
+func (a *agent) authorized(w http.ResponseWriter, req *http.Request) bool {
+	if req.Method != http.MethodPost {
+		http.Error(w, "expected a POST request", http.StatusMethodNotAllowed)
+		return false
+	}
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if a.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (a *agent) run(w http.ResponseWriter, req *http.Request) {
+	if !a.authorized(w, req) {
+		return
+	}
+	a.mu.Lock()
+	if a.running {
+		a.mu.Unlock()
+		http.Error(w, "already running a test", http.StatusConflict)
+		return
+	}
+	a.running = true
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.running = false
+		a.runner = nil
+		a.mu.Unlock()
+	}()
+
+	var cfg Config
+	if err := json.NewDecoder(req.Body).Decode(&cfg); err != nil {
+		http.Error(w, fmt.Sprintf("invalid test definition: %s", err), http.StatusBadRequest)
+		return
+	}
+	if a.local != nil {
+		a.local(&cfg)
+	}
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	write := func(message agentMessage) {
+		encoder.Encode(message)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	cfg.OnInterval = func(stats *Stats) {
+		write(newAgentMessage(stats, false))
+	}
+	runner, err := NewRunner(&cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.mu.Lock()
+	a.runner = runner
+	a.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	// The run is stopped when the controller goes away
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-req.Context().Done():
+			runner.Stop()
+		case <-finished:
+		}
+	}()
+	totals := runner.Run()
+	close(finished)
+	write(newAgentMessage(totals, true))
+}
+
+func (a *agent) stop(w http.ResponseWriter, req *http.Request) {
+	if !a.authorized(w, req) {
+		return
+	}
+	a.mu.Lock()
+	if a.runner != nil {
+		a.runner.Stop()
+	}
+	a.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Controller runs a test from several agents, and combines their stats
+type Controller struct {
+	cfg    Config
+	agents []string
+	token  string
+	client *http.Client
+}
+
+// NewController prepares a run from the agents, given as "host:port" addresses or URLs. The
+// whole Config is run by each agent: counts and rates are per agent. The stats of the intervals
+// are combined and handed to OnInterval.
+func NewController(config *Config, agents []string, token string) (*Controller, error) {
+	if len(agents) == 0 {
+		return nil, fmt.Errorf("no agents")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("the agents need a token")
+	}
+	return &Controller{cfg: *config, agents: agents, token: token, client: &http.Client{}}, nil
+}
+
+// agentURL returns the URL of an endpoint of an agent
+func agentURL(agent string, path string) string {
+	if !strings.Contains(agent, "://") {
+		agent = "http://" + agent
+	}
+	return strings.TrimSuffix(agent, "/") + path
+}
+
+// agentEvent is what the controller gets from an agent: the stats of an interval, its totals, or
+// the error that interrupted it
+type agentEvent struct {
+	agent int
+	stats *Stats
+	final bool
+	err   error
+}
+
+// Run starts the test on all the agents, and returns their combined totals once they are all
+// done. If one of them fails, the whole test is interrupted.
+func (c *Controller) Run() (*Stats, error) {
+	body, err := json.Marshal(&c.cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan agentEvent)
+	for index := range c.agents {
+		go c.runAgent(ctx, index, body, events)
+	}
+
+	merger := newIntervalMerger(len(c.agents), c.cfg.Flood)
+	totals := newStats(c.cfg.Flood)
+	for remaining := len(c.agents); remaining > 0; {
+		event := <-events
+		if event.err != nil {
+			return nil, fmt.Errorf("agent %s: %w", c.agents[event.agent], event.err)
+		}
+		var combined []*Stats
+		if event.final {
+			remaining--
+			totals.merge(event.stats)
+			if event.stats.Duration > totals.Duration {
+				totals.Duration = event.stats.Duration
+			}
+			combined = merger.finish(event.agent)
+		} else {
+			combined = merger.add(event.agent, event.stats)
+		}
+		if c.cfg.OnInterval != nil {
+			for _, interval := range combined {
+				c.cfg.OnInterval(interval)
+			}
+		}
+	}
+	return totals, nil
+}
+
+// runAgent runs the test on an agent, and hands its stats over to Run
+func (c *Controller) runAgent(ctx context.Context, index int, body []byte, events chan<- agentEvent) {
+	send := func(event agentEvent) {
+		event.agent = index
+		select {
+		case events <- event:
+		case <-ctx.Done():
+		}
+	}
+	response, err := c.post(ctx, c.agents[index], "/run", body)
+	if err != nil {
+		send(agentEvent{err: err})
+		return
+	}
+	defer response.Body.Close()
+
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var message agentMessage
+		if err := decoder.Decode(&message); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = fmt.Errorf("disconnected before the end of the test")
+			}
+			send(agentEvent{err: err})
+			return
+		}
+		stats, err := message.decode(c.cfg.Flood)
+		if err != nil {
+			send(agentEvent{err: err})
+			return
+		}
+		send(agentEvent{stats: stats, final: message.Final})
+		if message.Final {
+			return
+		}
+	}
+}
+
+// post sends a request to an endpoint of an agent, and returns its response if successful
+func (c *Controller) post(ctx context.Context, agent string, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, agentURL(agent, path), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	response, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		response.Body.Close()
+		return nil, fmt.Errorf("%s (%s)", strings.TrimSpace(string(message)), response.Status)
+	}
+	return response, nil
+}
+
+// Stop asks all the agents to stop sending queries, Run returns once their totals are in
+func (c *Controller) Stop() {
+	var wg sync.WaitGroup
+	for _, agent := range c.agents {
+		wg.Add(1)
+		go func(agent string) {
+			defer wg.Done()
+			if response, err := c.post(context.Background(), agent, "/stop", nil); err == nil {
+				response.Body.Close()
+			}
+		}(agent)
+	}
+	wg.Wait()
+}
+
+// intervalMerger combines the intervals of the agents in order: the nth combined interval is
+// made of the nth interval of each agent, once they have all reported it or are done
+type intervalMerger struct {
+	queues [][]*Stats
+	done   []bool
+	flood  bool
+}
+
+func newIntervalMerger(agents int, flood bool) *intervalMerger {
+	return &intervalMerger{queues: make([][]*Stats, agents), done: make([]bool, agents), flood: flood}
+}
+
+// add queues an interval of an agent, and returns the combined intervals now complete
+func (m *intervalMerger) add(agent int, interval *Stats) []*Stats {
+	m.queues[agent] = append(m.queues[agent], interval)
+	return m.combine()
+}
+
+// finish marks an agent as done, and returns the combined intervals now complete
+func (m *intervalMerger) finish(agent int) []*Stats {
+	m.done[agent] = true
+	return m.combine()
+}
+
+func (m *intervalMerger) combine() []*Stats {
+	var combined []*Stats
+	for {
+		pending := false
+		for agent, queue := range m.queues {
+			if len(queue) == 0 && !m.done[agent] {
+				// The interval of this agent is not in yet
+				return combined
+			}
+			pending = pending || len(queue) > 0
+		}
+		if !pending {
+			return combined
+		}
+		interval := newStats(m.flood)
+		for agent, queue := range m.queues {
+			if len(queue) == 0 {
+				continue
+			}
+			interval.merge(queue[0])
+			if queue[0].Duration > interval.Duration {
+				interval.Duration = queue[0].Duration
+			}
+			interval.Warmup = interval.Warmup || queue[0].Warmup
+			m.queues[agent] = queue[1:]
+		}
+		combined = append(combined, interval)
+	}
+}
@@ -0,0 +1,50 @@
+package stress
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIntervalMerger(t *testing.T) {
+	interval := func(sent int, duration time.Duration) *Stats {
+		stats := newStats(false)
+		stats.Sent = sent
+		stats.Duration = duration
+		return stats
+	}
+	merger := newIntervalMerger(2, false)
+	if combined := merger.add(0, interval(10, time.Second)); len(combined) != 0 {
+		t.Fatalf("Got %d intervals before the second agent reported, expected none", len(combined))
+	}
+	merger.add(0, interval(20, time.Second))
+	combined := merger.add(1, interval(5, 2*time.Second))
+	if len(combined) != 1 || combined[0].Sent != 15 || combined[0].Duration != 2*time.Second {
+		t.Fatalf("Got %v, expected a single interval of 15 queries over 2s", combined)
+	}
+	// Once an agent is done, the intervals of the others are combined without it
+	combined = merger.finish(1)
+	if len(combined) != 1 || combined[0].Sent != 20 {
+		t.Fatalf("Got %v, expected a single interval of 20 queries", combined)
+	}
+	if combined := merger.finish(0); len(combined) != 0 {
+		t.Errorf("Got %d intervals after the end, expected none", len(combined))
+	}
+}
+
+func TestControllerToken(t *testing.T) {
+	server := httptest.NewServer(NewAgent("secret", nil))
+	defer server.Close()
+
+	controller, err := NewController(NewConfig(), []string{server.URL}, "guess")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, err := controller.Run(); err == nil || !strings.Contains(err.Error(), "invalid token") {
+		t.Errorf("Got %v, expected the agent to reject the token", err)
+	}
+	if _, err := NewController(NewConfig(), []string{server.URL}, ""); err == nil {
+		t.Errorf("A controller without a token should fail")
+	}
+}
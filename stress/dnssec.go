@@ -0,0 +1,255 @@
+package stress
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// rootTrustAnchors are the DS records of the root zone KSKs
+var rootTrustAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+var errMissingSignature = errors.New("missing signature")
+
+// dnssecValidator verifies the signatures of answers, along the chain of DNSKEY and DS records
+// from a trust anchor. The keys of each zone are fetched once, and kept for the whole run.
+type dnssecValidator struct {
+	exchange func(*dns.Msg) (*dns.Msg, error)
+	anchors  map[string][]*dns.DS
+	mu       sync.Mutex
+	zones    map[string]*zoneKeys
+}
+
+// zoneKeys are the validated keys of a zone, or the reason why they could not be validated
+type zoneKeys struct {
+	ready chan struct{}
+	keys  []*dns.DNSKEY
+	err   error
+}
+
+// newDNSSECValidator creates a validator trusting the given DS records (the root ones if empty)
+func newDNSSECValidator(anchors []string, exchange func(*dns.Msg) (*dns.Msg, error)) (*dnssecValidator, error) {
+	if len(anchors) == 0 {
+		anchors = rootTrustAnchors
+	}
+	v := &dnssecValidator{
+		exchange: exchange,
+		anchors:  make(map[string][]*dns.DS),
+		zones:    make(map[string]*zoneKeys),
+	}
+	for _, anchor := range anchors {
+		rr, err := dns.NewRR(anchor)
+		if err != nil {
+			return nil, err
+		}
+		ds, ok := rr.(*dns.DS)
+		if !ok {
+			return nil, fmt.Errorf("trust anchor %q is not a DS record", anchor)
+		}
+		zone := dns.CanonicalName(ds.Hdr.Name)
+		v.anchors[zone] = append(v.anchors[zone], ds)
+	}
+	return v, nil
+}
+
+// validate checks the signatures of all the RRsets of the answer section. Negative answers are
+// not checked.
+func (v *dnssecValidator) validate(response *dns.Msg) error {
+	rrsets, sigs := splitRRsets(response.Answer)
+	for key, rrset := range rrsets {
+		if err := v.verifyRRset(rrset, sigs[key]); err != nil {
+			return fmt.Errorf("%s %s: %v", key.name, dns.TypeToString[key.rrtype], err)
+		}
+	}
+	return nil
+}
+
+// rrsetKey identifies an RRset, signatures are matched to it by the type they cover
+type rrsetKey struct {
+	name   string
+	rrtype uint16
+}
+
+// splitRRsets groups records by RRset, along with the signatures covering them
+func splitRRsets(records []dns.RR) (map[rrsetKey][]dns.RR, map[rrsetKey][]*dns.RRSIG) {
+	rrsets := make(map[rrsetKey][]dns.RR)
+	sigs := make(map[rrsetKey][]*dns.RRSIG)
+	for _, rr := range records {
+		name := dns.CanonicalName(rr.Header().Name)
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			key := rrsetKey{name, sig.TypeCovered}
+			sigs[key] = append(sigs[key], sig)
+			continue
+		}
+		key := rrsetKey{name, rr.Header().Rrtype}
+		rrsets[key] = append(rrsets[key], rr)
+	}
+	return rrsets, sigs
+}
+
+// verifyRRset checks that at least one of the signatures of an RRset is valid, using the keys
+// of the zone that signed it
+func (v *dnssecValidator) verifyRRset(rrset []dns.RR, sigs []*dns.RRSIG) error {
+	if len(sigs) == 0 {
+		return errMissingSignature
+	}
+	owner := rrset[0].Header().Name
+	var lastErr error
+	for _, sig := range sigs {
+		signer := dns.CanonicalName(sig.SignerName)
+		if !dns.IsSubDomain(signer, owner) {
+			lastErr = fmt.Errorf("signed by %s, which is not a parent zone", signer)
+			continue
+		}
+		keys, err := v.keys(signer)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if lastErr = verifySignature(rrset, sig, keys); lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+// verifySignature checks a signature of an RRset against a set of keys
+func verifySignature(rrset []dns.RR, sig *dns.RRSIG, keys []*dns.DNSKEY) error {
+	if !sig.ValidityPeriod(time.Now()) {
+		return fmt.Errorf("signature by key %d has expired or is not valid yet", sig.KeyTag)
+	}
+	for _, key := range keys {
+		if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+			continue
+		}
+		if err := sig.Verify(key, rrset); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("no valid signature by key %d of %s", sig.KeyTag, sig.SignerName)
+}
+
+// keys returns the validated keys of a zone, fetching them on first use
+func (v *dnssecValidator) keys(zone string) ([]*dns.DNSKEY, error) {
+	v.mu.Lock()
+	zk, ok := v.zones[zone]
+	if !ok {
+		zk = &zoneKeys{ready: make(chan struct{})}
+		v.zones[zone] = zk
+	}
+	v.mu.Unlock()
+
+	if ok {
+		<-zk.ready
+	} else {
+		zk.keys, zk.err = v.fetchKeys(zone)
+		close(zk.ready)
+	}
+	return zk.keys, zk.err
+}
+
+// fetchKeys queries the keys of a zone, and checks that they are the ones given by the DS
+// records of the parent zone (or by a trust anchor)
+func (v *dnssecValidator) fetchKeys(zone string) ([]*dns.DNSKEY, error) {
+	answer, err := v.query(zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the keys of %s: %v", zone, err)
+	}
+	var keys []*dns.DNSKEY
+	var keySet []dns.RR
+	var keySigs []*dns.RRSIG
+	for _, rr := range answer {
+		switch rr := rr.(type) {
+		case *dns.DNSKEY:
+			keys = append(keys, rr)
+			keySet = append(keySet, rr)
+		case *dns.RRSIG:
+			if rr.TypeCovered == dns.TypeDNSKEY {
+				keySigs = append(keySigs, rr)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys for %s", zone)
+	}
+
+	dsSet, err := v.delegation(zone)
+	if err != nil {
+		return nil, err
+	}
+	// The key set has to be signed by one of the keys given by the parent
+	var trusted []*dns.DNSKEY
+	for _, key := range keys {
+		for _, ds := range dsSet {
+			if key.KeyTag() == ds.KeyTag && key.Algorithm == ds.Algorithm {
+				if digest := key.ToDS(ds.DigestType); digest != nil && strings.EqualFold(digest.Digest, ds.Digest) {
+					trusted = append(trusted, key)
+				}
+			}
+		}
+	}
+	if len(trusted) == 0 {
+		return nil, fmt.Errorf("no key of %s matches its DS records", zone)
+	}
+	for _, sig := range keySigs {
+		if verifySignature(keySet, sig, trusted) == nil {
+			return keys, nil
+		}
+	}
+	return nil, fmt.Errorf("the keys of %s are not signed by a trusted key", zone)
+}
+
+// delegation returns the validated DS records of a zone, or its trust anchors
+func (v *dnssecValidator) delegation(zone string) ([]*dns.DS, error) {
+	if anchors, ok := v.anchors[zone]; ok {
+		return anchors, nil
+	}
+	if zone == "." {
+		return nil, fmt.Errorf("no trust anchor")
+	}
+
+	answer, err := v.query(zone, dns.TypeDS)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the DS records of %s: %v", zone, err)
+	}
+	rrsets, sigs := splitRRsets(answer)
+	key := rrsetKey{zone, dns.TypeDS}
+	if len(rrsets[key]) == 0 {
+		return nil, fmt.Errorf("%s is not a signed delegation", zone)
+	}
+	for _, sig := range sigs[key] {
+		// The DS records are signed by the parent zone
+		if dns.CanonicalName(sig.SignerName) == zone {
+			return nil, fmt.Errorf("DS records of %s are signed by the zone itself", zone)
+		}
+	}
+	if err := v.verifyRRset(rrsets[key], sigs[key]); err != nil {
+		return nil, fmt.Errorf("DS records of %s: %v", zone, err)
+	}
+	dsSet := make([]*dns.DS, 0, len(rrsets[key]))
+	for _, rr := range rrsets[key] {
+		dsSet = append(dsSet, rr.(*dns.DS))
+	}
+	return dsSet, nil
+}
+
+// query asks the resolver for the records needed to validate the answers
+func (v *dnssecValidator) query(name string, qtype uint16) ([]dns.RR, error) {
+	message := new(dns.Msg).SetQuestion(name, qtype)
+	message.SetEdns0(defaultEDNSBufferSize, true)
+	response, err := v.exchange(message)
+	if err != nil {
+		return nil, err
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("got %s", rcodeName(response.Rcode))
+	}
+	return response.Answer, nil
+}
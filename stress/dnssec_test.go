@@ -0,0 +1,82 @@
+package stress
+
+import (
+	"crypto"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// signedZone is a test zone signed with a single key
+type signedZone struct {
+	key     *dns.DNSKEY
+	private crypto.Signer
+}
+
+func newSignedZone(t *testing.T, zone string) *signedZone {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	private, err := key.Generate(256)
+	if err != nil {
+		t.Fatalf("Unable to generate a key: %s", err)
+	}
+	return &signedZone{key: key, private: private.(crypto.Signer)}
+}
+
+func (z *signedZone) sign(t *testing.T, rrset []dns.RR) *dns.RRSIG {
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		Algorithm:  z.key.Algorithm,
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.key.Hdr.Name,
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+	}
+	if err := sig.Sign(z.private, rrset); err != nil {
+		t.Fatalf("Unable to sign: %s", err)
+	}
+	return sig
+}
+
+func TestDNSSECValidator(t *testing.T) {
+	zone := newSignedZone(t, "example.")
+	keySig := zone.sign(t, []dns.RR{zone.key})
+	exchange := func(query *dns.Msg) (*dns.Msg, error) {
+		response := new(dns.Msg).SetReply(query)
+		if query.Question[0].Qtype == dns.TypeDNSKEY && query.Question[0].Name == "example." {
+			response.Answer = []dns.RR{zone.key, keySig}
+		} else {
+			response.Rcode = dns.RcodeNameError
+		}
+		return response, nil
+	}
+	v, err := newDNSSECValidator([]string{zone.key.ToDS(dns.SHA256).String()}, exchange)
+	if err != nil {
+		t.Fatalf("Unable to create the validator: %s", err)
+	}
+
+	a := &dns.A{Hdr: dns.RR_Header{Name: "www.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, A: net.IPv4(192, 0, 2, 1)}
+	sig := zone.sign(t, []dns.RR{a})
+
+	if err := v.validate(&dns.Msg{Answer: []dns.RR{a, sig}}); err != nil {
+		t.Errorf("A correctly signed answer failed validation: %s", err)
+	}
+	if err := v.validate(&dns.Msg{Answer: []dns.RR{a}}); err == nil {
+		t.Error("An unsigned answer should fail validation")
+	}
+	forged := &dns.A{Hdr: a.Hdr, A: net.IPv4(192, 0, 2, 2)}
+	if err := v.validate(&dns.Msg{Answer: []dns.RR{forged, sig}}); err == nil {
+		t.Error("A forged answer should fail validation")
+	}
+
+	other := newSignedZone(t, "example.")
+	if err := v.validate(&dns.Msg{Answer: []dns.RR{a, other.sign(t, []dns.RR{a})}}); err == nil {
+		t.Error("An answer signed by an untrusted key should fail validation")
+	}
+}
@@ -0,0 +1,181 @@
+package stress
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// protocolNames are the HTTP versions of the DOH answers, by major version
+var protocolNames = [...]string{1: "HTTP/1.1", 2: "HTTP/2", 3: "HTTP/3"}
+
+// protocolCounts counts the DOH answers by the HTTP version negotiated with the endpoint
+type protocolCounts struct {
+	counts [len(protocolNames)]uint64
+}
+
+func (p *protocolCounts) record(major int) {
+	if major > 0 && major < len(p.counts) {
+		atomic.AddUint64(&p.counts[major], 1)
+	}
+}
+
+// take adds the answers counted since the last call to a breakdown by protocol
+func (p *protocolCounts) take(byProtocol map[string]int) {
+	for major := range p.counts {
+		if n := atomic.SwapUint64(&p.counts[major], 0); n > 0 {
+			byProtocol[protocolNames[major]] += int(n)
+		}
+	}
+}
+
+// newDOHClient configures the client used for DOH requests, shared by all the threads so that
+// connections are reused between queries
+func newDOHClient(cfg *Config) (*http.Client, error) {
+	if cfg.DOHHTTP3 {
+		return newHTTP3Client(cfg)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	if cfg.Insecure || cfg.TLSServerName != "" || cfg.TLSCert != "" {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	maxIdle := cfg.DOHMaxIdleConns
+	if maxIdle <= 0 {
+		maxIdle = cfg.Concurrency
+	}
+	transport.MaxIdleConns = maxIdle
+	transport.MaxIdleConnsPerHost = maxIdle
+	if sources := cfg.Sources; len(sources) > 0 || cfg.IPVersion != 0 {
+		// Bind each connection to the next source address, the port is left to the system as
+		// there are several connections
+		var next uint64
+		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialer := &net.Dialer{}
+			if len(sources) > 0 {
+				source := sources[(atomic.AddUint64(&next, 1)-1)%uint64(len(sources))]
+				dialer.LocalAddr = &net.TCPAddr{IP: source.IP}
+			}
+			return dialer.DialContext(ctx, cfg.ipNetwork(network), address)
+		}
+	}
+	return &http.Client{Transport: transport, Timeout: cfg.Timeout}, nil
+}
+
+// newHTTP3Client configures the client used for DOH requests over HTTP/3, which keeps a single
+// QUIC connection to the endpoint for all the threads
+func newHTTP3Client(cfg *Config) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http3.Transport{TLSClientConfig: tlsConfig, QUICConfig: &quic.Config{KeepAlivePeriod: defaultTimeout}}
+	if sources := cfg.Sources; len(sources) > 0 || cfg.IPVersion != 0 {
+		// Each connection is dialed from the next source address
+		var next uint64
+		transport.Dial = func(ctx context.Context, address string, tlsConf *tls.Config, quicConf *quic.Config) (quic.EarlyConnection, error) {
+			network := cfg.ipNetwork("udp")
+			remoteAddr, err := net.ResolveUDPAddr(network, address)
+			if err != nil {
+				return nil, err
+			}
+			var source *net.UDPAddr
+			if len(sources) > 0 {
+				source = &net.UDPAddr{IP: sources[(atomic.AddUint64(&next, 1)-1)%uint64(len(sources))].IP}
+			}
+			udpConn, err := net.ListenUDP(network, source)
+			if err != nil {
+				return nil, err
+			}
+			conn, err := quic.DialEarly(ctx, udpConn, remoteAddr, tlsConf, quicConf)
+			if err != nil {
+				udpConn.Close()
+				return nil, err
+			}
+			go func() {
+				<-conn.Context().Done()
+				udpConn.Close()
+			}()
+			return conn, nil
+		}
+	}
+	return &http.Client{Transport: transport, Timeout: cfg.Timeout}, nil
+}
+
+// ParseHTTPHeaders parses headers given as "Name: value", the same name can be given several
+// times
+func ParseHTTPHeaders(list []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, item := range list {
+		name, value, found := strings.Cut(item, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q (expected Name: value)", item)
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// performDOHRequest sends a DNS query over HTTPS, and returns the answer along with the MAC of
+// the signature of the query
+func (r *Runner) performDOHRequest(query *dns.Msg) ([]byte, string, error) {
+	rawQuery, mac, err := r.cfg.TSIG.pack(query)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to pack DNS query: %v", err)
+	}
+
+	var req *http.Request
+	if r.cfg.DOHMethod == "POST" {
+		req, err = http.NewRequest("POST", r.cfg.DOHEndpoint, bytes.NewReader(rawQuery))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/dns-message")
+		}
+	} else {
+		encodedQuery := base64.RawURLEncoding.EncodeToString(rawQuery)
+		req, err = http.NewRequest("GET", r.cfg.DOHEndpoint+"?dns="+encodedQuery, nil)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create DOH request: %v", err)
+	}
+	for name, values := range r.cfg.DOHHeaders {
+		if name = http.CanonicalHeaderKey(name); name == "Host" {
+			// The client only sends the one of the request
+			req.Host = values[0]
+		} else {
+			req.Header[name] = values
+		}
+	}
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := r.dohClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("DOH request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	r.dohProtocols.record(resp.ProtoMajor)
+
+	if r.cfg.DOHHTTP2 && resp.ProtoMajor != 2 {
+		// Drain the body so that the connection can still be reused
+		ioutil.ReadAll(resp.Body)
+		return nil, "", fmt.Errorf("endpoint answered using %s instead of HTTP/2", resp.Proto)
+	}
+
+	answer, err := ioutil.ReadAll(resp.Body)
+	return answer, mac, err
+}
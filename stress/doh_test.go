@@ -0,0 +1,39 @@
+package stress
+
+import (
+	"testing"
+)
+
+func TestParseHTTPHeaders(t *testing.T) {
+	headers, err := ParseHTTPHeaders([]string{"X-Api-Key: secret", "x-tag:a", "X-Tag: b:c"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if got := headers.Get("X-Api-Key"); got != "secret" {
+		t.Errorf("Expected the value secret, got %q", got)
+	}
+	if got := headers.Values("X-Tag"); len(got) != 2 || got[0] != "a" || got[1] != "b:c" {
+		t.Errorf("Expected the values [a b:c], got %q", got)
+	}
+	for _, item := range []string{"X-Api-Key", ": value", "X Key: value"} {
+		if _, err := ParseHTTPHeaders([]string{item}); err == nil {
+			t.Errorf("Expected an error for %q", item)
+		}
+	}
+}
+
+func TestProtocolCounts(t *testing.T) {
+	var counts protocolCounts
+	for _, major := range []int{2, 2, 3, 0, 9} {
+		counts.record(major)
+	}
+	byProtocol := map[string]int{"HTTP/2": 1}
+	counts.take(byProtocol)
+	if len(byProtocol) != 2 || byProtocol["HTTP/2"] != 3 || byProtocol["HTTP/3"] != 1 {
+		t.Errorf("Unexpected breakdown %v", byProtocol)
+	}
+	byProtocol = make(map[string]int)
+	if counts.take(byProtocol); len(byProtocol) != 0 {
+		t.Errorf("The answers should only be taken once, got %v", byProtocol)
+	}
+}
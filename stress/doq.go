@@ -0,0 +1,141 @@
+package stress
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// quicSession is the QUIC connection to a resolver, shared by all the threads for DNS over QUIC
+// (RFC 9250). Each query is sent on its own stream, and the connection is only re-dialed after
+// an error.
+type quicSession struct {
+	mu       sync.Mutex
+	resolver string
+	network  string // "udp", or "udp4" and "udp6" to use a single IP version
+	source   *net.UDPAddr
+	tlsConf  *tls.Config
+	tsig     *TSIGKey
+	timeout  time.Duration // Bounds the time spent dialing, and waiting for each answer
+	udpConn  *net.UDPConn
+	conn     quic.Connection
+}
+
+// newDOQSessions prepares the DNS over QUIC sessions of the resolvers, the connections themselves
+// are opened by the first query
+func newDOQSessions(resolvers []string, network string, source func(address string) *net.UDPAddr, tlsConfig *tls.Config, tsig *TSIGKey, timeout time.Duration) map[string]*quicSession {
+	sessions := make(map[string]*quicSession, len(resolvers))
+	for _, address := range resolvers {
+		tlsConf := tlsConfig.Clone()
+		tlsConf.NextProtos = []string{"doq"}
+		if tlsConf.ServerName == "" {
+			tlsConf.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		sessions[address] = &quicSession{resolver: address, network: network, source: source(address), tlsConf: tlsConf, tsig: tsig, timeout: timeout}
+	}
+	return sessions
+}
+
+// connection returns the current QUIC connection, dialing it if needed
+func (s *quicSession) connection() (quic.Connection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil && s.conn.Context().Err() == nil {
+		return s.conn, nil
+	}
+	s.closeLocked()
+
+	remoteAddr, err := net.ResolveUDPAddr(s.network, s.resolver)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP(s.network, s.source)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	conn, err := quic.Dial(ctx, udpConn, remoteAddr, s.tlsConf, &quic.Config{KeepAlivePeriod: defaultTimeout})
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	s.udpConn = udpConn
+	s.conn = conn
+	return conn, nil
+}
+
+// exchange sends a query on a new stream and waits for its answer
+func (s *quicSession) exchange(message *dns.Msg) (*dns.Msg, error) {
+	conn, err := s.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream.SetDeadline(time.Now().Add(s.timeout))
+
+	// The message ID must be 0 over QUIC, streams already match answers to queries
+	query := message.Copy()
+	query.Id = 0
+	packed, mac, err := s.tsig.pack(query)
+	if err != nil {
+		stream.CancelWrite(0)
+		return nil, err
+	}
+	buf := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(buf, uint16(len(packed)))
+	copy(buf[2:], packed)
+	if _, err := stream.Write(buf); err != nil {
+		stream.CancelRead(0)
+		return nil, err
+	}
+	// Closing the sending side tells the resolver that the query is complete
+	stream.Close()
+
+	var length [2]byte
+	if _, err := io.ReadFull(stream, length[:]); err != nil {
+		stream.CancelRead(0)
+		return nil, err
+	}
+	answer := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(stream, answer); err != nil {
+		stream.CancelRead(0)
+		return nil, err
+	}
+
+	response, err := s.tsig.unpack(answer, mac)
+	if response != nil {
+		response.Id = message.Id
+	}
+	return response, err
+}
+
+func (s *quicSession) closeLocked() {
+	if s.conn != nil {
+		s.conn.CloseWithError(0, "")
+		s.conn = nil
+	}
+	if s.udpConn != nil {
+		s.udpConn.Close()
+		s.udpConn = nil
+	}
+}
+
+func (s *quicSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLocked()
+}
@@ -0,0 +1,164 @@
+package stress
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// selfSignedCertificate returns a certificate for 127.0.0.1, valid for an hour
+func selfSignedCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// doqResponder answers the DNS over QUIC queries with their question, checking their framing
+type doqResponder struct {
+	listener *quic.Listener
+	mu       sync.Mutex
+	conns    int
+	ids      []uint16 // IDs of the queries on the wire
+	errors   []string
+}
+
+func startDOQResponder(t *testing.T) *doqResponder {
+	tlsConf := &tls.Config{Certificates: []tls.Certificate{selfSignedCertificate(t)}, NextProtos: []string{"doq"}}
+	listener, err := quic.ListenAddr("127.0.0.1:0", tlsConf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	responder := &doqResponder{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			responder.mu.Lock()
+			responder.conns++
+			responder.mu.Unlock()
+			go responder.serve(conn)
+		}
+	}()
+	return responder
+}
+
+func (s *doqResponder) fail(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors = append(s.errors, message)
+}
+
+func (s *doqResponder) serve(conn quic.Connection) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		// The query is the whole stream, its length first, and the client closes its side
+		content, err := io.ReadAll(stream)
+		if err != nil || len(content) < 2 || int(binary.BigEndian.Uint16(content)) != len(content)-2 {
+			s.fail("the query is not prefixed with its length")
+			stream.CancelWrite(0)
+			continue
+		}
+		query := new(dns.Msg)
+		if err := query.Unpack(content[2:]); err != nil {
+			s.fail(err.Error())
+			stream.CancelWrite(0)
+			continue
+		}
+		s.mu.Lock()
+		s.ids = append(s.ids, query.Id)
+		s.mu.Unlock()
+		answer := new(dns.Msg).SetReply(query)
+		answer.Answer = append(answer.Answer, &dns.TXT{Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET}, Txt: []string{"doq"}})
+		packed, _ := answer.Pack()
+		framed := make([]byte, 2+len(packed))
+		binary.BigEndian.PutUint16(framed, uint16(len(packed)))
+		copy(framed[2:], packed)
+		stream.Write(framed)
+		stream.Close()
+	}
+}
+
+func TestDOQExchange(t *testing.T) {
+	responder := startDOQResponder(t)
+	address := responder.listener.Addr().String()
+	sessions := newDOQSessions([]string{address}, "udp", func(string) *net.UDPAddr { return nil },
+		&tls.Config{InsecureSkipVerify: true}, nil, 2*time.Second)
+	session := sessions[address]
+	defer session.close()
+	if session.tlsConf.ServerName != "127.0.0.1" || len(session.tlsConf.NextProtos) != 1 || session.tlsConf.NextProtos[0] != "doq" {
+		t.Errorf("Expected the doq protocol and the server name of the resolver, got %q and %v", session.tlsConf.ServerName, session.tlsConf.NextProtos)
+	}
+
+	for index, name := range []string{"first.example.", "second.example."} {
+		query := new(dns.Msg).SetQuestion(name, dns.TypeTXT)
+		query.Id = uint16(4242 + index)
+		response, err := session.exchange(query)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %s", name, err)
+		}
+		// The ID of the query is restored on its answer, and the query itself is left as it was
+		if response.Id != query.Id || query.Id != uint16(4242+index) {
+			t.Errorf("Expected the ID %d on the answer, got %d", 4242+index, response.Id)
+		}
+		if len(response.Answer) != 1 || response.Answer[0].Header().Name != name {
+			t.Errorf("Unexpected answer for %s: %v", name, response)
+		}
+	}
+
+	responder.mu.Lock()
+	defer responder.mu.Unlock()
+	if len(responder.errors) > 0 {
+		t.Errorf("Unexpected queries: %v", responder.errors)
+	}
+	if len(responder.ids) != 2 || responder.ids[0] != 0 || responder.ids[1] != 0 {
+		t.Errorf("Expected the queries to have the ID 0 on the wire, got %v", responder.ids)
+	}
+	if responder.conns != 1 {
+		t.Errorf("Expected the queries to share a connection, got %d", responder.conns)
+	}
+}
+
+func TestDOQExchangeUnreachable(t *testing.T) {
+	responder := startDOQResponder(t)
+	address := responder.listener.Addr().String()
+	responder.listener.Close()
+	sessions := newDOQSessions([]string{address}, "udp", func(string) *net.UDPAddr { return nil },
+		&tls.Config{InsecureSkipVerify: true}, nil, 200*time.Millisecond)
+	defer sessions[address].close()
+	if _, err := sessions[address].exchange(new(dns.Msg).SetQuestion("example.com.", dns.TypeA)); err == nil {
+		t.Errorf("Expected an error without a resolver")
+	}
+}
@@ -0,0 +1,215 @@
+package stress
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// defaultEDNSBufferSize is advertised when EDNS is only needed for the DO bit or the padding,
+// as recommended by the DNS flag day 2020
+const defaultEDNSBufferSize = 1232
+
+// ednsEnabled tells whether the queries carry an OPT record
+func (c *Config) ednsEnabled() bool {
+	return c.EDNSBufSize > 0 || c.DNSSECOK || c.EDNSPadding > 0 || c.Cookies || len(c.ClientSubnets) > 0
+}
+
+// setupEDNS adds the OPT record to a query, with the EDNS options of the run
+func (c *Config) setupEDNS(message *dns.Msg) {
+	if !c.ednsEnabled() {
+		return
+	}
+	bufSize := c.EDNSBufSize
+	if bufSize <= 0 {
+		bufSize = defaultEDNSBufferSize
+	}
+	message.SetEdns0(uint16(bufSize), c.DNSSECOK)
+	c.padQuery(message)
+}
+
+// padQuery pads a query to a multiple of the padding block size (RFC 7830), which has to be
+// done again whenever the question changes
+func (c *Config) padQuery(message *dns.Msg) {
+	if c.EDNSPadding <= 0 {
+		return
+	}
+	opt := message.IsEdns0()
+	if opt == nil {
+		return
+	}
+	options := opt.Option[:0]
+	for _, option := range opt.Option {
+		if option.Option() != dns.EDNS0PADDING {
+			options = append(options, option)
+		}
+	}
+	opt.Option = options
+
+	// The padding option itself takes 4 bytes, on top of its content
+	length := message.Len() + 4
+	padding := &dns.EDNS0_PADDING{Padding: make([]byte, (c.EDNSPadding-length%c.EDNSPadding)%c.EDNSPadding)}
+	opt.Option = append(opt.Option, padding)
+}
+
+// replaceOption sets an EDNS option of a query, replacing the one with the same code if any
+func replaceOption(message *dns.Msg, option dns.EDNS0) {
+	opt := message.IsEdns0()
+	if opt == nil {
+		return
+	}
+	options := opt.Option[:0]
+	for _, current := range opt.Option {
+		if current.Option() != option.Option() {
+			options = append(options, current)
+		}
+	}
+	opt.Option = append(options, option)
+}
+
+// ParseClientSubnets parses a comma-separated list of prefixes (e.g. "203.0.113.0/24,2001:db8::/56")
+func ParseClientSubnets(input string) ([]*net.IPNet, error) {
+	var subnets []*net.IPNet
+	for _, element := range strings.Split(input, ",") {
+		element = strings.TrimSpace(element)
+		if element == "" {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(element)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix %q", element)
+		}
+		subnets = append(subnets, subnet)
+	}
+	if len(subnets) == 0 {
+		return nil, fmt.Errorf("no prefixes")
+	}
+	return subnets, nil
+}
+
+// checkClientSubnets verifies that the random subnets fit in the prefixes
+func (c *Config) checkClientSubnets() error {
+	if c.ClientSubnetLength == 0 {
+		return nil
+	}
+	for _, subnet := range c.ClientSubnets {
+		ones, bits := subnet.Mask.Size()
+		if c.ClientSubnetLength < ones || c.ClientSubnetLength > bits {
+			return fmt.Errorf("no /%d subnets within %s", c.ClientSubnetLength, subnet)
+		}
+	}
+	return nil
+}
+
+// setClientSubnet sets the EDNS Client Subnet option (RFC 7871) of a query, with a prefix or a
+// random subnet within it. The query has to be padded again afterwards.
+func (c *Config) setClientSubnet(message *dns.Msg, rnd *rand.Rand) {
+	subnet := c.ClientSubnets[rnd.Intn(len(c.ClientSubnets))]
+	ones, bits := subnet.Mask.Size()
+	base := subnet.IP
+	if bits == 32 {
+		// IPv4 prefixes may be in their 16 bytes form, once decoded from JSON
+		base = base.To4()
+	}
+	address := make(net.IP, len(base))
+	copy(address, base)
+	length := ones
+	if c.ClientSubnetLength > 0 {
+		// Randomize the bits between the prefix and the subnet lengths
+		length = c.ClientSubnetLength
+		for bit := ones; bit < length; bit++ {
+			if rnd.Intn(2) == 1 {
+				address[bit/8] |= 0x80 >> (bit % 8)
+			}
+		}
+	}
+	family := uint16(2)
+	if bits == 32 {
+		family = 1
+	}
+	replaceOption(message, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: uint8(length),
+		Address:       address,
+	})
+}
+
+// cookieJar holds the DNS cookies (RFC 7873) of a thread: its client cookie, and the server
+// cookie last returned by each resolver, all hex encoded like in the COOKIE option
+type cookieJar struct {
+	mu      sync.Mutex
+	client  string
+	servers map[string]string
+}
+
+func newCookieJar(rnd *rand.Rand) *cookieJar {
+	return &cookieJar{client: fmt.Sprintf("%016x", rnd.Uint64()), servers: make(map[string]string)}
+}
+
+// addCookie sets the COOKIE option of a query to a resolver, along with the server cookie it
+// returned when there is one. The query has to be padded again afterwards.
+func (j *cookieJar) addCookie(message *dns.Msg, resolver string) {
+	j.mu.Lock()
+	cookie := j.client + j.servers[resolver]
+	j.mu.Unlock()
+	replaceOption(message, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+}
+
+// learn stores the server cookie of a response echoing the client cookie
+func (j *cookieJar) learn(response *dns.Msg, resolver string) {
+	opt := response.IsEdns0()
+	if opt == nil {
+		return
+	}
+	for _, option := range opt.Option {
+		cookie, ok := option.(*dns.EDNS0_COOKIE)
+		if !ok || !strings.HasPrefix(strings.ToLower(cookie.Cookie), j.client) {
+			continue
+		}
+		// Server cookies are 8 to 32 bytes long
+		if server := strings.ToLower(cookie.Cookie[len(j.client):]); len(server) >= 16 && len(server) <= 64 {
+			j.mu.Lock()
+			j.servers[resolver] = server
+			j.mu.Unlock()
+		}
+	}
+}
+
+// EDNSDescription describes the EDNS options of the queries for display, or returns an empty
+// string when they have no OPT record
+func (r *Runner) EDNSDescription() string {
+	if !r.cfg.ednsEnabled() {
+		return ""
+	}
+	bufSize := r.cfg.EDNSBufSize
+	if bufSize <= 0 {
+		bufSize = defaultEDNSBufferSize
+	}
+	description := fmt.Sprintf("buffer size %d", bufSize)
+	if r.cfg.DNSSECOK {
+		description += ", DO bit"
+	}
+	if r.cfg.EDNSPadding > 0 {
+		description += fmt.Sprintf(", padding to %d bytes", r.cfg.EDNSPadding)
+	}
+	if r.cfg.Cookies {
+		description += ", cookies"
+	}
+	if len(r.cfg.ClientSubnets) > 0 {
+		prefixes := make([]string, len(r.cfg.ClientSubnets))
+		for index, subnet := range r.cfg.ClientSubnets {
+			prefixes[index] = subnet.String()
+		}
+		if r.cfg.ClientSubnetLength > 0 {
+			description += fmt.Sprintf(", random /%d client subnets within %s", r.cfg.ClientSubnetLength, strings.Join(prefixes, ", "))
+		} else {
+			description += ", client subnet " + strings.Join(prefixes, ", ")
+		}
+	}
+	return description
+}
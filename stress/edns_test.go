@@ -0,0 +1,144 @@
+package stress
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestPadQuery(t *testing.T) {
+	cfg := &Config{EDNSPadding: 128}
+	message := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	cfg.setupEDNS(message)
+	for _, name := range []string{"example.com.", "a-much-longer-name.subdomain.example.com.", "x."} {
+		message.Question[0].Name = name
+		cfg.padQuery(message)
+		packed, err := message.Pack()
+		if err != nil {
+			t.Fatalf("Unable to pack the query: %s", err)
+		}
+		if len(packed)%128 != 0 {
+			t.Errorf("Query for %s is %d bytes long, expected a multiple of 128", name, len(packed))
+		}
+		if options := len(message.IsEdns0().Option); options != 1 {
+			t.Errorf("Query for %s has %d EDNS options, expected only the padding", name, options)
+		}
+	}
+}
+
+func TestCookieJar(t *testing.T) {
+	cfg := &Config{Cookies: true, EDNSPadding: 128}
+	jar := &cookieJar{client: "0123456789abcdef", servers: make(map[string]string)}
+	message := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	cfg.setupEDNS(message)
+	cookie := func() string {
+		options := message.IsEdns0().Option
+		if _, ok := options[len(options)-1].(*dns.EDNS0_PADDING); !ok {
+			t.Errorf("The padding is not the last option")
+		}
+		for _, option := range options {
+			if cookie, ok := option.(*dns.EDNS0_COOKIE); ok {
+				return cookie.Cookie
+			}
+		}
+		return ""
+	}
+
+	jar.addCookie(message, "192.0.2.1:53")
+	cfg.padQuery(message)
+	if got := cookie(); got != jar.client {
+		t.Errorf("Got the cookie %q before any answer, expected the client cookie", got)
+	}
+
+	response := new(dns.Msg)
+	response.SetEdns0(1232, false)
+	opt := response.IsEdns0()
+	opt.Option = []dns.EDNS0{&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "ffffffffffffffff" + "1111111111111111"}}
+	jar.learn(response, "192.0.2.1:53")
+	opt.Option = []dns.EDNS0{&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: jar.client + "2222222222222222"}}
+	jar.learn(response, "192.0.2.2:53")
+
+	jar.addCookie(message, "192.0.2.1:53")
+	cfg.padQuery(message)
+	if got := cookie(); got != jar.client {
+		t.Errorf("Got the cookie %q, the server cookie of another client should be ignored", got)
+	}
+	jar.addCookie(message, "192.0.2.2:53")
+	cfg.padQuery(message)
+	if got := cookie(); got != jar.client+"2222222222222222" {
+		t.Errorf("Got the cookie %q, expected the server cookie to be echoed", got)
+	}
+	cookies := 0
+	for _, option := range message.IsEdns0().Option {
+		if option.Option() == dns.EDNS0COOKIE {
+			cookies++
+		}
+	}
+	if cookies != 1 {
+		t.Errorf("The query has %d cookies, expected one", cookies)
+	}
+}
+
+func TestClientSubnet(t *testing.T) {
+	subnets, err := ParseClientSubnets("203.0.113.0/24, 2001:db8::/32")
+	if err != nil {
+		t.Fatalf("Unable to parse the prefixes: %s", err)
+	}
+	if len(subnets) != 2 {
+		t.Fatalf("Got %d prefixes, expected 2", len(subnets))
+	}
+	if _, err := ParseClientSubnets("203.0.113.0"); err == nil {
+		t.Errorf("An address without a prefix length is accepted")
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	cfg := &Config{ClientSubnets: subnets[:1], EDNSPadding: 128}
+	message := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	cfg.setupEDNS(message)
+	subnet := func() *dns.EDNS0_SUBNET {
+		var found *dns.EDNS0_SUBNET
+		for _, option := range message.IsEdns0().Option {
+			if ecs, ok := option.(*dns.EDNS0_SUBNET); ok {
+				if found != nil {
+					t.Fatalf("The query has several client subnets")
+				}
+				found = ecs
+			}
+		}
+		return found
+	}
+
+	cfg.setClientSubnet(message, rnd)
+	cfg.padQuery(message)
+	if ecs := subnet(); ecs.Family != 1 || ecs.SourceNetmask != 24 || !ecs.Address.Equal(net.ParseIP("203.0.113.0")) {
+		t.Errorf("Got the client subnet %s, expected 203.0.113.0/24", ecs)
+	}
+
+	cfg.ClientSubnets, cfg.ClientSubnetLength = subnets[1:], 48
+	if err := cfg.checkClientSubnets(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		cfg.setClientSubnet(message, rnd)
+		cfg.padQuery(message)
+		ecs := subnet()
+		if ecs.Family != 2 || ecs.SourceNetmask != 48 || !subnets[1].Contains(ecs.Address) {
+			t.Fatalf("Got the client subnet %s, expected a /48 within 2001:db8::/32", ecs)
+		}
+		if masked := ecs.Address.Mask(net.CIDRMask(48, 128)); !masked.Equal(ecs.Address) {
+			t.Fatalf("The client subnet %s has bits set after its length", ecs)
+		}
+		seen[ecs.Address.String()] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("The client subnets are not randomized")
+	}
+
+	cfg.ClientSubnetLength = 16
+	if err := cfg.checkClientSubnets(); err == nil {
+		t.Errorf("Subnets shorter than the prefix are accepted")
+	}
+}
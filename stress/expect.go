@@ -0,0 +1,121 @@
+package stress
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// expectKey identifies the queries an expectation applies to, a qtype of 0 matching all types
+type expectKey struct {
+	name  string
+	qtype uint16
+}
+
+// Expectations are the answers expected for some names, the values of each RRset in
+// presentation format
+type Expectations map[expectKey]map[string]bool
+
+// ParseExpectations parses a comma-separated list of "name=value" or "name/TYPE=value"
+// elements. Several values for the same name make up the expected RRset.
+func ParseExpectations(input string) (Expectations, error) {
+	parsed := make(Expectations)
+	for _, element := range strings.Split(input, ",") {
+		element = strings.TrimSpace(element)
+		if element == "" {
+			continue
+		}
+		separator := strings.Index(element, "=")
+		if separator <= 0 || separator == len(element)-1 {
+			return nil, fmt.Errorf("expected \"name=value\" in %q", element)
+		}
+		name, value := element[:separator], element[separator+1:]
+		key := expectKey{}
+		if slash := strings.Index(name, "/"); slash >= 0 {
+			qtype, ok := dns.StringToType[strings.ToUpper(name[slash+1:])]
+			if !ok {
+				return nil, fmt.Errorf("unknown record type in %q", element)
+			}
+			key.qtype = qtype
+			name = name[:slash]
+		}
+		key.name = dns.CanonicalName(name)
+		if parsed[key] == nil {
+			parsed[key] = make(map[string]bool)
+		}
+		parsed[key][strings.ToLower(value)] = true
+	}
+	if len(parsed) == 0 {
+		return nil, fmt.Errorf("empty list")
+	}
+	return parsed, nil
+}
+
+// matches tells whether the records of the queried type in an answer are exactly the expected
+// ones. Queries without expectations always match.
+func (e Expectations) matches(response *dns.Msg, name string, qtype uint16) bool {
+	name = dns.CanonicalName(name)
+	expected, ok := e[expectKey{name, qtype}]
+	if !ok {
+		if expected, ok = e[expectKey{name, 0}]; !ok {
+			return true
+		}
+	}
+
+	got := make(map[string]bool)
+	for _, rr := range response.Answer {
+		if rr.Header().Rrtype == qtype {
+			got[strings.ToLower(strings.TrimPrefix(rr.String(), rr.Header().String()))] = true
+		}
+	}
+	if len(got) != len(expected) {
+		return false
+	}
+	for value := range got {
+		if !expected[value] {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalJSON encodes the expectations as the "name/TYPE=value" elements they are parsed from,
+// so that a Config can be sent to the agents
+func (e Expectations) MarshalJSON() ([]byte, error) {
+	if e == nil {
+		return []byte("null"), nil
+	}
+	var elements []string
+	for key, values := range e {
+		name := key.name
+		if key.qtype != 0 {
+			name += "/" + dns.TypeToString[key.qtype]
+		}
+		for value := range values {
+			elements = append(elements, name+"="+value)
+		}
+	}
+	sort.Strings(elements)
+	return json.Marshal(elements)
+}
+
+// UnmarshalJSON decodes the elements written by MarshalJSON
+func (e *Expectations) UnmarshalJSON(data []byte) error {
+	var elements []string
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return err
+	}
+	if elements == nil {
+		*e = nil
+		return nil
+	}
+	parsed, err := ParseExpectations(strings.Join(elements, ","))
+	if err != nil {
+		return err
+	}
+	*e = parsed
+	return nil
+}
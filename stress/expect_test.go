@@ -0,0 +1,64 @@
+package stress
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestMatchesExpectation(t *testing.T) {
+	parsed, err := ParseExpectations("Example.com=192.0.2.1,example.com.=192.0.2.2,example.com/AAAA=2001:DB8::1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	answer := func(records ...string) *dns.Msg {
+		response := new(dns.Msg)
+		for _, record := range records {
+			rr, _ := dns.NewRR(record)
+			response.Answer = append(response.Answer, rr)
+		}
+		return response
+	}
+	tables := []struct {
+		response *dns.Msg
+		name     string
+		qtype    uint16
+		expected bool
+	}{
+		{answer("example.com. 60 IN A 192.0.2.2", "example.com. 60 IN A 192.0.2.1"), "example.com.", dns.TypeA, true},
+		{answer("example.com. 60 IN A 192.0.2.1"), "example.com.", dns.TypeA, false},
+		{answer("example.com. 60 IN A 192.0.2.1", "example.com. 60 IN A 192.0.2.3"), "example.com.", dns.TypeA, false},
+		{answer(), "example.com.", dns.TypeA, false},
+		{answer("example.com. 60 IN AAAA 2001:db8::1"), "EXAMPLE.com.", dns.TypeAAAA, true},
+		{answer(), "other.example.", dns.TypeA, true},
+	}
+	for _, table := range tables {
+		if got := parsed.matches(table.response, table.name, table.qtype); got != table.expected {
+			t.Errorf("Answer %v for %s matched: %v, expected %v", table.response.Answer, table.name, got, table.expected)
+		}
+	}
+
+	for _, input := range []string{"", "example.com", "=192.0.2.1", "example.com=", "example.com/BOGUS=1"} {
+		if _, err := ParseExpectations(input); err == nil {
+			t.Errorf("Parsing %q should fail", input)
+		}
+	}
+}
+
+func TestExpectationsJSON(t *testing.T) {
+	parsed, _ := ParseExpectations("example.com=192.0.2.1,example.com/AAAA=2001:db8::1")
+	data, err := json.Marshal(parsed)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var decoded Expectations
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unexpected error decoding %s: %s", data, err)
+	}
+	if !reflect.DeepEqual(decoded, parsed) {
+		t.Errorf("Got %v after decoding %s, expected %v", decoded, data, parsed)
+	}
+}
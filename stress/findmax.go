@@ -0,0 +1,103 @@
+package stress
+
+import (
+	"time"
+)
+
+// Defaults of the search of the maximum rate
+const (
+	defaultFindMaxStart  = 1000
+	defaultFindMaxWindow = 10 * time.Second
+)
+
+// findMaxPrecision is the gap between the highest rate met and the lowest one missed at which
+// the search ends, relatively to the first one
+const findMaxPrecision = 0.05
+
+// findMaxShortfall is the share of the target rate the answers may fall short of during a level:
+// beyond it, the resolvers are not keeping up even if they stay within the SLA
+const findMaxShortfall = 0.05
+
+// RateLevel is a rate tried by the search of the maximum rate, and how the resolvers held it
+type RateLevel struct {
+	Rate      int     // Target rate, in queries per second
+	Answered  float64 // Rate of the answers received
+	ErrorRate float64
+	P99       time.Duration
+	Met       bool // The answers kept up with the rate, within the SLA
+}
+
+// RateSearch is the outcome of the search of the maximum rate
+type RateSearch struct {
+	MaxRate int // Highest rate met, 0 if even the lowest one tried was missed
+	Levels  []RateLevel
+}
+
+// rateSearch doubles the target rate from FindMaxStart while the resolvers keep up with it within
+// the SLA, then searches between the highest rate met and the lowest one missed by bisection.
+// Each rate is held for FindMaxWindow, and the run is stopped once both are close enough.
+type rateSearch struct {
+	runner  *Runner
+	sla     SLA
+	window  time.Duration
+	result  RateSearch
+	rate    int
+	missed  int // Lowest rate missed, 0 until one is
+	started time.Time
+	level   *Stats // Stats of the intervals at the current rate
+	done    bool
+}
+
+func newRateSearch(r *Runner) *rateSearch {
+	return &rateSearch{runner: r, sla: r.cfg.SLA, window: r.cfg.FindMaxWindow, rate: r.cfg.FindMaxStart}
+}
+
+// start sets the limiter to the first rate
+func (s *rateSearch) start() {
+	s.setRate(s.rate)
+}
+
+func (s *rateSearch) setRate(rate int) {
+	s.rate = rate
+	s.started = time.Now()
+	s.level = newStats(s.runner.cfg.Flood)
+	s.runner.limiter.Store(newRateLimiter(rate, s.runner.cfg.Concurrency))
+}
+
+// add accounts for an interval, and moves to the next rate once the current one has been held
+// for the window
+func (s *rateSearch) add(interval *Stats) {
+	if s.done {
+		return
+	}
+	s.level.merge(interval)
+	s.level.Duration += interval.Duration
+	if time.Since(s.started) < s.window {
+		return
+	}
+
+	level := RateLevel{Rate: s.rate, Answered: float64(s.level.Received) / s.level.Duration.Seconds()}
+	if s.level.Sent > 0 {
+		level.ErrorRate = float64(s.level.Errors) / float64(s.level.Sent)
+		level.P99 = time.Duration(s.level.Latency.ValueAtQuantile(99)) * time.Microsecond
+	}
+	level.Met = s.sla.Met(s.level) && level.Answered >= float64(s.rate)*(1-findMaxShortfall)
+	s.result.Levels = append(s.result.Levels, level)
+	s.runner.cfg.logf("Rate of %d queries per second: %.0f answers per second, met: %v.", level.Rate, level.Answered, level.Met)
+	if level.Met {
+		s.result.MaxRate = s.rate
+	} else {
+		s.missed = s.rate
+	}
+
+	next := 2 * s.rate
+	if s.missed > 0 {
+		next = (s.result.MaxRate + s.missed) / 2
+	}
+	if s.missed > 0 && float64(s.missed-s.result.MaxRate) <= findMaxPrecision*float64(s.result.MaxRate) || next == s.rate || next == 0 {
+		s.done = true
+		s.runner.Stop()
+		return
+	}
+	s.setRate(next)
+}
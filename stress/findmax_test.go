@@ -0,0 +1,38 @@
+package stress
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRateSearch(t *testing.T) {
+	// The resolver answers up to 3000 queries per second, 3125 are still met within the shortfall
+	r := &Runner{cfg: *NewConfig()}
+	r.cfg.FindMaxStart = 1000
+	search := newRateSearch(r)
+	search.start()
+	var tried []int
+	for !search.done {
+		tried = append(tried, search.rate)
+		answered := search.rate
+		if answered > 3000 {
+			answered = 3000
+		}
+		interval := newStats(false)
+		interval.Sent = search.rate
+		interval.Received = answered
+		interval.Duration = time.Second
+		search.add(interval)
+	}
+	expected := []int{1000, 2000, 4000, 3000, 3500, 3250, 3125}
+	if !reflect.DeepEqual(tried, expected) {
+		t.Errorf("Tried %v, expected %v", tried, expected)
+	}
+	if search.result.MaxRate != 3125 || len(search.result.Levels) != len(expected) {
+		t.Errorf("Got the maximum rate %d after %d levels, expected 3125", search.result.MaxRate, len(search.result.Levels))
+	}
+	if r.Rate() != 3125 {
+		t.Errorf("Got the rate %d, expected the last one tried", r.Rate())
+	}
+}
@@ -0,0 +1,413 @@
+package stress
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// floodSweepInterval is how often the queries still waiting for an answer are checked for
+// expiry, which is a walk over all of them
+const floodSweepInterval = 100 * time.Millisecond
+
+// defaultFloodBatch is the number of queries sent at once when flooding, unless set otherwise
+const defaultFloodBatch = 32
+
+// floodSender is used by a flooding thread to send queries without waiting for their answers.
+// Over UDP, the queries of a thread share one socket per resolver, and the answers are read in
+// the background and matched to the queries by ID and resolver. Unless a rate paces them, they
+// are sent by batches of FloodBatch. Queries not answered within the timeout are counted as
+// dropped. With InFlight, the thread waits for answers once that many queries are in flight.
+type floodSender struct {
+	runner     *Runner
+	threadID   int
+	mu         sync.Mutex
+	pending    map[pendingKey][]time.Time // Send times of the UDP queries waiting for an answer
+	inFlight   int
+	slots      chan struct{} // Taken by the queries in flight, when their number is bounded
+	answered   int
+	dropped    int
+	elapsed    time.Duration
+	maxElapsed time.Duration
+	latencies  []time.Duration
+	sizes      []int // Wire sizes of the answers
+	outcomes   map[string]int
+	truncated  int
+	lastSweep  time.Time
+	conns      map[string]*dns.Conn
+	batches    map[string]*floodBatch // Queries packed but not sent yet, by resolver, when batching
+	cookies    *cookieJar             // Learns the server cookies of the answers, with -cookies
+	packed     *packCache             // Wire format of the queries, when it can be cached
+	buf        []byte                 // Query being sent one by one
+}
+
+// pendingKey identifies the answer to a UDP query: answers from other resolvers may have the
+// same ID
+type pendingKey struct {
+	id      uint16
+	address string
+}
+
+// floodBatch holds the packed queries to a resolver, sent at once when it is full. The queries
+// are packed one after the other in the same buffer, which is reused for the next batches.
+type floodBatch struct {
+	conn    batchConn
+	ids     []uint16
+	buf     []byte
+	ends    []int // End of each query in buf
+	packets [][]byte
+}
+
+// batchConn sends several packets at once on a socket
+type batchConn interface {
+	// writeBatch sends the packets, and returns the number of them that were sent
+	writeBatch(packets [][]byte) (int, error)
+}
+
+// writeConn sends the packets of a batch one by one
+type writeConn struct {
+	conn net.Conn
+}
+
+func (c *writeConn) writeBatch(packets [][]byte) (int, error) {
+	for index, packet := range packets {
+		if _, err := c.conn.Write(packet); err != nil {
+			return index, err
+		}
+	}
+	return len(packets), nil
+}
+
+func newFloodSender(runner *Runner, threadID int) *floodSender {
+	f := &floodSender{
+		runner:    runner,
+		threadID:  threadID,
+		pending:   make(map[pendingKey][]time.Time),
+		outcomes:  make(map[string]int),
+		lastSweep: time.Now(),
+		conns:     make(map[string]*dns.Conn),
+		batches:   make(map[string]*floodBatch),
+	}
+	if runner.cfg.InFlight > 0 {
+		f.slots = make(chan struct{}, runner.cfg.InFlight)
+	}
+	return f
+}
+
+// acquire waits for a slot among the InFlight ones before a query is sent. The batched queries
+// are sent first, as the slots are freed by their answers, or by their expiry.
+func (f *floodSender) acquire() {
+	if f.slots == nil {
+		return
+	}
+	select {
+	case f.slots <- struct{}{}:
+		return
+	default:
+	}
+	for address := range f.batches {
+		f.flush(address)
+	}
+	ticker := time.NewTicker(floodSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case f.slots <- struct{}{}:
+			return
+		case <-ticker.C:
+			f.mu.Lock()
+			f.sweep(time.Now(), false)
+			f.mu.Unlock()
+		}
+	}
+}
+
+// release frees the slots of queries that are no longer in flight
+func (f *floodSender) release(n int) {
+	if f.slots == nil {
+		return
+	}
+	for ; n > 0; n-- {
+		<-f.slots
+	}
+}
+
+// batching tells whether the queries are sent by batches of FloodBatch, which is only the case
+// while they are not paced: batches would otherwise wait for the next queries to fill up
+func (f *floodSender) batching() bool {
+	r := f.runner
+	return r.cfg.FloodBatch > 1 && r.limiter.Load() == nil && r.timing == nil && r.cfg.Arrivals != "poisson" && r.bursts == nil
+}
+
+// sharesSockets tells whether the queries are sent on the UDP sockets of the thread, rather than
+// each one on its own
+func (f *floodSender) sharesSockets() bool {
+	cfg := &f.runner.cfg
+	return !cfg.TCP && !cfg.DoT && cfg.DOHEndpoint == "" && !cfg.DoQ
+}
+
+// send sends a query to the resolver, its answer will be accounted for once it arrives. Queries
+// sent on the UDP sockets are packed right away, the message can be reused afterwards.
+func (f *floodSender) send(address string, query *dns.Msg) {
+	f.acquire()
+	if !f.sharesSockets() {
+		// Other transports have no shared socket, each query waits for its answer on its own
+		f.mu.Lock()
+		f.inFlight++
+		f.mu.Unlock()
+		go func() {
+			start := time.Now()
+			response, err := f.runner.exchange(f.threadID, address, query)
+			f.learnCookie(response, err, address)
+			f.done(response, err, time.Since(start))
+		}()
+		return
+	}
+
+	f.mu.Lock()
+	f.inFlight++
+	f.mu.Unlock()
+	co, err := f.conn(address)
+	if err == nil && f.batching() {
+		err = f.queue(address, co, query)
+	} else if err == nil {
+		f.flush(address)
+		f.mu.Lock()
+		key := pendingKey{query.Id, address}
+		f.pending[key] = append(f.pending[key], time.Now())
+		f.mu.Unlock()
+		if f.buf, err = f.appendPacked(f.buf[:0], query); err == nil {
+			_, err = co.Write(f.buf)
+		}
+		if err != nil {
+			f.mu.Lock()
+			f.popPending(pendingKey{query.Id, address})
+			f.mu.Unlock()
+		}
+	}
+	if err != nil {
+		f.done(nil, err, 0)
+	}
+}
+
+// queue packs a query into the batch of the resolver, and sends the batch once it is full
+func (f *floodSender) queue(address string, co *dns.Conn, query *dns.Msg) error {
+	batch, ok := f.batches[address]
+	if !ok {
+		batch = &floodBatch{conn: newBatchConn(co.Conn)}
+		f.batches[address] = batch
+	}
+	var err error
+	if batch.buf, err = f.appendPacked(batch.buf, query); err != nil {
+		return err
+	}
+	batch.ids = append(batch.ids, query.Id)
+	batch.ends = append(batch.ends, len(batch.buf))
+	if len(batch.ids) >= f.runner.cfg.FloodBatch {
+		f.flush(address)
+	}
+	return nil
+}
+
+// flush sends the queries of the batch of a resolver, if there are any
+func (f *floodSender) flush(address string) {
+	batch, ok := f.batches[address]
+	if !ok || len(batch.ids) == 0 {
+		return
+	}
+	now := time.Now()
+	f.mu.Lock()
+	for _, id := range batch.ids {
+		key := pendingKey{id, address}
+		f.pending[key] = append(f.pending[key], now)
+	}
+	f.mu.Unlock()
+	batch.packets = batch.packets[:0]
+	start := 0
+	for _, end := range batch.ends {
+		batch.packets = append(batch.packets, batch.buf[start:end])
+		start = end
+	}
+	sent, err := batch.conn.writeBatch(batch.packets)
+	for _, id := range batch.ids[sent:] {
+		f.mu.Lock()
+		f.popPending(pendingKey{id, address})
+		f.mu.Unlock()
+		f.done(nil, err, 0)
+	}
+	batch.ids = batch.ids[:0]
+	batch.buf = batch.buf[:0]
+	batch.ends = batch.ends[:0]
+}
+
+// appendPacked appends the wire format of a query to a buffer, from the cache when there is one
+func (f *floodSender) appendPacked(buf []byte, query *dns.Msg) ([]byte, error) {
+	if f.packed != nil {
+		return f.packed.appendPacked(buf, query)
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return buf, err
+	}
+	return append(buf, packed...), nil
+}
+
+// conn returns the socket used to flood a resolver, opening it if needed
+func (f *floodSender) conn(address string) (*dns.Conn, error) {
+	if co, ok := f.conns[address]; ok {
+		return co, nil
+	}
+	dnsconn, err := f.runner.dialResolver(f.runner.transportNetwork(), address, f.runner.source(f.threadID, address))
+	if err != nil {
+		return nil, err
+	}
+	co := &dns.Conn{Conn: dnsconn}
+	f.conns[address] = co
+	go f.receive(address, co)
+	return co, nil
+}
+
+// receive reads the answers arriving on a socket, until it is closed
+func (f *floodSender) receive(address string, co *dns.Conn) {
+	for {
+		response, err := co.ReadMsg()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			// Errors such as unreachable resolvers are only reported by the expiry of the queries
+			continue
+		}
+		f.mu.Lock()
+		sentAt, ok := f.popPending(pendingKey{response.Id, address})
+		f.mu.Unlock()
+		if ok {
+			f.learnCookie(response, nil, address)
+			f.done(response, nil, time.Since(sentAt))
+		}
+	}
+}
+
+// learnCookie stores the server cookie of an answer, when cookies are sent
+func (f *floodSender) learnCookie(response *dns.Msg, err error, address string) {
+	if f.cookies != nil && err == nil {
+		f.cookies.learn(response, address)
+	}
+}
+
+// popPending removes the oldest query waiting for an answer with the given ID from a resolver
+func (f *floodSender) popPending(key pendingKey) (time.Time, bool) {
+	times, ok := f.pending[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	if len(times) == 1 {
+		delete(f.pending, key)
+	} else {
+		f.pending[key] = times[1:]
+	}
+	return times[0], true
+}
+
+// done accounts for a query that got an answer, or failed
+func (f *floodSender) done(response *dns.Msg, err error, spent time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inFlight--
+	f.release(1)
+	f.outcomes[queryOutcome(response, err)]++
+	if err != nil {
+		f.dropped++
+		return
+	}
+	f.answered++
+	if response.Truncated {
+		f.truncated++
+	}
+	f.elapsed += spent
+	f.latencies = append(f.latencies, spent)
+	f.sizes = append(f.sizes, wireSize(response))
+	if spent > f.maxElapsed {
+		f.maxElapsed = spent
+	}
+}
+
+// sweep counts the queries that have been waiting for an answer for too long as dropped
+func (f *floodSender) sweep(now time.Time, force bool) {
+	if !force && now.Sub(f.lastSweep) < floodSweepInterval {
+		return
+	}
+	f.lastSweep = now
+	for key, times := range f.pending {
+		expired := 0
+		for expired < len(times) && now.Sub(times[expired]) > f.runner.cfg.Timeout {
+			expired++
+		}
+		if expired == len(times) {
+			delete(f.pending, key)
+		} else if expired > 0 {
+			f.pending[key] = times[expired:]
+		}
+		f.inFlight -= expired
+		f.release(expired)
+		f.dropped += expired
+		if expired > 0 {
+			f.outcomes[outcomeTimeout] += expired
+		}
+	}
+}
+
+// collect fills the stats of a report with the answers received since the previous one
+func (f *floodSender) collect(message *statsMessage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sweep(time.Now(), false)
+	message.received = f.answered
+	message.err = f.dropped
+	message.elapsed = f.elapsed
+	message.maxElapsed = f.maxElapsed
+	message.latencies = f.latencies
+	message.responseSizes = f.sizes
+	message.byOutcome = f.outcomes
+	message.truncated = f.truncated
+	f.answered = 0
+	f.truncated = 0
+	f.dropped = 0
+	f.elapsed = 0
+	f.maxElapsed = 0
+	f.latencies = nil
+	f.sizes = nil
+	f.outcomes = make(map[string]int)
+}
+
+// finish waits for the answers to the queries still in flight, then closes the sockets. The
+// queries still unanswered are dropped.
+func (f *floodSender) finish() {
+	for address := range f.batches {
+		f.flush(address)
+	}
+	deadline := time.Now().Add(f.runner.cfg.Timeout)
+	for time.Now().Before(deadline) {
+		f.mu.Lock()
+		inFlight := f.inFlight
+		f.mu.Unlock()
+		if inFlight <= 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, co := range f.conns {
+		co.Close()
+	}
+	f.mu.Lock()
+	f.sweep(time.Now().Add(f.runner.cfg.Timeout), true)
+	f.dropped += f.inFlight
+	if f.inFlight > 0 {
+		f.outcomes[outcomeTimeout] += f.inFlight
+	}
+	f.inFlight = 0
+	f.mu.Unlock()
+}
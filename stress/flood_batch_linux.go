@@ -0,0 +1,56 @@
+//go:build linux
+
+package stress
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// batchWriter is the part of the ipv4 and ipv6 packet connections sending with sendmmsg
+type batchWriter interface {
+	WriteBatch(messages []ipv4.Message, flags int) (int, error)
+}
+
+// mmsgConn sends the batches of packets of a connected UDP socket with sendmmsg
+type mmsgConn struct {
+	conn     net.Conn
+	writer   batchWriter
+	messages []ipv4.Message
+}
+
+func newBatchConn(conn net.Conn) batchConn {
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		return &writeConn{conn}
+	}
+	c := &mmsgConn{conn: conn}
+	if address, ok := udpConn.LocalAddr().(*net.UDPAddr); ok && address.IP.To4() == nil {
+		c.writer = ipv6.NewPacketConn(udpConn)
+	} else {
+		c.writer = ipv4.NewPacketConn(udpConn)
+	}
+	return c
+}
+
+func (c *mmsgConn) writeBatch(packets [][]byte) (int, error) {
+	if cap(c.messages) < len(packets) {
+		c.messages = make([]ipv4.Message, len(packets))
+	}
+	messages := c.messages[:len(packets)]
+	for index, packet := range packets {
+		messages[index].Buffers = [][]byte{packet}
+	}
+	sent := 0
+	for sent < len(messages) {
+		// The kernel may send only part of the batch
+		n, err := c.writer.WriteBatch(messages[sent:], 0)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
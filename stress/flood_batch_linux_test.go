@@ -0,0 +1,71 @@
+package stress
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestMmsgConn(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	batch := newBatchConn(conn)
+	if _, ok := batch.(*mmsgConn); !ok {
+		t.Fatalf("Expected sendmmsg on a UDP socket, got %T", batch)
+	}
+
+	for _, packets := range [][][]byte{
+		{[]byte("first"), []byte("second"), []byte("third")},
+		{[]byte("fourth")}, // The messages of the previous batch are reused
+	} {
+		sent, err := batch.writeBatch(packets)
+		if err != nil || sent != len(packets) {
+			t.Fatalf("Expected %d packets sent, got %d (%v)", len(packets), sent, err)
+		}
+		buf := make([]byte, 512)
+		for _, expected := range packets {
+			listener.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, err := listener.ReadFrom(buf)
+			if err != nil || string(buf[:n]) != string(expected) {
+				t.Errorf("Expected the packet %q, got %q (%v)", expected, buf[:n], err)
+			}
+		}
+	}
+}
+
+// stingyWriter takes one message per call, as a kernel sending part of the batch
+type stingyWriter struct {
+	calls int
+	fail  int // Call failing, 0 for none
+}
+
+func (w *stingyWriter) WriteBatch(messages []ipv4.Message, flags int) (int, error) {
+	w.calls++
+	if w.calls == w.fail {
+		return 0, errors.New("no buffer space available")
+	}
+	return 1, nil
+}
+
+func TestMmsgConnPartialWrites(t *testing.T) {
+	packets := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	writer := &stingyWriter{}
+	if sent, err := (&mmsgConn{writer: writer}).writeBatch(packets); sent != 3 || err != nil || writer.calls != 3 {
+		t.Errorf("Expected the rest of the batch to be sent again, got %d sent in %d calls (%v)", sent, writer.calls, err)
+	}
+	writer = &stingyWriter{fail: 3}
+	if sent, err := (&mmsgConn{writer: writer}).writeBatch(packets); sent != 2 || err == nil {
+		t.Errorf("Expected the packets sent before the error, got %d (%v)", sent, err)
+	}
+}
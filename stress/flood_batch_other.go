@@ -0,0 +1,9 @@
+//go:build !linux
+
+package stress
+
+import "net"
+
+func newBatchConn(conn net.Conn) batchConn {
+	return &writeConn{conn}
+}
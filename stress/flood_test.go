@@ -0,0 +1,240 @@
+package stress
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestFloodInFlight(t *testing.T) {
+	r := &Runner{cfg: *NewConfig()}
+	r.cfg.InFlight = 2
+	r.cfg.Timeout = time.Hour
+	f := newFloodSender(r, 0)
+	for _, address := range []string{"192.0.2.1:53", "192.0.2.2:53"} {
+		f.acquire()
+		f.inFlight++
+		f.pending[pendingKey{1, address}] = []time.Time{time.Now()}
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		f.acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("A third query should wait with 2 in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// The same ID from another resolver does not match the queries
+	if _, ok := f.popPending(pendingKey{1, "192.0.2.3:53"}); ok {
+		t.Errorf("The answer of another resolver should not match")
+	}
+	if _, ok := f.popPending(pendingKey{1, "192.0.2.2:53"}); !ok {
+		t.Fatalf("The answer should match the query to its resolver")
+	}
+	f.done(new(dns.Msg), nil, time.Millisecond)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("The answer should free a slot")
+	}
+}
+
+// floodResponder answers the queries on a local UDP socket according to their name: the ones for
+// drop. get no answer, the ones for slow. a late one, the ones for fail. a SERVFAIL, and the
+// others an answer sent twice, the copy having to be ignored
+type floodResponder struct {
+	conn     net.PacketConn
+	mu       sync.Mutex
+	received map[string]int // Queries by first label
+}
+
+func startFloodResponder(t *testing.T) *floodResponder {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	responder := &floodResponder{conn: conn, received: make(map[string]int)}
+	go responder.serve()
+	return responder
+}
+
+func (s *floodResponder) serve() {
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query := new(dns.Msg)
+		if query.Unpack(buf[:n]) != nil || len(query.Question) == 0 {
+			continue
+		}
+		label := dns.SplitDomainName(query.Question[0].Name)[0]
+		s.mu.Lock()
+		s.received[label]++
+		s.mu.Unlock()
+		answer := new(dns.Msg).SetReply(query)
+		packed, _ := answer.Pack()
+		switch label {
+		case "drop":
+		case "slow":
+			time.AfterFunc(50*time.Millisecond, func() { s.conn.WriteTo(packed, from) })
+		case "fail":
+			answer.Rcode = dns.RcodeServerFailure
+			packed, _ = answer.Pack()
+			s.conn.WriteTo(packed, from)
+		default:
+			s.conn.WriteTo(packed, from)
+			s.conn.WriteTo(packed, from)
+		}
+	}
+}
+
+func TestFloodAnswers(t *testing.T) {
+	responder := startFloodResponder(t)
+	cfg := NewConfig()
+	cfg.Resolvers = []string{responder.conn.LocalAddr().String()}
+	cfg.Domains = []string{"ok.example.", "slow.example.", "drop.example.", "fail.example."}
+	cfg.Concurrency = 2
+	cfg.Count = 200
+	cfg.Flood = true
+	cfg.Timeout = 300 * time.Millisecond
+	runner, err := NewRunner(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totals := runner.Run()
+
+	responder.mu.Lock()
+	defer responder.mu.Unlock()
+	queries := 0
+	for _, n := range responder.received {
+		queries += n
+	}
+	if totals.Sent != cfg.Count || queries != cfg.Count {
+		t.Fatalf("Expected %d queries sent and received by the resolver, got %d and %d", cfg.Count, totals.Sent, queries)
+	}
+	if responder.received["drop"] == 0 || responder.received["slow"] == 0 || responder.received["ok"] == 0 {
+		t.Fatalf("Expected queries for all the domains, got %v", responder.received)
+	}
+	answered := responder.received["ok"] + responder.received["slow"] + responder.received["fail"]
+	if totals.Received != answered {
+		t.Errorf("Expected %d answers, the copies ignored, got %d", answered, totals.Received)
+	}
+	if dropped := responder.received["drop"]; totals.Errors != dropped || totals.ByOutcome[outcomeTimeout] != dropped {
+		t.Errorf("Expected the %d unanswered queries to be dropped as timeouts, got %d errors and %d timeouts",
+			dropped, totals.Errors, totals.ByOutcome[outcomeTimeout])
+	}
+	if served, failed := totals.ByOutcome["NOERROR"], totals.ByOutcome["SERVFAIL"]; served != responder.received["ok"]+responder.received["slow"] || failed != responder.received["fail"] {
+		t.Errorf("Unexpected response codes: %v for %v", totals.ByOutcome, responder.received)
+	}
+	if totals.MaxElapsed < 50*time.Millisecond {
+		t.Errorf("Expected the latency of the late answers, got at most %s", totals.MaxElapsed)
+	}
+}
+
+func TestFloodSweep(t *testing.T) {
+	r := &Runner{cfg: *NewConfig()}
+	r.cfg.Timeout = time.Second
+	f := newFloodSender(r, 0)
+	now := time.Now()
+	f.lastSweep = now.Add(-time.Minute)
+	f.pending[pendingKey{1, "192.0.2.1:53"}] = []time.Time{now.Add(-2 * time.Second), now}
+	f.pending[pendingKey{2, "192.0.2.1:53"}] = []time.Time{now.Add(-3 * time.Second)}
+	f.inFlight = 4 // One more query waits for its answer on its own connection
+
+	f.sweep(now, false)
+	if f.dropped != 2 || f.outcomes[outcomeTimeout] != 2 || f.inFlight != 2 || len(f.pending) != 1 {
+		t.Fatalf("Expected the 2 expired queries to be dropped, got %d dropped, %d in flight and %v", f.dropped, f.inFlight, f.pending)
+	}
+	// Sweeps are spaced out, unless forced
+	r.cfg.Timeout = time.Millisecond
+	f.sweep(now.Add(floodSweepInterval/2), false)
+	if f.dropped != 2 {
+		t.Errorf("Expected no sweep right after the previous one, got %d dropped", f.dropped)
+	}
+
+	// The queries still in flight at the end are dropped, wherever they wait
+	f.finish()
+	if f.dropped != 4 || f.outcomes[outcomeTimeout] != 4 || f.inFlight != 0 || len(f.pending) != 0 {
+		t.Errorf("Expected all the queries to be dropped at the end, got %d dropped, %d in flight and %v", f.dropped, f.inFlight, f.pending)
+	}
+
+	var message statsMessage
+	f.collect(&message)
+	if message.err != 4 || message.byOutcome[outcomeTimeout] != 4 || f.dropped != 0 || len(f.outcomes) != 0 {
+		t.Errorf("Expected the drops to be reported once, got %d and %v", message.err, message.byOutcome)
+	}
+}
+
+// shortBatchConn sends only the first packets of each batch, as a socket failing mid-batch
+type shortBatchConn struct {
+	limit int
+	sent  [][]byte
+}
+
+func (c *shortBatchConn) writeBatch(packets [][]byte) (int, error) {
+	n := c.limit
+	if n > len(packets) {
+		n = len(packets)
+	}
+	for _, packet := range packets[:n] {
+		c.sent = append(c.sent, append([]byte(nil), packet...))
+	}
+	if n < len(packets) {
+		return n, errors.New("no buffer space available")
+	}
+	return n, nil
+}
+
+func TestFloodPartialBatch(t *testing.T) {
+	r := &Runner{cfg: *NewConfig()}
+	r.cfg.FloodBatch = 4
+	r.cfg.InFlight = 4
+	f := newFloodSender(r, 0)
+	address := "192.0.2.1:53"
+	conn := &shortBatchConn{limit: 2}
+	f.batches[address] = &floodBatch{conn: conn}
+	for id := uint16(1); id <= 4; id++ {
+		f.acquire()
+		f.inFlight++
+		query := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+		query.Id = id
+		if err := f.queue(address, nil, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The full batch was flushed, and only its first 2 queries went out
+	if len(conn.sent) != 2 {
+		t.Fatalf("Expected 2 packets sent, got %d", len(conn.sent))
+	}
+	for index, packet := range conn.sent {
+		query := new(dns.Msg)
+		if err := query.Unpack(packet); err != nil || query.Id != uint16(index+1) {
+			t.Errorf("Expected the query %d to be sent in order, got %v (%v)", index+1, query.Id, err)
+		}
+	}
+	for id := uint16(1); id <= 4; id++ {
+		_, pending := f.pending[pendingKey{id, address}]
+		if pending != (id <= 2) {
+			t.Errorf("Expected only the queries sent to wait for an answer, got %v for %d", pending, id)
+		}
+	}
+	if f.inFlight != 2 || f.dropped != 2 || f.outcomes[outcomeNetworkError] != 2 || len(f.slots) != 2 {
+		t.Errorf("Expected the 2 queries not sent to be counted as errors and free their slots, got %d in flight, %d dropped, %v and %d slots taken",
+			f.inFlight, f.dropped, f.outcomes, len(f.slots))
+	}
+	if batch := f.batches[address]; len(batch.ids) != 0 || len(batch.buf) != 0 || len(batch.ends) != 0 {
+		t.Errorf("Expected the batch to be emptied, got %d queries", len(batch.ids))
+	}
+}
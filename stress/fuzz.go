@@ -0,0 +1,163 @@
+package stress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	mathrand "math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Mutations of the fuzzed queries, the last one only over TCP and TLS
+var fuzzMutations = []string{
+	"truncated-header",   // Shorter than the 12 bytes of a header
+	"bogus-counts",       // Random counts of records in the sections
+	"bogus-label-length", // The first label of the name goes beyond the end of the packet
+	"pointer-loop",       // The name is a compression pointer to itself
+	"oversized-name",     // Name longer than 255 bytes
+	"random-bytes",       // A few bytes of the query replaced
+	"bogus-tcp-length",   // The length prefix announces more bytes than sent
+}
+
+// Outcomes of the fuzzed queries that got no DNS answer, next to the response codes
+const (
+	fuzzDropped    = "dropped"    // No answer within the timeout
+	fuzzReset      = "reset"      // The connection was reset
+	fuzzRefused    = "refused"    // The connection was refused, or the port unreachable
+	fuzzClosed     = "closed"     // The resolver closed the connection without answering
+	fuzzUnparsable = "unparsable" // The answer is shorter than a header
+)
+
+// fuzzedPacket is a malformed query
+type fuzzedPacket struct {
+	mutation string
+	data     []byte
+	length   int // Length announced over TCP and TLS
+}
+
+// fuzzQuery corrupts a packed query with a mutation picked at random
+func fuzzQuery(rnd *mathrand.Rand, packed []byte, stream bool) fuzzedPacket {
+	mutations := len(fuzzMutations)
+	if !stream {
+		mutations--
+	}
+	packet := fuzzedPacket{mutation: fuzzMutations[rnd.Intn(mutations)]}
+	header := append([]byte(nil), packed[:12]...)
+	switch packet.mutation {
+	case "truncated-header":
+		packet.data = header[:1+rnd.Intn(11)]
+	case "bogus-counts":
+		packet.data = append([]byte(nil), packed...)
+		for offset := 4; offset < 12; offset += 2 {
+			binary.BigEndian.PutUint16(packet.data[offset:], uint16(rnd.Intn(1<<16)))
+		}
+	case "bogus-label-length":
+		rest := packed[13:]
+		if len(rest) > 62 {
+			rest = rest[:62]
+		}
+		packet.data = append(append(header, 63), rest[:rnd.Intn(len(rest))]...)
+	case "pointer-loop":
+		setCounts(header, 1)
+		packet.data = append(header, 0xc0, 12, 0, 1, 0, 1)
+	case "oversized-name":
+		setCounts(header, 1)
+		label := append([]byte{63}, bytes.Repeat([]byte("a"), 63)...)
+		packet.data = append(append(header, bytes.Repeat(label, 5)...), 0, 0, 1, 0, 1)
+	case "random-bytes":
+		packet.data = append([]byte(nil), packed...)
+		for n := 1 + rnd.Intn(4); n > 0; n-- {
+			packet.data[2+rnd.Intn(len(packet.data)-2)] = byte(rnd.Intn(256))
+		}
+	case "bogus-tcp-length":
+		packet.data = append([]byte(nil), packed...)
+		packet.length = len(packed) + 1 + rnd.Intn(100)
+	}
+	if packet.length == 0 {
+		packet.length = len(packet.data)
+	}
+	return packet
+}
+
+// setCounts sets the header to a single question, without records
+func setCounts(header []byte, questions uint16) {
+	binary.BigEndian.PutUint16(header[4:], questions)
+	for offset := 6; offset < 12; offset += 2 {
+		binary.BigEndian.PutUint16(header[offset:], 0)
+	}
+}
+
+// sendFuzzed sends a malformed query on a new connection, and returns how the resolver reacted:
+// the response code of its answer, or one of the fuzz outcomes
+func (r *Runner) sendFuzzed(threadID int, resolver string, packet fuzzedPacket) string {
+	network := r.transportNetwork()
+	conn, err := r.dialResolver(network, resolver, r.source(threadID, resolver))
+	if err != nil {
+		return fuzzOutcome(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(r.cfg.Timeout))
+
+	answer := make([]byte, 512)
+	var n int
+	if strings.HasPrefix(network, "udp") {
+		if _, err := conn.Write(packet.data); err != nil {
+			return fuzzOutcome(err)
+		}
+		if n, err = conn.Read(answer); err != nil {
+			return fuzzOutcome(err)
+		}
+	} else {
+		prefixed := make([]byte, 2, 2+len(packet.data))
+		binary.BigEndian.PutUint16(prefixed, uint16(packet.length))
+		if _, err := conn.Write(append(prefixed, packet.data...)); err != nil {
+			return fuzzOutcome(err)
+		}
+		if _, err := io.ReadFull(conn, answer[:2]); err != nil {
+			return fuzzOutcome(err)
+		}
+		length := int(binary.BigEndian.Uint16(answer))
+		if length > len(answer) {
+			length = len(answer)
+		}
+		if n, err = io.ReadFull(conn, answer[:length]); err != nil && n < 12 {
+			return fuzzOutcome(err)
+		}
+	}
+	if n < 12 {
+		return fuzzUnparsable
+	}
+	return rcodeName(int(answer[3] & 0xf))
+}
+
+// fuzzOutcome names the error that prevented getting an answer to a malformed query
+func fuzzOutcome(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return fuzzDropped
+	case errors.Is(err, syscall.ECONNRESET):
+		return fuzzReset
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return fuzzRefused
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return fuzzClosed
+	}
+	return outcomeNetworkError
+}
+
+// addFuzz merges the outcomes of fuzzed queries, by mutation
+func addFuzz(total map[string]map[string]int, added map[string]map[string]int) {
+	for mutation, outcomes := range added {
+		if total[mutation] == nil {
+			total[mutation] = make(map[string]int)
+		}
+		for outcome, n := range outcomes {
+			total[mutation][outcome] += n
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package stress
+
+import (
+	"io"
+	mathrand "math/rand"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestFuzzQuery(t *testing.T) {
+	packed, err := new(dns.Msg).SetQuestion("www.example.com.", dns.TypeA).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := mathrand.New(mathrand.NewSource(1))
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		packet := fuzzQuery(rnd, packed, false)
+		seen[packet.mutation] = true
+		switch packet.mutation {
+		case "bogus-tcp-length":
+			t.Fatalf("Unexpected TCP mutation over UDP")
+		case "random-bytes", "bogus-counts":
+			// May still be a valid query
+		default:
+			if err := new(dns.Msg).Unpack(packet.data); err == nil {
+				t.Errorf("Expected a malformed packet for %s, got %x", packet.mutation, packet.data)
+			}
+		}
+		if packet.length != len(packet.data) {
+			t.Errorf("Unexpected length %d for %d bytes (%s)", packet.length, len(packet.data), packet.mutation)
+		}
+	}
+	if len(seen) != len(fuzzMutations)-1 {
+		t.Errorf("Expected all the UDP mutations, got %v", seen)
+	}
+
+	for i := 0; i < 100; i++ {
+		if packet := fuzzQuery(rnd, packed, true); packet.mutation == "bogus-tcp-length" {
+			if packet.length <= len(packet.data) || len(packet.data) != len(packed) {
+				t.Errorf("Unexpected length %d for %d bytes", packet.length, len(packet.data))
+			}
+			return
+		}
+	}
+	t.Errorf("Expected the TCP mutation over TCP")
+}
+
+func TestFuzzOutcome(t *testing.T) {
+	for err, expected := range map[error]string{
+		os.ErrDeadlineExceeded: fuzzDropped,
+		syscall.ECONNRESET:     fuzzReset,
+		syscall.ECONNREFUSED:   fuzzRefused,
+		io.EOF:                 fuzzClosed,
+		io.ErrClosedPipe:       outcomeNetworkError,
+	} {
+		if outcome := fuzzOutcome(err); outcome != expected {
+			t.Errorf("Expected %q for %v, got %q", expected, err, outcome)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package stress
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// HeaderFlags are the bits of the header set on the queries, on top of RD
+type HeaderFlags struct {
+	Authoritative     bool // AA
+	AuthenticatedData bool // AD
+	CheckingDisabled  bool // CD
+	Zero              bool // Z, reserved
+}
+
+// ParseOpcode parses the opcode of the queries, given by name (e.g. NOTIFY) or by value from 0 to
+// 15. Updates are sent with Update instead.
+func ParseOpcode(text string) (int, error) {
+	opcode, found := dns.StringToOpcode[strings.ToUpper(text)]
+	if !found {
+		value, err := strconv.ParseUint(text, 10, 4)
+		if err != nil {
+			return 0, fmt.Errorf("unknown opcode %q", text)
+		}
+		opcode = int(value)
+	}
+	if opcode == dns.OpcodeUpdate {
+		return 0, fmt.Errorf("updates are sent with their own option")
+	}
+	return opcode, nil
+}
+
+// ParseHeaderFlags parses a comma-separated list of header bits: aa, ad, cd and z
+func ParseHeaderFlags(text string) (HeaderFlags, error) {
+	var flags HeaderFlags
+	for _, item := range strings.Split(text, ",") {
+		switch strings.ToLower(strings.TrimSpace(item)) {
+		case "aa":
+			flags.Authoritative = true
+		case "ad":
+			flags.AuthenticatedData = true
+		case "cd":
+			flags.CheckingDisabled = true
+		case "z":
+			flags.Zero = true
+		case "":
+		default:
+			return HeaderFlags{}, fmt.Errorf("unknown header flag %q (expected aa, ad, cd or z)", item)
+		}
+	}
+	return flags, nil
+}
+
+// String lists the bits set, e.g. "ad,cd", or "none"
+func (f HeaderFlags) String() string {
+	var names []string
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{{f.Authoritative, "aa"}, {f.AuthenticatedData, "ad"}, {f.CheckingDisabled, "cd"}, {f.Zero, "z"}} {
+		if flag.set {
+			names = append(names, flag.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// checkHeader makes sure that the opcode can be set on the queries of the run
+func checkHeader(cfg *Config, transfers bool) error {
+	switch {
+	case cfg.Opcode < 0 || cfg.Opcode > 15:
+		return fmt.Errorf("the opcode has to be between 0 and 15")
+	case cfg.Opcode == dns.OpcodeUpdate:
+		return fmt.Errorf("updates are sent with Update instead of their opcode")
+	case cfg.Opcode != dns.OpcodeQuery && (cfg.Update || transfers):
+		return fmt.Errorf("the opcode of updates and zone transfers cannot be changed")
+	}
+	return nil
+}
+
+// setupHeader sets the opcode and the bits of the header of a query
+func (c *Config) setupHeader(message *dns.Msg) {
+	message.Opcode = c.Opcode
+	if c.Iterative {
+		message.RecursionDesired = false
+	}
+	message.Authoritative = c.HeaderFlags.Authoritative
+	message.AuthenticatedData = c.HeaderFlags.AuthenticatedData
+	message.CheckingDisabled = c.HeaderFlags.CheckingDisabled
+	message.Zero = c.HeaderFlags.Zero
+}
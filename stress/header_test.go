@@ -0,0 +1,52 @@
+package stress
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseOpcode(t *testing.T) {
+	for text, expected := range map[string]int{"QUERY": dns.OpcodeQuery, "notify": dns.OpcodeNotify, "STATUS": dns.OpcodeStatus, "15": 15} {
+		if opcode, err := ParseOpcode(text); err != nil || opcode != expected {
+			t.Errorf("Expected %d for %q, got %d (%v)", expected, text, opcode, err)
+		}
+	}
+	for _, text := range []string{"UPDATE", "5", "16", "PING"} {
+		if _, err := ParseOpcode(text); err == nil {
+			t.Errorf("Expected an error for %q", text)
+		}
+	}
+}
+
+func TestParseHeaderFlags(t *testing.T) {
+	flags, err := ParseHeaderFlags("AA, cd,z")
+	if err != nil || flags != (HeaderFlags{Authoritative: true, CheckingDisabled: true, Zero: true}) {
+		t.Errorf("Unexpected flags %+v (%v)", flags, err)
+	}
+	if flags.String() != "aa,cd,z" {
+		t.Errorf("Unexpected description %q", flags)
+	}
+	if _, err := ParseHeaderFlags("aa,tc"); err == nil {
+		t.Errorf("Expected an error for the TC bit")
+	}
+}
+
+func TestSetupHeader(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Opcode = dns.OpcodeNotify
+	cfg.HeaderFlags = HeaderFlags{AuthenticatedData: true, Zero: true}
+	message := new(dns.Msg).SetQuestion("example.com.", dns.TypeSOA)
+	cfg.setupHeader(message)
+	packed, err := message.Pack()
+	if err != nil {
+		t.Fatalf("The query should pack: %s", err)
+	}
+	unpacked := new(dns.Msg)
+	if err := unpacked.Unpack(packed); err != nil {
+		t.Fatalf("The query should unpack: %s", err)
+	}
+	if unpacked.Opcode != dns.OpcodeNotify || !unpacked.AuthenticatedData || !unpacked.Zero || unpacked.CheckingDisabled || !unpacked.RecursionDesired {
+		t.Errorf("Unexpected header %+v", unpacked.MsgHdr)
+	}
+}
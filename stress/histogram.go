@@ -0,0 +1,78 @@
+package stress
+
+import (
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// maxTrackedLatency is the highest latency recorded in the histograms, longer ones are clamped
+const maxTrackedLatency = time.Minute
+
+func newLatencyHistogram() *hdrhistogram.Histogram {
+	// Latencies are recorded in microseconds, with 3 significant figures
+	return hdrhistogram.New(1, int64(maxTrackedLatency/time.Microsecond), 3)
+}
+
+// recordLatency adds a latency to the histogram
+func recordLatency(histogram *hdrhistogram.Histogram, latency time.Duration) {
+	if latency > maxTrackedLatency {
+		latency = maxTrackedLatency
+	}
+	histogram.RecordValue(int64(latency / time.Microsecond))
+}
+
+// LatencyStdDev returns the standard deviation of the latencies of the answers, in milliseconds
+func (s *Stats) LatencyStdDev() float64 {
+	return s.Latency.StdDev() / 1000
+}
+
+// LatencyIQR returns the interquartile range of the latencies of the answers (from the 25th to
+// the 75th percentile), in milliseconds
+func (s *Stats) LatencyIQR() float64 {
+	return float64(s.Latency.ValueAtQuantile(75)-s.Latency.ValueAtQuantile(25)) / 1000
+}
+
+// LatencyBucket counts the answers with a latency above the bound of the previous bucket, and up
+// to its own
+type LatencyBucket struct {
+	Upper time.Duration
+	Count int
+}
+
+// LatencyBuckets counts the answers in log-spaced buckets of latency, whose bounds follow the
+// 1-2-5 series (e.g. 1ms, 2ms, 5ms, 10ms) from the lowest latency recorded to the highest one
+func (s *Stats) LatencyBuckets() []LatencyBucket {
+	if s.Latency.TotalCount() == 0 {
+		return nil
+	}
+	var buckets []LatencyBucket
+	lowest, highest := s.Latency.Min(), s.Latency.Max()
+	last := int64(-1) // Bound of the last bucket, in microseconds
+	for bound := int64(1); last < highest; bound = nextLatencyBound(bound) {
+		if bound >= lowest {
+			buckets = append(buckets, LatencyBucket{Upper: time.Duration(bound) * time.Microsecond})
+			last = bound
+		}
+	}
+	for _, bar := range s.Latency.Distribution() {
+		index := 0
+		for index < len(buckets)-1 && time.Duration(bar.From)*time.Microsecond > buckets[index].Upper {
+			index++
+		}
+		buckets[index].Count += int(bar.Count)
+	}
+	return buckets
+}
+
+// nextLatencyBound returns the bound following one of the 1-2-5 series, in microseconds
+func nextLatencyBound(bound int64) int64 {
+	leading := bound
+	for leading >= 10 {
+		leading /= 10
+	}
+	if leading == 2 {
+		return bound * 5 / 2
+	}
+	return bound * 2
+}
@@ -0,0 +1,41 @@
+package stress
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLatencyBuckets(t *testing.T) {
+	stats := newStats(false)
+	if buckets := stats.LatencyBuckets(); buckets != nil {
+		t.Errorf("Expected no buckets without answers, got %v", buckets)
+	}
+	stats.add(statsMessage{latencies: []time.Duration{
+		300 * time.Microsecond, 400 * time.Microsecond, 900 * time.Microsecond, 1500 * time.Microsecond, 30 * time.Millisecond,
+	}})
+	bounds := []time.Duration{500 * time.Microsecond, time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond}
+	counts := []int{2, 1, 1, 0, 0, 0, 1}
+	expected := make([]LatencyBucket, len(bounds))
+	for index := range bounds {
+		expected[index] = LatencyBucket{Upper: bounds[index], Count: counts[index]}
+	}
+	if buckets := stats.LatencyBuckets(); !reflect.DeepEqual(buckets, expected) {
+		t.Errorf("Got %v, expected %v", buckets, expected)
+	}
+}
+
+func TestLatencySpread(t *testing.T) {
+	stats := newStats(false)
+	var latencies []time.Duration
+	for _, ms := range []int{1, 2, 3, 4, 5, 6, 7, 8} {
+		latencies = append(latencies, time.Duration(ms)*time.Millisecond)
+	}
+	stats.add(statsMessage{latencies: latencies})
+	if iqr := stats.LatencyIQR(); iqr < 3.99 || iqr > 4.01 {
+		t.Errorf("Unexpected interquartile range %vms", iqr)
+	}
+	if stdDev := stats.LatencyStdDev(); stdDev < 2.28 || stdDev > 2.30 {
+		t.Errorf("Unexpected standard deviation %vms", stdDev)
+	}
+}
@@ -0,0 +1,127 @@
+package stress
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// latencyBuckets are the upper bounds (in seconds) of the latency histogram buckets
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// metricsRegistry holds the counters exposed to Prometheus. It is only created when
+// the metrics are enabled.
+type metricsRegistry struct {
+	mu           sync.Mutex
+	sent         uint64
+	answered     uint64
+	errors       uint64
+	rcodes       map[int]uint64
+	buckets      []uint64
+	latencySum   float64
+	latencyCount uint64
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		rcodes:  make(map[int]uint64),
+		buckets: make([]uint64, len(latencyBuckets)),
+	}
+}
+
+// observeSent counts a query for which no answer is waited for
+func (m *metricsRegistry) observeSent() {
+	m.mu.Lock()
+	m.sent++
+	m.mu.Unlock()
+}
+
+// observe records the outcome of a single query
+func (m *metricsRegistry) observe(response *dns.Msg, err error, spent time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent++
+	if err != nil {
+		m.errors++
+		return
+	}
+	if response != nil {
+		m.answered++
+		m.rcodes[response.Rcode]++
+	}
+
+	seconds := spent.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			m.buckets[i]++
+		}
+	}
+	m.latencySum += seconds
+	m.latencyCount++
+}
+
+// writeTo writes all the metrics using the Prometheus text exposition format
+func (m *metricsRegistry) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP dnsstresss_queries_sent_total Number of DNS queries sent.")
+	fmt.Fprintln(w, "# TYPE dnsstresss_queries_sent_total counter")
+	fmt.Fprintf(w, "dnsstresss_queries_sent_total %d\n", m.sent)
+
+	fmt.Fprintln(w, "# HELP dnsstresss_queries_answered_total Number of DNS queries that got an answer.")
+	fmt.Fprintln(w, "# TYPE dnsstresss_queries_answered_total counter")
+	fmt.Fprintf(w, "dnsstresss_queries_answered_total %d\n", m.answered)
+
+	fmt.Fprintln(w, "# HELP dnsstresss_errors_total Number of DNS queries that did not get an answer.")
+	fmt.Fprintln(w, "# TYPE dnsstresss_errors_total counter")
+	fmt.Fprintf(w, "dnsstresss_errors_total %d\n", m.errors)
+
+	fmt.Fprintln(w, "# HELP dnsstresss_responses_total Number of DNS responses received, by response code.")
+	fmt.Fprintln(w, "# TYPE dnsstresss_responses_total counter")
+	rcodes := make([]int, 0, len(m.rcodes))
+	for rcode := range m.rcodes {
+		rcodes = append(rcodes, rcode)
+	}
+	sort.Ints(rcodes)
+	for _, rcode := range rcodes {
+		fmt.Fprintf(w, "dnsstresss_responses_total{rcode=%q} %d\n", rcodeName(rcode), m.rcodes[rcode])
+	}
+
+	fmt.Fprintln(w, "# HELP dnsstresss_latency_seconds Time taken to receive an answer.")
+	fmt.Fprintln(w, "# TYPE dnsstresss_latency_seconds histogram")
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(w, "dnsstresss_latency_seconds_bucket{le=\"%g\"} %d\n", bound, m.buckets[i])
+	}
+	fmt.Fprintf(w, "dnsstresss_latency_seconds_bucket{le=\"+Inf\"} %d\n", m.latencyCount)
+	fmt.Fprintf(w, "dnsstresss_latency_seconds_sum %g\n", m.latencySum)
+	fmt.Fprintf(w, "dnsstresss_latency_seconds_count %d\n", m.latencyCount)
+}
+
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.writeTo(w)
+}
+
+// rcodeName returns the textual representation of a response code
+func rcodeName(rcode int) string {
+	if name, ok := dns.RcodeToString[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// Metrics returns the handler serving the metrics in the Prometheus text format, which are only
+// collected when Config.Metrics is set
+func (r *Runner) Metrics() http.Handler {
+	if r.metrics == nil {
+		return http.NotFoundHandler()
+	}
+	return r.metrics
+}
@@ -0,0 +1,45 @@
+package stress
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestMetricsRegistry(t *testing.T) {
+	m := newMetricsRegistry()
+
+	noError := new(dns.Msg)
+	servFail := new(dns.Msg)
+	servFail.Rcode = dns.RcodeServerFailure
+
+	m.observe(noError, nil, 2*time.Millisecond)
+	m.observe(noError, nil, 200*time.Millisecond)
+	m.observe(servFail, nil, 20*time.Millisecond)
+	m.observe(nil, errors.New("i/o timeout"), time.Second)
+	m.observeSent()
+
+	var buf bytes.Buffer
+	m.writeTo(&buf)
+	output := buf.String()
+
+	for _, expected := range []string{
+		"dnsstresss_queries_sent_total 5\n",
+		"dnsstresss_queries_answered_total 3\n",
+		"dnsstresss_errors_total 1\n",
+		"dnsstresss_responses_total{rcode=\"NOERROR\"} 2\n",
+		"dnsstresss_responses_total{rcode=\"SERVFAIL\"} 1\n",
+		"dnsstresss_latency_seconds_bucket{le=\"0.0025\"} 1\n",
+		"dnsstresss_latency_seconds_bucket{le=\"0.025\"} 2\n",
+		"dnsstresss_latency_seconds_bucket{le=\"+Inf\"} 3\n",
+		"dnsstresss_latency_seconds_count 3\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Missing %q in metrics output:\n%s", expected, output)
+		}
+	}
+}
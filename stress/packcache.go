@@ -0,0 +1,51 @@
+package stress
+
+import (
+	"encoding/binary"
+
+	"github.com/miekg/dns"
+)
+
+// maxPackedQueries bounds the number of queries whose wire format is kept by each thread, the
+// others are packed for each send
+const maxPackedQueries = 4096
+
+// packCache keeps the wire format of the queries of a thread, by name and type, so that they are
+// only packed once: only their ID is changed for each send. It is only used when the queries
+// differ by nothing else, see Runner.cachesPackedQueries.
+type packCache struct {
+	packed map[queryEntry][]byte
+}
+
+func newPackCache() *packCache {
+	return &packCache{packed: make(map[queryEntry][]byte)}
+}
+
+// appendPacked appends the wire format of a query to a buffer, without allocating once the query
+// was packed before
+func (c *packCache) appendPacked(buf []byte, message *dns.Msg) ([]byte, error) {
+	key := queryEntry{name: message.Question[0].Name, qtype: message.Question[0].Qtype}
+	packed, ok := c.packed[key]
+	if !ok {
+		var err error
+		if packed, err = message.Pack(); err != nil {
+			return buf, err
+		}
+		if len(c.packed) < maxPackedQueries {
+			c.packed[key] = packed
+		}
+	}
+	start := len(buf)
+	buf = append(buf, packed...)
+	binary.BigEndian.PutUint16(buf[start:], message.Id)
+	return buf, nil
+}
+
+// cachesPackedQueries tells whether the wire format of a query only depends on its name and
+// type, and its ID: a random prefix already makes the names unique, a wordlist makes too many of
+// them, other options change the queries for each send
+func (r *Runner) cachesPackedQueries() bool {
+	cfg := &r.cfg
+	return cfg.QueryPattern == "" && !cfg.RandomPrefix && r.words == nil && !cfg.RandomCase && !cfg.Update &&
+		!cfg.Cookies && len(cfg.ClientSubnets) == 0 && cfg.TSIG == nil
+}
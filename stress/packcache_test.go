@@ -0,0 +1,38 @@
+package stress
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestPackCache(t *testing.T) {
+	cfg := NewConfig()
+	cfg.EDNSPadding = 128
+	message := new(dns.Msg).SetQuestion("example.com.", dns.TypeAAAA)
+	cfg.setupEDNS(message)
+	cfg.padQuery(message)
+
+	cache := newPackCache()
+	for _, id := range []uint16{1, 0xbeef} {
+		message.Id = id
+		packed, err := cache.appendPacked([]byte{0xff}, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, _ := message.Pack()
+		if !bytes.Equal(packed[1:], expected) || packed[0] != 0xff {
+			t.Errorf("Got %x for the query %d, expected %x after the buffer", packed, id, expected)
+		}
+	}
+
+	buf := make([]byte, 0, 512)
+	allocations := testing.AllocsPerRun(100, func() {
+		message.Id++
+		buf, _ = cache.appendPacked(buf[:0], message)
+	})
+	if allocations != 0 {
+		t.Errorf("Got %.0f allocations to send a cached query, expected none", allocations)
+	}
+}
@@ -0,0 +1,403 @@
+package stress
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// capture holds the queries read from a packet capture, in the order they were sent
+type capture struct {
+	queries *queryList
+	offsets []time.Duration // Time of each query since the first one
+}
+
+// Link types of the captures, as registered in the pcap format
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkSLL      = 113
+	linkIPv4     = 228
+	linkIPv6     = 229
+	linkSLL2     = 276
+)
+
+// Blocks of the pcapng format read from the captures
+const (
+	pcapngSection   = 0x0a0d0d0a
+	pcapngInterface = 1
+	pcapngEnhanced  = 6
+	pcapngByteOrder = 0x1a2b3c4d
+)
+
+// maxCapturedPacket bounds the size of the packets and blocks read from a capture
+const maxCapturedPacket = 1 << 20
+
+// loadCapture reads the queries of a pcap or pcapng file
+func loadCapture(path string) (*capture, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseCapture(bufio.NewReader(file))
+}
+
+// parseCapture extracts the DNS queries sent over UDP from a packet capture, in the pcap or pcapng
+// format. The packets that are not queries, or are fragmented, are skipped.
+func parseCapture(input io.Reader) (*capture, error) {
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(input, magic); err != nil {
+		return nil, fmt.Errorf("not a packet capture")
+	}
+	c := &capture{queries: &queryList{}}
+	var first time.Time
+	add := func(timestamp time.Time, link uint32, packet []byte) {
+		entry, ok := capturedQuery(link, packet)
+		if !ok {
+			return
+		}
+		if len(c.offsets) == 0 {
+			first = timestamp
+		}
+		offset := timestamp.Sub(first)
+		if previous := len(c.offsets) - 1; previous >= 0 && offset < c.offsets[previous] {
+			// Packets captured out of order are sent along with the previous ones
+			offset = c.offsets[previous]
+		}
+		c.queries.entries = append(c.queries.entries, entry)
+		c.offsets = append(c.offsets, offset)
+	}
+
+	var err error
+	if binary.LittleEndian.Uint32(magic) == pcapngSection {
+		err = readPcapng(io.MultiReader(bytes.NewReader(magic), input), add)
+	} else {
+		err = readPcap(magic, input, add)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(c.queries.entries) == 0 {
+		return nil, fmt.Errorf("no DNS queries over UDP")
+	}
+	weights, _ := defaultWeights(nil, len(c.queries.entries), "queries")
+	c.queries.choice = newWeightedChoice(weights)
+	return c, nil
+}
+
+// readPcap reads the packets of the classic pcap format, whose magic number was already read
+func readPcap(magic []byte, input io.Reader, add func(time.Time, uint32, []byte)) error {
+	var order binary.ByteOrder
+	var nanoseconds bool
+	for _, candidate := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch candidate.Uint32(magic) {
+		case 0xa1b2c3d4:
+			order = candidate
+		case 0xa1b23c4d:
+			order, nanoseconds = candidate, true
+		}
+	}
+	if order == nil {
+		return fmt.Errorf("not a pcap or pcapng capture")
+	}
+	header := make([]byte, 20)
+	if _, err := io.ReadFull(input, header); err != nil {
+		return fmt.Errorf("truncated pcap header")
+	}
+	link := order.Uint32(header[16:]) & 0xffff
+
+	record := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(input, record); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("truncated pcap record")
+		}
+		length := order.Uint32(record[8:])
+		if length > maxCapturedPacket {
+			return fmt.Errorf("invalid pcap record of %d bytes", length)
+		}
+		packet := make([]byte, length)
+		if _, err := io.ReadFull(input, packet); err != nil {
+			return fmt.Errorf("truncated pcap record")
+		}
+		fraction := int64(order.Uint32(record[4:]))
+		if !nanoseconds {
+			fraction *= 1000
+		}
+		add(time.Unix(int64(order.Uint32(record[0:])), fraction), link, packet)
+	}
+}
+
+// pcapngLink is an interface of a pcapng section, the packets refer to it by index
+type pcapngLink struct {
+	link      uint32
+	perSecond uint64 // Resolution of the timestamps
+}
+
+// readPcapng reads the packets of the enhanced packet blocks of the pcapng format
+func readPcapng(input io.Reader, add func(time.Time, uint32, []byte)) error {
+	var order binary.ByteOrder = binary.LittleEndian
+	var interfaces []pcapngLink
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(input, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("truncated pcapng block")
+		}
+		blockType := order.Uint32(header)
+		if blockType == pcapngSection {
+			// Each section has its own byte order and interfaces
+			magic := make([]byte, 4)
+			if _, err := io.ReadFull(input, magic); err != nil {
+				return fmt.Errorf("truncated pcapng section")
+			}
+			if binary.BigEndian.Uint32(magic) == pcapngByteOrder {
+				order = binary.BigEndian
+			} else if binary.LittleEndian.Uint32(magic) == pcapngByteOrder {
+				order = binary.LittleEndian
+			} else {
+				return fmt.Errorf("invalid pcapng byte order")
+			}
+			interfaces = nil
+			if _, err := readBlockBody(order.Uint32(header[4:]), 12, input); err != nil {
+				return err
+			}
+			continue
+		}
+		body, err := readBlockBody(order.Uint32(header[4:]), 8, input)
+		if err != nil {
+			return err
+		}
+		switch blockType {
+		case pcapngInterface:
+			if len(body) < 8 {
+				return fmt.Errorf("truncated pcapng interface")
+			}
+			interfaces = append(interfaces, pcapngLink{
+				link:      uint32(order.Uint16(body)),
+				perSecond: pcapngResolution(order, body[8:]),
+			})
+		case pcapngEnhanced:
+			if len(body) < 20 {
+				return fmt.Errorf("truncated pcapng packet")
+			}
+			index := order.Uint32(body)
+			length := order.Uint32(body[12:])
+			if int(index) >= len(interfaces) || uint64(length) > uint64(len(body)-20) {
+				return fmt.Errorf("invalid pcapng packet")
+			}
+			ticks := uint64(order.Uint32(body[4:]))<<32 | uint64(order.Uint32(body[8:]))
+			perSecond := interfaces[index].perSecond
+			seconds, fraction := ticks/perSecond, ticks%perSecond
+			timestamp := time.Unix(int64(seconds), int64(float64(fraction)*1e9/float64(perSecond)))
+			add(timestamp, interfaces[index].link, body[20:20+length])
+		}
+	}
+}
+
+// readBlockBody reads the rest of a pcapng block of the given total length, once its first bytes
+// were read, and returns it without its trailing length
+func readBlockBody(length uint32, already uint32, input io.Reader) ([]byte, error) {
+	if length%4 != 0 || length < already+4 || length > maxCapturedPacket {
+		return nil, fmt.Errorf("invalid pcapng block of %d bytes", length)
+	}
+	body := make([]byte, length-already)
+	if _, err := io.ReadFull(input, body); err != nil {
+		return nil, fmt.Errorf("truncated pcapng block")
+	}
+	return body[:len(body)-4], nil
+}
+
+// pcapngResolution returns the number of timestamps per second of an interface, from the
+// if_tsresol option, microseconds by default
+func pcapngResolution(order binary.ByteOrder, options []byte) uint64 {
+	for len(options) >= 4 {
+		code, length := order.Uint16(options), int(order.Uint16(options[2:]))
+		if code == 0 || 4+length > len(options) {
+			break
+		}
+		if code == 9 && length == 1 {
+			exponent := options[4] & 0x7f
+			base := uint64(10)
+			if options[4]&0x80 != 0 {
+				base = 2
+			}
+			perSecond := uint64(1)
+			for i := byte(0); i < exponent && perSecond < 1e18; i++ {
+				perSecond *= base
+			}
+			return perSecond
+		}
+		options = options[4+(length+3)/4*4:]
+	}
+	return 1e6
+}
+
+// capturedQuery returns the query carried by a captured packet, if it is a DNS query over UDP
+func capturedQuery(link uint32, packet []byte) (queryEntry, bool) {
+	ip, ok := linkPayload(link, packet)
+	if !ok {
+		return queryEntry{}, false
+	}
+	datagram, ok := udpPayload(ip)
+	if !ok {
+		return queryEntry{}, false
+	}
+	message := new(dns.Msg)
+	if err := message.Unpack(datagram); err != nil {
+		return queryEntry{}, false
+	}
+	if message.Response || message.Opcode != dns.OpcodeQuery || len(message.Question) != 1 {
+		return queryEntry{}, false
+	}
+	return queryEntry{name: message.Question[0].Name, qtype: message.Question[0].Qtype}, true
+}
+
+// linkPayload returns the IP packet of a frame of the given link type
+func linkPayload(link uint32, frame []byte) ([]byte, bool) {
+	switch link {
+	case linkNull, linkLoop:
+		if len(frame) < 4 {
+			return nil, false
+		}
+		return frame[4:], true
+	case linkRaw, linkIPv4, linkIPv6:
+		return frame, true
+	case linkEthernet:
+		offset := 12
+		for offset+2 <= len(frame) {
+			switch binary.BigEndian.Uint16(frame[offset:]) {
+			case 0x8100, 0x88a8:
+				// VLAN tags
+				offset += 4
+				continue
+			case 0x0800, 0x86dd:
+				return frame[offset+2:], true
+			}
+			return nil, false
+		}
+	case linkSLL:
+		if len(frame) >= 16 {
+			return frame[16:], true
+		}
+	case linkSLL2:
+		if len(frame) >= 20 {
+			return frame[20:], true
+		}
+	}
+	return nil, false
+}
+
+// udpPayload returns the payload of an IPv4 or IPv6 packet carrying an unfragmented UDP datagram
+func udpPayload(packet []byte) ([]byte, bool) {
+	if len(packet) < 1 {
+		return nil, false
+	}
+	var transport []byte
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < 20 {
+			return nil, false
+		}
+		headerLength := int(packet[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(packet[2:]))
+		if headerLength < 20 || total < headerLength || total > len(packet) {
+			return nil, false
+		}
+		if binary.BigEndian.Uint16(packet[6:])&0x3fff != 0 || packet[9] != 17 {
+			// A fragment, or another protocol
+			return nil, false
+		}
+		transport = packet[headerLength:total]
+	case 6:
+		if len(packet) < 40 {
+			return nil, false
+		}
+		end := 40 + int(binary.BigEndian.Uint16(packet[4:]))
+		if end > len(packet) {
+			return nil, false
+		}
+		next, offset := packet[6], 40
+		for next != 17 {
+			switch next {
+			case 0, 43, 60:
+				// Hop-by-hop, routing and destination options headers
+				if offset+2 > end {
+					return nil, false
+				}
+				next, offset = packet[offset], offset+(int(packet[offset+1])+1)*8
+			default:
+				// Fragments and other protocols
+				return nil, false
+			}
+		}
+		if offset > end {
+			return nil, false
+		}
+		transport = packet[offset:end]
+	default:
+		return nil, false
+	}
+	if len(transport) < 8 {
+		return nil, false
+	}
+	length := int(binary.BigEndian.Uint16(transport[4:]))
+	if length < 8 || length > len(transport) {
+		return nil, false
+	}
+	return transport[8:length], true
+}
+
+// replayTiming paces the queries of all the threads to follow the timing of the capture, sped up
+// by a factor. Once the capture is over, it is replayed again with the same timing.
+type replayTiming struct {
+	offsets []time.Duration
+	period  time.Duration // Time between the starts of two replays of the capture
+	speed   float64
+	start   time.Time
+	issued  uint64
+}
+
+// newReplayTiming returns the timing of the capture, starting now
+func newReplayTiming(c *capture, speed float64) *replayTiming {
+	last := c.offsets[len(c.offsets)-1]
+	period := last
+	if len(c.offsets) > 1 {
+		// The last query is followed by the mean gap between the queries
+		period += last / time.Duration(len(c.offsets)-1)
+	}
+	if period <= 0 {
+		period = time.Second
+	}
+	return &replayTiming{offsets: c.offsets, period: period, speed: speed, start: time.Now()}
+}
+
+func (t *replayTiming) wait() bool {
+	index := atomic.AddUint64(&t.issued, 1) - 1
+	count := uint64(len(t.offsets))
+	at := time.Duration(index/count)*t.period + t.offsets[index%count]
+	if delay := time.Until(t.start.Add(time.Duration(float64(at) / t.speed))); delay > 0 {
+		time.Sleep(delay)
+	}
+	return true
+}
+
+// CaptureDuration returns the time between the first and the last queries of the replayed capture
+func (r *Runner) CaptureDuration() time.Duration {
+	if r.capture == nil {
+		return 0
+	}
+	return r.capture.offsets[len(r.capture.offsets)-1]
+}
@@ -0,0 +1,134 @@
+package stress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// udpPacket returns an IPv4 or IPv6 packet carrying a DNS message over UDP
+func udpPacket(t *testing.T, ipv6 bool, message *dns.Msg) []byte {
+	payload, err := message.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	datagram := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(datagram[0:], 40000)
+	binary.BigEndian.PutUint16(datagram[2:], 53)
+	binary.BigEndian.PutUint16(datagram[4:], uint16(8+len(payload)))
+	datagram = append(datagram, payload...)
+	if ipv6 {
+		header := make([]byte, 40)
+		header[0] = 6 << 4
+		binary.BigEndian.PutUint16(header[4:], uint16(len(datagram)))
+		header[6] = 17
+		return append(header, datagram...)
+	}
+	header := make([]byte, 20)
+	header[0] = 4<<4 | 5
+	binary.BigEndian.PutUint16(header[2:], uint16(20+len(datagram)))
+	header[9] = 17
+	return append(header, datagram...)
+}
+
+// ethernetFrame wraps an IPv4 packet in an Ethernet frame
+func ethernetFrame(packet []byte) []byte {
+	frame := make([]byte, 14)
+	binary.BigEndian.PutUint16(frame[12:], 0x0800)
+	return append(frame, packet...)
+}
+
+func TestParsePcap(t *testing.T) {
+	query := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	answer := new(dns.Msg).SetReply(query)
+	fragment := udpPacket(t, false, new(dns.Msg).SetQuestion("fragment.example.", dns.TypeA))
+	fragment[6] = 0x20 // More fragments
+	packets := [][]byte{
+		ethernetFrame(udpPacket(t, false, query)),
+		ethernetFrame(udpPacket(t, false, answer)),
+		ethernetFrame(fragment),
+		ethernetFrame([]byte("not an IP packet")),
+		ethernetFrame(udpPacket(t, false, new(dns.Msg).SetQuestion("example.org.", dns.TypeMX))),
+	}
+
+	var capture bytes.Buffer
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint32(header[20:], linkEthernet)
+	capture.Write(header)
+	for index, packet := range packets {
+		record := make([]byte, 16)
+		binary.LittleEndian.PutUint32(record[0:], 1700000000)
+		binary.LittleEndian.PutUint32(record[4:], uint32(index*250000))
+		binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+		binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+		capture.Write(record)
+		capture.Write(packet)
+	}
+
+	parsed, err := parseCapture(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []queryEntry{{"example.com.", dns.TypeA}, {"example.org.", dns.TypeMX}}
+	if len(parsed.queries.entries) != len(expected) {
+		t.Fatalf("Got the queries %v, expected %v", parsed.queries.entries, expected)
+	}
+	for index, entry := range expected {
+		if parsed.queries.entries[index] != entry {
+			t.Errorf("Got the query %v, expected %v", parsed.queries.entries[index], entry)
+		}
+	}
+	if parsed.offsets[0] != 0 || parsed.offsets[1] != time.Second {
+		t.Errorf("Got the offsets %v, expected the queries 1s apart", parsed.offsets)
+	}
+}
+
+func TestParsePcapng(t *testing.T) {
+	var capture bytes.Buffer
+	block := func(blockType uint32, body []byte) {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(12+len(body)))
+		binary.Write(&capture, binary.BigEndian, blockType)
+		capture.Write(length)
+		capture.Write(body)
+		capture.Write(length)
+	}
+	section := make([]byte, 16)
+	binary.BigEndian.PutUint32(section[0:], pcapngByteOrder)
+	binary.BigEndian.PutUint16(section[4:], 1)
+	block(pcapngSection, section)
+	// A raw IP interface with nanosecond timestamps
+	block(pcapngInterface, []byte{0, linkRaw, 0, 0, 0, 0, 0xff, 0xff, 0, 9, 0, 1, 9, 0, 0, 0, 0, 0, 0, 0})
+	for index, name := range []string{"example.com.", "example.net."} {
+		packet := udpPacket(t, true, new(dns.Msg).SetQuestion(name, dns.TypeAAAA))
+		body := make([]byte, 20, 20+len(packet))
+		ticks := uint64(1700000000*time.Second + time.Duration(index)*1500*time.Microsecond)
+		binary.BigEndian.PutUint32(body[4:], uint32(ticks>>32))
+		binary.BigEndian.PutUint32(body[8:], uint32(ticks))
+		binary.BigEndian.PutUint32(body[12:], uint32(len(packet)))
+		binary.BigEndian.PutUint32(body[16:], uint32(len(packet)))
+		block(pcapngEnhanced, append(body, packet...))
+	}
+
+	parsed, err := parseCapture(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.queries.entries) != 2 || parsed.queries.entries[1] != (queryEntry{"example.net.", dns.TypeAAAA}) {
+		t.Fatalf("Unexpected queries %v", parsed.queries.entries)
+	}
+	if parsed.offsets[1] != 1500*time.Microsecond {
+		t.Errorf("Got the offsets %v, expected the queries 1.5ms apart", parsed.offsets)
+	}
+
+	if _, err := parseCapture(bytes.NewReader([]byte("name A\n"))); err == nil {
+		t.Errorf("A query file should not be parsed as a capture")
+	}
+}
@@ -0,0 +1,221 @@
+package stress
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// poolKeepAlive is the period of the TCP keepalives of the pooled connections, which keep them
+// open while they are idle (e.g. while the run is paused)
+const poolKeepAlive = 15 * time.Second
+
+// Bounds of the wait before dialing a pooled connection again after a failure, doubled on each
+// consecutive one
+const (
+	poolMinBackoff = 10 * time.Millisecond
+	poolMaxBackoff = 2 * time.Second
+)
+
+// connPool holds the PoolSize connections to a resolver shared by all the threads over TCP or
+// TLS. The queries are pipelined (RFC 7766): each connection carries any number of them at once,
+// their answers being matched by ID in the order they arrive.
+type connPool struct {
+	conns []*pipelinedConn
+	next  uint64
+}
+
+func newConnPool(r *Runner, resolver string, size int) *connPool {
+	pool := &connPool{conns: make([]*pipelinedConn, size)}
+	for index := range pool.conns {
+		// Each connection is dialed from the source address of a thread
+		pool.conns[index] = &pipelinedConn{runner: r, resolver: resolver, source: r.source(index, resolver), pending: make(map[uint16]*pipelinedQuery)}
+	}
+	return pool
+}
+
+// exchange sends a query on the next connection of the pool, and waits for its answer
+func (p *connPool) exchange(message *dns.Msg) (*dns.Msg, error) {
+	conn := p.conns[(atomic.AddUint64(&p.next, 1)-1)%uint64(len(p.conns))]
+	return conn.exchange(message)
+}
+
+func (p *connPool) close() {
+	for _, conn := range p.conns {
+		conn.mu.Lock()
+		conn.closeLocked(net.ErrClosed)
+		conn.mu.Unlock()
+	}
+}
+
+// pipelinedConn is a connection of a pool, read by its own goroutine. It is dialed again after
+// an error, waiting longer after each consecutive failure to connect.
+type pipelinedConn struct {
+	runner   *Runner
+	resolver string
+	source   *net.UDPAddr
+	mu       sync.Mutex // Guards the fields below, and the writes on the connection
+	co       *dns.Conn
+	pending  map[uint16]*pipelinedQuery // Queries waiting for their answer, by ID on the connection
+	nextID   uint16
+	failures int       // Consecutive failures to connect
+	retryAt  time.Time // The connection is not dialed again before
+	dialErr  error
+}
+
+// pipelinedQuery is a query waiting for its answer on a pipelined connection
+type pipelinedQuery struct {
+	mac    string
+	answer chan pipelinedAnswer
+}
+
+type pipelinedAnswer struct {
+	response *dns.Msg
+	err      error
+}
+
+func (c *pipelinedConn) exchange(message *dns.Msg) (*dns.Msg, error) {
+	response, reused, err := c.send(message)
+	if err != nil && reused && isClosedConn(err) {
+		// The resolver closed the connection since the previous queries (e.g. after too many
+		// of them, or while it was idle), send the query on a new one
+		response, _, err = c.send(message)
+	}
+	return response, err
+}
+
+// send writes a query on the connection, dialing it if needed. The query is sent with an ID not
+// in flight on the connection, the answer gets the ID of the message back.
+func (c *pipelinedConn) send(message *dns.Msg) (*dns.Msg, bool, error) {
+	timeout := c.runner.cfg.Timeout
+	c.mu.Lock()
+	reused := c.co != nil
+	if err := c.connectLocked(); err != nil {
+		c.mu.Unlock()
+		return nil, false, err
+	}
+	if len(c.pending) > int(^uint16(0)) {
+		c.mu.Unlock()
+		return nil, reused, fmt.Errorf("no query ID left on the connection")
+	}
+	for c.pending[c.nextID] != nil {
+		c.nextID++
+	}
+	query := message.Copy()
+	query.Id = c.nextID
+	c.nextID++
+	packed, mac, err := c.runner.cfg.TSIG.pack(query)
+	if err != nil {
+		c.mu.Unlock()
+		return nil, reused, err
+	}
+	waiting := &pipelinedQuery{mac: mac, answer: make(chan pipelinedAnswer, 1)}
+	c.pending[query.Id] = waiting
+	c.co.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := c.co.Write(packed); err != nil {
+		c.closeLocked(err)
+		c.mu.Unlock()
+		return nil, reused, err
+	}
+	c.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case answer := <-waiting.answer:
+		if answer.response != nil {
+			answer.response.Id = message.Id
+		}
+		return answer.response, reused, answer.err
+	case <-timer.C:
+		c.mu.Lock()
+		if c.pending[query.Id] == waiting {
+			delete(c.pending, query.Id)
+		}
+		c.mu.Unlock()
+		return nil, reused, fmt.Errorf("no answer on the pooled connection: %w", os.ErrDeadlineExceeded)
+	}
+}
+
+// connectLocked dials the connection if it is not open, unless the last attempt failed too
+// recently
+func (c *pipelinedConn) connectLocked() error {
+	if c.co != nil {
+		return nil
+	}
+	if time.Now().Before(c.retryAt) {
+		return c.dialErr
+	}
+	conn, err := c.runner.dialResolver(c.runner.transportNetwork(), c.resolver, c.source)
+	if err != nil {
+		backoff := poolMinBackoff << c.failures
+		if backoff > poolMaxBackoff || backoff <= 0 {
+			backoff = poolMaxBackoff
+		} else {
+			c.failures++
+		}
+		c.retryAt = time.Now().Add(backoff)
+		c.dialErr = err
+		return err
+	}
+	c.failures = 0
+	tcpConn, _ := conn.(*net.TCPConn)
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tcpConn, _ = tlsConn.NetConn().(*net.TCPConn)
+	}
+	if tcpConn != nil {
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(poolKeepAlive)
+	}
+	c.co = &dns.Conn{Conn: conn}
+	go c.read(c.co)
+	return nil
+}
+
+// read hands the answers arriving on the connection to the queries waiting for them, until it
+// fails or is closed
+func (c *pipelinedConn) read(co *dns.Conn) {
+	for {
+		answer, err := co.ReadMsgHeader(nil)
+		if err != nil {
+			c.mu.Lock()
+			if c.co == co {
+				c.closeLocked(err)
+			}
+			c.mu.Unlock()
+			return
+		}
+		if len(answer) < 2 {
+			continue
+		}
+		id := uint16(answer[0])<<8 | uint16(answer[1])
+		c.mu.Lock()
+		waiting := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if waiting != nil {
+			response, err := c.runner.cfg.TSIG.unpack(answer, waiting.mac)
+			waiting.answer <- pipelinedAnswer{response: response, err: err}
+		}
+	}
+}
+
+// closeLocked closes the connection, failing the queries waiting for an answer on it with the
+// error
+func (c *pipelinedConn) closeLocked(err error) {
+	if c.co == nil {
+		return
+	}
+	c.co.Close()
+	c.co = nil
+	for id, waiting := range c.pending {
+		waiting.answer <- pipelinedAnswer{err: err}
+		delete(c.pending, id)
+	}
+}
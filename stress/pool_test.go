@@ -0,0 +1,76 @@
+package stress
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestPipelinedConnAnswersOutOfOrder(t *testing.T) {
+	r := &Runner{cfg: *NewConfig()}
+	r.cfg.TCP = true
+	r.cfg.Timeout = time.Second
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := &pipelinedConn{runner: r, pending: make(map[uint16]*pipelinedQuery)}
+	conn.co = &dns.Conn{Conn: client}
+	go conn.read(conn.co)
+
+	// The resolver answers both queries once it got them, the last one first
+	go func() {
+		co := &dns.Conn{Conn: server}
+		var queries []*dns.Msg
+		for len(queries) < 2 {
+			query, err := co.ReadMsg()
+			if err != nil {
+				return
+			}
+			queries = append(queries, query)
+		}
+		for index := len(queries) - 1; index >= 0; index-- {
+			answer := new(dns.Msg).SetReply(queries[index])
+			answer.Answer = append(answer.Answer, &dns.TXT{Hdr: dns.RR_Header{Name: queries[index].Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET}, Txt: []string{"answer"}})
+			co.WriteMsg(answer)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"first.example.", "second.example."} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			query := new(dns.Msg).SetQuestion(name, dns.TypeTXT)
+			query.Id = 1234 // Both queries have the same ID, the connection gives them their own
+			response, err := conn.exchange(query)
+			if err != nil {
+				t.Errorf("Unexpected error for %s: %s", name, err)
+				return
+			}
+			if response.Id != 1234 || response.Answer[0].Header().Name != name {
+				t.Errorf("Unexpected answer for %s: %v", name, response)
+			}
+		}(name)
+	}
+	wg.Wait()
+}
+
+func TestPipelinedConnBackoff(t *testing.T) {
+	r := &Runner{cfg: *NewConfig()}
+	r.cfg.TCP = true
+	// Nothing listens on the port, the connections are refused
+	conn := &pipelinedConn{runner: r, resolver: "127.0.0.1:1", pending: make(map[uint16]*pipelinedQuery)}
+	query := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	if _, err := conn.exchange(query); err == nil {
+		t.Fatalf("The connection should be refused")
+	}
+	retryAt := conn.retryAt
+	if conn.failures != 1 || !retryAt.After(time.Now()) {
+		t.Fatalf("The connection should wait before being dialed again (%d failures)", conn.failures)
+	}
+	if _, err := conn.exchange(query); err == nil || conn.failures != 1 || conn.retryAt != retryAt {
+		t.Errorf("The connection should not be dialed again before %s", retryAt)
+	}
+}
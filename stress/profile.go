@@ -0,0 +1,129 @@
+package stress
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RampStep is a stage of a load profile, where the rate goes linearly from From to To queries per
+// second over Duration
+type RampStep struct {
+	From     int
+	To       int
+	Duration time.Duration
+}
+
+// ParseRampProfile parses a comma-separated list of "from:to:duration" steps, the rates being
+// in queries per second with an optional "qps" suffix (e.g. "0:1000qps:60s,1000:5000:120s")
+func ParseRampProfile(input string) ([]RampStep, error) {
+	var steps []RampStep
+	for _, element := range strings.Split(input, ",") {
+		element = strings.TrimSpace(element)
+		if element == "" {
+			continue
+		}
+		fields := strings.Split(element, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("expected \"from:to:duration\" in %q", element)
+		}
+		var rates [2]int
+		for index, field := range fields[:2] {
+			rate, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(field), "qps"))
+			if err != nil || rate < 0 {
+				return nil, fmt.Errorf("invalid rate %q in %q", field, element)
+			}
+			rates[index] = rate
+		}
+		duration, err := time.ParseDuration(fields[2])
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid duration %q in %q", fields[2], element)
+		}
+		steps = append(steps, RampStep{From: rates[0], To: rates[1], Duration: duration})
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty profile")
+	}
+	return steps, nil
+}
+
+// profileDuration returns the total duration of a load profile
+func profileDuration(steps []RampStep) time.Duration {
+	var total time.Duration
+	for _, step := range steps {
+		total += step.Duration
+	}
+	return total
+}
+
+// loadProfile is the target rate over time: the ramp steps, then the final rate for ever
+type loadProfile struct {
+	steps []RampStep
+	final float64
+}
+
+// constantProfile returns the profile of a fixed rate
+func constantProfile(rate int) loadProfile {
+	return loadProfile{final: float64(rate)}
+}
+
+// rampProfile returns the profile going through the steps, then staying at the last rate
+func rampProfile(steps []RampStep) loadProfile {
+	return loadProfile{steps: steps, final: float64(steps[len(steps)-1].To)}
+}
+
+// rateAt returns the target rate after the given time, in queries per second
+func (p loadProfile) rateAt(elapsed time.Duration) float64 {
+	for _, step := range p.steps {
+		if elapsed < step.Duration {
+			progress := elapsed.Seconds() / step.Duration.Seconds()
+			return float64(step.From) + float64(step.To-step.From)*progress
+		}
+		elapsed -= step.Duration
+	}
+	return p.final
+}
+
+// queriesAt returns the number of queries sent at the target rate after the given time, which
+// is the area under the rate curve
+func (p loadProfile) queriesAt(elapsed time.Duration) float64 {
+	total := 0.
+	for _, step := range p.steps {
+		from, to, length := float64(step.From), float64(step.To), step.Duration.Seconds()
+		if elapsed < step.Duration {
+			t := elapsed.Seconds()
+			return total + from*t + (to-from)*t*t/(2*length)
+		}
+		total += (from + to) * length / 2
+		elapsed -= step.Duration
+	}
+	return total + p.final*elapsed.Seconds()
+}
+
+// timeOf returns the time at which the given number of queries have been sent at the target
+// rate, or false if the rate stays at zero before
+func (p loadProfile) timeOf(queries float64) (time.Duration, bool) {
+	var offset time.Duration
+	for _, step := range p.steps {
+		from, to, length := float64(step.From), float64(step.To), step.Duration.Seconds()
+		area := (from + to) * length / 2
+		if queries < area {
+			if queries <= 0 {
+				return offset, true
+			}
+			// Smallest root of from*t + (to-from)*t²/(2*length) = queries, in a form that
+			// also holds for flat steps
+			k := (to - from) / (2 * length)
+			t := 2 * queries / (from + math.Sqrt(from*from+4*k*queries))
+			return offset + time.Duration(t*float64(time.Second)), true
+		}
+		queries -= area
+		offset += step.Duration
+	}
+	if p.final <= 0 {
+		return 0, false
+	}
+	return offset + time.Duration(queries/p.final*float64(time.Second)), true
+}
@@ -0,0 +1,58 @@
+package stress
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRampProfile(t *testing.T) {
+	steps, err := ParseRampProfile("0:1000qps:60s, 1000:5000QPS:2m")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []RampStep{{0, 1000, time.Minute}, {1000, 5000, 2 * time.Minute}}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("Got %v, expected %v", steps, expected)
+	}
+	if total := profileDuration(steps); total != 3*time.Minute {
+		t.Errorf("Got a duration of %s, expected 3m0s", total)
+	}
+
+	for _, input := range []string{"", "0:1000", "0:1000:60s:1", "a:1000:60s", "-1:1000:60s", "0:1000:soon", "0:1000:0s"} {
+		if _, err := ParseRampProfile(input); err == nil {
+			t.Errorf("Parsing %q should fail", input)
+		}
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	profile := rampProfile([]RampStep{{0, 1000, 10 * time.Second}, {1000, 1000, 10 * time.Second}, {1000, 0, 10 * time.Second}})
+	tables := []struct {
+		elapsed time.Duration
+		rate    float64
+		queries float64
+	}{
+		{0, 0, 0},
+		{5 * time.Second, 500, 1250},
+		{10 * time.Second, 1000, 5000},
+		{15 * time.Second, 1000, 10000},
+		{25 * time.Second, 500, 18750},
+	}
+	for _, table := range tables {
+		if rate := profile.rateAt(table.elapsed); math.Abs(rate-table.rate) > 1e-6 {
+			t.Errorf("Rate after %s: got %f, expected %f", table.elapsed, rate, table.rate)
+		}
+		if queries := profile.queriesAt(table.elapsed); math.Abs(queries-table.queries) > 1e-6 {
+			t.Errorf("Queries after %s: got %f, expected %f", table.elapsed, queries, table.queries)
+		}
+		if elapsed, ok := profile.timeOf(table.queries); !ok || (elapsed-table.elapsed).Abs() > time.Microsecond {
+			t.Errorf("Time of %f queries: got %s, expected %s", table.queries, elapsed, table.elapsed)
+		}
+	}
+	// The rate ends at zero, no more queries can be sent after the profile
+	if _, ok := profile.timeOf(20000); ok {
+		t.Errorf("Queries after the end of the profile should never be sent")
+	}
+}
@@ -0,0 +1,114 @@
+package stress
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// queryEntry is a query read from the -qfile
+type queryEntry struct {
+	name  string
+	qtype uint16
+}
+
+// queryList is the corpus of queries the threads pick from
+type queryList struct {
+	entries []queryEntry
+	choice  weightedChoice
+}
+
+// ParseQueryFile parses lines of "name qtype [weight]". Empty lines and lines starting with #
+// are ignored.
+func ParseQueryFile(input io.Reader) (*queryList, error) {
+	list := &queryList{}
+	var weights []int
+	scanner := bufio.NewScanner(input)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected \"name qtype [weight]\"", line)
+		}
+		qtype, ok := dns.StringToType[strings.ToUpper(fields[1])]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown record type %q", line, fields[1])
+		}
+		weight := 1
+		if len(fields) == 3 {
+			parsed, err := strconv.Atoi(fields[2])
+			if err != nil || parsed <= 0 {
+				return nil, fmt.Errorf("line %d: invalid weight %q", line, fields[2])
+			}
+			weight = parsed
+		}
+		list.entries = append(list.entries, queryEntry{name: dns.Fqdn(fields[0]), qtype: qtype})
+		weights = append(weights, weight)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(list.entries) == 0 {
+		return nil, fmt.Errorf("no queries")
+	}
+	list.choice = newWeightedChoice(weights)
+	return list, nil
+}
+
+// loadQueryFile reads the queries of a file
+func loadQueryFile(path string) (*queryList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseQueryFile(file)
+}
+
+// ReloadQueryFile reads the query file again, and returns the number of queries it now has. The
+// previous queries are kept if the file became invalid.
+func (r *Runner) ReloadQueryFile() (int, error) {
+	list, err := loadQueryFile(r.cfg.QueryFile)
+	if err != nil {
+		return 0, err
+	}
+	r.queries.Store(list)
+	return len(list.entries), nil
+}
+
+// LoadedQueries returns the number of queries of the query file
+func (r *Runner) LoadedQueries() int {
+	if list, ok := r.queries.Load().(*queryList); ok {
+		return len(list.entries)
+	}
+	return 0
+}
+
+// nextQuery returns the next query of the file: picked at random proportionally to the weights,
+// or the next line when replaying the file (or a capture) in order, looping at its end
+func (r *Runner) nextQuery(rnd *rand.Rand) queryEntry {
+	list := r.queries.Load().(*queryList)
+	if r.cfg.QueryFileInOrder || r.cfg.Replay != "" {
+		index := (atomic.AddUint64(&r.queryCounter, 1) - 1) % uint64(len(list.entries))
+		return list.entries[index]
+	}
+	return list.entries[list.choice.pick(rnd)]
+}
+
+// types returns the distinct record types of the queries, in ascending order
+func (l *queryList) types() []uint16 {
+	seen := make(map[uint16]QueryCounts)
+	for _, entry := range l.entries {
+		seen[entry.qtype] = QueryCounts{}
+	}
+	return SortedTypes(seen)
+}
@@ -0,0 +1,32 @@
+package stress
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseQueryFile(t *testing.T) {
+	list, err := ParseQueryFile(strings.NewReader("# exported queries\nexample.com A 10\n\ncdn.example.com. aaaa\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []queryEntry{{"example.com.", dns.TypeA}, {"cdn.example.com.", dns.TypeAAAA}}
+	if !reflect.DeepEqual(list.entries, expected) {
+		t.Errorf("Got %v, expected %v", list.entries, expected)
+	}
+	if !reflect.DeepEqual(list.choice.cumulative, []int{10, 11}) {
+		t.Errorf("Got weights %v, expected [10 11]", list.choice.cumulative)
+	}
+	if types := list.types(); !reflect.DeepEqual(types, []uint16{dns.TypeA, dns.TypeAAAA}) {
+		t.Errorf("Got types %v", types)
+	}
+
+	for _, input := range []string{"", "# nothing\n", "example.com\n", "example.com A 1 2\n", "example.com BOGUS\n", "example.com A 0\n"} {
+		if _, err := ParseQueryFile(strings.NewReader(input)); err == nil {
+			t.Errorf("Parsing %q should fail", input)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package stress
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// pacer delays the queries of a thread to follow the target rate
+type pacer interface {
+	// wait blocks until the next query can be sent, and returns false if none ever will be
+	wait() bool
+}
+
+// rateLimiter is a token bucket shared by all the threads, refilled following a load profile. It
+// keeps track of the number of tokens handed out since the start, and of the time at which the
+// next one becomes available according to the profile, rather than of the tokens count.
+type rateLimiter struct {
+	mu      sync.Mutex
+	profile loadProfile
+	burst   float64 // Capacity of the bucket
+	start   time.Time
+	issued  float64
+}
+
+// newRateLimiter returns a limiter allowing the given number of queries per second, with bursts
+// of at most burst queries
+func newRateLimiter(rate int, burst int) *rateLimiter {
+	return newProfileLimiter(constantProfile(rate), burst)
+}
+
+// newProfileLimiter returns a limiter following a load profile, with bursts of at most burst
+// queries
+func newProfileLimiter(profile loadProfile, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{profile: profile, burst: float64(burst), start: time.Now()}
+}
+
+// wait blocks until a token is available, and returns false if none will ever be as the rate
+// stays at zero
+func (r *rateLimiter) wait() bool {
+	r.mu.Lock()
+	if floor := r.profile.queriesAt(time.Since(r.start)) - r.burst + 1; r.issued < floor {
+		// The bucket is full, tokens are not accumulated beyond its capacity
+		r.issued = floor
+	}
+	available, ok := r.profile.timeOf(r.issued)
+	if ok {
+		r.issued++
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	if delay := time.Until(r.start.Add(available)); delay > 0 {
+		time.Sleep(delay)
+	}
+	return true
+}
+
+// maxArrivalsLag is how late a thread can be on its arrivals and still catch up, by sending its
+// next queries right away
+const maxArrivalsLag = 100 * time.Millisecond
+
+// poissonArrivals paces the queries of a thread as a Poisson process: the intervals between them
+// are exponentially distributed, with a mean following the share of the thread in the profile.
+// A thread that falls too far behind draws its next query from the current time instead.
+type poissonArrivals struct {
+	profile loadProfile
+	share   float64 // Fraction of the target rate sent by this thread
+	start   time.Time
+	next    time.Time
+	rnd     *rand.Rand
+}
+
+func newPoissonArrivals(profile loadProfile, share float64, start time.Time, rnd *rand.Rand) *poissonArrivals {
+	return &poissonArrivals{profile: profile, share: share, start: start, next: time.Now(), rnd: rnd}
+}
+
+func (p *poissonArrivals) wait() bool {
+	if !p.advance(time.Now()) {
+		return false
+	}
+	if delay := time.Until(p.next); delay > 0 {
+		time.Sleep(delay)
+	}
+	return true
+}
+
+// advance draws the time of the next query, and returns false if the rate stays at zero
+func (p *poissonArrivals) advance(now time.Time) bool {
+	if p.next.Before(now.Add(-maxArrivalsLag)) {
+		p.next = now
+	}
+	// The interval is drawn as a number of queries of the profile, which maps it to a duration
+	// following the changes of the rate
+	queries := p.profile.queriesAt(p.next.Sub(p.start)) + p.rnd.ExpFloat64()/p.share
+	at, ok := p.profile.timeOf(queries)
+	if !ok {
+		return false
+	}
+	p.next = p.start.Add(at)
+	return true
+}
@@ -0,0 +1,53 @@
+package stress
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	r := newRateLimiter(1000, 10)
+	start := time.Now()
+	// The first 10 tokens are available right away, the next 100 take 100ms
+	for i := 0; i < 110; i++ {
+		r.wait()
+	}
+	if spent := time.Since(start); spent < 90*time.Millisecond {
+		t.Errorf("Rate limiter is too fast: 110 tokens in %s", spent)
+	}
+}
+
+func TestPoissonArrivals(t *testing.T) {
+	start := time.Now()
+	arrivals := newPoissonArrivals(constantProfile(1000), 0.5, start, rand.New(rand.NewSource(1)))
+	arrivals.next = start
+	intervals := make([]float64, 10000)
+	previous := start
+	for i := range intervals {
+		if !arrivals.advance(start) {
+			t.Fatalf("No arrival at a constant rate")
+		}
+		intervals[i] = arrivals.next.Sub(previous).Seconds()
+		previous = arrivals.next
+	}
+	// Exponential intervals have a standard deviation equal to their mean, 2ms at 500 qps
+	var sum, squares float64
+	for _, interval := range intervals {
+		sum += interval
+	}
+	mean := sum / float64(len(intervals))
+	for _, interval := range intervals {
+		squares += (interval - mean) * (interval - mean)
+	}
+	deviation := math.Sqrt(squares / float64(len(intervals)))
+	if math.Abs(mean-0.002) > 0.0001 || math.Abs(deviation-0.002) > 0.0001 {
+		t.Errorf("Got intervals of %fs ± %fs, expected 0.002s ± 0.002s", mean, deviation)
+	}
+
+	stopped := newPoissonArrivals(rampProfile([]RampStep{{0, 0, time.Second}}), 1, start, rand.New(rand.NewSource(1)))
+	if stopped.advance(start) {
+		t.Errorf("Arrivals should stop once the rate stays at zero")
+	}
+}
@@ -0,0 +1,132 @@
+package stress
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// SystemResolver stands for the resolvers of the system in a list of resolvers
+const SystemResolver = "system"
+
+// ParseResolverList parses a comma-separated list of resolver addresses, where "system" stands
+// for the resolvers configured on the system (see SystemResolvers)
+func ParseResolverList(input string, defaultPort string) ([]string, error) {
+	var addresses []string
+	for _, element := range strings.Split(input, ",") {
+		element = strings.TrimSpace(element)
+		if element == "" {
+			continue
+		}
+		if strings.EqualFold(element, SystemResolver) {
+			servers, err := SystemResolvers()
+			if err != nil {
+				return nil, err
+			}
+			for _, server := range servers {
+				addresses = append(addresses, net.JoinHostPort(server, defaultPort))
+			}
+			continue
+		}
+		address, err := ParseIPPortWithDefault(element, defaultPort)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("empty list")
+	}
+	return addresses, nil
+}
+
+// SystemResolvers returns the addresses of the resolvers the system uses, without their port:
+// the name servers of /etc/resolv.conf, or the DNS servers of the network adapters on Windows
+func SystemResolvers() ([]string, error) {
+	servers, err := systemResolvers()
+	if err != nil {
+		return nil, fmt.Errorf("unable to find the resolvers of the system: %w", err)
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("the system has no resolver configured")
+	}
+	return servers, nil
+}
+
+// parseResolvConf returns the name servers of a resolv.conf file, in their order
+func parseResolvConf(path string) ([]string, error) {
+	config, err := dns.ClientConfigFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return config.Servers, nil
+}
+
+// ParseResolverWeights parses the comma-separated weights of the resolvers, which must be as
+// many as the resolvers
+func ParseResolverWeights(input string, count int) ([]int, error) {
+	weights := make([]int, 0, count)
+	for _, element := range strings.Split(input, ",") {
+		weight, err := strconv.Atoi(strings.TrimSpace(element))
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid weight %q", element)
+		}
+		weights = append(weights, weight)
+	}
+	if len(weights) != count {
+		return nil, fmt.Errorf("got %d weights for %d resolvers", len(weights), count)
+	}
+	return weights, nil
+}
+
+// resolverDistribution picks the resolver each query is sent to: in turn ("round-robin"), at
+// random proportionally to weights ("weighted"), or from the query name ("hash") so that a
+// given name always reaches the same resolver
+type resolverDistribution struct {
+	strategy string
+	count    int
+	choice   weightedChoice
+	next     uint64
+}
+
+func newResolverDistribution(strategy string, count int, weights []int) (*resolverDistribution, error) {
+	switch strategy {
+	case "round-robin", "hash":
+	case "weighted":
+		if weights == nil {
+			return nil, fmt.Errorf("the weighted strategy needs -resolver-weights")
+		}
+	default:
+		return nil, fmt.Errorf("unknown strategy %q (expected round-robin, weighted or hash)", strategy)
+	}
+	if weights == nil {
+		weights = make([]int, count)
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	return &resolverDistribution{strategy: strategy, count: count, choice: newWeightedChoice(weights)}, nil
+}
+
+// pick returns the index of the resolver to send a query for the given name to
+func (d *resolverDistribution) pick(rnd *rand.Rand, name string) int {
+	if d.count == 1 {
+		return 0
+	}
+	switch d.strategy {
+	case "weighted":
+		return d.choice.pick(rnd)
+	case "hash":
+		hash := fnv.New32a()
+		hash.Write([]byte(strings.ToLower(name)))
+		return int(hash.Sum32() % uint32(d.count))
+	default:
+		return int((atomic.AddUint64(&d.next, 1) - 1) % uint64(d.count))
+	}
+}
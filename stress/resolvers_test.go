@@ -0,0 +1,80 @@
+package stress
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseResolverList(t *testing.T) {
+	addresses, err := ParseResolverList("127.0.0.1, 10.0.0.1:5353,[::1]", "53")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []string{"127.0.0.1:53", "10.0.0.1:5353", "[::1]:53"}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("Got %v, expected %v", addresses, expected)
+	}
+
+	for _, input := range []string{"", " , ", "127.0.0.1,example.com"} {
+		if _, err := ParseResolverList(input, "53"); err == nil {
+			t.Errorf("Parsing %q should fail", input)
+		}
+	}
+}
+
+func TestParseResolvConf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	content := "# Generated\nsearch example.com\nnameserver 192.0.2.53\nnameserver 2001:db8::53\noptions ndots:2\nnameserver 192.0.2.54\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	servers, err := parseResolvConf(path)
+	expected := []string{"192.0.2.53", "2001:db8::53", "192.0.2.54"}
+	if err != nil || !reflect.DeepEqual(servers, expected) {
+		t.Errorf("Got %v (%v), expected %v", servers, err, expected)
+	}
+	if _, err := parseResolvConf(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
+
+func TestParseResolverWeights(t *testing.T) {
+	weights, err := ParseResolverWeights("3, 1", 2)
+	if err != nil || !reflect.DeepEqual(weights, []int{3, 1}) {
+		t.Errorf("Got %v (%v), expected [3 1]", weights, err)
+	}
+	for _, input := range []string{"3", "3,1,1", "3,0", "3,a"} {
+		if _, err := ParseResolverWeights(input, 2); err == nil {
+			t.Errorf("Parsing %q should fail", input)
+		}
+	}
+}
+
+func TestResolverDistribution(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+
+	roundRobin, _ := newResolverDistribution("round-robin", 3, nil)
+	for i := 0; i < 6; i++ {
+		if picked := roundRobin.pick(rnd, "example.com."); picked != i%3 {
+			t.Errorf("Query #%d sent to resolver %d, expected %d", i, picked, i%3)
+		}
+	}
+
+	hash, _ := newResolverDistribution("hash", 3, nil)
+	first := hash.pick(rnd, "www.example.com.")
+	for i := 0; i < 10; i++ {
+		if picked := hash.pick(rnd, "WWW.example.com."); picked != first {
+			t.Errorf("Same name sent to resolver %d, then %d", first, picked)
+		}
+	}
+
+	if _, err := newResolverDistribution("weighted", 2, nil); err == nil {
+		t.Error("The weighted strategy should require weights")
+	}
+	if _, err := newResolverDistribution("random", 2, nil); err == nil {
+		t.Error("Unknown strategies should be rejected")
+	}
+}
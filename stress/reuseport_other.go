@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package stress
+
+import "syscall"
+
+// ReusePortSupported tells whether Config.ReusePort can be used on this system
+const ReusePortSupported = false
+
+func setReusePort(network, address string, conn syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,100 @@
+package stress
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// ParseReverseRanges parses comma-separated CIDR ranges (e.g. "10.0.0.0/16,2001:db8::/120")
+func ParseReverseRanges(input string) ([]*net.IPNet, error) {
+	return ParseClientSubnets(input)
+}
+
+// reverseSweep generates the in-addr.arpa and ip6.arpa names of the addresses of ranges
+type reverseSweep struct {
+	bases   []net.IP
+	masks   []net.IPMask
+	sizes   []uint64 // Addresses swept in each range, at most 2^63
+	total   uint64
+	counter uint64 // Last address swept in order
+}
+
+func newReverseSweep(ranges []*net.IPNet) *reverseSweep {
+	sweep := &reverseSweep{}
+	for _, ipRange := range ranges {
+		ones, bits := ipRange.Mask.Size()
+		base := ipRange.IP
+		if bits == 32 {
+			// IPv4 ranges may be in their 16 bytes form, once decoded from JSON
+			base = base.To4()
+		}
+		// The ranges of more than 2^63 addresses are only swept in part
+		size := uint64(1) << 63
+		if bits-ones < 63 {
+			size = uint64(1) << (bits - ones)
+		}
+		sweep.bases = append(sweep.bases, base.Mask(ipRange.Mask))
+		sweep.masks = append(sweep.masks, ipRange.Mask)
+		sweep.sizes = append(sweep.sizes, size)
+		// Past 2^64 addresses in total, the last ranges are left out
+		if sweep.total+size >= sweep.total {
+			sweep.total += size
+		}
+	}
+	return sweep
+}
+
+// rangeAt returns the range of an offset within the ranges, following each other, and the offset
+// within this range
+func (s *reverseSweep) rangeAt(offset uint64) (int, uint64) {
+	index := 0
+	for offset >= s.sizes[index] && index < len(s.sizes)-1 {
+		offset -= s.sizes[index]
+		index++
+	}
+	return index, offset
+}
+
+// address returns the address at an offset within the ranges
+func (s *reverseSweep) address(offset uint64) net.IP {
+	index, offset := s.rangeAt(offset)
+	address := make(net.IP, len(s.bases[index]))
+	copy(address, s.bases[index])
+	// The host bits of the base are zero, the offset fits in them
+	var host [8]byte
+	binary.BigEndian.PutUint64(host[:], offset)
+	for i := 1; i <= len(address) && i <= len(host); i++ {
+		address[len(address)-i] |= host[len(host)-i]
+	}
+	return address
+}
+
+// first returns the name of the first address of the ranges
+func (s *reverseSweep) first() string {
+	name, _ := dns.ReverseAddr(s.address(0).String())
+	return name
+}
+
+// next returns the name of the next address of the ranges in order, or of a random address of
+// them, the ranges being picked proportionally to their sizes
+func (s *reverseSweep) next(rnd *rand.Rand, random bool) string {
+	var address net.IP
+	if random {
+		index, _ := s.rangeAt(rnd.Uint64() % s.total)
+		address = make(net.IP, len(s.bases[index]))
+		copy(address, s.bases[index])
+		// All the host bits are randomized, even in the ranges only swept in part
+		mask := s.masks[index]
+		for i := range address {
+			address[i] |= byte(rnd.Intn(256)) &^ mask[i]
+		}
+	} else {
+		address = s.address((atomic.AddUint64(&s.counter, 1) - 1) % s.total)
+	}
+	name, _ := dns.ReverseAddr(address.String())
+	return name
+}
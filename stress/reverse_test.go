@@ -0,0 +1,37 @@
+package stress
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestReverseSweep(t *testing.T) {
+	ranges, err := ParseReverseRanges("192.0.2.254/31, 2001:db8::/127")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sweep := newReverseSweep(ranges)
+	expected := []string{
+		"254.2.0.192.in-addr.arpa.",
+		"255.2.0.192.in-addr.arpa.",
+		"0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+		"254.2.0.192.in-addr.arpa.",
+	}
+	for _, name := range expected {
+		if got := sweep.next(nil, false); got != name {
+			t.Errorf("Got %s, expected %s", got, name)
+		}
+	}
+
+	ranges, _ = ParseReverseRanges("10.0.0.0/8,2001:db8::/32")
+	sweep = newReverseSweep(ranges)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		name := sweep.next(rnd, true)
+		if !strings.HasSuffix(name, ".10.in-addr.arpa.") && !strings.HasSuffix(name, ".8.b.d.0.1.0.0.2.ip6.arpa.") {
+			t.Fatalf("The random name %s is out of the ranges", name)
+		}
+	}
+}
@@ -0,0 +1,577 @@
+package stress
+
+import (
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Runner sends the queries of a run, from several threads, and aggregates their stats
+type Runner struct {
+	cfg          Config
+	resolvers    []string
+	connected    []string // The resolvers, and the compared one
+	ipv4Sources  []*net.UDPAddr
+	ipv6Sources  []*net.UDPAddr
+	picker       *resolverDistribution
+	domains      []string
+	domainChoice weightedChoice
+	queryTypes   []uint16
+	typeChoice   weightedChoice
+	queries      atomic.Value // Current *queryList, replaced when the query file is reloaded
+	capture      *capture     // Queries of the replayed capture
+	timing       *replayTiming
+	reverse      *reverseSweep // Addresses of the reverse ranges, when the queries are made for them
+	words        *wordlist     // With Wordlist
+	transfers    bool          // The queries are zone transfers
+	tuner        *concurrencyTuner
+	search       *rateSearch
+	profile      *loadProfile // Target rate, when there is one
+	bursts       *burstPacer  // With Burst
+	started      time.Time
+	limiter      atomic.Pointer[rateLimiter] // Replaced when the rate is changed
+	tlsConfig    *tls.Config
+	dohClient    *http.Client
+	dohProtocols protocolCounts
+	doqSessions  map[string]*quicSession
+	pools        map[string]*connPool // Connections shared by the threads, by resolver, with PoolSize
+	validator    *dnssecValidator
+	ttls         *ttlTracker // With TrackTTL
+	metrics      *metricsRegistry
+
+	remainingQueries int64  // Shared budget of queries left to send, when a count is set
+	stopRequested    int32  // Set once the threads should stop sending queries
+	paused           int32  // Set while the threads should hold their queries
+	activeThreads    int32  // Threads let sending queries by SetConcurrency, 0 for all of them
+	patternCounter   uint64 // Last integer used to expand the query pattern
+	queryCounter     uint64 // Position of the next query when the file is replayed in order
+}
+
+// NewRunner checks the options of a run and prepares it. The target domains are made fully
+// qualified, and the resolvers are expected to be "host:port" addresses.
+func NewRunner(config *Config) (*Runner, error) {
+	r := &Runner{cfg: *config}
+	cfg := &r.cfg
+	if cfg.Concurrency <= 0 {
+		return nil, fmt.Errorf("the concurrency must be positive")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Timeout < 0 {
+		return nil, fmt.Errorf("the timeout cannot be negative")
+	} else if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+
+	// Query names come from the target domains, a pattern or a query file
+	if cfg.QueryPattern != "" {
+		if len(cfg.Domains) > 0 {
+			return nil, fmt.Errorf("target domains cannot be used along with a query pattern")
+		}
+		if err := ValidateQueryPattern(cfg.QueryPattern); err != nil {
+			return nil, fmt.Errorf("invalid query pattern: %w", err)
+		}
+		cfg.QueryPattern = dns.Fqdn(cfg.QueryPattern)
+		// The first expansion of the pattern is used to check the resolvers
+		r.domains = []string{fmt.Sprintf(cfg.QueryPattern, 0)}
+	}
+	if cfg.QueryFile != "" {
+		if len(cfg.Domains) > 0 || cfg.QueryPattern != "" {
+			return nil, fmt.Errorf("target domains and query patterns cannot be used along with a query file")
+		}
+		list, err := loadQueryFile(cfg.QueryFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the query file: %w", err)
+		}
+		r.queries.Store(list)
+		// The first query of the file is used to check the resolvers
+		r.domains = []string{list.entries[0].name}
+	}
+	if cfg.Replay != "" {
+		if len(cfg.Domains) > 0 || cfg.QueryPattern != "" || cfg.QueryFile != "" {
+			return nil, fmt.Errorf("target domains, query patterns and query files cannot be used along with a replay")
+		}
+		replay, err := loadCapture(cfg.Replay)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the capture: %w", err)
+		}
+		r.capture = replay
+		r.queries.Store(replay.queries)
+		// The first query of the capture is used to check the resolvers
+		r.domains = []string{replay.queries.entries[0].name}
+		if cfg.Count == 0 && cfg.Duration == 0 {
+			// The capture is replayed once
+			cfg.Count = len(replay.queries.entries)
+		}
+	}
+	if len(cfg.ReverseRanges) > 0 {
+		if len(cfg.Domains) > 0 || cfg.QueryPattern != "" || cfg.QueryFile != "" || cfg.Replay != "" {
+			return nil, fmt.Errorf("target domains, query patterns, query files and replays cannot be used along with reverse ranges")
+		}
+		r.reverse = newReverseSweep(cfg.ReverseRanges)
+		// The first address of the ranges is used to check the resolvers
+		r.domains = []string{r.reverse.first()}
+	}
+	if cfg.ReplaySpeed < 0 {
+		return nil, fmt.Errorf("the replay speed cannot be negative")
+	}
+	if cfg.ReplaySpeed > 0 && cfg.Replay == "" {
+		return nil, fmt.Errorf("a replay speed needs a capture to replay")
+	}
+	if r.domains == nil {
+		if len(cfg.Domains) == 0 {
+			return nil, fmt.Errorf("no target domains")
+		}
+		r.domains = make([]string, len(cfg.Domains))
+		for index, domain := range cfg.Domains {
+			r.domains[index] = dns.Fqdn(domain)
+		}
+	}
+	if cfg.Wordlist != "" || cfg.WordlistDepth != 0 {
+		if cfg.Wordlist == "" || cfg.WordlistDepth < 0 {
+			return nil, fmt.Errorf("the depth of the names needs a wordlist, and cannot be negative")
+		}
+		if len(cfg.Domains) == 0 || cfg.RandomPrefix || cfg.Update {
+			return nil, fmt.Errorf("the labels of a wordlist are prepended to the target domains, without random prefixes or updates")
+		}
+		words, err := loadWordlist(cfg.Wordlist)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the wordlist: %w", err)
+		}
+		if cfg.WordlistDepth == 0 {
+			cfg.WordlistDepth = 1
+		}
+		r.words = &wordlist{words: words, depth: cfg.WordlistDepth}
+		for _, domain := range r.domains {
+			if length := r.words.longestName(domain); length > 255 {
+				return nil, fmt.Errorf("the names made from the wordlist for %s can be %d bytes long, more than the 255 of a name", domain, length)
+			}
+		}
+	}
+	domainWeights, err := defaultWeights(cfg.DomainWeights, len(r.domains), "domains")
+	if err != nil {
+		return nil, err
+	}
+	r.domainChoice = newWeightedChoice(domainWeights)
+	if err := cfg.checkClientSubnets(); err != nil {
+		return nil, err
+	}
+	if cfg.InFlight < 0 || cfg.InFlight > 0 && !cfg.Flood {
+		return nil, fmt.Errorf("the queries in flight are only bounded when flooding")
+	}
+	if cfg.TSIG != nil && cfg.Flood {
+		return nil, fmt.Errorf("the signatures of the answers cannot be verified when flooding")
+	}
+	if cfg.RetryTCP && (cfg.TCP || cfg.DoT || cfg.DoQ || cfg.DOHEndpoint != "" || cfg.Flood) {
+		return nil, fmt.Errorf("truncated answers can only be retried over TCP when querying over UDP, without flooding")
+	}
+	if cfg.Amplification && (cfg.Flood || cfg.Update) {
+		return nil, fmt.Errorf("the amplification of the answers is only measured for queries, without flooding")
+	}
+	if cfg.Fuzz < 0 || cfg.Fuzz > 1 {
+		return nil, fmt.Errorf("the share of fuzzed queries has to be between 0 and 1")
+	}
+	if cfg.Fuzz > 0 && (cfg.Flood || cfg.DOHEndpoint != "" || cfg.DoQ || cfg.Update) {
+		return nil, fmt.Errorf("fuzzed queries are only sent over UDP, TCP or TLS, without flooding or updates")
+	}
+	if len(cfg.SOAZones) > 0 || cfg.SOAInterval != 0 {
+		if len(cfg.SOAZones) == 0 || cfg.SOAInterval < 0 {
+			return nil, fmt.Errorf("the SOA records need zones to check, and their interval cannot be negative")
+		}
+		zones := make([]string, len(cfg.SOAZones))
+		for i, zone := range cfg.SOAZones {
+			if _, ok := dns.IsDomainName(zone); !ok {
+				return nil, fmt.Errorf("invalid zone %q", zone)
+			}
+			zones[i] = dns.Fqdn(zone)
+		}
+		cfg.SOAZones = zones
+		if cfg.SOAInterval == 0 {
+			cfg.SOAInterval = defaultSOAInterval
+		}
+	}
+	if cfg.RandomCase && cfg.Flood {
+		return nil, fmt.Errorf("the case of the names cannot be checked when flooding, as the answers are not parsed")
+	}
+
+	switch cfg.IPVersion {
+	case 0, 4, 6:
+	default:
+		return nil, fmt.Errorf("unknown IP version %d (expected 4 or 6)", cfg.IPVersion)
+	}
+
+	r.queryTypes = cfg.QueryTypes
+	if len(r.queryTypes) == 0 {
+		// The address records of the IP version tested are asked by default
+		r.queryTypes = []uint16{dns.TypeA}
+		if cfg.IPVersion == 6 {
+			r.queryTypes = []uint16{dns.TypeAAAA}
+		}
+	}
+	if cfg.Update {
+		if err := checkUpdates(cfg); err != nil {
+			return nil, err
+		}
+		for _, qtype := range r.queryTypes {
+			if err := checkUpdateType(qtype); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if r.transfers, err = checkTransfers(cfg, r.queryTypes); err != nil {
+		return nil, err
+	} else if err := checkHeader(cfg, r.transfers); err != nil {
+		return nil, err
+	} else if r.transfers && !cfg.DoT {
+		cfg.TCP = true
+	}
+	typeWeights, err := defaultWeights(cfg.TypeWeights, len(r.queryTypes), "query types")
+	if err != nil {
+		return nil, err
+	}
+	r.typeChoice = newWeightedChoice(typeWeights)
+	if list, ok := r.queries.Load().(*queryList); ok {
+		r.queryTypes = list.types()
+	}
+
+	for _, source := range cfg.Sources {
+		if version := ipVersion(source.IP.String()); cfg.IPVersion != 0 && version != cfg.IPVersion {
+			return nil, fmt.Errorf("source address %s is not an IPv%d address", source.IP, cfg.IPVersion)
+		}
+		if source.IP.To4() != nil {
+			r.ipv4Sources = append(r.ipv4Sources, source)
+		} else {
+			r.ipv6Sources = append(r.ipv6Sources, source)
+		}
+	}
+
+	if cfg.DOHEndpoint != "" {
+		switch cfg.DOHMethod {
+		case "":
+			cfg.DOHMethod = "GET"
+		case "GET", "POST":
+		default:
+			return nil, fmt.Errorf("unknown DOH method %q (expected GET or POST)", cfg.DOHMethod)
+		}
+		if cfg.DOHHTTP2 && cfg.DOHHTTP3 {
+			return nil, fmt.Errorf("HTTP/2 and HTTP/3 cannot both be required")
+		}
+		r.dohClient, err = newDOHClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.CompareResolver != "" {
+			return nil, fmt.Errorf("a DOH endpoint cannot be compared with a resolver")
+		}
+	} else {
+		if cfg.DoQ && (cfg.TCP || cfg.DoT) {
+			return nil, fmt.Errorf("DNS over QUIC cannot be used along with TCP or TLS")
+		}
+		if len(cfg.Resolvers) == 0 {
+			return nil, fmt.Errorf("no resolvers")
+		}
+		for _, address := range cfg.Resolvers {
+			if version := ipVersion(address); cfg.IPVersion != 0 && version != 0 && version != cfg.IPVersion {
+				return nil, fmt.Errorf("resolver %s is not an IPv%d address", address, cfg.IPVersion)
+			}
+		}
+		r.resolvers = cfg.Resolvers
+		if cfg.CompareResolver != "" {
+			if cfg.Flood {
+				return nil, fmt.Errorf("the answers cannot be compared when flooding, as they are not parsed")
+			}
+			r.connected = append(r.resolvers[:len(r.resolvers):len(r.resolvers)], cfg.CompareResolver)
+		} else {
+			r.connected = r.resolvers
+		}
+		strategy := cfg.Distribution
+		if strategy == "" {
+			strategy = "round-robin"
+		}
+		r.picker, err = newResolverDistribution(strategy, len(r.resolvers), cfg.ResolverWeights)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.DoT || cfg.DoQ {
+			r.tlsConfig, err = newTLSConfig(cfg)
+			if err != nil {
+				return nil, err
+			}
+		} else if cfg.TLSCert != "" {
+			return nil, fmt.Errorf("a client certificate needs TLS, QUIC or a DOH endpoint")
+		}
+		if cfg.DoQ {
+			// The connections are shared by all the threads, they use the first source address
+			r.doqSessions = newDOQSessions(r.connected, r.transportNetwork(), func(address string) *net.UDPAddr {
+				return r.source(0, address)
+			}, r.tlsConfig, cfg.TSIG, cfg.Timeout)
+		}
+	}
+	if cfg.ReusePort && !ReusePortSupported {
+		return nil, fmt.Errorf("SO_REUSEPORT is not supported on this system")
+	}
+	if cfg.TrackTTL {
+		if cfg.Flood || cfg.Update || r.transfers {
+			return nil, fmt.Errorf("the TTLs are only tracked for the answers of queries, without flooding")
+		}
+		r.ttls = newTTLTracker()
+	}
+	if cfg.PoolSize != 0 {
+		if cfg.PoolSize < 0 || !cfg.TCP && !cfg.DoT || cfg.DOHEndpoint != "" || cfg.DoQ || r.transfers {
+			return nil, fmt.Errorf("connection pools are only used for queries over TCP or TLS")
+		}
+		r.pools = make(map[string]*connPool, len(r.connected))
+		for _, address := range r.connected {
+			r.pools[address] = newConnPool(r, address, cfg.PoolSize)
+		}
+	}
+
+	if cfg.EDNSBufSize > 65535 || cfg.EDNSPadding > 65535 {
+		return nil, fmt.Errorf("the EDNS buffer size and padding cannot exceed 65535 bytes")
+	}
+	if cfg.DNSSECValidate {
+		if cfg.Flood {
+			return nil, fmt.Errorf("DNSSEC validation cannot be used when flooding, as the answers are not parsed")
+		}
+		// The records needed to validate the answers are asked to the first resolver
+		address := r.CheckedResolvers()[0]
+		r.validator, err = newDNSSECValidator(cfg.DNSSECAnchors, func(message *dns.Msg) (*dns.Msg, error) {
+			return r.exchange(0, address, message)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the DNSSEC trust anchors: %w", err)
+		}
+		cfg.DNSSECOK = true
+	}
+	if cfg.Expect != nil && cfg.Flood {
+		return nil, fmt.Errorf("expected answers cannot be checked when flooding, as the answers are not parsed")
+	}
+
+	if cfg.ReplaySpeed > 0 && (cfg.Ramp != nil || cfg.Rate > 0 || cfg.Arrivals == "poisson") {
+		return nil, fmt.Errorf("the timing of the capture cannot be followed along with a rate or a load profile")
+	}
+	if cfg.Ramp != nil {
+		if cfg.Rate > 0 {
+			return nil, fmt.Errorf("a load profile cannot be used along with a fixed rate")
+		}
+		if cfg.Duration == 0 {
+			// The run ends with the profile, unless it should keep going at the last rate
+			cfg.Duration = profileDuration(cfg.Ramp)
+		}
+		profile := rampProfile(cfg.Ramp)
+		r.profile = &profile
+	} else if cfg.Rate > 0 {
+		profile := constantProfile(cfg.Rate)
+		r.profile = &profile
+	}
+	if cfg.AutoConcurrency {
+		if cfg.Rampup > 0 || cfg.Arrivals == "poisson" {
+			return nil, fmt.Errorf("the auto concurrency cannot be used along with a ramp-up or poisson arrivals, it starts the threads itself")
+		}
+		if cfg.SLA.MaxErrorRate < 0 || cfg.SLA.MaxP99 < 0 {
+			return nil, fmt.Errorf("the bounds of the SLA cannot be negative")
+		}
+		r.tuner = newConcurrencyTuner(cfg.Concurrency, cfg.SLA)
+	}
+	if cfg.FindMax {
+		if cfg.Rate > 0 || cfg.Ramp != nil || cfg.Arrivals == "poisson" || cfg.ReplaySpeed > 0 || cfg.AutoConcurrency {
+			return nil, fmt.Errorf("the search of the maximum rate sets the rate itself, it cannot be used along with a rate, a load profile, a replay timing or the auto concurrency")
+		}
+		if cfg.FindMaxStart < 0 || cfg.FindMaxWindow < 0 || cfg.SLA.MaxErrorRate < 0 || cfg.SLA.MaxP99 < 0 {
+			return nil, fmt.Errorf("the options of the search of the maximum rate cannot be negative")
+		}
+		if cfg.FindMaxStart == 0 {
+			cfg.FindMaxStart = defaultFindMaxStart
+		}
+		if cfg.FindMaxWindow == 0 {
+			cfg.FindMaxWindow = defaultFindMaxWindow
+		}
+		r.search = newRateSearch(r)
+	}
+	if cfg.Burst != 0 || cfg.BurstInterval != 0 {
+		if cfg.Burst <= 0 || cfg.BurstInterval < 0 {
+			return nil, fmt.Errorf("the size of the bursts has to be positive, and their interval cannot be negative")
+		}
+		if r.profile != nil || cfg.Arrivals == "poisson" || cfg.ReplaySpeed > 0 || cfg.FindMax || cfg.AutoConcurrency {
+			return nil, fmt.Errorf("the bursts set the timing of the queries, they cannot be used along with a rate, a load profile, poisson arrivals, a replay timing, the search of the maximum rate or the auto concurrency")
+		}
+		if cfg.BurstInterval == 0 {
+			cfg.BurstInterval = defaultBurstInterval
+		}
+	}
+	switch cfg.Arrivals {
+	case "", "constant":
+	case "poisson":
+		if r.profile == nil {
+			return nil, fmt.Errorf("poisson arrivals need a rate or a load profile")
+		}
+	default:
+		return nil, fmt.Errorf("unknown arrivals distribution %q (expected constant or poisson)", cfg.Arrivals)
+	}
+	if cfg.Metrics {
+		r.metrics = newMetricsRegistry()
+	}
+	return r, nil
+}
+
+// defaultWeights returns the same weight for all the items when none are given
+func defaultWeights(weights []int, count int, items string) ([]int, error) {
+	if weights == nil {
+		weights = make([]int, count)
+		for index := range weights {
+			weights[index] = 1
+		}
+	}
+	if len(weights) != count {
+		return nil, fmt.Errorf("got %d weights for %d %s", len(weights), count, items)
+	}
+	return weights, nil
+}
+
+// Domains returns the fully qualified target domains, or the first name the queries are made for
+// when they come from a pattern or a query file
+func (r *Runner) Domains() []string {
+	return r.domains
+}
+
+// QueryTypes returns the record types queried
+func (r *Runner) QueryTypes() []uint16 {
+	return r.queryTypes
+}
+
+// CheckedResolvers returns the resolvers the target domains can be checked against with Check,
+// including the compared one, or a single empty address when using DOH
+func (r *Runner) CheckedResolvers() []string {
+	if r.cfg.DOHEndpoint != "" {
+		return []string{""}
+	}
+	return r.connected
+}
+
+// Check sends a single query for a domain to a resolver, to make sure that it can be resolved
+// before the run. The zones to update or transfer are checked with a query of their SOA record.
+func (r *Runner) Check(address string, domain string) error {
+	qtype := r.queryTypes[0]
+	if r.cfg.Update || r.transfers {
+		qtype = dns.TypeSOA
+	}
+	message := new(dns.Msg).SetQuestion(domain, qtype)
+	r.cfg.setupHeader(message)
+	r.cfg.setupEDNS(message)
+	_, err := r.exchange(0, address, message)
+	return err
+}
+
+// Run sends the queries until the count or the duration is reached, or until Stop is called,
+// and returns the stats of the whole run. The stats of each interval are handed to OnInterval
+// meanwhile.
+func (r *Runner) Run() *Stats {
+	channel := make(chan statsMessage, r.cfg.Concurrency)
+	atomic.StoreInt64(&r.remainingQueries, int64(r.cfg.Count))
+	r.started = time.Now()
+	if r.profile != nil && r.cfg.Arrivals != "poisson" {
+		r.limiter.Store(newProfileLimiter(*r.profile, r.cfg.Concurrency))
+	}
+	if r.cfg.ReplaySpeed > 0 {
+		r.timing = newReplayTiming(r.capture, r.cfg.ReplaySpeed)
+	}
+	if r.cfg.Burst > 0 {
+		r.bursts = newBurstPacer(r.cfg.Burst, r.cfg.BurstInterval, r.started)
+	}
+	if r.search != nil {
+		r.search.start()
+	}
+	// The answers to the checks made before the run are not part of it
+	r.dohProtocols = protocolCounts{}
+
+	var wg sync.WaitGroup
+	wg.Add(r.cfg.Concurrency)
+	if r.cfg.Rampup > 0 {
+		// Threads are started in the background so that the stats are aggregated meanwhile
+		go r.startThreads(channel, &wg)
+	} else {
+		r.startThreads(channel, &wg)
+	}
+	if r.cfg.Duration > 0 {
+		timer := time.AfterFunc(r.cfg.Duration, r.Stop)
+		defer timer.Stop()
+	}
+
+	// Threads only stop once the query budget is exhausted or the duration has elapsed
+	go func() {
+		wg.Wait()
+		channel <- statsMessage{flush: true, final: true}
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go r.timerStats(channel, done)
+	// The checks of the SOA records are over before the connections are closed
+	var monitor sync.WaitGroup
+	monitorDone := make(chan struct{})
+	if len(r.cfg.SOAZones) > 0 {
+		monitor.Add(1)
+		go func() {
+			defer monitor.Done()
+			r.monitorSOA(channel, monitorDone)
+		}()
+	}
+	totals := r.aggregate(channel)
+	close(monitorDone)
+	monitor.Wait()
+	for _, session := range r.doqSessions {
+		session.close()
+	}
+	for _, pool := range r.pools {
+		pool.close()
+	}
+	return totals
+}
+
+// Stop asks all the threads to stop sending queries, Run returns once their last answers are in
+func (r *Runner) Stop() {
+	atomic.StoreInt32(&r.stopRequested, 1)
+}
+
+// newPacer returns what paces the queries of a thread: the timing of the replayed capture, its
+// own Poisson arrivals, or the current limiter shared by all the threads
+func (r *Runner) newPacer(rnd *rand.Rand) pacer {
+	if r.timing != nil {
+		return r.timing
+	}
+	if r.bursts != nil {
+		return r.bursts
+	}
+	if r.cfg.Arrivals == "poisson" {
+		return newPoissonArrivals(*r.profile, 1/float64(r.cfg.Concurrency), r.started, rnd)
+	}
+	return sharedLimiter{r}
+}
+
+// startThreads launches the threads, evenly spread over the ramp-up duration
+func (r *Runner) startThreads(channel chan<- statsMessage, wg *sync.WaitGroup) {
+	var step time.Duration
+	if r.cfg.Concurrency > 1 {
+		step = r.cfg.Rampup / time.Duration(r.cfg.Concurrency-1)
+	}
+	for threadID := 0; threadID < r.cfg.Concurrency; threadID++ {
+		if threadID > 0 && step > 0 {
+			time.Sleep(step)
+		}
+		go func(threadID int) {
+			defer wg.Done()
+			r.thread(threadID, channel)
+		}(threadID)
+	}
+	if r.cfg.Rampup > 0 {
+		r.cfg.logf("All %d threads started.", r.cfg.Concurrency)
+	}
+}
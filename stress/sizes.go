@@ -0,0 +1,39 @@
+package stress
+
+import (
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/miekg/dns"
+)
+
+// ResponseSizeBounds are the upper bounds, in bytes, of the buckets ResponseSizeBuckets counts
+// the answers in: among them the 512 bytes of plain UDP, the 1232 bytes EDNS buffer recommended
+// to avoid fragmentation, and the 1472 bytes fitting in an Ethernet frame
+var ResponseSizeBounds = []int{128, 256, 512, 1232, 1472, 4096}
+
+func newSizeHistogram() *hdrhistogram.Histogram {
+	// Sizes are recorded in bytes, exactly up to 2048 bytes
+	return hdrhistogram.New(1, dns.MaxMsgSize, 3)
+}
+
+// wireSize returns the size of a message once packed, with the name compression servers use
+func wireSize(message *dns.Msg) int {
+	compress := message.Compress
+	message.Compress = true
+	size := message.Len()
+	message.Compress = compress
+	return size
+}
+
+// ResponseSizeBuckets returns the number of answers of each size bucket: up to each of the
+// ResponseSizeBounds, then above the last one
+func (s *Stats) ResponseSizeBuckets() []int {
+	buckets := make([]int, len(ResponseSizeBounds)+1)
+	for _, bar := range s.ResponseSizes.Distribution() {
+		index := 0
+		for index < len(ResponseSizeBounds) && bar.From > int64(ResponseSizeBounds[index]) {
+			index++
+		}
+		buckets[index] += int(bar.Count)
+	}
+	return buckets
+}
@@ -0,0 +1,18 @@
+package stress
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResponseSizeBuckets(t *testing.T) {
+	stats := newStats(false)
+	stats.add(statsMessage{responseSizes: []int{40, 128, 129, 512, 1400, 1500, 4096, 9000}})
+	expected := []int{2, 1, 1, 0, 1, 2, 1}
+	if buckets := stats.ResponseSizeBuckets(); !reflect.DeepEqual(buckets, expected) {
+		t.Errorf("Got %v, expected %v", buckets, expected)
+	}
+	if stats.ResponseSizes.Min() != 40 || stats.ResponseSizes.Max() < 9000 {
+		t.Errorf("Got min=%d max=%d, expected 40 and 9000", stats.ResponseSizes.Min(), stats.ResponseSizes.Max())
+	}
+}
@@ -0,0 +1,194 @@
+package stress
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// defaultSOAInterval is the time between two checks of the SOA records, unless set otherwise
+const defaultSOAInterval = 5 * time.Second
+
+// SOACounts are the checks of the SOA record of a zone on a resolver, made apart from the queries
+// of the threads
+type SOACounts struct {
+	Checks      int
+	Errors      int // Checks without a SOA record in the answer
+	Elapsed     time.Duration
+	MaxElapsed  time.Duration
+	FirstSerial uint32 // Serial at the start of the period, before its changes
+	Serial      uint32 // Serial of the last answer of the period
+	Changes     int    // Times the serial changed from the previous answer
+	Behind      int    // Answers with an older serial than the one of another resolver at the same check
+}
+
+// MeanLatency returns the mean time spent per check, in milliseconds
+func (c SOACounts) MeanLatency() float64 {
+	if c.Checks == 0 {
+		return 0
+	}
+	return 1000. * c.Elapsed.Seconds() / float64(c.Checks)
+}
+
+// answered tells whether the serials are set
+func (c SOACounts) answered() bool {
+	return c.Checks > c.Errors
+}
+
+// addSOA merges the checks of an interval into the aggregated ones, by zone then resolver
+func addSOA(total map[string]map[string]SOACounts, added map[string]map[string]SOACounts) {
+	for zone, resolvers := range added {
+		if total[zone] == nil {
+			total[zone] = make(map[string]SOACounts)
+		}
+		for resolver, counts := range resolvers {
+			current := total[zone][resolver]
+			if counts.answered() {
+				if !current.answered() {
+					current.FirstSerial = counts.FirstSerial
+				}
+				current.Serial = counts.Serial
+			}
+			current.Checks += counts.Checks
+			current.Errors += counts.Errors
+			current.Elapsed += counts.Elapsed
+			if counts.MaxElapsed > current.MaxElapsed {
+				current.MaxElapsed = counts.MaxElapsed
+			}
+			current.Changes += counts.Changes
+			current.Behind += counts.Behind
+			total[zone][resolver] = current
+		}
+	}
+}
+
+// serialNewer compares two serials with the arithmetic of RFC 1982, as they wrap around
+func serialNewer(serial, than uint32) bool {
+	return int32(serial-than) > 0
+}
+
+// soaCheck is the outcome of the check of a zone on a resolver
+type soaCheck struct {
+	zone     string
+	resolver string
+	serial   uint32
+	elapsed  time.Duration
+	err      error
+}
+
+// monitorSOA checks the SOA records of the zones on each resolver at the start of the run, then
+// every SOAInterval, and reports them to the aggregation until the run is done
+func (r *Runner) monitorSOA(channel chan<- statsMessage, done <-chan struct{}) {
+	var resolvers []string
+	if r.cfg.DOHEndpoint != "" {
+		resolvers = []string{r.cfg.DOHEndpoint}
+	} else {
+		// A resolver listed several times is checked once
+		seen := make(map[string]bool)
+		for _, resolver := range r.resolvers {
+			if !seen[resolver] {
+				seen[resolver] = true
+				resolvers = append(resolvers, resolver)
+			}
+		}
+	}
+	serials := make(map[string]uint32) // Last serial by zone and resolver
+	ticker := time.NewTicker(r.cfg.SOAInterval)
+	defer ticker.Stop()
+	for {
+		checks := make([]soaCheck, 0, len(r.cfg.SOAZones)*len(resolvers))
+		for _, zone := range r.cfg.SOAZones {
+			for _, resolver := range resolvers {
+				checks = append(checks, soaCheck{zone: zone, resolver: resolver})
+			}
+		}
+		var wg sync.WaitGroup
+		wg.Add(len(checks))
+		for i := range checks {
+			go func(check *soaCheck) {
+				defer wg.Done()
+				r.checkSOA(check)
+			}(&checks[i])
+		}
+		wg.Wait()
+
+		select {
+		case channel <- statsMessage{bySOA: r.countSOA(checks, serials)}:
+		case <-done:
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// checkSOA queries the SOA record of a zone on its own connection, or stream with DOH and DoQ
+func (r *Runner) checkSOA(check *soaCheck) {
+	message := new(dns.Msg).SetQuestion(check.zone, dns.TypeSOA)
+	start := time.Now()
+	var response *dns.Msg
+	var err error
+	if r.cfg.DOHEndpoint != "" || r.cfg.DoQ {
+		response, err = r.exchange(0, check.resolver, message)
+	} else {
+		response, err = r.exchangeOver(r.transportNetwork(), 0, check.resolver, message)
+	}
+	check.elapsed = time.Since(start)
+	if err != nil {
+		check.err = err
+		return
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		check.err = fmt.Errorf("got %s", rcodeName(response.Rcode))
+		return
+	}
+	for _, record := range response.Answer {
+		if soa, ok := record.(*dns.SOA); ok {
+			check.serial = soa.Serial
+			return
+		}
+	}
+	check.err = fmt.Errorf("no SOA record in the answer")
+}
+
+// countSOA turns the checks into counts, comparing the serials with the previous ones and with
+// the ones of the other resolvers
+func (r *Runner) countSOA(checks []soaCheck, serials map[string]uint32) map[string]map[string]SOACounts {
+	newest := make(map[string]uint32)
+	for _, check := range checks {
+		if latest, ok := newest[check.zone]; check.err == nil && (!ok || serialNewer(check.serial, latest)) {
+			newest[check.zone] = check.serial
+		}
+	}
+	bySOA := make(map[string]map[string]SOACounts)
+	for _, check := range checks {
+		if bySOA[check.zone] == nil {
+			bySOA[check.zone] = make(map[string]SOACounts)
+		}
+		counts := SOACounts{Checks: 1, Elapsed: check.elapsed, MaxElapsed: check.elapsed}
+		if check.err != nil {
+			counts.Errors = 1
+			r.cfg.logf("%s SOA error: %s (%s)", check.zone, check.err, check.resolver)
+		} else {
+			counts.FirstSerial = check.serial
+			counts.Serial = check.serial
+			key := check.zone + " " + check.resolver
+			if previous, ok := serials[key]; ok && previous != check.serial {
+				counts.Changes = 1
+				counts.FirstSerial = previous
+				r.cfg.logf("%s serial changed from %d to %d (%s)", check.zone, previous, check.serial, check.resolver)
+			}
+			serials[key] = check.serial
+			if serialNewer(newest[check.zone], check.serial) {
+				counts.Behind = 1
+			}
+		}
+		bySOA[check.zone][check.resolver] = counts
+	}
+	return bySOA
+}
@@ -0,0 +1,65 @@
+package stress
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSerialNewer(t *testing.T) {
+	for _, test := range []struct {
+		serial, than uint32
+		expected     bool
+	}{
+		{2, 1, true},
+		{1, 2, false},
+		{1, 1, false},
+		{0, 0xffffffff, true}, // Wrapped around
+		{0xffffffff, 0, false},
+	} {
+		if newer := serialNewer(test.serial, test.than); newer != test.expected {
+			t.Errorf("Expected %v for %d newer than %d, got %v", test.expected, test.serial, test.than, newer)
+		}
+	}
+}
+
+func TestCountSOA(t *testing.T) {
+	r := &Runner{}
+	serials := make(map[string]uint32)
+	checks := []soaCheck{
+		{zone: "example.com.", resolver: "a", serial: 10, elapsed: time.Millisecond},
+		{zone: "example.com.", resolver: "b", serial: 10, elapsed: 3 * time.Millisecond},
+	}
+	first := r.countSOA(checks, serials)
+	if counts := first["example.com."]["a"]; counts.Checks != 1 || counts.Changes != 0 || counts.Behind != 0 || counts.Serial != 10 {
+		t.Errorf("Unexpected counts for the first check: %+v", counts)
+	}
+
+	checks[0].serial = 11
+	checks[1].err = errors.New("timeout")
+	second := r.countSOA(checks, serials)
+	if counts := second["example.com."]["a"]; counts.Changes != 1 || counts.FirstSerial != 10 || counts.Serial != 11 {
+		t.Errorf("Expected a change from 10 to 11, got %+v", counts)
+	}
+	if counts := second["example.com."]["b"]; counts.Errors != 1 || counts.Behind != 0 {
+		t.Errorf("Expected an error, got %+v", counts)
+	}
+
+	checks[1].err = nil
+	third := r.countSOA(checks, serials)
+	if counts := third["example.com."]["b"]; counts.Behind != 1 || counts.Changes != 0 {
+		t.Errorf("Expected b to be behind a, got %+v", counts)
+	}
+
+	total := make(map[string]map[string]SOACounts)
+	for _, interval := range []map[string]map[string]SOACounts{first, second, third} {
+		addSOA(total, interval)
+	}
+	a, b := total["example.com."]["a"], total["example.com."]["b"]
+	if a.Checks != 3 || a.Changes != 1 || a.FirstSerial != 10 || a.Serial != 11 || a.MaxElapsed != time.Millisecond {
+		t.Errorf("Unexpected totals for a: %+v", a)
+	}
+	if b.Checks != 3 || b.Errors != 1 || b.Behind != 1 || b.Serial != 10 || b.MeanLatency() != 3 {
+		t.Errorf("Unexpected totals for b: %+v", b)
+	}
+}
@@ -0,0 +1,425 @@
+package stress
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/miekg/dns"
+)
+
+type statsMessage struct {
+	sent              int
+	received          int
+	err               int
+	firstErr          int // Queries that failed on their first attempt, before retrying
+	retries           int
+	invalid           int            // Answers failing DNSSEC validation
+	mismatches        int            // Answers different from the expected ones
+	caseMismatches    int            // Answers not keeping the case of the query name
+	truncated         int            // Answers with the TC bit set
+	tcpFallbacks      int            // Queries sent again over TCP after a truncated answer
+	transferRecords   int            // Records received in the zone transfers
+	byOutcome         map[string]int // Response codes, timeouts and network errors
+	flush             bool
+	final             bool
+	warmupOver        bool // The flush ends the warmup
+	elapsed           time.Duration
+	maxElapsed        time.Duration
+	byType            map[uint16]QueryCounts // Only filled when several query types are used
+	byResolver        map[string]QueryCounts // Only filled when several resolvers are used
+	byDomain          map[string]QueryCounts // Only filled when several target domains are used
+	byUpdate          map[string]QueryCounts // Only filled when sending updates
+	byAmplification   map[string]AmplificationCounts
+	byFuzz            map[string]map[string]int // Outcomes of the fuzzed queries, by mutation
+	byCache           map[string]CacheCounts    // Only filled with TrackTTL, by target domain
+	latencies         []time.Duration
+	responseSizes     []int
+	compared          *Comparison // Only set when comparing with another resolver, without the latencies
+	comparedLatencies []time.Duration
+	bySOA             map[string]map[string]SOACounts // Checks of the SOA records, by zone then resolver
+}
+
+// QueryCounts are the statistics of a subset of the queries (e.g. of a query type)
+type QueryCounts struct {
+	Sent    int
+	Errors  int
+	Elapsed time.Duration
+}
+
+// addCounts merges the counts of an interval into the aggregated ones
+func addCounts[K comparable](total map[K]QueryCounts, added map[K]QueryCounts) {
+	for key, counts := range added {
+		current := total[key]
+		current.Sent += counts.Sent
+		current.Errors += counts.Errors
+		current.Elapsed += counts.Elapsed
+		total[key] = current
+	}
+}
+
+// Outcomes of the queries that did not get an answer, next to the response codes
+const (
+	outcomeTimeout      = "timeout"
+	outcomeNetworkError = "network error"
+	outcomeBadSignature = "bad signature"
+)
+
+// queryOutcome returns the response code of an answer, or the kind of error that prevented
+// getting one
+func queryOutcome(response *dns.Msg, err error) string {
+	if err != nil {
+		if errors.Is(err, errInvalidSignature) {
+			return outcomeBadSignature
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return outcomeTimeout
+		}
+		return outcomeNetworkError
+	}
+	return rcodeName(response.Rcode)
+}
+
+// QueryEvent describes a query sent by a thread, and how it went
+type QueryEvent struct {
+	Start     time.Time
+	Latency   time.Duration
+	Name      string
+	Type      uint16
+	Resolver  string // Address of the resolver, or the DOH endpoint
+	Transport string // e.g. "UDP", "TLS/IPv6" or "DOH"
+	Outcome   string // Response code, or why no answer came (e.g. "timeout")
+	Err       error  // Set for the failed queries, including the answers counted as errors
+}
+
+// failedRcode tells whether a response code means that the resolver did not resolve the query,
+// which is counted as an error
+func failedRcode(rcode int) bool {
+	return rcode == dns.RcodeServerFailure || rcode == dns.RcodeRefused
+}
+
+// SortedOutcomes returns the outcomes of a breakdown: the response codes by value, then the errors
+func SortedOutcomes(byOutcome map[string]int) []string {
+	rank := func(outcome string) int {
+		switch outcome {
+		case outcomeTimeout:
+			return 1 << 16
+		case outcomeNetworkError:
+			return 1<<16 + 1
+		case outcomeBadSignature:
+			return 1<<16 + 2
+		}
+		if rcode, ok := dns.StringToRcode[outcome]; ok {
+			return rcode
+		}
+		return 1<<16 - 1
+	}
+	outcomes := make([]string, 0, len(byOutcome))
+	for outcome := range byOutcome {
+		outcomes = append(outcomes, outcome)
+	}
+	sort.Slice(outcomes, func(i, j int) bool {
+		if rank(outcomes[i]) != rank(outcomes[j]) {
+			return rank(outcomes[i]) < rank(outcomes[j])
+		}
+		return outcomes[i] < outcomes[j]
+	})
+	return outcomes
+}
+
+// SortedTypes returns the record types of a breakdown, in ascending order
+func SortedTypes(byType map[uint16]QueryCounts) []uint16 {
+	qtypes := make([]uint16, 0, len(byType))
+	for qtype := range byType {
+		qtypes = append(qtypes, qtype)
+	}
+	sort.Slice(qtypes, func(i, j int) bool { return qtypes[i] < qtypes[j] })
+	return qtypes
+}
+
+// Stats aggregates the statistics over a period of time: an interval, or the whole run for the
+// summary. While flooding, the errors are the queries left unanswered.
+type Stats struct {
+	Sent            int
+	Received        int
+	Errors          int
+	FirstErrors     int // Queries that failed on their first attempt, before retrying
+	Retries         int
+	Invalid         int            // Answers failing DNSSEC validation
+	Mismatches      int            // Answers different from the expected ones
+	CaseMismatches  int            // Answers not keeping the case of the query name, with RandomCase
+	Truncated       int            // Answers with the TC bit set
+	TCPFallbacks    int            // Queries sent again over TCP after a truncated answer, with RetryTCP
+	TransferRecords int            // Records received in the zone transfers
+	ByOutcome       map[string]int // Response codes, timeouts and network errors
+	ByProtocol      map[string]int // Answers of the DOH endpoint by HTTP version (e.g. "HTTP/2")
+	Elapsed         time.Duration  // Time spent waiting for the answers
+	MaxElapsed      time.Duration
+	ByType          map[uint16]QueryCounts // Only filled when several query types are used
+	ByResolver      map[string]QueryCounts // Only filled when several resolvers are used
+	ByDomain        map[string]QueryCounts // Only filled when several target domains are used
+	ByUpdate        map[string]QueryCounts // Only filled when sending updates, by operation (UpdateAdd or UpdateDelete)
+	// ByAmplification is only filled with Amplification, by name and type (e.g. "example.com./ANY")
+	ByAmplification map[string]AmplificationCounts
+	// BySOA are the checks of the SOA records of the SOAZones, by zone then resolver (or DOH
+	// endpoint). They are not part of the other stats.
+	BySOA map[string]map[string]SOACounts
+	// ByFuzz counts how the resolvers reacted to the malformed queries sent with Fuzz, by mutation
+	// then by response code or fuzz outcome (e.g. "dropped" or "reset"). They are not part of the
+	// other stats.
+	ByFuzz        map[string]map[string]int
+	ByCache       map[string]CacheCounts  // Only filled with TrackTTL, by target domain
+	Latency       *hdrhistogram.Histogram `json:"-"` // Latencies of the answers, in microseconds
+	ResponseSizes *hdrhistogram.Histogram `json:"-"` // Wire sizes of the answers, in bytes
+	Compared      *Comparison             // Only set when comparing with another resolver
+	Duration      time.Duration
+	Warmup        bool // The interval is part of the warmup, it is not part of the summary
+	// Threads are the ones sending queries during an interval with AutoConcurrency, or the ones
+	// of the best rate for the summary
+	Threads int
+	// TargetRate is the rate tried during an interval by the search of the maximum rate, whose
+	// outcome is only set for the summary
+	TargetRate int
+	Search     *RateSearch
+	flood      bool
+}
+
+func newStats(flood bool) *Stats {
+	return &Stats{
+		ByType:          make(map[uint16]QueryCounts),
+		ByResolver:      make(map[string]QueryCounts),
+		ByDomain:        make(map[string]QueryCounts),
+		ByUpdate:        make(map[string]QueryCounts),
+		ByOutcome:       make(map[string]int),
+		ByProtocol:      make(map[string]int),
+		Latency:         newLatencyHistogram(),
+		ResponseSizes:   newSizeHistogram(),
+		ByAmplification: make(map[string]AmplificationCounts),
+		ByFuzz:          make(map[string]map[string]int),
+		ByCache:         make(map[string]CacheCounts),
+		BySOA:           make(map[string]map[string]SOACounts),
+		flood:           flood,
+	}
+}
+
+// add accounts for the queries reported by a thread
+func (s *Stats) add(message statsMessage) {
+	s.Sent += message.sent
+	s.Received += message.received
+	s.Errors += message.err
+	s.FirstErrors += message.firstErr
+	s.Retries += message.retries
+	s.Invalid += message.invalid
+	s.Mismatches += message.mismatches
+	s.CaseMismatches += message.caseMismatches
+	s.Truncated += message.truncated
+	s.TCPFallbacks += message.tcpFallbacks
+	s.TransferRecords += message.transferRecords
+	for outcome, n := range message.byOutcome {
+		s.ByOutcome[outcome] += n
+	}
+	s.Elapsed += message.elapsed
+	if message.maxElapsed > s.MaxElapsed {
+		s.MaxElapsed = message.maxElapsed
+	}
+	addCounts(s.ByType, message.byType)
+	addCounts(s.ByResolver, message.byResolver)
+	addCounts(s.ByDomain, message.byDomain)
+	addCounts(s.ByUpdate, message.byUpdate)
+	addAmplification(s.ByAmplification, message.byAmplification)
+	addFuzz(s.ByFuzz, message.byFuzz)
+	addCache(s.ByCache, message.byCache)
+	addSOA(s.BySOA, message.bySOA)
+	for _, spent := range message.latencies {
+		recordLatency(s.Latency, spent)
+	}
+	for _, size := range message.responseSizes {
+		s.ResponseSizes.RecordValue(int64(size))
+	}
+	if message.compared != nil {
+		if s.Compared == nil {
+			s.Compared = newComparison()
+		}
+		s.Compared.merge(message.compared)
+		for _, spent := range message.comparedLatencies {
+			recordLatency(s.Compared.Latency, spent)
+		}
+	}
+}
+
+// Timeouts returns the number of queries that got no answer in time, which are counted as errors
+func (s *Stats) Timeouts() int {
+	return s.ByOutcome[outcomeTimeout]
+}
+
+// merge adds the statistics of another period
+func (s *Stats) merge(other *Stats) {
+	s.Sent += other.Sent
+	s.Received += other.Received
+	s.Errors += other.Errors
+	s.FirstErrors += other.FirstErrors
+	s.Retries += other.Retries
+	s.Invalid += other.Invalid
+	s.Mismatches += other.Mismatches
+	s.CaseMismatches += other.CaseMismatches
+	s.Truncated += other.Truncated
+	s.TCPFallbacks += other.TCPFallbacks
+	s.TransferRecords += other.TransferRecords
+	for outcome, n := range other.ByOutcome {
+		s.ByOutcome[outcome] += n
+	}
+	for protocol, n := range other.ByProtocol {
+		s.ByProtocol[protocol] += n
+	}
+	s.Elapsed += other.Elapsed
+	if other.MaxElapsed > s.MaxElapsed {
+		s.MaxElapsed = other.MaxElapsed
+	}
+	addCounts(s.ByType, other.ByType)
+	addCounts(s.ByResolver, other.ByResolver)
+	addCounts(s.ByDomain, other.ByDomain)
+	addCounts(s.ByUpdate, other.ByUpdate)
+	addAmplification(s.ByAmplification, other.ByAmplification)
+	addFuzz(s.ByFuzz, other.ByFuzz)
+	addCache(s.ByCache, other.ByCache)
+	addSOA(s.BySOA, other.BySOA)
+	s.Latency.Merge(other.Latency)
+	s.ResponseSizes.Merge(other.ResponseSizes)
+	if other.Compared != nil {
+		if s.Compared == nil {
+			s.Compared = newComparison()
+		}
+		s.Compared.merge(other.Compared)
+	}
+}
+
+func (s *Stats) reset() {
+	latency := s.Latency
+	latency.Reset()
+	responseSizes := s.ResponseSizes
+	responseSizes.Reset()
+	*s = Stats{
+		ByType:          make(map[uint16]QueryCounts),
+		ByResolver:      make(map[string]QueryCounts),
+		ByDomain:        make(map[string]QueryCounts),
+		ByUpdate:        make(map[string]QueryCounts),
+		ByOutcome:       make(map[string]int),
+		ByProtocol:      make(map[string]int),
+		Latency:         latency,
+		ResponseSizes:   responseSizes,
+		ByAmplification: make(map[string]AmplificationCounts),
+		ByFuzz:          make(map[string]map[string]int),
+		ByCache:         make(map[string]CacheCounts),
+		BySOA:           make(map[string]map[string]SOACounts),
+		flood:           s.flood,
+	}
+}
+
+// MeanLatency returns the mean time spent per query, in milliseconds
+func (s *Stats) MeanLatency() float64 {
+	measured := s.Sent
+	if s.flood {
+		// Only the answered queries have a latency
+		measured = s.Received
+	}
+	if measured == 0 {
+		return 0
+	}
+	return 1000. * s.Elapsed.Seconds() / float64(measured)
+}
+
+// aggregate collects the reports of the threads into interval stats, handed to OnInterval on
+// each flush, and returns the stats of the whole run after the final one
+func (r *Runner) aggregate(channel <-chan statsMessage) *Stats {
+	start := time.Now()
+	warming := r.cfg.Warmup > 0
+	measureStart := start // Start of the first interval after the warmup
+	interval := newStats(r.cfg.Flood)
+	totals := newStats(r.cfg.Flood)
+	for {
+		// Read the channel and add the number of sent messages
+		added := <-channel
+		interval.add(added)
+		if !added.flush {
+			continue
+		}
+
+		// Something has asked for a flush
+		interval.Duration = time.Since(start)
+		interval.Warmup = warming
+		if r.dohClient != nil {
+			r.dohProtocols.take(interval.ByProtocol)
+		}
+		if r.tuner != nil {
+			interval.Threads = r.tuner.threads()
+		}
+		if r.search != nil {
+			interval.TargetRate = r.search.rate
+		}
+		if r.cfg.OnInterval != nil {
+			r.cfg.OnInterval(interval)
+		}
+		if r.tuner != nil {
+			r.tuner.tune(interval)
+		}
+		if r.search != nil && !interval.Warmup {
+			r.search.add(interval)
+		}
+
+		start = time.Now()
+		if interval.Warmup {
+			// Intervals of the warmup are not part of the summary
+			if added.warmupOver {
+				warming = false
+				measureStart = start
+			}
+		} else {
+			totals.merge(interval)
+		}
+		interval.reset()
+
+		if added.final {
+			totals.Duration = time.Since(measureStart)
+			if r.tuner != nil {
+				totals.Threads = r.tuner.bestActive
+			}
+			if r.search != nil {
+				result := r.search.result
+				totals.Search = &result
+			}
+			return totals
+		}
+	}
+}
+
+// timerStats periodically triggers a flush of the stats, until done is closed. The interval
+// running at the end of the warmup is cut short, so that the summary starts right after it.
+func (r *Runner) timerStats(channel chan<- statsMessage, done <-chan struct{}) {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	var warmupEnd <-chan time.Time
+	if r.cfg.Warmup > 0 {
+		timer := time.NewTimer(r.cfg.Warmup)
+		defer timer.Stop()
+		warmupEnd = timer.C
+	}
+	for {
+		message := statsMessage{flush: true}
+		select {
+		case <-ticker.C:
+		case <-warmupEnd:
+			message.warmupOver = true
+			ticker.Reset(r.cfg.Interval)
+		case <-done:
+			return
+		}
+		select {
+		case channel <- message:
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package stress
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestQueryOutcome(t *testing.T) {
+	answer := new(dns.Msg)
+	answer.Rcode = dns.RcodeNameError
+	if outcome := queryOutcome(answer, nil); outcome != "NXDOMAIN" {
+		t.Errorf("Got %s, expected NXDOMAIN", outcome)
+	}
+	if outcome := queryOutcome(nil, os.ErrDeadlineExceeded); outcome != outcomeTimeout {
+		t.Errorf("Got %s, expected %s", outcome, outcomeTimeout)
+	}
+	if outcome := queryOutcome(nil, errors.New("connection refused")); outcome != outcomeNetworkError {
+		t.Errorf("Got %s, expected %s", outcome, outcomeNetworkError)
+	}
+
+	outcomes := SortedOutcomes(map[string]int{outcomeTimeout: 1, "SERVFAIL": 1, "NOERROR": 1, outcomeNetworkError: 1, "NXDOMAIN": 1})
+	expected := []string{"NOERROR", "SERVFAIL", "NXDOMAIN", outcomeTimeout, outcomeNetworkError}
+	if !reflect.DeepEqual(outcomes, expected) {
+		t.Errorf("Got %v, expected %v", outcomes, expected)
+	}
+}
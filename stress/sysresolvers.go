@@ -0,0 +1,8 @@
+//go:build !windows
+
+package stress
+
+// systemResolvers returns the name servers of /etc/resolv.conf
+func systemResolvers() ([]string, error) {
+	return parseResolvConf("/etc/resolv.conf")
+}
@@ -0,0 +1,51 @@
+//go:build windows
+
+package stress
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// systemResolvers returns the DNS servers of the network adapters that are up, as configured in
+// their IP settings
+func systemResolvers() ([]string, error) {
+	size := uint32(15000)
+	var buffer []byte
+	var adapters *windows.IpAdapterAddresses
+	for {
+		buffer = make([]byte, size)
+		adapters = (*windows.IpAdapterAddresses)(unsafe.Pointer(&buffer[0]))
+		flags := uint32(windows.GAA_FLAG_SKIP_UNICAST | windows.GAA_FLAG_SKIP_ANYCAST | windows.GAA_FLAG_SKIP_MULTICAST | windows.GAA_FLAG_SKIP_FRIENDLY_NAME)
+		err := windows.GetAdaptersAddresses(syscall.AF_UNSPEC, flags, 0, adapters, &size)
+		if err == nil {
+			break
+		}
+		if err != windows.ERROR_BUFFER_OVERFLOW {
+			return nil, os.NewSyscallError("getadaptersaddresses", err)
+		}
+	}
+
+	var servers []string
+	seen := make(map[string]bool)
+	for adapter := adapters; adapter != nil; adapter = adapter.Next {
+		if adapter.OperStatus != windows.IfOperStatusUp {
+			continue
+		}
+		for server := adapter.FirstDnsServerAddress; server != nil; server = server.Next {
+			ip := server.Address.IP()
+			// Windows lists the deprecated site-local addresses fec0:0:0:ffff::1-3 by default
+			if ip == nil || ip.IsUnspecified() || ip.To4() == nil && ip[0] == 0xfe && ip[1]&0xc0 == 0xc0 {
+				continue
+			}
+			if address := ip.String(); !seen[address] {
+				seen[address] = true
+				servers = append(servers, address)
+			}
+		}
+	}
+	return servers, nil
+}
@@ -0,0 +1,102 @@
+package stress
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// isTransfer tells whether a query type asks for a zone transfer
+func isTransfer(qtype uint16) bool {
+	return qtype == dns.TypeAXFR || qtype == dns.TypeIXFR
+}
+
+// checkTransfers verifies that the options of a run apply to zone transfers, made when the
+// query types are AXFR or IXFR
+func checkTransfers(cfg *Config, queryTypes []uint16) (bool, error) {
+	transfers := 0
+	for _, qtype := range queryTypes {
+		if isTransfer(qtype) {
+			transfers++
+		}
+	}
+	switch {
+	case transfers == 0:
+		return false, nil
+	case transfers < len(queryTypes):
+		return false, fmt.Errorf("zone transfers cannot be mixed with other query types")
+	case cfg.QueryFile != "" || cfg.Replay != "" || len(cfg.ReverseRanges) > 0:
+		return false, fmt.Errorf("the zones to transfer are the target domains, not the names of a query file, capture or reverse ranges")
+	case cfg.Flood || cfg.Update:
+		return false, fmt.Errorf("zone transfers cannot be made when flooding or sending updates")
+	case cfg.DoQ || cfg.DOHEndpoint != "" || cfg.RetryTCP:
+		return false, fmt.Errorf("zone transfers are only made over TCP or TLS")
+	case cfg.CompareResolver != "" || cfg.Amplification:
+		return false, fmt.Errorf("zone transfers cannot be compared with another server or measured for amplification")
+	case cfg.RandomCase || cfg.DNSSECValidate || cfg.Expect != nil:
+		return false, fmt.Errorf("the records of zone transfers cannot be checked")
+	}
+	return true, nil
+}
+
+// Transfers tells whether the queries are zone transfers of the target domains, with the AXFR or
+// IXFR query types
+func (r *Runner) Transfers() bool {
+	return r.transfers
+}
+
+// setTransferSerial sets the SOA record of the serial an IXFR query asks the changes from
+func (c *Config) setTransferSerial(message *dns.Msg) {
+	question := message.Question[0]
+	if question.Qtype != dns.TypeIXFR {
+		message.Ns = nil
+		return
+	}
+	message.Ns = []dns.RR{&dns.SOA{
+		Hdr:    dns.RR_Header{Name: question.Name, Rrtype: dns.TypeSOA, Class: dns.ClassINET},
+		Ns:     ".",
+		Mbox:   ".",
+		Serial: c.TransferSerial,
+	}}
+}
+
+// transfer makes a zone transfer from a server on a new connection, and returns all the records
+// received as the answer of a single message
+func (r *Runner) transfer(threadID int, server string, message *dns.Msg) (*dns.Msg, error) {
+	conn, err := r.dialResolver(r.transportNetwork(), server, r.source(threadID, server))
+	if err != nil {
+		return nil, err
+	}
+	transfer := &dns.Transfer{Conn: &dns.Conn{Conn: conn}, ReadTimeout: r.cfg.Timeout, WriteTimeout: r.cfg.Timeout}
+	if key := r.cfg.TSIG; key != nil {
+		if message.IsTsig() != nil {
+			message.Extra = message.Extra[:len(message.Extra)-1]
+		}
+		message.SetTsig(key.Name, key.Algorithm, tsigFudge, time.Now().Unix())
+		transfer.TsigSecret = map[string]string{key.Name: key.Secret}
+	}
+	envelopes, err := transfer.In(message, server)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	response := new(dns.Msg).SetReply(message)
+	for envelope := range envelopes {
+		if envelope.Error != nil && err == nil {
+			err = envelope.Error
+		}
+		response.Answer = append(response.Answer, envelope.RR...)
+	}
+	if err != nil {
+		// A transfer refused by the server is answered like a query, the package only reports its
+		// response code in the error
+		var rcode int
+		if _, scanErr := fmt.Sscanf(err.Error(), "dns: bad xfr rcode: %d", &rcode); scanErr == nil {
+			response.Rcode = rcode
+			return response, nil
+		}
+		return nil, err
+	}
+	return response, nil
+}
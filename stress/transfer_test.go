@@ -0,0 +1,41 @@
+package stress
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCheckTransfers(t *testing.T) {
+	cfg := NewConfig()
+	if transfers, err := checkTransfers(cfg, []uint16{dns.TypeA, dns.TypeAAAA}); transfers || err != nil {
+		t.Errorf("Queries should not be transfers (%v, %v)", transfers, err)
+	}
+	if transfers, err := checkTransfers(cfg, []uint16{dns.TypeAXFR, dns.TypeIXFR}); !transfers || err != nil {
+		t.Errorf("AXFR and IXFR should make transfers (%v, %v)", transfers, err)
+	}
+	if _, err := checkTransfers(cfg, []uint16{dns.TypeAXFR, dns.TypeA}); err == nil {
+		t.Errorf("Transfers should not be mixed with queries")
+	}
+	cfg.Flood = true
+	if _, err := checkTransfers(cfg, []uint16{dns.TypeAXFR}); err == nil {
+		t.Errorf("Transfers should not be made when flooding")
+	}
+}
+
+func TestSetTransferSerial(t *testing.T) {
+	cfg := NewConfig()
+	cfg.TransferSerial = 2024010101
+	message := new(dns.Msg).SetQuestion("example.com.", dns.TypeIXFR)
+	cfg.setTransferSerial(message)
+	if len(message.Ns) != 1 || message.Ns[0].(*dns.SOA).Serial != 2024010101 {
+		t.Fatalf("Unexpected authority section %v", message.Ns)
+	}
+	if _, err := message.Pack(); err != nil {
+		t.Errorf("The IXFR query should pack: %s", err)
+	}
+	message.Question[0].Qtype = dns.TypeAXFR
+	if cfg.setTransferSerial(message); message.Ns != nil {
+		t.Errorf("AXFR queries should have no authority section, got %v", message.Ns)
+	}
+}
@@ -0,0 +1,103 @@
+package stress
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// tsigFudge is the time difference allowed between the signer and the verifier, in seconds
+const tsigFudge = 300
+
+// tsigAlgorithms are the HMAC algorithms the queries can be signed with
+var tsigAlgorithms = []string{dns.HmacSHA1, dns.HmacSHA224, dns.HmacSHA256, dns.HmacSHA384, dns.HmacSHA512}
+
+// errInvalidSignature is returned for the answers not signed with the key of the queries
+var errInvalidSignature = errors.New("invalid TSIG signature")
+
+// TSIGKey is the key signing the queries (RFC 8945), the answers have to be signed with it too
+type TSIGKey struct {
+	Name      string // Fully qualified, in lower case
+	Algorithm string // e.g. "hmac-sha256."
+	Secret    string // Base64 encoded
+}
+
+// ParseTSIGKey parses a key given as "name:algorithm:secret" (e.g. "key.example.:hmac-sha256:c2VjcmV0")
+func ParseTSIGKey(input string) (*TSIGKey, error) {
+	fields := strings.SplitN(input, ":", 3)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("expected \"name:algorithm:secret\"")
+	}
+	key := &TSIGKey{
+		Name:      dns.CanonicalName(fields[0]),
+		Algorithm: dns.CanonicalName(fields[1]),
+		Secret:    fields[2],
+	}
+	if _, ok := dns.IsDomainName(key.Name); !ok || key.Name == "." {
+		return nil, fmt.Errorf("invalid key name %q", fields[0])
+	}
+	supported := false
+	for _, algorithm := range tsigAlgorithms {
+		supported = supported || key.Algorithm == algorithm
+	}
+	if !supported {
+		return nil, fmt.Errorf("unsupported algorithm %q (expected hmac-sha1, hmac-sha224, hmac-sha256, hmac-sha384 or hmac-sha512)", fields[1])
+	}
+	if _, err := base64.StdEncoding.DecodeString(key.Secret); err != nil || key.Secret == "" {
+		return nil, fmt.Errorf("invalid secret, expected it base64 encoded")
+	}
+	return key, nil
+}
+
+// pack packs a query signed with the key, and returns the MAC of the signature the answer has
+// to be signed with. Without a key, the query is only packed.
+func (k *TSIGKey) pack(message *dns.Msg) ([]byte, string, error) {
+	if k == nil {
+		packed, err := message.Pack()
+		return packed, "", err
+	}
+	if message.IsTsig() != nil {
+		message.Extra = message.Extra[:len(message.Extra)-1]
+	}
+	// The signature covers the ID and the time, it is made again for each attempt
+	message.SetTsig(k.Name, k.Algorithm, tsigFudge, time.Now().Unix())
+	return dns.TsigGenerate(message, k.Secret, "", false)
+}
+
+// unpack parses an answer, and verifies its signature when there is a key. The answer is still
+// returned along with an invalid signature error.
+func (k *TSIGKey) unpack(answer []byte, mac string) (*dns.Msg, error) {
+	response := new(dns.Msg)
+	if err := response.Unpack(answer); err != nil {
+		return nil, err
+	}
+	if k != nil {
+		if err := dns.TsigVerify(answer, k.Secret, mac, false); err != nil {
+			return response, fmt.Errorf("%w: %s", errInvalidSignature, err)
+		}
+	}
+	return response, nil
+}
+
+// writeQuery sends a query on a connection, and returns the MAC of its signature
+func (k *TSIGKey) writeQuery(co *dns.Conn, message *dns.Msg) (string, error) {
+	packed, mac, err := k.pack(message)
+	if err != nil {
+		return "", err
+	}
+	_, err = co.Write(packed)
+	return mac, err
+}
+
+// readAnswer reads an answer from a connection, and verifies its signature
+func (k *TSIGKey) readAnswer(co *dns.Conn, mac string) (*dns.Msg, error) {
+	answer, err := co.ReadMsgHeader(nil)
+	if err != nil {
+		return nil, err
+	}
+	return k.unpack(answer, mac)
+}
@@ -0,0 +1,72 @@
+package stress
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseTSIGKey(t *testing.T) {
+	key, err := ParseTSIGKey("Key.Example:HMAC-SHA256:c2VjcmV0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := TSIGKey{Name: "key.example.", Algorithm: dns.HmacSHA256, Secret: "c2VjcmV0"}
+	if *key != expected {
+		t.Errorf("Got %+v, expected %+v", *key, expected)
+	}
+
+	for _, input := range []string{"key.example.:c2VjcmV0", "key.example.:hmac-md5:c2VjcmV0", "key.example.:hmac-sha1:not base64!", ".:hmac-sha1:c2VjcmV0"} {
+		if _, err := ParseTSIGKey(input); err == nil {
+			t.Errorf("Parsing %q should fail", input)
+		}
+	}
+}
+
+func TestTSIGSignature(t *testing.T) {
+	key := &TSIGKey{Name: "key.example.", Algorithm: dns.HmacSHA256, Secret: "c2VjcmV0"}
+	query := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	query.SetEdns0(1232, false)
+
+	// Each attempt is signed again, without piling up the TSIG records
+	var mac string
+	for attempt := 0; attempt < 2; attempt++ {
+		packed, signature, err := key.pack(query)
+		if err != nil {
+			t.Fatalf("Unable to sign the query: %s", err)
+		}
+		if err := dns.TsigVerify(packed, key.Secret, "", false); err != nil {
+			t.Fatalf("Invalid signature of the query: %s", err)
+		}
+		if len(query.Extra) != 1 {
+			t.Fatalf("The query has %d additional records, expected only the OPT one", len(query.Extra))
+		}
+		mac = signature
+	}
+
+	answer := func(secret string) []byte {
+		response := new(dns.Msg).SetReply(query)
+		response.SetTsig(key.Name, key.Algorithm, tsigFudge, time.Now().Unix())
+		packed, _, err := dns.TsigGenerate(response, secret, mac, false)
+		if err != nil {
+			t.Fatalf("Unable to sign the answer: %s", err)
+		}
+		return packed
+	}
+	if _, err := key.unpack(answer(key.Secret), mac); err != nil {
+		t.Errorf("Unexpected error for a signed answer: %s", err)
+	}
+	response, err := key.unpack(answer("b3RoZXI="), mac)
+	if !errors.Is(err, errInvalidSignature) || response == nil {
+		t.Errorf("Got %v for an answer signed with another key, expected an invalid signature", err)
+	}
+	if outcome := queryOutcome(response, err); outcome != outcomeBadSignature {
+		t.Errorf("Got the outcome %q, expected %q", outcome, outcomeBadSignature)
+	}
+	unsigned, _ := new(dns.Msg).SetReply(query).Pack()
+	if _, err := key.unpack(unsigned, mac); !errors.Is(err, errInvalidSignature) {
+		t.Errorf("Got %v for an unsigned answer, expected an invalid signature", err)
+	}
+}
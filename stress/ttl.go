@@ -0,0 +1,102 @@
+package stress
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// maxTrackedNames bounds the names whose TTLs are tracked, e.g. with random prefixes: the answers
+// for the other names are counted as misses
+const maxTrackedNames = 100000
+
+// CacheCounts are the answers for a target domain served from the cache of the resolver, or
+// resolved again, as inferred from their TTLs
+type CacheCounts struct {
+	Hits   int
+	Misses int
+}
+
+// HitRatio returns the share of the answers served from the cache
+func (c CacheCounts) HitRatio() float64 {
+	if c.Hits+c.Misses == 0 {
+		return 0
+	}
+	return float64(c.Hits) / float64(c.Hits+c.Misses)
+}
+
+// addCache merges the cache counts of an interval into the aggregated ones
+func addCache(total map[string]CacheCounts, added map[string]CacheCounts) {
+	for key, counts := range added {
+		current := total[key]
+		current.Hits += counts.Hits
+		current.Misses += counts.Misses
+		total[key] = current
+	}
+}
+
+// ttlTracker follows the TTLs of the answers for each name and type, shared by the threads. A
+// cache serves its entries with a TTL decaying from the one it got, so an answer with a lower TTL
+// than the highest one seen for the name is a hit. An answer with that full TTL is a miss, unless
+// it comes less than a second after the miss that got it: the TTLs are in seconds.
+type ttlTracker struct {
+	mu    sync.Mutex
+	names map[string]*ttlState
+}
+
+type ttlState struct {
+	maxTTL uint32    // Highest TTL seen, the one of the answers resolved again
+	fullAt time.Time // Last miss, which got the highest TTL
+}
+
+func newTTLTracker() *ttlTracker {
+	return &ttlTracker{names: make(map[string]*ttlState)}
+}
+
+// observe tells whether an answer was served from the cache. The first answer for a name is a
+// miss, and so are the ones without records.
+func (t *ttlTracker) observe(name string, qtype uint16, response *dns.Msg, now time.Time) bool {
+	ttl, ok := answerTTL(response)
+	if !ok {
+		return false
+	}
+	key := strings.ToLower(name) + "/" + dns.TypeToString[qtype]
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.names[key]
+	if state == nil {
+		if len(t.names) < maxTrackedNames {
+			t.names[key] = &ttlState{maxTTL: ttl, fullAt: now}
+		}
+		return false
+	}
+	if ttl < state.maxTTL {
+		return true
+	}
+	if ttl == state.maxTTL && now.Sub(state.fullAt) < time.Second {
+		return true
+	}
+	state.maxTTL = ttl
+	state.fullAt = now
+	return false
+}
+
+// answerTTL returns the lowest TTL of the records of an answer: the ones of the answer section,
+// or the SOA record of a negative answer
+func answerTTL(response *dns.Msg) (uint32, bool) {
+	records := response.Answer
+	if len(records) == 0 {
+		records = response.Ns
+	}
+	var ttl uint32
+	found := false
+	for _, record := range records {
+		if header := record.Header(); !found || header.Ttl < ttl {
+			ttl = header.Ttl
+			found = true
+		}
+	}
+	return ttl, found
+}
@@ -0,0 +1,54 @@
+package stress
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func answerWithTTL(t *testing.T, ttl string) *dns.Msg {
+	record, err := dns.NewRR("example.com. " + ttl + " IN A 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := new(dns.Msg)
+	response.Answer = []dns.RR{record}
+	return response
+}
+
+func TestTTLTracker(t *testing.T) {
+	tracker := newTTLTracker()
+	start := time.Now()
+	for _, step := range []struct {
+		ttl   string
+		after time.Duration
+		hit   bool
+	}{
+		{"300", 0, false},                       // First answer
+		{"300", 500 * time.Millisecond, true},   // Within the second of the miss
+		{"298", 2 * time.Second, true},          // Decayed
+		{"300", 3 * time.Second, false},         // Resolved again
+		{"300", 4500 * time.Millisecond, false}, // A TTL that does not decay
+		{"60", 5 * time.Second, true},
+	} {
+		if hit := tracker.observe("Example.com.", dns.TypeA, answerWithTTL(t, step.ttl), start.Add(step.after)); hit != step.hit {
+			t.Errorf("Expected hit=%v for a TTL of %s after %s", step.hit, step.ttl, step.after)
+		}
+	}
+	if tracker.observe("example.com.", dns.TypeAAAA, answerWithTTL(t, "10"), start) {
+		t.Errorf("Expected a miss for another type")
+	}
+	if tracker.observe("example.com.", dns.TypeA, new(dns.Msg), start) {
+		t.Errorf("Expected a miss for an answer without records")
+	}
+}
+
+func TestCacheCountsHitRatio(t *testing.T) {
+	if ratio := (CacheCounts{Hits: 3, Misses: 1}).HitRatio(); ratio != 0.75 {
+		t.Errorf("Unexpected ratio %v", ratio)
+	}
+	if ratio := (CacheCounts{}).HitRatio(); ratio != 0 {
+		t.Errorf("Unexpected ratio %v", ratio)
+	}
+}
@@ -0,0 +1,94 @@
+package stress
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// Operations of the UPDATE messages, by which ByUpdate breaks the stats down
+const (
+	UpdateAdd    = "add"
+	UpdateDelete = "delete"
+)
+
+// updateTTL is the TTL of the records added to the zones
+const updateTTL = 60
+
+// checkUpdates verifies that the options of a run apply to updates
+func checkUpdates(cfg *Config) error {
+	switch {
+	case cfg.QueryFile != "" || cfg.QueryPattern != "" || cfg.Replay != "":
+		return fmt.Errorf("the zones to update are the target domains, not the names of a query file, pattern or capture")
+	case cfg.Flood:
+		return fmt.Errorf("updates cannot be sent when flooding, as the records added are deleted afterwards")
+	case cfg.CompareResolver != "":
+		return fmt.Errorf("updates cannot be compared with another server")
+	case cfg.RandomCase || cfg.DNSSECValidate || cfg.Expect != nil:
+		return fmt.Errorf("the answers to updates cannot be checked")
+	}
+	return nil
+}
+
+// checkUpdateType verifies that records of a type can be generated for the updates
+func checkUpdateType(qtype uint16) error {
+	switch qtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypeTXT:
+		return nil
+	}
+	return fmt.Errorf("updates cannot add %s records (expected A, AAAA or TXT)", dns.TypeToString[qtype])
+}
+
+// updater generates the dynamic UPDATE messages (RFC 2136) of a thread: each one adds a record
+// with a random name to a zone, and the next one deletes it once it has been added
+type updater struct {
+	added []addedRecord // Records to delete, in the order they were added
+	sent  addedRecord   // Record of the last message
+	op    string        // Operation of the last message
+}
+
+type addedRecord struct {
+	zone string
+	rr   dns.RR
+}
+
+// next returns the next UPDATE message, for the given zone and record type when it adds a
+// record, and the name of this record
+func (u *updater) next(rnd *rand.Rand, zone string, qtype uint16) (*dns.Msg, string) {
+	message := new(dns.Msg)
+	if len(u.added) > 0 {
+		u.sent, u.added = u.added[0], u.added[1:]
+		u.op = UpdateDelete
+		message.SetUpdate(u.sent.zone)
+		message.Remove([]dns.RR{dns.Copy(u.sent.rr)})
+		return message, u.sent.rr.Header().Name
+	}
+
+	header := dns.RR_Header{Name: randomLabel(rnd, 12) + "." + zone, Rrtype: qtype, Class: dns.ClassINET, Ttl: updateTTL}
+	var rr dns.RR
+	switch qtype {
+	case dns.TypeAAAA:
+		address := make(net.IP, net.IPv6len)
+		copy(address, net.ParseIP("2001:db8::"))
+		rnd.Read(address[8:])
+		rr = &dns.AAAA{Hdr: header, AAAA: address}
+	case dns.TypeTXT:
+		rr = &dns.TXT{Hdr: header, Txt: []string{"dnsstresss " + randomLabel(rnd, 16)}}
+	default:
+		rr = &dns.A{Hdr: header, A: net.IPv4(192, 0, 2, byte(rnd.Intn(256)))}
+	}
+	u.sent = addedRecord{zone: zone, rr: rr}
+	u.op = UpdateAdd
+	message.SetUpdate(zone)
+	message.Insert([]dns.RR{rr})
+	return message, header.Name
+}
+
+// done accounts for the answer to the last message: the records added are deleted afterwards
+func (u *updater) done(response *dns.Msg, err error) {
+	if u.op == UpdateAdd && err == nil && response.Rcode == dns.RcodeSuccess {
+		u.added = append(u.added, u.sent)
+	}
+}
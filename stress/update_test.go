@@ -0,0 +1,50 @@
+package stress
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestUpdater(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	u := &updater{}
+
+	message, name := u.next(rnd, "example.com.", dns.TypeAAAA)
+	if u.op != UpdateAdd || message.Opcode != dns.OpcodeUpdate || message.Question[0].Name != "example.com." {
+		t.Fatalf("Expected an update of example.com. adding a record, got %s", message)
+	}
+	if !strings.HasSuffix(name, ".example.com.") || len(message.Ns) != 1 {
+		t.Fatalf("Expected one record added in example.com., got %v", message.Ns)
+	}
+	added := message.Ns[0]
+	if added.Header().Name != name || added.Header().Rrtype != dns.TypeAAAA || added.Header().Class != dns.ClassINET {
+		t.Errorf("Unexpected record added: %s", added)
+	}
+
+	// A failed addition is not deleted
+	u.done(new(dns.Msg).SetRcode(message, dns.RcodeRefused), nil)
+	u.next(rnd, "example.com.", dns.TypeA)
+	if u.op != UpdateAdd {
+		t.Errorf("Got a %s after a failed addition, expected another addition", u.op)
+	}
+	u.done(new(dns.Msg).SetReply(message), nil)
+	deletion, deleted := u.next(rnd, "other.example.", dns.TypeA)
+	if u.op != UpdateDelete || deletion.Question[0].Name != "example.com." {
+		t.Fatalf("Expected an update of example.com. deleting a record, got %s", deletion)
+	}
+	if header := deletion.Ns[0].Header(); header.Name != deleted || header.Class != dns.ClassNONE || header.Ttl != 0 {
+		t.Errorf("Unexpected record deleted: %s", deletion.Ns[0])
+	}
+	u.done(new(dns.Msg).SetReply(deletion), nil)
+	u.next(rnd, "example.com.", dns.TypeTXT)
+	if u.op != UpdateAdd {
+		t.Errorf("Got a %s after the deletion, expected an addition", u.op)
+	}
+
+	if err := checkUpdateType(dns.TypeMX); err == nil {
+		t.Errorf("Updates should not add MX records")
+	}
+}
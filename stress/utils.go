@@ -0,0 +1,264 @@
+package stress
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ParseIPPort returns a valid string that can be passed to net.Dial, containing both the IP
+// address and the port number.
+func ParseIPPort(input string) (string, error) {
+	return ParseIPPortWithDefault(input, "53")
+}
+
+// ParseIPPortWithDefault is like ParseIPPort, using the given port when the input has none.
+func ParseIPPortWithDefault(input string, defaultPort string) (string, error) {
+	if ip := net.ParseIP(input); ip != nil {
+		// A "pure" IP was passed, with no port number (or name)
+		return net.JoinHostPort(ip.String(), defaultPort), nil
+	}
+	if strings.HasPrefix(input, "[") && strings.HasSuffix(input, "]") {
+		// A bracketed IPv6 address, with no port number
+		if ip := net.ParseIP(input[1 : len(input)-1]); ip != nil {
+			return net.JoinHostPort(ip.String(), defaultPort), nil
+		}
+		return input, fmt.Errorf("invalid IPv6 address %s", input)
+	}
+	// Input has both address and port
+	host, port, err := net.SplitHostPort(input)
+	if err != nil {
+		return input, fmt.Errorf("%v (expected IP, IP:port or [IPv6]:port)", err)
+	}
+	if portNumber, err := strconv.ParseUint(port, 10, 16); err != nil || portNumber == 0 {
+		return input, fmt.Errorf("invalid port %q in address %s", port, input)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// ParseSourceAddr returns the local address to bind to, from either a bare IP address or an
+// IP:port pair. When no port is given, the system picks an ephemeral one.
+func ParseSourceAddr(input string) (*net.UDPAddr, error) {
+	if ip := net.ParseIP(input); ip != nil {
+		return &net.UDPAddr{IP: ip}, nil
+	}
+	host, port, err := net.SplitHostPort(input)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, &net.AddrError{Err: "invalid IP address", Addr: host}
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, &net.AddrError{Err: "invalid port", Addr: input}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(portNumber)}, nil
+}
+
+// ipVersion returns the IP version of the host of a "host:port" address, 0 for a host name
+func ipVersion(address string) int {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return 0
+	}
+	if ip.To4() != nil {
+		return 4
+	}
+	return 6
+}
+
+// ParseSourceList parses a comma-separated list of local addresses to bind to, each one being
+// an IP, an IP:port pair, or the name of a network interface standing for all its addresses
+// (except the link-local ones).
+func ParseSourceList(input string) ([]*net.UDPAddr, error) {
+	var sources []*net.UDPAddr
+	for _, element := range strings.Split(input, ",") {
+		element = strings.TrimSpace(element)
+		if element == "" {
+			continue
+		}
+		source, err := ParseSourceAddr(element)
+		if err == nil {
+			sources = append(sources, source)
+			continue
+		}
+		iface, ifaceErr := net.InterfaceByName(element)
+		if ifaceErr != nil {
+			// Not an interface either, the address was meant
+			return nil, err
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, addr := range addrs {
+			network, ok := addr.(*net.IPNet)
+			if !ok || network.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			sources = append(sources, &net.UDPAddr{IP: network.IP})
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("interface %s has no usable address", element)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("empty list")
+	}
+	return sources, nil
+}
+
+// ParseWeightedList parses a comma-separated list of "item:weight" elements. The weight is
+// optional and defaults to 1.
+func ParseWeightedList(input string) ([]string, []int, error) {
+	var items []string
+	var weights []int
+	for _, element := range strings.Split(input, ",") {
+		element = strings.TrimSpace(element)
+		if element == "" {
+			continue
+		}
+		weight := 1
+		if separator := strings.LastIndex(element, ":"); separator >= 0 {
+			parsed, err := strconv.Atoi(element[separator+1:])
+			if err != nil || parsed <= 0 {
+				return nil, nil, fmt.Errorf("invalid weight in %q", element)
+			}
+			weight = parsed
+			element = element[:separator]
+		}
+		if element == "" {
+			return nil, nil, fmt.Errorf("missing value in %q", input)
+		}
+		items = append(items, element)
+		weights = append(weights, weight)
+	}
+	if len(items) == 0 {
+		return nil, nil, fmt.Errorf("empty list")
+	}
+	return items, weights, nil
+}
+
+// weightedChoice picks indexes at random, proportionally to their weights
+type weightedChoice struct {
+	cumulative []int
+}
+
+func newWeightedChoice(weights []int) weightedChoice {
+	cumulative := make([]int, len(weights))
+	total := 0
+	for i, weight := range weights {
+		total += weight
+		cumulative[i] = total
+	}
+	return weightedChoice{cumulative: cumulative}
+}
+
+// pick returns a random index; the random generator is passed so that each thread can use its own
+func (w weightedChoice) pick(rnd *rand.Rand) int {
+	if len(w.cumulative) == 1 {
+		return 0
+	}
+	target := rnd.Intn(w.cumulative[len(w.cumulative)-1])
+	// Binary search of the first cumulative weight above the target
+	low, high := 0, len(w.cumulative)-1
+	for low < high {
+		middle := (low + high) / 2
+		if w.cumulative[middle] > target {
+			high = middle
+		} else {
+			low = middle + 1
+		}
+	}
+	return low
+}
+
+// ValidateQueryPattern checks that a query pattern contains exactly one integer verb (%d,
+// optionally with a width such as %05d). Literal percent signs have to be escaped as %%.
+func ValidateQueryPattern(pattern string) error {
+	verbs := 0
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' {
+			continue
+		}
+		i++
+		for i < len(pattern) && pattern[i] >= '0' && pattern[i] <= '9' {
+			i++
+		}
+		if i >= len(pattern) {
+			return fmt.Errorf("incomplete verb at the end of %q", pattern)
+		}
+		switch pattern[i] {
+		case '%':
+		case 'd':
+			verbs++
+		default:
+			return fmt.Errorf("unsupported verb %%%c in %q (only %%d is allowed)", pattern[i], pattern)
+		}
+	}
+	if verbs != 1 {
+		return fmt.Errorf("%q should contain exactly one %%d verb, found %d", pattern, verbs)
+	}
+	return nil
+}
+
+// ParseQueryTypes parses a comma-separated list of record types, each one with an optional
+// weight (e.g. "A:50,AAAA:40,HTTPS:10").
+func ParseQueryTypes(input string) ([]uint16, []int, error) {
+	names, weights, err := ParseWeightedList(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	qtypes := make([]uint16, len(names))
+	for index, name := range names {
+		qtype, ok := dns.StringToType[strings.ToUpper(name)]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown record type %q", name)
+		}
+		qtypes[index] = qtype
+	}
+	return qtypes, weights, nil
+}
+
+const labelCharacters = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// randomLabel returns a random DNS label of the given length
+func randomLabel(rnd *rand.Rand, length int) string {
+	label := make([]byte, length)
+	for i := range label {
+		label[i] = labelCharacters[rnd.Intn(len(labelCharacters))]
+	}
+	return string(label)
+}
+
+// randomCase randomizes the case of the letters of a name, which resolvers have to preserve in
+// their answers (DNS 0x20)
+func randomCase(rnd *rand.Rand, name string) string {
+	mixed := []byte(name)
+	for i, char := range mixed {
+		if ('a' <= char && char <= 'z' || 'A' <= char && char <= 'Z') && rnd.Intn(2) == 0 {
+			mixed[i] = char ^ 0x20
+		}
+	}
+	return string(mixed)
+}
+
+// keepsCase tells whether the question of an answer has the name of the query, with its case
+func keepsCase(response *dns.Msg, name string) bool {
+	return len(response.Question) > 0 && response.Question[0].Name == name
+}
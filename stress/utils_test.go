@@ -0,0 +1,257 @@
+package stress
+
+import (
+	"math/rand"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseIPPort(t *testing.T) {
+	tables := []struct {
+		input    string
+		expected string
+	}{
+		// Explicit IP and port
+		{"127.0.0.1:53", "127.0.0.1:53"},
+		{"1.1.1.1:5353", "1.1.1.1:5353"},
+		// The function should add the implicit port
+		{"127.0.0.1", "127.0.0.1:53"},
+		// Should work with IPv6 addresses (with no brackets)
+		// (see https://github.com/MickaelBergem/dnsstresss/issues/3#issuecomment-160758393)
+		{"2001:4b98:dc2:45:216:3eff:fe4b:8c5b", "[2001:4b98:dc2:45:216:3eff:fe4b:8c5b]:53"},
+		{"[2001:4b98:dc2:45:216:3eff:fe4b:8c5b]:53", "[2001:4b98:dc2:45:216:3eff:fe4b:8c5b]:53"},
+		{"::1", "[::1]:53"},
+		{"[::1]:5353", "[::1]:5353"},
+		// Brackets with no port number
+		{"[::1]", "[::1]:53"},
+		// IPv6 addresses are normalized
+		{"[2001:DB8:0:0::1]:53", "[2001:db8::1]:53"},
+	}
+
+	for _, table := range tables {
+		result, _ := ParseIPPort(table.input)
+		if result != table.expected {
+			t.Errorf("Invalid parsing of input %s: got %s but expected %s", table.input, result, table.expected)
+		}
+	}
+
+	// Invalid inputs
+	for _, input := range []string{
+		"2001:4b98:dc2:45:216:3eff:fe4b:8c5b:53",
+		"[::1]:",
+		"[::1]:dns",
+		"[::1]:70000",
+		"[::1",
+		"[not-an-ip]",
+		"127.0.0.1:0",
+	} {
+		if _, err := ParseIPPort(input); err == nil {
+			t.Errorf("Invalid input %s should return a non-nil error", input)
+		}
+	}
+}
+
+func TestParseIPPortWithDefault(t *testing.T) {
+	tables := []struct {
+		input    string
+		expected string
+	}{
+		{"127.0.0.1", "127.0.0.1:853"},
+		{"::1", "[::1]:853"},
+		{"[::1]", "[::1]:853"},
+		// An explicit port is kept
+		{"127.0.0.1:53", "127.0.0.1:53"},
+	}
+
+	for _, table := range tables {
+		result, _ := ParseIPPortWithDefault(table.input, "853")
+		if result != table.expected {
+			t.Errorf("Invalid parsing of input %s: got %s but expected %s", table.input, result, table.expected)
+		}
+	}
+}
+
+func TestParseSourceAddr(t *testing.T) {
+	tables := []struct {
+		input    string
+		expected string
+	}{
+		// A bare IP gets an ephemeral port
+		{"192.0.2.1", "192.0.2.1:0"},
+		{"2001:db8::1", "[2001:db8::1]:0"},
+		// Explicit port
+		{"192.0.2.1:4242", "192.0.2.1:4242"},
+		{"[2001:db8::1]:4242", "[2001:db8::1]:4242"},
+	}
+
+	for _, table := range tables {
+		result, err := ParseSourceAddr(table.input)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %s: %s", table.input, err)
+			continue
+		}
+		if result.String() != table.expected {
+			t.Errorf("Invalid parsing of input %s: got %s but expected %s", table.input, result, table.expected)
+		}
+	}
+
+	// Invalid inputs
+	for _, input := range []string{"localhost", "localhost:53", "192.0.2.1:http", "192.0.2.1:70000"} {
+		if _, err := ParseSourceAddr(input); err == nil {
+			t.Errorf("Invalid input %s should return a non-nil error", input)
+		}
+	}
+}
+
+func TestParseWeightedList(t *testing.T) {
+	items, weights, err := ParseWeightedList("example.com:70, cdn.example.com:20,api.example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(items, []string{"example.com", "cdn.example.com", "api.example.com"}) {
+		t.Errorf("Invalid items: %v", items)
+	}
+	if !reflect.DeepEqual(weights, []int{70, 20, 1}) {
+		t.Errorf("Invalid weights: %v", weights)
+	}
+
+	// Invalid inputs
+	for _, input := range []string{"", ",", "example.com:", "example.com:0", "example.com:-3", "example.com:abc", ":10"} {
+		if _, _, err := ParseWeightedList(input); err == nil {
+			t.Errorf("Invalid input %q should return a non-nil error", input)
+		}
+	}
+}
+
+func TestWeightedChoice(t *testing.T) {
+	choice := newWeightedChoice([]int{70, 20, 10})
+	rnd := rand.New(rand.NewSource(42))
+	picked := make([]int, 3)
+	for i := 0; i < 100000; i++ {
+		picked[choice.pick(rnd)]++
+	}
+	for index, expected := range []int{70000, 20000, 10000} {
+		if picked[index] < expected*95/100 || picked[index] > expected*105/100 {
+			t.Errorf("Index %d was picked %d times, expected around %d", index, picked[index], expected)
+		}
+	}
+
+	if newWeightedChoice([]int{5}).pick(rnd) != 0 {
+		t.Error("A single choice should always be picked")
+	}
+}
+
+func TestValidateQueryPattern(t *testing.T) {
+	for _, pattern := range []string{"host-%d.zone.example.", "%d.example.com", "host-%06d.example.com", "100%%-%d.example.com"} {
+		if err := ValidateQueryPattern(pattern); err != nil {
+			t.Errorf("Pattern %q should be valid, got %s", pattern, err)
+		}
+	}
+
+	for _, pattern := range []string{"example.com", "%d-%d.example.com", "%s.example.com", "host-%", "100%%.example.com"} {
+		if err := ValidateQueryPattern(pattern); err == nil {
+			t.Errorf("Pattern %q should be invalid", pattern)
+		}
+	}
+}
+
+func TestParseQueryTypes(t *testing.T) {
+	qtypes, weights, err := ParseQueryTypes("A:50,aaaa:40,HTTPS:10")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(qtypes, []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeHTTPS}) {
+		t.Errorf("Invalid types: %v", qtypes)
+	}
+	if !reflect.DeepEqual(weights, []int{50, 40, 10}) {
+		t.Errorf("Invalid weights: %v", weights)
+	}
+
+	if _, _, err := ParseQueryTypes("A,NOPE"); err == nil {
+		t.Error("Unknown types should return a non-nil error")
+	}
+}
+
+func TestParseSourceList(t *testing.T) {
+	sources, err := ParseSourceList("192.0.2.1, 192.0.2.2:4242,2001:db8::1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var got []string
+	for _, source := range sources {
+		got = append(got, source.String())
+	}
+	if expected := "192.0.2.1:0 192.0.2.2:4242 [2001:db8::1]:0"; strings.Join(got, " ") != expected {
+		t.Errorf("Got %v, expected %s", got, expected)
+	}
+
+	// Interfaces stand for their addresses
+	interfaces, _ := net.Interfaces()
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+		sources, err := ParseSourceList(iface.Name)
+		if err != nil {
+			t.Errorf("Unexpected error parsing interface %s: %s", iface.Name, err)
+		} else if !sources[0].IP.IsLoopback() {
+			t.Errorf("Got %v for interface %s, expected loopback addresses", sources, iface.Name)
+		}
+		break
+	}
+
+	for _, input := range []string{"", "192.0.2.1,localhost", "no-such-interface0"} {
+		if _, err := ParseSourceList(input); err == nil {
+			t.Errorf("Parsing %q should fail", input)
+		}
+	}
+}
+
+func TestIPVersion(t *testing.T) {
+	tables := []struct {
+		address string
+		version int
+	}{
+		{"127.0.0.1:53", 4},
+		{"[2001:db8::1]:53", 6},
+		{"[::ffff:192.0.2.1]:53", 4},
+		{"::1", 6},
+		{"dns.example.com:53", 0},
+	}
+	for _, table := range tables {
+		if version := ipVersion(table.address); version != table.version {
+			t.Errorf("Got version %d for %s, expected %d", version, table.address, table.version)
+		}
+	}
+}
+
+func TestRandomCase(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	name := "www.example-42.com."
+	mixed := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		randomized := randomCase(rnd, name)
+		if !strings.EqualFold(randomized, name) {
+			t.Fatalf("Got %q, expected %q with another case", randomized, name)
+		}
+		mixed[randomized] = true
+
+		response := new(dns.Msg).SetQuestion(randomized, dns.TypeA)
+		if !keepsCase(response, randomized) {
+			t.Errorf("The answer for %q should keep its case", randomized)
+		}
+	}
+	if len(mixed) < 2 {
+		t.Errorf("The case of %q is not randomized", name)
+	}
+	if keepsCase(new(dns.Msg).SetQuestion("WWW.example-42.com.", dns.TypeA), "www.example-42.com.") {
+		t.Errorf("An answer with another case is accepted")
+	}
+	if keepsCase(new(dns.Msg), name) {
+		t.Errorf("An answer without a question is accepted")
+	}
+}
@@ -0,0 +1,73 @@
+package stress
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// wordlist prepends labels taken from a list of words to the target domains: unlike random
+// prefixes, the names come back, like the ones of real traffic
+type wordlist struct {
+	words []string
+	depth int // Highest number of labels prepended
+}
+
+// ParseWordlist reads the labels of a wordlist, one per line. Empty lines and the ones starting
+// with # are skipped.
+func ParseWordlist(input io.Reader) ([]string, error) {
+	var words []string
+	scanner := bufio.NewScanner(input)
+	for line := 1; scanner.Scan(); line++ {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		if labels, ok := dns.IsDomainName(word); !ok || labels != 1 || strings.HasSuffix(word, ".") || len(word) > 63 {
+			return nil, fmt.Errorf("line %d: invalid label %q", line, word)
+		}
+		words = append(words, word)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("no words")
+	}
+	return words, nil
+}
+
+// loadWordlist reads the labels of a wordlist file
+func loadWordlist(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseWordlist(file)
+}
+
+// prefix returns from one to depth words picked at random, joined as labels
+func (w *wordlist) prefix(rnd *rand.Rand) string {
+	labels := make([]string, 1+rnd.Intn(w.depth))
+	for i := range labels {
+		labels[i] = w.words[rnd.Intn(len(w.words))]
+	}
+	return strings.Join(labels, ".")
+}
+
+// longestName returns the length of the longest name the wordlist can make for a domain
+func (w *wordlist) longestName(domain string) int {
+	longest := 0
+	for _, word := range w.words {
+		if len(word) > longest {
+			longest = len(word)
+		}
+	}
+	return w.depth*(longest+1) + len(domain)
+}
@@ -0,0 +1,45 @@
+package stress
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWordlist(t *testing.T) {
+	words, err := ParseWordlist(strings.NewReader("# subdomains\nwww\n\n  mail \napi-v2\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := []string{"www", "mail", "api-v2"}; !reflect.DeepEqual(words, expected) {
+		t.Errorf("Got %v, expected %v", words, expected)
+	}
+
+	for _, input := range []string{"", "# nothing\n", "www.mail\n", "www.\n", strings.Repeat("a", 64) + "\n"} {
+		if _, err := ParseWordlist(strings.NewReader(input)); err == nil {
+			t.Errorf("Parsing %q should fail", input)
+		}
+	}
+}
+
+func TestWordlistPrefix(t *testing.T) {
+	w := &wordlist{words: []string{"www", "mail"}, depth: 3}
+	rnd := rand.New(rand.NewSource(1))
+	depths := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		labels := strings.Split(w.prefix(rnd), ".")
+		depths[len(labels)] = true
+		for _, label := range labels {
+			if label != "www" && label != "mail" {
+				t.Fatalf("Unexpected label %q", label)
+			}
+		}
+	}
+	if !reflect.DeepEqual(depths, map[int]bool{1: true, 2: true, 3: true}) {
+		t.Errorf("Expected from 1 to 3 labels, got %v", depths)
+	}
+	if length := w.longestName("example.com."); length != 3*5+12 {
+		t.Errorf("Unexpected longest name of %d bytes", length)
+	}
+}
@@ -0,0 +1,531 @@
+package stress
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// acquireQuery takes one query from the shared budget, and returns false once it is exhausted
+// or when the threads have to stop
+func (r *Runner) acquireQuery(threadID int) bool {
+	r.waitWhilePaused(threadID)
+	if atomic.LoadInt32(&r.stopRequested) != 0 {
+		return false
+	}
+	if r.cfg.Count <= 0 {
+		return true
+	}
+	return atomic.AddInt64(&r.remainingQueries, -1) >= 0
+}
+
+// expandQueryPattern returns the next query name generated from the pattern
+func (r *Runner) expandQueryPattern(rnd *mathrand.Rand) string {
+	if r.cfg.PatternRandom {
+		return fmt.Sprintf(r.cfg.QueryPattern, rnd.Int31())
+	}
+	return fmt.Sprintf(r.cfg.QueryPattern, atomic.AddUint64(&r.patternCounter, 1))
+}
+
+func (r *Runner) thread(threadID int, sentCounterCh chan<- statsMessage) {
+	// Resolve the domain as fast as possible
+	r.cfg.logf("Starting thread #%d.", threadID)
+
+	// Every N steps, we will tell the stats module how many requests we sent
+	displayStep := 5
+	randomID := make([]byte, 2) // Kept to generate the IDs without allocating
+	errors := 0
+	firstErrors := 0 // Queries that failed on their first attempt
+	retried := 0     // Additional attempts made
+	invalid := 0     // Answers failing DNSSEC validation
+	mismatches := 0  // Answers different from the expected ones
+	caseMismatches := 0
+	truncated := 0
+	tcpFallbacks := 0 // Truncated answers retried over TCP
+	transferRecords := 0
+	transport := r.queryTransport()
+	fuzzed := 0 // Malformed queries, reported apart
+
+	// Each thread uses its own random generator to pick the domains
+	rnd := mathrand.New(mathrand.NewSource(time.Now().UnixNano() + int64(threadID)))
+	pacer := r.newPacer(rnd)
+
+	message := new(dns.Msg).SetQuestion(r.domains[0], r.queryTypes[0])
+	r.cfg.setupHeader(message)
+	r.cfg.setupEDNS(message)
+	var cookies *cookieJar
+	if r.cfg.Cookies {
+		cookies = newCookieJar(rnd)
+	}
+
+	// Non-flooding threads may keep their connections to the resolvers open
+	exchange := func(address string, query *dns.Msg) (*dns.Msg, error) {
+		return r.exchange(threadID, address, query)
+	}
+	if r.transfers {
+		exchange = func(address string, query *dns.Msg) (*dns.Msg, error) {
+			return r.transfer(threadID, address, query)
+		}
+	} else if r.PersistentConnections() {
+		conns := make(map[string]*persistentConn, len(r.connected))
+		for _, address := range r.connected {
+			conn := &persistentConn{runner: r, resolver: address, source: r.source(threadID, address)}
+			if r.cachesPackedQueries() {
+				// Each connection has its own cache, as the compared resolver is queried meanwhile
+				conn.packed = newPackCache()
+			}
+			defer conn.close()
+			conns[address] = conn
+		}
+		exchange = func(address string, query *dns.Msg) (*dns.Msg, error) {
+			return conns[address].exchange(query)
+		}
+	}
+
+	var start time.Time
+	var elapsed time.Duration    // Total time spent resolving
+	var maxElapsed time.Duration // Maximum time took by a request
+
+	// Update the counter of sent requests and requests
+	var latencies []time.Duration
+	var responseSizes []int
+	var byType map[uint16]QueryCounts
+	if len(r.queryTypes) > 1 {
+		byType = make(map[uint16]QueryCounts)
+	}
+	var byResolver map[string]QueryCounts
+	if len(r.resolvers) > 1 {
+		byResolver = make(map[string]QueryCounts)
+	}
+	var byDomain map[string]QueryCounts
+	if len(r.domains) > 1 {
+		byDomain = make(map[string]QueryCounts)
+	}
+	var byAmplification map[string]AmplificationCounts
+	if r.cfg.Amplification {
+		byAmplification = make(map[string]AmplificationCounts)
+	}
+	var byUpdate map[string]QueryCounts
+	var updates *updater
+	if r.cfg.Update {
+		byUpdate = make(map[string]QueryCounts)
+		updates = &updater{}
+	}
+	var flooder *floodSender
+	if r.cfg.Flood {
+		flooder = newFloodSender(r, threadID)
+		flooder.cookies = cookies
+		if r.cachesPackedQueries() {
+			flooder.packed = newPackCache()
+		}
+	}
+	var compared *Comparison
+	var comparedLatencies []time.Duration
+	if r.cfg.CompareResolver != "" {
+		compared = &Comparison{}
+	}
+	var byCache map[string]CacheCounts
+	if r.ttls != nil {
+		byCache = make(map[string]CacheCounts)
+	}
+	var byFuzz map[string]map[string]int
+	if r.cfg.Fuzz > 0 {
+		byFuzz = make(map[string]map[string]int)
+	}
+	byOutcome := make(map[string]int)
+	report := func(sent int) {
+		sent -= fuzzed
+		message := statsMessage{
+			sent:              sent,
+			received:          sent - errors,
+			err:               errors,
+			firstErr:          firstErrors,
+			retries:           retried,
+			invalid:           invalid,
+			mismatches:        mismatches,
+			caseMismatches:    caseMismatches,
+			truncated:         truncated,
+			tcpFallbacks:      tcpFallbacks,
+			transferRecords:   transferRecords,
+			byOutcome:         byOutcome,
+			elapsed:           elapsed,
+			maxElapsed:        maxElapsed,
+			byType:            byType,
+			byResolver:        byResolver,
+			byDomain:          byDomain,
+			byUpdate:          byUpdate,
+			byAmplification:   byAmplification,
+			byFuzz:            byFuzz,
+			byCache:           byCache,
+			latencies:         latencies,
+			responseSizes:     responseSizes,
+			compared:          compared,
+			comparedLatencies: comparedLatencies,
+		}
+		if flooder != nil {
+			flooder.collect(&message)
+		}
+		sentCounterCh <- message
+		latencies = nil
+		responseSizes = nil
+		if compared != nil {
+			compared = &Comparison{}
+			comparedLatencies = nil
+		}
+		if byType != nil {
+			byType = make(map[uint16]QueryCounts)
+		}
+		if byResolver != nil {
+			byResolver = make(map[string]QueryCounts)
+		}
+		if byDomain != nil {
+			byDomain = make(map[string]QueryCounts)
+		}
+		if byUpdate != nil {
+			byUpdate = make(map[string]QueryCounts)
+		}
+		if byAmplification != nil {
+			byAmplification = make(map[string]AmplificationCounts)
+		}
+		if byFuzz != nil {
+			byFuzz = make(map[string]map[string]int)
+		}
+		if byCache != nil {
+			byCache = make(map[string]CacheCounts)
+		}
+		fuzzed = 0
+		errors = 0
+		firstErrors = 0
+		retried = 0
+		invalid = 0
+		mismatches = 0
+		caseMismatches = 0
+		truncated = 0
+		tcpFallbacks = 0
+		transferRecords = 0
+		byOutcome = make(map[string]int)
+		elapsed = 0
+		maxElapsed = 0
+	}
+
+	for {
+		for i := 0; i < displayStep; i++ {
+			if !r.acquireQuery(threadID) || (pacer != nil && !pacer.wait()) {
+				if flooder != nil {
+					// Wait for the last answers before the final report
+					report(i)
+					flooder.finish()
+					i = 0
+				}
+				report(i)
+				return
+			}
+
+			// Try to resolve the domain
+			var domain string
+			var qtype uint16
+			if r.cfg.QueryFile != "" || r.cfg.Replay != "" {
+				entry := r.nextQuery(rnd)
+				domain = entry.name
+				qtype = entry.qtype
+			} else {
+				if r.cfg.QueryPattern != "" {
+					domain = r.expandQueryPattern(rnd)
+				} else if r.reverse != nil {
+					domain = r.reverse.next(rnd, r.cfg.ReverseRandom)
+				} else {
+					domain = r.domains[r.domainChoice.pick(rnd)]
+				}
+				qtype = r.queryTypes[r.typeChoice.pick(rnd)]
+			}
+			target := domain // Without the random prefix
+			if r.cfg.RandomPrefix {
+				domain = randomLabel(rnd, 8) + "." + domain
+			} else if r.words != nil {
+				domain = r.words.prefix(rnd) + "." + domain
+			}
+			if r.cfg.RandomCase {
+				domain = randomCase(rnd, domain)
+			}
+			if updates != nil {
+				// The target domains are the zones to update
+				message, domain = updates.next(rnd, target, qtype)
+				qtype = updates.sent.rr.Header().Rrtype
+				r.cfg.setupEDNS(message)
+			} else {
+				message.Question[0].Name = domain
+				message.Question[0].Qtype = qtype
+				if r.transfers {
+					r.cfg.setTransferSerial(message)
+				}
+			}
+			var address string
+			if r.picker != nil {
+				address = r.resolvers[r.picker.pick(rnd, domain)]
+			}
+			if cookies != nil {
+				cookies.addCookie(message, address)
+			}
+			if len(r.cfg.ClientSubnets) > 0 {
+				r.cfg.setClientSubnet(message, rnd)
+			}
+			r.cfg.padQuery(message)
+			query := message
+			if r.cfg.Flood && !flooder.sharesSockets() {
+				// In-flight requests may be packed concurrently, each one needs its own message
+				query = message.Copy()
+			}
+			if r.cfg.RandomIDs {
+				// Regenerate message Id to avoid servers dropping (seemingly) duplicate messages
+				rand.Read(randomID)
+				query.Id = binary.BigEndian.Uint16(randomID)
+			}
+
+			if byFuzz != nil && rnd.Float64() < r.cfg.Fuzz {
+				if packed, err := query.Pack(); err == nil {
+					packet := fuzzQuery(rnd, packed, r.cfg.TCP || r.cfg.DoT)
+					outcome := r.sendFuzzed(threadID, address, packet)
+					if byFuzz[packet.mutation] == nil {
+						byFuzz[packet.mutation] = make(map[string]int)
+					}
+					byFuzz[packet.mutation][outcome]++
+					fuzzed++
+					continue
+				}
+			}
+
+			if r.cfg.Flood {
+				flooder.send(address, query)
+				if r.metrics != nil {
+					r.metrics.observeSent()
+				}
+				if byType != nil {
+					counts := byType[qtype]
+					counts.Sent++
+					byType[qtype] = counts
+				}
+				if byResolver != nil {
+					counts := byResolver[address]
+					counts.Sent++
+					byResolver[address] = counts
+				}
+				if byDomain != nil {
+					counts := byDomain[target]
+					counts.Sent++
+					byDomain[target] = counts
+				}
+			} else {
+				// The compared resolver gets the same query meanwhile
+				var comparison chan comparedAnswer
+				if compared != nil {
+					comparison = make(chan comparedAnswer, 1)
+					go func(query *dns.Msg) {
+						if cookies != nil {
+							cookies.addCookie(query, r.cfg.CompareResolver)
+							r.cfg.padQuery(query)
+						}
+						start := time.Now()
+						response, err := exchange(r.cfg.CompareResolver, query)
+						for attempt := 0; err != nil && attempt < r.cfg.Retries; attempt++ {
+							response, err = exchange(r.cfg.CompareResolver, query)
+						}
+						if cookies != nil && err == nil {
+							cookies.learn(response, r.cfg.CompareResolver)
+						}
+						comparison <- comparedAnswer{response: response, err: err, spent: time.Since(start)}
+					}(query.Copy())
+				}
+				start = time.Now()
+				response, err := exchange(address, query)
+				if err != nil {
+					firstErrors++
+				}
+				for attempt := 0; err != nil && attempt < r.cfg.Retries; attempt++ {
+					retried++
+					response, err = exchange(address, query)
+				}
+				if err == nil && response.Truncated {
+					truncated++
+					if r.cfg.RetryTCP {
+						// As a stub resolver would, the latency includes both exchanges
+						tcpFallbacks++
+						response, err = r.exchangeOver(r.cfg.ipNetwork("tcp"), threadID, address, query)
+					}
+				}
+				spent := time.Since(start)
+				if cookies != nil && err == nil {
+					cookies.learn(response, address)
+				}
+				if r.metrics != nil {
+					r.metrics.observe(response, err, spent)
+				}
+				elapsed += spent
+				latencies = append(latencies, spent)
+				if spent > maxElapsed {
+					maxElapsed = spent
+				}
+				outcome := queryOutcome(response, err)
+				byOutcome[outcome]++
+				var size int
+				if err == nil {
+					size = wireSize(response)
+					responseSizes = append(responseSizes, size)
+				}
+				if r.transfers && err == nil {
+					transferRecords += len(response.Answer)
+				}
+				if byAmplification != nil && err == nil {
+					key := amplificationKey(target, qtype)
+					counts := byAmplification[key]
+					counts.Answers++
+					counts.QueryBytes += query.Len()
+					counts.ResponseBytes += size
+					if size > counts.MaxResponse {
+						counts.MaxResponse = size
+					}
+					byAmplification[key] = counts
+				}
+				if r.cfg.RandomCase && err == nil && !keepsCase(response, domain) {
+					r.cfg.logf("%s answered without the case of the name: %v (%s)", domain, response.Question, address)
+					caseMismatches++
+				}
+				if comparison != nil {
+					answer := <-comparison
+					if difference := compared.compareAnswers(response, err, answer); difference != "" {
+						r.cfg.logf("%s answers differ: %s (%s, %s)", domain, difference, address, r.cfg.CompareResolver)
+					}
+					comparedLatencies = append(comparedLatencies, answer.spent)
+				}
+				if updates != nil {
+					updates.done(response, err)
+				}
+				if err == nil && (failedRcode(response.Rcode) || (updates != nil || r.transfers) && response.Rcode != dns.RcodeSuccess) {
+					// The resolver answered, but could not resolve the query (or apply the update, or transfer the zone)
+					err = fmt.Errorf("got %s", rcodeName(response.Rcode))
+				}
+				if err != nil {
+					r.cfg.logf("%s error: %s (%s)", domain, err, address)
+					errors++
+				} else if r.validator != nil {
+					if err := r.validator.validate(response); err != nil {
+						r.cfg.logf("%s DNSSEC validation failed: %s (%s)", domain, err, address)
+						invalid++
+					}
+				}
+				if err == nil && r.cfg.Expect != nil && !r.cfg.Expect.matches(response, domain, qtype) {
+					r.cfg.logf("%s unexpected answer: %v (%s)", domain, response.Answer, address)
+					mismatches++
+				}
+				if byCache != nil && err == nil {
+					counts := byCache[target]
+					if r.ttls.observe(domain, qtype, response, time.Now()) {
+						counts.Hits++
+					} else {
+						counts.Misses++
+					}
+					byCache[target] = counts
+				}
+				if r.cfg.OnQuery != nil {
+					resolver := address
+					if r.cfg.DOHEndpoint != "" {
+						resolver = r.cfg.DOHEndpoint
+					}
+					r.cfg.OnQuery(QueryEvent{Start: start, Latency: spent, Name: domain, Type: qtype, Resolver: resolver, Transport: transport, Outcome: outcome, Err: err})
+				}
+				if byType != nil {
+					counts := byType[qtype]
+					counts.Sent++
+					counts.Elapsed += spent
+					if err != nil {
+						counts.Errors++
+					}
+					byType[qtype] = counts
+				}
+				if byResolver != nil {
+					counts := byResolver[address]
+					counts.Sent++
+					counts.Elapsed += spent
+					if err != nil {
+						counts.Errors++
+					}
+					byResolver[address] = counts
+				}
+				if byDomain != nil {
+					counts := byDomain[target]
+					counts.Sent++
+					counts.Elapsed += spent
+					if err != nil {
+						counts.Errors++
+					}
+					byDomain[target] = counts
+				}
+				if byUpdate != nil {
+					counts := byUpdate[updates.op]
+					counts.Sent++
+					counts.Elapsed += spent
+					if err != nil {
+						counts.Errors++
+					}
+					byUpdate[updates.op] = counts
+				}
+			}
+		}
+
+		report(displayStep)
+	}
+}
+
+// exchange sends a query to a resolver on behalf of a thread, and waits for its answer
+func (r *Runner) exchange(threadID int, resolver string, message *dns.Msg) (*dns.Msg, error) {
+	// Check if DOH is enabled
+	if r.cfg.DOHEndpoint != "" {
+		response, mac, err := r.performDOHRequest(message)
+		if err != nil {
+			return nil, fmt.Errorf("DOH request failed: %w", err)
+		}
+		if len(response) == 0 {
+			return nil, fmt.Errorf("empty DOH response")
+		}
+		answer, err := r.cfg.TSIG.unpack(response, mac)
+		if err != nil && !errors.Is(err, errInvalidSignature) {
+			return nil, fmt.Errorf("invalid DOH response: %v", err)
+		}
+		return answer, err
+	}
+
+	if r.cfg.DoQ {
+		return r.doqSessions[resolver].exchange(message)
+	}
+	if r.pools != nil {
+		return r.pools[resolver].exchange(message)
+	}
+
+	// Standard DNS request (UDP or TCP)
+	return r.exchangeOver(r.transportNetwork(), threadID, resolver, message)
+}
+
+// exchangeOver sends a query to a resolver on a new connection of the given network, and waits
+// for its answer
+func (r *Runner) exchangeOver(network string, threadID int, resolver string, message *dns.Msg) (*dns.Msg, error) {
+	dnsconn, err := r.dialResolver(network, resolver, r.source(threadID, resolver))
+	if err != nil {
+		return nil, err
+	}
+	co := &dns.Conn{Conn: dnsconn}
+	defer co.Close()
+
+	// Actually send the message and wait for answer, which may never come over UDP
+	co.SetDeadline(time.Now().Add(r.cfg.Timeout))
+	mac, err := r.cfg.TSIG.writeQuery(co, message)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.cfg.TSIG.readAnswer(co, mac)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MickaelBergem/dnsstresss/stress"
+	"github.com/logrusorgru/aurora"
+)
+
+// thresholdsExitCode is the exit status of a run going over its -max-error-rate or -max-p99
+const thresholdsExitCode = 3
+
+// errorRateLimit is the parsed -max-error-rate, negative when it is not checked
+var errorRateLimit = -1.
+
+// parsePercentage parses a share given as a percentage (e.g. "1%") or a fraction (e.g. "0.01")
+func parsePercentage(input string) (float64, error) {
+	input = strings.TrimSpace(input)
+	divisor := 1.
+	if strings.HasSuffix(input, "%") {
+		input = strings.TrimSuffix(input, "%")
+		divisor = 100
+	}
+	value, err := strconv.ParseFloat(input, 64)
+	if err != nil || math.IsNaN(value) || value < 0 || value/divisor > 1 {
+		return 0, fmt.Errorf("expected a percentage such as 1%% or a fraction such as 0.01")
+	}
+	return value / divisor, nil
+}
+
+// parseThresholds checks the thresholds given on the command line, exiting if they are invalid
+func parseThresholds() {
+	if maxP99 < 0 {
+		fmt.Println(aurora.Red("-max-p99 cannot be negative"))
+		os.Exit(2)
+	}
+	if maxErrorRate == "" {
+		return
+	}
+	var err error
+	errorRateLimit, err = parsePercentage(maxErrorRate)
+	if err != nil {
+		fmt.Println(aurora.Sprintf(aurora.Red("%s (%s)"), "Unable to parse the maximum error rate", err))
+		os.Exit(2)
+	}
+}
+
+// slaDescription describes the SLA of -auto-concurrency and -find-max for display
+func slaDescription() string {
+	description := fmt.Sprintf("the errors under %s", slaErrorRate)
+	if slaP99 > 0 {
+		description += fmt.Sprintf(" and the p99 latency under %s", slaP99)
+	}
+	return description
+}
+
+// thresholdViolations describes the thresholds the run went over
+func thresholdViolations(totals *stress.Stats) []string {
+	if errorRateLimit < 0 && maxP99 == 0 {
+		return nil
+	}
+	if totals.Sent == 0 {
+		return []string{"no queries were sent"}
+	}
+	var violations []string
+	if errorRate := float64(totals.Errors) / float64(totals.Sent); errorRateLimit >= 0 && errorRate > errorRateLimit {
+		violations = append(violations, fmt.Sprintf("error rate of %.2f%%, above %.2f%%", 100*errorRate, 100*errorRateLimit))
+	}
+	if p99 := percentileMs(totals.Latency, 99); maxP99 > 0 && p99 > 1000.*maxP99.Seconds() {
+		violations = append(violations, fmt.Sprintf("p99 latency of %.1fms, above %s", p99, maxP99.Round(time.Microsecond)))
+	}
+	return violations
+}
+
+// checkThresholds exits with thresholdsExitCode once the run is over if it went over a threshold
+func checkThresholds(totals *stress.Stats) {
+	violations := thresholdViolations(totals)
+	if len(violations) == 0 {
+		return
+	}
+	for _, violation := range violations {
+		fmt.Fprintln(os.Stderr, aurora.Sprintf(aurora.Red("%s (%s)"), "Threshold exceeded", violation))
+	}
+	os.Exit(thresholdsExitCode)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/MickaelBergem/dnsstresss/stress"
+)
+
+func TestParsePercentage(t *testing.T) {
+	for _, test := range []struct {
+		input    string
+		expected float64
+		valid    bool
+	}{
+		{"1%", 0.01, true},
+		{" 2.5% ", 0.025, true},
+		{"0.01", 0.01, true},
+		{"0", 0, true},
+		{"100%", 1, true},
+		{"101%", 0, false},
+		{"1.5", 0, false},
+		{"-1", 0, false},
+		{"-1%", 0, false},
+		{"NaN", 0, false},
+		{"NaN%", 0, false},
+		{"Inf", 0, false},
+		{"-Inf%", 0, false},
+		{"", 0, false},
+		{"one", 0, false},
+	} {
+		value, err := parsePercentage(test.input)
+		if test.valid != (err == nil) || value != test.expected {
+			t.Errorf("Expected %v (valid: %v) for %q, got %v (%v)", test.expected, test.valid, test.input, value, err)
+		}
+	}
+}
+
+func TestThresholdViolations(t *testing.T) {
+	defer func(limit float64, p99 time.Duration) {
+		errorRateLimit, maxP99 = limit, p99
+	}(errorRateLimit, maxP99)
+
+	latency := hdrhistogram.New(1, 60000000, 3)
+	for i := 0; i < 100; i++ {
+		latency.RecordValue(5000) // 5ms
+	}
+	totals := &stress.Stats{Sent: 100, Errors: 3, Latency: latency}
+	for _, test := range []struct {
+		limit    float64
+		p99      time.Duration
+		totals   *stress.Stats
+		expected []string
+	}{
+		{-1, 0, totals, nil},
+		{0.05, 10 * time.Millisecond, totals, nil},
+		{0.03, 0, totals, nil}, // At the limit
+		{0.01, 0, totals, []string{"error rate of 3.00%, above 1.00%"}},
+		{-1, 2 * time.Millisecond, totals, []string{"p99 latency of 5.0ms, above 2ms"}},
+		{0, time.Millisecond, totals, []string{"error rate of 3.00%, above 0.00%", "p99 latency of 5.0ms, above 1ms"}},
+		{0.01, 0, &stress.Stats{Latency: latency}, []string{"no queries were sent"}},
+	} {
+		errorRateLimit, maxP99 = test.limit, test.p99
+		if violations := thresholdViolations(test.totals); !reflect.DeepEqual(violations, test.expected) {
+			t.Errorf("Expected %q with a limit of %v and a p99 of %s, got %q", test.expected, test.limit, test.p99, violations)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MickaelBergem/dnsstresss/stress"
+	"github.com/miekg/dns"
+)
+
+// liveDashboard replaces the scrolling stats with -tui
+var liveDashboard *dashboard
+
+// sparkBlocks are the bars of the sparklines, from the lowest to the highest value
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// rateStep is the change of the target rate made by the +/- keys
+const rateStep = 0.1
+
+// runControl is what the dashboard can do to the run: a local run can also be paused and have
+// its rate changed, a run from agents can only be stopped
+type runControl interface {
+	Stop()
+}
+
+// dashboard is the interactive display of the stats: the rates and latencies of the last
+// interval with their recent history, and the breakdown of the whole run
+type dashboard struct {
+	mu      sync.Mutex
+	title   string
+	control runControl
+	runner  *stress.Runner // Set for a local run
+	restore func()
+	started time.Time
+
+	// Last interval
+	sent         int
+	errors       int
+	timeouts     int
+	rate         float64
+	receivedRate float64
+	meanLatency  float64
+	maxLatency   float64
+	stdDev       float64
+	percentiles  string
+	outcomes     string
+	warmup       bool
+
+	qpsHistory []float64
+	p99History []float64
+	totals     struct {
+		sent, errors int
+	}
+	byResolver map[string]stress.QueryCounts
+	byDomain   map[string]stress.QueryCounts
+	status     string // Outcome of the last key
+	closed     bool
+}
+
+// startDashboard takes over the terminal to display the stats of the run, until close is called
+func startDashboard(title string, control runControl) (*dashboard, error) {
+	restore, err := rawTerminal(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	d := &dashboard{
+		title:      title,
+		control:    control,
+		restore:    restore,
+		started:    time.Now(),
+		byResolver: make(map[string]stress.QueryCounts),
+		byDomain:   make(map[string]stress.QueryCounts),
+	}
+	d.runner, _ = control.(*stress.Runner)
+	// Use the alternate screen, so that the terminal gets its content back at the end
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	d.render()
+	go d.readKeys()
+	return d, nil
+}
+
+// close gives the terminal back, the summary can be printed afterwards
+func (d *dashboard) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Print("\x1b[?25h\x1b[?1049l")
+	d.restore()
+	d.closed = true
+}
+
+// update accounts for the stats of an interval, and redraws the dashboard
+func (d *dashboard) update(interval *stress.Stats) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sent = interval.Sent
+	d.errors = interval.Errors
+	d.timeouts = interval.Timeouts()
+	d.rate = float64(interval.Sent) / interval.Duration.Seconds()
+	d.receivedRate = float64(interval.Received) / interval.Duration.Seconds()
+	d.meanLatency = interval.MeanLatency()
+	d.maxLatency = 1000. * interval.MaxElapsed.Seconds()
+	d.stdDev = interval.LatencyStdDev()
+	d.percentiles = formatPercentiles(interval.Latency)
+	d.warmup = interval.Warmup
+	var outcomes []string
+	for _, outcome := range stress.SortedOutcomes(interval.ByOutcome) {
+		if outcome != dns.RcodeToString[dns.RcodeSuccess] {
+			outcomes = append(outcomes, fmt.Sprintf("%s=%d", outcome, interval.ByOutcome[outcome]))
+		}
+	}
+	d.outcomes = strings.Join(outcomes, " ")
+
+	d.qpsHistory = append(d.qpsHistory, d.rate)
+	d.p99History = append(d.p99History, percentileMs(interval.Latency, 99))
+	d.totals.sent += interval.Sent
+	d.totals.errors += interval.Errors
+	addBreakdown(d.byResolver, interval.ByResolver)
+	addBreakdown(d.byDomain, interval.ByDomain)
+	d.renderLocked()
+}
+
+// addBreakdown adds the counts of an interval to the ones of the run
+func addBreakdown(total map[string]stress.QueryCounts, added map[string]stress.QueryCounts) {
+	for key, counts := range added {
+		current := total[key]
+		current.Sent += counts.Sent
+		current.Errors += counts.Errors
+		current.Elapsed += counts.Elapsed
+		total[key] = current
+	}
+}
+
+// readKeys handles the keys typed during the run, until it is stopped
+func (d *dashboard) readKeys() {
+	key := make([]byte, 1)
+	for {
+		if _, err := os.Stdin.Read(key); err != nil {
+			return
+		}
+		d.mu.Lock()
+		stop := d.handleKey(key[0])
+		d.renderLocked()
+		d.mu.Unlock()
+		if stop {
+			// Run returns once the queries in flight are answered
+			d.control.Stop()
+			return
+		}
+	}
+}
+
+// handleKey acts on a key, and returns true when the run has to stop
+func (d *dashboard) handleKey(key byte) bool {
+	switch key {
+	case 'q', 'Q':
+		d.status = "Stopping, waiting for the queries in flight..."
+		return true
+	case 'p', 'P', ' ':
+		if d.runner == nil {
+			d.status = "The agents cannot be paused"
+		} else if d.runner.Paused() {
+			d.runner.Resume()
+			d.status = "Resumed"
+		} else {
+			d.runner.Pause()
+			d.status = "Paused"
+		}
+	case '+', '=', '-', '_':
+		if d.runner == nil {
+			d.status = "The rate of the agents cannot be changed"
+			break
+		}
+		// Without a limit, the rate starts from the one reached
+		current := float64(d.runner.Rate())
+		if current == 0 {
+			current = d.rate
+		}
+		factor := 1 + rateStep
+		if key == '-' || key == '_' {
+			factor = 1 - rateStep
+		}
+		target := int(math.Max(1, math.Round(current*factor)))
+		if err := d.runner.SetRate(target); err != nil {
+			d.status = fmt.Sprintf("Unable to change the rate (%s)", err)
+		} else {
+			d.status = fmt.Sprintf("Target rate set to %d queries per second", target)
+		}
+	}
+	return false
+}
+
+func (d *dashboard) render() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.renderLocked()
+}
+
+// renderLocked redraws the whole dashboard, fitting it into the terminal
+func (d *dashboard) renderLocked() {
+	if d.closed {
+		return
+	}
+	width, height, err := terminalSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	bold := func(text string) string { return "\x1b[1m" + text + "\x1b[0m" }
+	faint := func(text string) string { return "\x1b[2m" + text + "\x1b[0m" }
+
+	elapsed := time.Since(d.started).Round(time.Second)
+	lines := []string{
+		fmt.Sprintf("%s  %s", bold("dnsstresss"), d.title),
+		faint(fmt.Sprintf("Running for %s %s", elapsed, formatProgress())),
+		"",
+	}
+	errorRate := 0.
+	if d.sent > 0 {
+		errorRate = 100. * float64(d.errors) / float64(d.sent)
+	}
+	state := ""
+	if d.runner != nil && d.runner.Paused() {
+		state = "  " + bold("PAUSED")
+	} else if d.warmup {
+		state = "  " + faint("(warmup)")
+	}
+	lines = append(lines,
+		fmt.Sprintf("%s %8.0f r/s   %s %8.0f r/s   %s %d (%.1f%%)   %s %d%s",
+			faint("Sent:"), d.rate,
+			faint("Received:"), d.receivedRate,
+			faint(errorsLabel()+":"), d.errors, errorRate,
+			faint("Timeouts:"), d.timeouts, state),
+		fmt.Sprintf("%s mean=%.1fms sd=%.1fms max=%.1fms  [%s]", faint("Latency:"), d.meanLatency, d.stdDev, d.maxLatency, d.percentiles),
+	)
+	if d.outcomes != "" {
+		lines = append(lines, faint("("+d.outcomes+")"))
+	}
+	target := "unlimited"
+	if d.runner != nil {
+		if rate := d.runner.Rate(); rate > 0 {
+			target = fmt.Sprintf("%d queries per second", rate)
+		}
+	}
+	sparkWidth := width - 10
+	lines = append(lines,
+		"",
+		fmt.Sprintf("%s %s", faint("QPS     "), sparkline(d.qpsHistory, sparkWidth)),
+		fmt.Sprintf("%s %s", faint("p99 (ms)"), sparkline(d.p99History, sparkWidth)),
+		"",
+		fmt.Sprintf("%s %s   %s %d sent, %d errors", faint("Target rate:"), target, faint("Total:"), d.totals.sent, d.totals.errors),
+	)
+
+	help := "[p] pause/resume  [+/-] change the rate  [q] quit with a summary"
+	if d.runner == nil {
+		help = "[q] quit with a summary"
+	}
+	footer := []string{"", faint(help)}
+	if d.status != "" {
+		footer = append([]string{"", d.status}, footer...)
+	}
+
+	// The breakdowns use the remaining rows
+	panels := [][]string{
+		breakdownPanel("Resolvers", d.byResolver),
+		breakdownPanel("Domains", d.byDomain),
+	}
+	for _, panel := range panels {
+		if panel == nil {
+			continue
+		}
+		available := height - len(lines) - len(footer) - 1
+		if available < 3 {
+			break
+		}
+		if len(panel) > available {
+			panel = append(panel[:available-1], faint("..."))
+		}
+		lines = append(lines, "")
+		lines = append(lines, panel...)
+	}
+	lines = append(lines, footer...)
+
+	var screen strings.Builder
+	screen.WriteString("\x1b[H")
+	for index, line := range lines {
+		if index >= height {
+			break
+		}
+		screen.WriteString(truncateLine(line, width))
+		screen.WriteString("\x1b[K\n")
+	}
+	screen.WriteString("\x1b[J")
+	os.Stdout.WriteString(screen.String())
+}
+
+// breakdownPanel returns the lines of the breakdown of the run by resolver or domain, or nil
+// when the queries are not broken down
+func breakdownPanel(title string, breakdown map[string]stress.QueryCounts) []string {
+	if len(breakdown) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(breakdown))
+	for key := range breakdown {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := []string{fmt.Sprintf("\x1b[1m%-40s %10s %8s %10s\x1b[0m", title, "Sent", "Errors", "Mean")}
+	for _, key := range keys {
+		counts := breakdown[key]
+		errorRate, mean := 0., 0.
+		if counts.Sent > 0 {
+			errorRate = 100. * float64(counts.Errors) / float64(counts.Sent)
+			mean = 1000. * counts.Elapsed.Seconds() / float64(counts.Sent)
+		}
+		lines = append(lines, fmt.Sprintf("%-40s %10d %7.1f%% %8.1fms", key, counts.Sent, errorRate, mean))
+	}
+	return lines
+}
+
+// sparkline draws the last values that fit in the width as bars, scaled to their maximum
+func sparkline(values []float64, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	highest := 0.
+	for _, value := range values {
+		highest = math.Max(highest, value)
+	}
+	bars := make([]rune, len(values))
+	for index, value := range values {
+		level := 0
+		if highest > 0 {
+			level = int(math.Round(value / highest * float64(len(sparkBlocks)-1)))
+		}
+		bars[index] = sparkBlocks[level]
+	}
+	return string(bars)
+}
+
+// truncateLine cuts a line to the width of the terminal, not counting the escape sequences
+func truncateLine(line string, width int) string {
+	var result strings.Builder
+	visible := 0
+	escape := false
+	for _, char := range line {
+		switch {
+		case char == '\x1b':
+			escape = true
+		case escape:
+			escape = char < '@' || char > '~' || char == '['
+		default:
+			if visible >= width {
+				continue
+			}
+			visible++
+		}
+		result.WriteRune(char)
+	}
+	return result.String()
+}
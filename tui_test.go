@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/MickaelBergem/dnsstresss/stress"
+)
+
+func TestSparkline(t *testing.T) {
+	for _, test := range []struct {
+		values   []float64
+		width    int
+		expected string
+	}{
+		{nil, 10, ""},
+		{[]float64{1, 2}, 0, ""},
+		{[]float64{0, 0}, 10, "▁▁"},
+		{[]float64{0, 1, 2}, 10, "▁▅█"},
+		{[]float64{7, 1, 2}, 2, "▅█"}, // Only the last values that fit, scaled to their maximum
+		{[]float64{0, 1, 2, 3, 4, 5, 6, 7}, 8, "▁▂▃▄▅▆▇█"},
+	} {
+		if line := sparkline(test.values, test.width); line != test.expected {
+			t.Errorf("Expected %q for %v in %d columns, got %q", test.expected, test.values, test.width, line)
+		}
+	}
+}
+
+func TestTruncateLine(t *testing.T) {
+	for _, test := range []struct {
+		line     string
+		width    int
+		expected string
+	}{
+		{"abcdef", 10, "abcdef"},
+		{"abcdef", 3, "abc"},
+		{"abcdef", 0, ""},
+		{"aé▁bc", 3, "aé▁"},
+		// The escape sequences take no room, and the ones after the cut are kept
+		{"\x1b[1mabcdef\x1b[0m", 2, "\x1b[1mab\x1b[0m"},
+		{"ab\x1b[38;5;196mcd\x1b[0mef", 3, "ab\x1b[38;5;196mc\x1b[0m"},
+		{"\x1b[2Kabc", 3, "\x1b[2Kabc"},
+	} {
+		if line := truncateLine(test.line, test.width); line != test.expected {
+			t.Errorf("Expected %q for %q in %d columns, got %q", test.expected, test.line, test.width, line)
+		}
+	}
+}
+
+// agentsControl stands for the controller of a run from agents, which can only be stopped
+type agentsControl struct{}
+
+func (agentsControl) Stop() {}
+
+func TestDashboardKeys(t *testing.T) {
+	cfg := stress.NewConfig()
+	cfg.Resolvers = []string{"127.0.0.1:5499"}
+	cfg.Domains = []string{"example.com."}
+	cfg.Rate = 100
+	runner, err := stress.NewRunner(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.SetRate(100); err != nil {
+		t.Fatal(err)
+	}
+	d := &dashboard{control: runner, runner: runner, rate: 250}
+	for _, test := range []struct {
+		key    byte
+		stop   bool
+		paused bool
+		rate   int
+		status string
+	}{
+		{'+', false, false, 110, "Target rate set to 110 queries per second"},
+		{'=', false, false, 121, "Target rate set to 121 queries per second"},
+		{'-', false, false, 109, "Target rate set to 109 queries per second"},
+		{'_', false, false, 98, "Target rate set to 98 queries per second"},
+		{'p', false, true, 98, "Paused"},
+		{' ', false, false, 98, "Resumed"},
+		{'P', false, true, 98, "Paused"},
+		{'x', false, true, 98, "Paused"}, // Other keys are ignored
+		{'q', true, true, 98, "Stopping, waiting for the queries in flight..."},
+	} {
+		if stop := d.handleKey(test.key); stop != test.stop {
+			t.Errorf("Expected %q to stop the run: %v, got %v", test.key, test.stop, stop)
+		}
+		if runner.Paused() != test.paused || runner.Rate() != test.rate || d.status != test.status {
+			t.Errorf("Expected %q to leave the run paused: %v at %d queries per second (%q), got %v at %d (%q)",
+				test.key, test.paused, test.rate, test.status, runner.Paused(), runner.Rate(), d.status)
+		}
+	}
+
+	// Without a limit, the rate starts from the one reached
+	if err := runner.SetRate(0); err != nil {
+		t.Fatal(err)
+	}
+	if d.handleKey('+'); runner.Rate() != 275 {
+		t.Errorf("Expected a target rate of 275 queries per second, got %d", runner.Rate())
+	}
+	d.rate = 0
+	runner.SetRate(0)
+	if d.handleKey('-'); runner.Rate() != 1 {
+		t.Errorf("Expected the target rate to stay positive, got %d", runner.Rate())
+	}
+}
+
+func TestDashboardKeysAgents(t *testing.T) {
+	d := &dashboard{control: agentsControl{}}
+	for _, test := range []struct {
+		key    byte
+		stop   bool
+		status string
+	}{
+		{'p', false, "The agents cannot be paused"},
+		{'+', false, "The rate of the agents cannot be changed"},
+		{'Q', true, "Stopping, waiting for the queries in flight..."},
+	} {
+		if stop := d.handleKey(test.key); stop != test.stop || d.status != test.status {
+			t.Errorf("Expected %q to stop the run: %v (%q), got %v (%q)", test.key, test.stop, test.status, stop, d.status)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// webOutput streams the stats to the browsers of -web, when set
+var webOutput *webDashboard
+
+// maxWebHistory bounds the intervals sent to a browser when it connects, to draw the charts of
+// the run so far
+const maxWebHistory = 600
+
+// webDashboard serves a page charting the stats of the run, sent to the browsers over a
+// websocket at each interval
+type webDashboard struct {
+	title   string
+	mu      sync.Mutex
+	history [][]byte
+	clients map[chan []byte]bool
+	closed  bool
+	sending sync.WaitGroup // Browsers still being sent the records
+}
+
+// startWebDashboard serves the dashboard in the background, the page is at / and the stats at /ws
+func startWebDashboard(addr, title string) *webDashboard {
+	d := &webDashboard{title: title, clients: make(map[chan []byte]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.servePage)
+	mux.Handle("/ws", websocket.Handler(d.serveStats))
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Fprintf(os.Stderr, "Web dashboard stopped: %s\n", err)
+		}
+	}()
+	return d
+}
+
+func (d *webDashboard) servePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	webPage.Execute(w, d.title)
+}
+
+// serveStats sends the records of the run so far, then each new one, until the browser leaves
+func (d *webDashboard) serveStats(ws *websocket.Conn) {
+	defer ws.Close()
+	records := make(chan []byte, 16)
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	history := append([][]byte(nil), d.history...)
+	d.clients[records] = true
+	d.sending.Add(1)
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.clients, records)
+		d.mu.Unlock()
+		d.sending.Done()
+	}()
+
+	// The browser sends nothing, reading only notices when it leaves
+	left := make(chan struct{})
+	go func() {
+		var discarded []byte
+		for websocket.Message.Receive(ws, &discarded) == nil {
+		}
+		close(left)
+	}()
+	for _, record := range history {
+		if websocket.Message.Send(ws, string(record)) != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case record, ok := <-records:
+			if !ok || websocket.Message.Send(ws, string(record)) != nil {
+				return
+			}
+		case <-left:
+			return
+		}
+	}
+}
+
+// writeRecord sends a record to the connected browsers, the ones too slow to take it miss it
+func (d *webDashboard) writeRecord(record statsRecord) error {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	if len(d.history) == maxWebHistory {
+		d.history = d.history[1:]
+	}
+	d.history = append(d.history, encoded)
+	for client := range d.clients {
+		select {
+		case client <- encoded:
+		default:
+		}
+	}
+	return nil
+}
+
+// close ends the streams once the browsers got the last records, waiting a second at most
+func (d *webDashboard) close() {
+	d.mu.Lock()
+	d.closed = true
+	for client := range d.clients {
+		close(client)
+	}
+	d.mu.Unlock()
+	sent := make(chan struct{})
+	go func() {
+		d.sending.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+	}
+}
+
+// webPage is the dashboard, drawing its charts without any external script
+var webPage = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>dnsstresss - {{.}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; background: #fafafa; color: #222; }
+h1 { font-size: 1.3em; }
+#status { color: #888; }
+.chart { background: #fff; border: 1px solid #ddd; margin: 1em 0; padding: 0.5em; }
+.chart h2 { font-size: 1em; margin: 0 0 0.3em; }
+.legend span { margin-right: 1em; font-size: 0.9em; }
+canvas { width: 100%; height: 200px; }
+</style>
+</head>
+<body>
+<h1>dnsstresss - {{.}}</h1>
+<div id="status">Connecting...</div>
+<div class="chart"><h2>Queries per second</h2><div class="legend" id="rate-legend"></div><canvas id="rate"></canvas></div>
+<div class="chart"><h2>Errors (%)</h2><div class="legend" id="errors-legend"></div><canvas id="errors"></canvas></div>
+<div class="chart"><h2>Latency percentiles (ms)</h2><div class="legend" id="latency-legend"></div><canvas id="latency"></canvas></div>
+<script>
+var colors = ["#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd"];
+var charts = {
+  rate: {series: {"sent": [], "received": []}},
+  errors: {series: {"errors": [], "timeouts": []}},
+  latency: {series: {"p50": [], "p90": [], "p99": [], "p99.9": []}}
+};
+var done = false;
+
+function draw(name) {
+  var chart = charts[name], canvas = document.getElementById(name);
+  canvas.width = canvas.clientWidth;
+  canvas.height = canvas.clientHeight;
+  var ctx = canvas.getContext("2d"), keys = Object.keys(chart.series), max = 0;
+  keys.forEach(function(key) { chart.series[key].forEach(function(v) { max = Math.max(max, v); }); });
+  max = max > 0 ? max * 1.1 : 1;
+  ctx.clearRect(0, 0, canvas.width, canvas.height);
+  ctx.fillStyle = "#888";
+  ctx.fillText(max.toPrecision(3), 2, 10);
+  var legend = [];
+  keys.forEach(function(key, index) {
+    var values = chart.series[key];
+    ctx.strokeStyle = colors[index];
+    ctx.beginPath();
+    values.forEach(function(v, i) {
+      var x = values.length > 1 ? i * canvas.width / (values.length - 1) : 0;
+      var y = canvas.height - v * canvas.height / max;
+      if (i == 0) { ctx.moveTo(x, y); } else { ctx.lineTo(x, y); }
+    });
+    ctx.stroke();
+    var last = values.length ? values[values.length - 1] : 0;
+    legend.push('<span style="color:' + colors[index] + '">' + key + ": " + last.toFixed(1) + "</span>");
+  });
+  document.getElementById(name + "-legend").innerHTML = legend.join("");
+}
+
+function add(record) {
+  var duration = record.duration_s || 1, percentiles = record.latency_percentiles_ms || {};
+  var values = {
+    rate: {"sent": record.sent / duration, "received": record.received / duration},
+    errors: {"errors": record.sent ? 100 * record.errors / record.sent : 0, "timeouts": record.sent ? 100 * record.timeouts / record.sent : 0},
+    latency: {"p50": percentiles.p50 || 0, "p90": percentiles.p90 || 0, "p99": percentiles.p99 || 0, "p99.9": percentiles["p99.9"] || 0}
+  };
+  Object.keys(charts).forEach(function(name) {
+    Object.keys(charts[name].series).forEach(function(key) {
+      var series = charts[name].series[key];
+      series.push(values[name][key]);
+      if (series.length > 600) { series.shift(); }
+    });
+  });
+}
+
+var socket = new WebSocket((location.protocol == "https:" ? "wss://" : "ws://") + location.host + "/ws");
+socket.onopen = function() { document.getElementById("status").textContent = "Running"; };
+socket.onclose = function() {
+  if (!done) { document.getElementById("status").textContent = "Disconnected"; }
+};
+socket.onmessage = function(event) {
+  var record = JSON.parse(event.data);
+  if (record.type == "summary") {
+    done = true;
+    document.getElementById("status").textContent = "Done: " + record.sent + " queries sent, " + record.errors + " errors, mean latency " + record.mean_latency_ms.toFixed(1) + "ms";
+    return;
+  }
+  add(record);
+  Object.keys(charts).forEach(draw);
+};
+window.onresize = function() { Object.keys(charts).forEach(draw); };
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func newTestDashboard() *webDashboard {
+	return &webDashboard{title: "test", clients: make(map[chan []byte]bool)}
+}
+
+// receiveRecord reads the next record sent to a browser
+func receiveRecord(t *testing.T, ws *websocket.Conn) statsRecord {
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var message string
+	if err := websocket.Message.Receive(ws, &message); err != nil {
+		t.Fatal(err)
+	}
+	var record statsRecord
+	if err := json.Unmarshal([]byte(message), &record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestWebDashboardHistory(t *testing.T) {
+	d := newTestDashboard()
+	for sent := 0; sent < maxWebHistory+5; sent++ {
+		if err := d.writeRecord(statsRecord{Type: "interval", Sent: sent}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(d.history) != maxWebHistory {
+		t.Fatalf("Expected the history to keep %d records, got %d", maxWebHistory, len(d.history))
+	}
+
+	server := httptest.NewServer(websocket.Handler(d.serveStats))
+	defer server.Close()
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	// The oldest records were dropped, the browser gets the other ones in order
+	for sent := 5; sent < maxWebHistory+5; sent++ {
+		if record := receiveRecord(t, ws); record.Sent != sent {
+			t.Fatalf("Expected the record of %d queries sent, got %d", sent, record.Sent)
+		}
+	}
+
+	// Then the new records, the browser being registered before it got the history
+	if err := d.writeRecord(statsRecord{Type: "summary", Sent: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	if record := receiveRecord(t, ws); record.Type != "summary" || record.Sent != 1000 {
+		t.Errorf("Expected the summary, got %+v", record)
+	}
+
+	// Closing the dashboard ends the stream
+	d.close()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var message string
+	if err := websocket.Message.Receive(ws, &message); err == nil {
+		t.Errorf("Expected the stream to end, got %q", message)
+	}
+	if err := d.writeRecord(statsRecord{Type: "interval"}); err != nil || len(d.history) != maxWebHistory {
+		t.Errorf("Expected the records to be ignored once closed")
+	}
+}
+
+func TestWebDashboardSlowClient(t *testing.T) {
+	d := newTestDashboard()
+	slow, fast := make(chan []byte, 1), make(chan []byte, 4)
+	d.clients[slow] = true
+	d.clients[fast] = true
+	done := make(chan struct{})
+	go func() {
+		for sent := 0; sent < 3; sent++ {
+			d.writeRecord(statsRecord{Type: "interval", Sent: sent})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected the records to be written without waiting for the slow browser")
+	}
+	if len(slow) != 1 || len(fast) != 3 {
+		t.Errorf("Expected the slow browser to miss the records it had no room for, got %d and %d", len(slow), len(fast))
+	}
+	var record statsRecord
+	if err := json.Unmarshal(<-slow, &record); err != nil || record.Sent != 0 {
+		t.Errorf("Expected the slow browser to keep the first record, got %+v (%v)", record, err)
+	}
+}